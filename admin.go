@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// maxDeleteBatch is the most keys datastore accepts in a single DeleteMulti call.
+const maxDeleteBatch = 500
+
+var knownKinds = []string{UserKind, StoreKind, ItemKind, ItemAliasKind, WebhookKind, FavoriteKind, HiddenItemKind, ClientErrorKind, ItemQueryCountKind, ExternalUserIDKind, RegionSubscriptionKind}
+
+// purgeAllowedKinds are the kinds PurgeKinds may clear, set with the comma-separated
+// PURGE_ALLOWED_KINDS env variable. By default, user and store data can't be purged.
+var purgeAllowedKinds = kindsFromEnv("PURGE_ALLOWED_KINDS", []string{ItemKind, ItemAliasKind})
+
+// ******************************************
+// ** BEGIN PurgeKinds
+// ******************************************
+
+type PurgeKindsReq struct {
+	// Kinds names each kind to clear. There is no way to clear every kind at once.
+	Kinds []string `json:"kinds"`
+}
+
+type PurgeKindsResp struct {
+	// Deleted maps each purged kind to the number of entities deleted.
+	Deleted  map[string]int `json:"deleted"`
+	Progress *BatchProgress `json:"progress"`
+}
+
+// PurgeKinds deletes every entity of the named kinds, in batches limited by jobLimits. It is
+// an admin endpoint. If the purge yields before it's done, the same request continues it.
+func PurgeKinds(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req PurgeKindsReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidatePurgeKindsReq(&req, purgeAllowedKinds); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	resp := &PurgeKindsResp{Deleted: make(map[string]int, len(req.Kinds))}
+	kinds := req.Kinds
+	resp.Progress, err = runBatches(ctx, "purge kinds", jobLimits, func(ctx context.Context, size int) (int, bool, error) {
+		kind := kinds[0]
+		n, err := purgeKindBatchInStorage(ctx, client, kind, size)
+		resp.Deleted[kind] += n
+		if err != nil {
+			return n, false, err
+		}
+		if n < size {
+			kinds = kinds[1:]
+		}
+		return n, len(kinds) == 0, nil
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidatePurgeKindsReq(req *PurgeKindsReq, allowed []string) error {
+	if len(req.Kinds) == 0 {
+		return fmt.Errorf("missing kinds to purge")
+	}
+	seen := make(map[string]bool)
+	kinds := make([]string, 0, len(req.Kinds))
+	for _, kind := range req.Kinds {
+		kind = strings.TrimSpace(kind)
+		if !containsKind(knownKinds, kind) {
+			return fmt.Errorf("unknown kind %q", kind)
+		}
+		if !containsKind(allowed, kind) {
+			return fmt.Errorf("kind %q is not allowed to be purged", kind)
+		}
+		if seen[kind] {
+			continue
+		}
+		seen[kind] = true
+		kinds = append(kinds, kind)
+	}
+	req.Kinds = kinds
+	return nil
+}
+
+// ******************************************
+// ** END PurgeKinds
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryRawItem
+// ******************************************
+
+type QueryRawItemReq struct {
+	ItemName string `json:"item_name"`
+}
+
+// QueryRawItem fetches the item entity as stored, with every stock report and the users
+// who made it. It is an admin endpoint since it exposes user IDs.
+func QueryRawItem(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryRawItemReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	req.ItemName = strings.ToLower(strings.TrimSpace(req.ItemName))
+	if req.ItemName == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing item name")
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	var item Item
+	if err := client.Get(ctx, nameKey(ctx, ItemKind, req.ItemName), &item); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return http.StatusNotFound, fmt.Errorf("item %q is not in storage", req.ItemName)
+		}
+		return http.StatusInternalServerError, fmt.Errorf("failed to fetch item %q from storage: %v", req.ItemName, err)
+	}
+
+	if err := EncodeResp(w, &item); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END QueryRawItem
+// ******************************************
+
+// ******************************************
+// ** BEGIN DebugSortStores
+// ******************************************
+
+// maxDebugSortStores caps the number of stores DebugSortStores takes.
+const maxDebugSortStores = 100
+
+type DebugSortStoresReq struct {
+	ZipCode  string   `json:"zip_code"`
+	StoreIDs []string `json:"store_ids"`
+}
+
+type DebugSortStoresResp struct {
+	ZipCode string  `json:"zip_code"`
+	Lat     float64 `json:"latitude"`
+	Long    float64 `json:"longitude"`
+	// Stores are in the order QueryStores lists them for a user in the zip code.
+	Stores []*DebugSortedStore `json:"stores"`
+	// Missing are the requested store IDs that aren't in storage.
+	Missing []string `json:"missing"`
+}
+
+// DebugSortedStore is a store along with its distance from the zip code and its place in
+// the sort order, starting at 1.
+type DebugSortedStore struct {
+	Rank          int     `json:"rank"`
+	StoreID       string  `json:"store_id"`
+	Name          string  `json:"name"`
+	Lat           float64 `json:"latitude"`
+	Long          float64 `json:"longitude"`
+	DistanceMiles float64 `json:"distance_miles"`
+}
+
+// DebugSortStores sorts the stores by distance from a zip code the way QueryStores does and
+// shows the distance it computed for each, to diagnose why a store is listed first. It is an
+// admin endpoint.
+func DebugSortStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req DebugSortStoresReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidateDebugSortStoresReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	found, err := getStoresInStorage(ctx, client, req.StoreIDs)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	stores := make([]*Store, 0, len(found))
+	missing := make([]string, 0)
+	for _, id := range req.StoreIDs {
+		if st, ok := found[id]; ok {
+			stores = append(stores, st)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	resp, err := debugSortStores(stores, req.ZipCode)
+	if err != nil {
+		return zipCodeErrStatus(err), err
+	}
+	resp.Missing = missing
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateDebugSortStoresReq(req *DebugSortStoresReq) error {
+	if err := validateZipCode(req.ZipCode); err != nil {
+		return err
+	}
+	if len(req.StoreIDs) == 0 {
+		return fmt.Errorf("missing store ids")
+	}
+	if len(req.StoreIDs) > maxDebugSortStores {
+		return fmt.Errorf("got %d store ids, want at most %d", len(req.StoreIDs), maxDebugSortStores)
+	}
+	seen := make(map[string]bool, len(req.StoreIDs))
+	ids := make([]string, 0, len(req.StoreIDs))
+	for i, id := range req.StoreIDs {
+		if id == "" {
+			return fmt.Errorf("store id at index %d is empty", i)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	req.StoreIDs = ids
+	return nil
+}
+
+// debugSortStores sorts the stores with sortStoresByDistance and annotates each with its
+// rank and distance from the zip code.
+func debugSortStores(stores []*Store, zipCode string) (*DebugSortStoresResp, error) {
+	if err := sortStoresByDistance(stores, zipCode); err != nil {
+		return nil, err
+	}
+	coords := zipCodeToLatLong[zipCode]
+	resp := &DebugSortStoresResp{
+		ZipCode: zipCode,
+		Lat:     coords.Lat,
+		Long:    coords.Long,
+		Stores:  make([]*DebugSortedStore, 0, len(stores)),
+	}
+	for i, st := range stores {
+		resp.Stores = append(resp.Stores, &DebugSortedStore{
+			Rank:          i + 1,
+			StoreID:       st.StoreID,
+			Name:          st.Name,
+			Lat:           st.Lat,
+			Long:          st.Long,
+			DistanceMiles: Distance(st.Lat, st.Long, coords.Lat, coords.Long),
+		})
+	}
+	return resp, nil
+}
+
+// ******************************************
+// ** END DebugSortStores
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryConfig
+// ******************************************
+
+// QueryConfigResp is the server's effective configuration. Secrets are never included, only
+// whether they are set.
+type QueryConfigResp struct {
+	ProjectID         string            `json:"project_id"`
+	EmulatorHost      string            `json:"emulator_host"`
+	Namespace         string            `json:"namespace"`
+	AllowedClientIDs  []string          `json:"allowed_client_ids"`
+	DefaultClientID   string            `json:"default_client_id"`
+	PurgeAllowedKinds []string          `json:"purge_allowed_kinds"`
+	MaxRequestTimeout string            `json:"max_request_timeout"`
+	ReadHeaderTimeout string            `json:"read_header_timeout"`
+	ReadTimeout       string            `json:"read_timeout"`
+	MinFuzzyQueryLen  int               `json:"min_fuzzy_query_len"`
+	MinReportSeenCnt  int               `json:"min_report_seen_count"`
+	DistanceWorkers   int               `json:"distance_workers"`
+	UploadWorkers     int               `json:"upload_workers"`
+	AddressPatterns   []string          `json:"address_patterns"`
+	ChainPatterns     []string          `json:"chain_patterns"`
+	OutboundBlocked   []string          `json:"outbound_blocked_cidrs"`
+	WebhookAttempts   int               `json:"webhook_max_attempts"`
+	StatsCountsTTL    string            `json:"stats_counts_ttl"`
+	StaticCacheMaxAge string            `json:"static_cache_max_age"`
+	MapPageLimits     pageLimits        `json:"map_page_limits"`
+	StoresPageLimits  pageLimits        `json:"stores_page_limits"`
+	FeedPageLimits    pageLimits        `json:"feed_page_limits"`
+	AutocompleteLimit pageLimits        `json:"autocomplete_limits"`
+	GapsPageLimits    pageLimits        `json:"gaps_page_limits"`
+	GapsRecentHours   int               `json:"gaps_recent_hours"`
+	MaxGapsItems      int               `json:"max_gaps_items"`
+	ChainRecentDays   int               `json:"chain_recent_days"`
+	SeenCountDecay    string            `json:"seen_count_decay"`
+	SeenDecayHours    int               `json:"seen_count_decay_hours"`
+	StatsPageLimits   pageLimits        `json:"stats_page_limits"`
+	QueryCountFlush   string            `json:"query_count_flush_interval"`
+	QueryCountPending int               `json:"query_count_max_pending"`
+	BatchJobSize      int               `json:"batch_job_size"`
+	BatchJobDelay     string            `json:"batch_job_delay"`
+	BatchJobMaxTime   string            `json:"batch_job_max_duration"`
+	BlobStore         string            `json:"blob_store"`
+	BlobBucket        string            `json:"blob_bucket"`
+	MaxPhotoBytes     int               `json:"max_photo_bytes"`
+	MaxReceiptBytes   int               `json:"max_receipt_bytes"`
+	HiddenItemCnt     int               `json:"hidden_item_count"`
+	OTLPEndpoint      string            `json:"otlp_endpoint"`
+	MaxShoppingList   int               `json:"max_shopping_list_items"`
+	MaxReportItemLen  int               `json:"max_report_item_len"`
+	MaxReportItems    int               `json:"max_report_items"`
+	MaxImportReports  int               `json:"max_import_reports"`
+	MaxQueryItemNames int               `json:"max_query_item_names"`
+	MaxRegionZipCodes int               `json:"max_region_zip_codes"`
+	QueryStoresLimit  int               `json:"query_stores_limit"`
+	CoordSources      []string          `json:"coord_sources"`
+	DeletedStoreRpts  string            `json:"deleted_store_reports"`
+	AgeRounding       string            `json:"age_rounding"`
+	MaxReportAgeDays  int               `json:"max_report_age_days"`
+	PruneReportDays   int               `json:"prune_report_age_days"`
+	StrongReads       map[string]bool   `json:"strong_read_endpoints"`
+	Maintenance       bool              `json:"maintenance_mode"`
+	MaintenanceRetry  int               `json:"maintenance_retry_after_sec"`
+	Features          map[string]bool   `json:"features"`
+	Secrets           map[string]string `json:"secrets"`
+}
+
+// secretEnvVars are the env variables whose values QueryConfig must not expose.
+var secretEnvVars = []string{"ADMIN_KEY", "MAPS_CLIENT_API_KEY", "WEBHOOK_SECRET"}
+
+// QueryConfig fetches the server's effective configuration. It is an admin endpoint.
+func QueryConfig(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	resp := effectiveConfig()
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func effectiveConfig() *QueryConfigResp {
+	resp := &QueryConfigResp{
+		ProjectID:         os.Getenv("PROJECT_ID"),
+		EmulatorHost:      os.Getenv("DATASTORE_EMULATOR_HOST"),
+		Namespace:         storageNamespace,
+		AllowedClientIDs:  make([]string, 0, len(allowedClientIDs)),
+		DefaultClientID:   defaultClientID,
+		PurgeAllowedKinds: purgeAllowedKinds,
+		MaxRequestTimeout: maxRequestTimeout.String(),
+		ReadHeaderTimeout: readHeaderTimeout.String(),
+		ReadTimeout:       readTimeout.String(),
+		MinFuzzyQueryLen:  minFuzzyQueryLen,
+		MinReportSeenCnt:  minReportSeenCnt,
+		DistanceWorkers:   distanceWorkers,
+		UploadWorkers:     uploadWorkers,
+		WebhookAttempts:   webhookMaxAttempts,
+		StatsCountsTTL:    statsCountsTTL.String(),
+		StaticCacheMaxAge: staticCacheMaxAge.String(),
+		MapPageLimits:     mapPageLimits,
+		StoresPageLimits:  storesPageLimits,
+		FeedPageLimits:    feedPageLimits,
+		AutocompleteLimit: autocompleteLimits,
+		GapsPageLimits:    gapsPageLimits,
+		GapsRecentHours:   gapsRecentHours,
+		MaxGapsItems:      maxGapsItems,
+		ChainRecentDays:   chainRecentDays,
+		SeenCountDecay:    seenCountDecay,
+		SeenDecayHours:    seenCountDecayHours,
+		StatsPageLimits:   statsPageLimits,
+		QueryCountFlush:   queryCountFlushInterval.String(),
+		QueryCountPending: queryCountMaxPending,
+		BatchJobSize:      jobLimits.Size,
+		BatchJobDelay:     jobLimits.Delay.String(),
+		BatchJobMaxTime:   jobLimits.MaxDuration.String(),
+		BlobStore:         "gcs",
+		BlobBucket:        os.Getenv("BLOB_BUCKET"),
+		MaxPhotoBytes:     photoBlobs.MaxBytes,
+		MaxReceiptBytes:   receiptBlobs.MaxBytes,
+		OTLPEndpoint:      os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		MaxShoppingList:   maxShoppingListItems,
+		MaxReportItemLen:  maxReportItemLen,
+		MaxReportItems:    maxReportItems,
+		MaxImportReports:  maxImportReports,
+		MaxQueryItemNames: maxQueryItemNames,
+		MaxRegionZipCodes: maxRegionZipCodes,
+		QueryStoresLimit:  queryStoresLimit,
+		CoordSources:      coordSources,
+		DeletedStoreRpts:  deletedStoreReports,
+		AgeRounding:       ageRounding,
+		MaxReportAgeDays:  maxReportAgeDays,
+		PruneReportDays:   pruneReportAgeDays,
+		StrongReads:       strongReadEndpoints,
+		Maintenance:       maintenance.enabled(),
+		MaintenanceRetry:  maintenanceRetryAfterSec,
+		Features:          features,
+		Secrets:           make(map[string]string, len(secretEnvVars)),
+	}
+	for id := range allowedClientIDs {
+		resp.AllowedClientIDs = append(resp.AllowedClientIDs, id)
+	}
+	sort.Strings(resp.AllowedClientIDs)
+	for _, re := range addressPatterns {
+		resp.AddressPatterns = append(resp.AddressPatterns, re.String())
+	}
+	for _, p := range chainPatterns {
+		resp.ChainPatterns = append(resp.ChainPatterns, p.String())
+	}
+	for _, n := range outboundBlockedNetworks {
+		resp.OutboundBlocked = append(resp.OutboundBlocked, n.String())
+	}
+	resp.HiddenItemCnt = hiddenItems.len()
+	if _, ok := blobs.(*memBlobStore); ok {
+		resp.BlobStore = "memory"
+	}
+	for _, key := range secretEnvVars {
+		if os.Getenv(key) == "" {
+			resp.Secrets[key] = "unset"
+		} else {
+			resp.Secrets[key] = "set"
+		}
+	}
+	return resp
+}
+
+// ******************************************
+// ** END QueryConfig
+// ******************************************
+
+// purgeKindBatchInStorage deletes up to size entities of the kind and returns how many were
+// deleted.
+func purgeKindBatchInStorage(ctx context.Context, client *datastore.Client, kind string, size int) (int, error) {
+	keys, err := client.GetAll(ctx, newQuery(ctx, kind).KeysOnly().Limit(size), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query %s keys in storage: %v", kind, err)
+	}
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := client.DeleteMulti(ctx, keys[start:end]); err != nil {
+			return start, fmt.Errorf("failed to delete %s entities in storage: %v", kind, err)
+		}
+	}
+	return len(keys), nil
+}
+
+// kindsFromEnv returns the comma-separated kinds in the env variable key, or def if it is
+// unset. An unknown kind stops the server.
+func kindsFromEnv(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var kinds []string
+	for _, kind := range strings.Split(v, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if !containsKind(knownKinds, kind) {
+			log.Fatalf("%s env variable has unknown kind %q", key, kind)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+func containsKind(kinds []string, kind string) bool {
+	for _, k := range kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
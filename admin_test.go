@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCleanAndValidatePurgeKindsReq(t *testing.T) {
+	allowed := []string{ItemKind, ItemAliasKind, WebhookKind}
+
+	req := &PurgeKindsReq{Kinds: []string{" Item ", "Item"}}
+	if err := cleanAndValidatePurgeKindsReq(req, allowed); err != nil {
+		t.Fatalf("cleanAndValidatePurgeKindsReq(Item) failed: %v", err)
+	}
+	// Only the named kind is purged, not every allowed kind.
+	if want := []string{ItemKind}; !reflect.DeepEqual(req.Kinds, want) {
+		t.Errorf("got kinds %q, want %q", req.Kinds, want)
+	}
+
+	for _, kinds := range [][]string{
+		nil,
+		{"Items"},
+		{UserKind},
+		{ItemKind, StoreKind},
+	} {
+		if err := cleanAndValidatePurgeKindsReq(&PurgeKindsReq{Kinds: kinds}, allowed); err == nil {
+			t.Errorf("cleanAndValidatePurgeKindsReq(%q) succeeded, want error", kinds)
+		}
+	}
+}
+
+func TestRawItemJSON(t *testing.T) {
+	item := &Item{
+		Name: "eggs",
+		StockReports: []*StockReport{{
+			UsersInfo:    []*User{{UserID: "user", TimestampSec: 100}},
+			StoreInfo:    &Store{StoreID: "store"},
+			TimestampSec: 100,
+			InStock:      true,
+			SeenCnt:      1,
+		}},
+	}
+	buf, err := json.Marshal(item)
+	if err != nil {
+		t.Fatalf("failed to encode item: %v", err)
+	}
+	var raw struct {
+		Name         string `json:"name"`
+		StockReports []struct {
+			UsersInfo []struct {
+				UserID string `json:"user_id"`
+			} `json:"user_info"`
+			StoreInfo struct {
+				StoreID string `json:"storeId"`
+			} `json:"store_info"`
+			TimestampSec int64 `json:"timestamp_sec"`
+			InStock      bool  `json:"in_stock"`
+			SeenCnt      int   `json:"seen_cnt"`
+		} `json:"stock_report"`
+	}
+	if err := json.Unmarshal(buf, &raw); err != nil {
+		t.Fatalf("failed to decode item: %v", err)
+	}
+	if raw.Name != "eggs" || len(raw.StockReports) != 1 {
+		t.Fatalf("got raw item %s", buf)
+	}
+	sr := raw.StockReports[0]
+	if len(sr.UsersInfo) != 1 || sr.UsersInfo[0].UserID != "user" || sr.StoreInfo.StoreID != "store" || sr.TimestampSec != 100 || !sr.InStock || sr.SeenCnt != 1 {
+		t.Errorf("got raw item %s", buf)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	for key, value := range map[string]string{
+		"PROJECT_ID":          "cv19-shopping-aid-test",
+		"ADMIN_KEY":           "hunter2",
+		"MAPS_CLIENT_API_KEY": "",
+	} {
+		orig, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		defer func(key string) {
+			if ok {
+				os.Setenv(key, orig)
+			} else {
+				os.Unsetenv(key)
+			}
+		}(key)
+	}
+
+	cfg := effectiveConfig()
+	if cfg.ProjectID != "cv19-shopping-aid-test" {
+		t.Errorf("got project id %q, want the PROJECT_ID env variable", cfg.ProjectID)
+	}
+	if cfg.Secrets["ADMIN_KEY"] != "set" || cfg.Secrets["MAPS_CLIENT_API_KEY"] != "unset" {
+		t.Errorf("got secrets %v, want ADMIN_KEY set and MAPS_CLIENT_API_KEY unset", cfg.Secrets)
+	}
+
+	buf, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("failed to encode config: %v", err)
+	}
+	if strings.Contains(string(buf), "hunter2") {
+		t.Errorf("config %s exposes the admin key", buf)
+	}
+}
+
+func TestDebugSortStores(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "tacoma", Name: "Safeway", Lat: 47.25, Long: -122.44},
+		{StoreID: "downtown", Name: "QFC", Lat: 47.615, Long: -122.335},
+		{StoreID: "fremont", Name: "PCC", Lat: 47.651, Long: -122.35},
+	}
+	coords := zipCodeToLatLong["98101"]
+	want := map[string]float64{}
+	for _, st := range stores {
+		want[st.StoreID] = Distance(st.Lat, st.Long, coords.Lat, coords.Long)
+	}
+
+	resp, err := debugSortStores(stores, "98101")
+	if err != nil {
+		t.Fatalf("debugSortStores() failed: %v", err)
+	}
+	if resp.Lat != coords.Lat || resp.Long != coords.Long {
+		t.Errorf("got coords (%v, %v), want %v", resp.Lat, resp.Long, coords)
+	}
+	var order []string
+	for i, st := range resp.Stores {
+		order = append(order, st.StoreID)
+		if st.Rank != i+1 {
+			t.Errorf("store %q has rank %d, want %d", st.StoreID, st.Rank, i+1)
+		}
+		if st.DistanceMiles != want[st.StoreID] {
+			t.Errorf("store %q is %v miles away, want %v", st.StoreID, st.DistanceMiles, want[st.StoreID])
+		}
+		if i > 0 && resp.Stores[i-1].DistanceMiles > st.DistanceMiles {
+			t.Errorf("store %q is closer than %q but sorted after it", st.StoreID, resp.Stores[i-1].StoreID)
+		}
+	}
+	if wantOrder := []string{"downtown", "fremont", "tacoma"}; !reflect.DeepEqual(order, wantOrder) {
+		t.Errorf("got order %v, want %v", order, wantOrder)
+	}
+
+	if _, err := debugSortStores(stores, "00000"); zipCodeErrStatus(err) != 400 {
+		t.Errorf("debugSortStores() with an unsupported zip code got error %v, want a bad request", err)
+	}
+}
+
+func TestCleanAndValidateDebugSortStoresReq(t *testing.T) {
+	req := &DebugSortStoresReq{ZipCode: "98101", StoreIDs: []string{"a", "b", "a"}}
+	if err := cleanAndValidateDebugSortStoresReq(req); err != nil {
+		t.Fatalf("cleanAndValidateDebugSortStoresReq() failed: %v", err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(req.StoreIDs, want) {
+		t.Errorf("got store ids %v, want duplicates dropped: %v", req.StoreIDs, want)
+	}
+	for _, bad := range []*DebugSortStoresReq{
+		{ZipCode: "98101"},
+		{ZipCode: "", StoreIDs: []string{"a"}},
+		{ZipCode: "98101", StoreIDs: []string{"a", ""}},
+	} {
+		if err := cleanAndValidateDebugSortStoresReq(bad); err == nil {
+			t.Errorf("cleanAndValidateDebugSortStoresReq(%+v) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ItemAlias routes a variant item name (the key) to its canonical item name.
+type ItemAlias struct {
+	Alias     string `datastore:"alias" json:"alias"`
+	Canonical string `datastore:"canonical" json:"canonical"`
+}
+
+// ******************************************
+// ** BEGIN AliasItem
+// ******************************************
+
+type AliasItemReq struct {
+	Alias     string `json:"alias"`
+	Canonical string `json:"canonical"`
+}
+
+// AliasItem merges the alias item's stock reports into the canonical item and records the
+// alias so that later uploads and queries of the alias use the canonical item. It is an
+// admin endpoint. Aliases must point at a canonical name, never at another alias, so that
+// resolving an alias takes a single lookup.
+func AliasItem(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req AliasItemReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidateAliasItemReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	// Queries can't run in the transaction, so an alias added to the alias item meanwhile
+	// isn't caught. That takes two admins aliasing at once.
+	q := newQuery(ctx, ItemAliasKind).Filter("canonical =", req.Alias).KeysOnly().Limit(1)
+	if keys, err := client.GetAll(ctx, q, nil); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to query for aliases of item %q: %v", req.Alias, err)
+	} else if len(keys) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("alias item %q is the canonical item of other aliases", req.Alias)
+	}
+
+	aliasKey := nameKey(ctx, ItemKind, req.Alias)
+	canonicalKey := nameKey(ctx, ItemKind, req.Canonical)
+	if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var canonicalAlias ItemAlias
+		if err := tx.Get(nameKey(ctx, ItemAliasKind, req.Canonical), &canonicalAlias); err == nil {
+			return errCanonicalIsAlias
+		} else if err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("failed to look up item alias %q in storage: %v", req.Canonical, err)
+		}
+
+		var alias, canonical Item
+		if err := tx.Get(aliasKey, &alias); err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("failed to fetch item %q from storage: %v", req.Alias, err)
+		}
+		if err := tx.Get(canonicalKey, &canonical); err != nil {
+			if err != datastore.ErrNoSuchEntity {
+				return fmt.Errorf("failed to fetch item %q from storage: %v", req.Canonical, err)
+			}
+			canonical.Name = req.Canonical
+		}
+
+		if len(alias.StockReports) > 0 {
+			canonical.StockReports = mergeStockReports(canonical.StockReports, alias.StockReports)
+			if _, err := tx.Put(canonicalKey, &canonical); err != nil {
+				return fmt.Errorf("failed to update item %q in storage: %v", req.Canonical, err)
+			}
+		}
+		if err := tx.Delete(aliasKey); err != nil {
+			return fmt.Errorf("failed to delete item %q in storage: %v", req.Alias, err)
+		}
+		aliasRecord := &ItemAlias{Alias: req.Alias, Canonical: req.Canonical}
+		if _, err := tx.Put(nameKey(ctx, ItemAliasKind, req.Alias), aliasRecord); err != nil {
+			return fmt.Errorf("failed to create item alias in storage: %v", err)
+		}
+		return nil
+	}); err == errCanonicalIsAlias {
+		return http.StatusBadRequest, fmt.Errorf("canonical item %q is itself an alias", req.Canonical)
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// errCanonicalIsAlias is returned from the AliasItem transaction when the canonical item
+// turns out to be an alias.
+var errCanonicalIsAlias = fmt.Errorf("canonical item is an alias")
+
+func cleanAndValidateAliasItemReq(req *AliasItemReq) error {
+	req.Alias = strings.ToLower(strings.TrimSpace(req.Alias))
+	req.Canonical = strings.ToLower(strings.TrimSpace(req.Canonical))
+	if req.Alias == "" {
+		return fmt.Errorf("missing alias item name")
+	}
+	if req.Canonical == "" {
+		return fmt.Errorf("missing canonical item name")
+	}
+	if name, ok := resolveItemName(req.Canonical); ok {
+		req.Canonical = name
+	}
+	if req.Alias == req.Canonical {
+		return fmt.Errorf("alias and canonical item names are the same")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END AliasItem
+// ******************************************
+
+// mergeStockReports merges the src reports into dst. Reports for the same store and stock
+// status are combined: their users are unioned, the seen count is the number of distinct
+// users, and the timestamp and level are those of the latest of the two.
+func mergeStockReports(dst, src []*StockReport) []*StockReport {
+	for _, s := range src {
+		merged := false
+		for _, d := range dst {
+			if d.StoreInfo.StoreID != s.StoreInfo.StoreID || d.InStock != s.InStock || d.Unknown != s.Unknown {
+				continue
+			}
+			seen := make(map[string]bool, len(d.UsersInfo))
+			for _, u := range d.UsersInfo {
+				seen[u.UserID] = true
+			}
+			for _, u := range s.UsersInfo {
+				if !seen[u.UserID] {
+					seen[u.UserID] = true
+					d.UsersInfo = append(d.UsersInfo, u)
+				}
+			}
+			d.SeenCnt = len(d.UsersInfo)
+			if s.PhotoURL != "" && (d.PhotoURL == "" || s.TimestampSec > d.TimestampSec) {
+				d.PhotoURL = s.PhotoURL
+			}
+			if s.TimestampSec > d.TimestampSec {
+				d.TimestampSec = s.TimestampSec
+				d.Level = s.Level
+			}
+			merged = true
+			break
+		}
+		if !merged {
+			dst = append(dst, s)
+		}
+	}
+	return dst
+}
+
+// getItemAliases looks up which of the item names are aliases. The result maps each alias
+// to its canonical name and omits names that aren't aliases.
+func getItemAliases(ctx context.Context, client *datastore.Client, names []string) (map[string]string, error) {
+	keys := make([]*datastore.Key, len(names))
+	for i, name := range names {
+		keys[i] = nameKey(ctx, ItemAliasKind, name)
+	}
+	aliases := make([]ItemAlias, len(names))
+	err := getMulti(ctx, client, keys, aliases)
+	merr, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		return nil, fmt.Errorf("failed to look up item aliases in storage: %v", err)
+	}
+
+	res := make(map[string]string)
+	for i, name := range names {
+		if isMultiErr && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue // not an alias
+			}
+			return nil, fmt.Errorf("failed to look up item alias %q in storage: %v", name, merr[i])
+		}
+		res[name] = aliases[i].Canonical
+	}
+	return res, nil
+}
+
+// applyItemAliases replaces aliased item names with their canonical names. Names already in
+// seen are dropped so an alias and its canonical name aren't both kept; the kept names are
+// added to seen.
+func applyItemAliases(names []string, aliases map[string]string, seen map[string]bool) []string {
+	res := make([]string, 0, len(names))
+	for _, name := range names {
+		if canonical, ok := aliases[name]; ok {
+			name = canonical
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		res = append(res, name)
+	}
+	return res
+}
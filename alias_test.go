@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeStockReports(t *testing.T) {
+	kirkland := &Store{StoreID: "kirkland"}
+	seattle := &Store{StoreID: "seattle"}
+	canonical := []*StockReport{
+		{StoreInfo: kirkland, InStock: true, SeenCnt: 2, TimestampSec: 100,
+			UsersInfo: []*User{{UserID: "tony"}, {UserID: "peter"}}},
+	}
+	alias := []*StockReport{
+		// Same store and status: users are unioned.
+		{StoreInfo: kirkland, InStock: true, SeenCnt: 2, TimestampSec: 200,
+			UsersInfo: []*User{{UserID: "peter"}, {UserID: "steve"}}},
+		// Different status: kept as its own report.
+		{StoreInfo: kirkland, InStock: false, SeenCnt: 1, TimestampSec: 150,
+			UsersInfo: []*User{{UserID: "bruce"}}},
+		{StoreInfo: seattle, InStock: true, SeenCnt: 1, TimestampSec: 50,
+			UsersInfo: []*User{{UserID: "tony"}}},
+	}
+
+	merged := mergeStockReports(canonical, alias)
+	if len(merged) != 3 {
+		t.Fatalf("got %d reports, want 3", len(merged))
+	}
+	got := merged[0]
+	if got.SeenCnt != 3 || len(got.UsersInfo) != 3 || got.TimestampSec != 200 {
+		t.Errorf("got merged report with SeenCnt %d, %d users, timestamp %d, want 3, 3, 200",
+			got.SeenCnt, len(got.UsersInfo), got.TimestampSec)
+	}
+	if merged[1].InStock || merged[2].StoreInfo.StoreID != "seattle" {
+		t.Errorf("unmatched alias reports were not appended as-is")
+	}
+}
+
+func TestApplyItemAliases(t *testing.T) {
+	aliases := map[string]string{"paper towel": "paper towels", "tp": "toilet paper"}
+	seen := make(map[string]bool)
+
+	inStock := applyItemAliases([]string{"paper towel", "flour", "paper towels"}, aliases, seen)
+	outStock := applyItemAliases([]string{"tp", "flour"}, aliases, seen)
+
+	if want := []string{"paper towels", "flour"}; !reflect.DeepEqual(inStock, want) {
+		t.Errorf("got in-stock %q, want %q", inStock, want)
+	}
+	if want := []string{"toilet paper"}; !reflect.DeepEqual(outStock, want) {
+		t.Errorf("got out-stock %q, want %q", outStock, want)
+	}
+}
+
+func TestCleanAndValidateAliasItemReq(t *testing.T) {
+	req := &AliasItemReq{Alias: " TP ", Canonical: "Paper Toilet"}
+	if err := cleanAndValidateAliasItemReq(req); err != nil {
+		t.Fatalf("cleanAndValidateAliasItemReq() failed: %v", err)
+	}
+	if req.Alias != "tp" || req.Canonical != "toilet paper" {
+		t.Errorf("got alias %q of %q, want tp of the catalog item toilet paper", req.Alias, req.Canonical)
+	}
+
+	for _, req := range []*AliasItemReq{
+		{Canonical: "toilet paper"},
+		{Alias: "tp"},
+		{Alias: "toilet paper", Canonical: "paper toilet"},
+	} {
+		if err := cleanAndValidateAliasItemReq(req); err == nil {
+			t.Errorf("cleanAndValidateAliasItemReq(%+v) succeeded, want error", req)
+		}
+	}
+}
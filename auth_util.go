@@ -0,0 +1,24 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+const adminKeyHeader = "X-Admin-Key"
+
+// ValidateAdmin checks that the request carries the admin key configured for the deployment.
+// Admin endpoints are disabled entirely when no admin key is configured.
+func ValidateAdmin(r *http.Request) error {
+	adminKey := os.Getenv("ADMIN_KEY") // See GCP console for admin key
+	if adminKey == "" {
+		return fmt.Errorf("admin endpoints are disabled")
+	}
+	got := r.Header.Get(adminKeyHeader)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(adminKey)) != 1 {
+		return fmt.Errorf("admin key is invalid")
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// autocompleteLimits are the limits on the number of item names ItemAutocomplete returns,
+// set with the AUTOCOMPLETE_DEFAULT_LIMIT and AUTOCOMPLETE_MAX_LIMIT env variables.
+var autocompleteLimits = pageLimitsFromEnv("AUTOCOMPLETE", pageLimits{Default: 10, Max: 50})
+
+// How well an item name matches an autocomplete prefix, best first.
+const (
+	nameStartsWithPrefix = iota
+	tokenStartsWithPrefix
+	nameContainsPrefix
+)
+
+// ******************************************
+// ** BEGIN ItemAutocomplete
+// ******************************************
+
+type ItemAutocompleteReq struct {
+	UserID string `json:"user_id"`
+	Prefix string `json:"prefix"`
+	// Limit caps the number of item names returned. It defaults to autocompleteLimits.Default.
+	Limit int `json:"limit"`
+}
+
+type ItemAutocompleteResp []string
+
+// ItemAutocomplete completes the prefix a user typed to catalog item names, so that clients
+// don't have to download every item token with QueryItemTokens to do it themselves. Names
+// are ranked by how they match with autocompleteItems.
+func ItemAutocomplete(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ItemAutocompleteReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateItemAutocompleteReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	resp := autocompleteItems(req.Prefix, req.Limit)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateItemAutocompleteReq(req *ItemAutocompleteReq) error {
+	req.Prefix = strings.ToLower(req.Prefix)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.Prefix == "" {
+		return fmt.Errorf("missing prefix")
+	}
+	if req.Limit < 0 || req.Limit > autocompleteLimits.Max {
+		return fmt.Errorf("limit must be between 0 and %d", autocompleteLimits.Max)
+	}
+	if req.Limit == 0 {
+		req.Limit = autocompleteLimits.Default
+	}
+	return nil
+}
+
+// ******************************************
+// ** END ItemAutocomplete
+// ******************************************
+
+// autocompleteItems returns up to limit catalog item names that match the lowercase prefix.
+// Names that start with the prefix come first, then names with a token that starts with it,
+// then names that contain it anywhere. Within each, shorter names come first, then by name.
+// Hidden items are left out.
+func autocompleteItems(prefix string, limit int) ItemAutocompleteResp {
+	type match struct {
+		name string
+		rank int
+	}
+	var matches []match
+	for i, name := range itemNames {
+		if hiddenItems.has(name) {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(name, prefix):
+			matches = append(matches, match{name, nameStartsWithPrefix})
+		case tokensStartWith(itemTokens[i], prefix):
+			matches = append(matches, match{name, tokenStartsWithPrefix})
+		case strings.Contains(name, prefix):
+			matches = append(matches, match{name, nameContainsPrefix})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		if len(matches[i].name) != len(matches[j].name) {
+			return len(matches[i].name) < len(matches[j].name)
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	resp := make(ItemAutocompleteResp, 0, limit)
+	for _, m := range matches {
+		if len(resp) == limit {
+			break
+		}
+		resp = append(resp, m.name)
+	}
+	return resp
+}
+
+// tokensStartWith reports whether any of the tokens starts with the prefix.
+func tokensStartWith(tokens Tokens, prefix string) bool {
+	for _, t := range tokens {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAutocompleteItems(t *testing.T) {
+	if got := autocompleteItems("toi", 10); len(got) == 0 || got[0] != "toilet paper" {
+		t.Errorf("autocompleteItems(toi) = %q, want toilet paper first", got)
+	}
+
+	got := autocompleteItems("ric", autocompleteLimits.Max)
+	if len(got) != autocompleteLimits.Max {
+		t.Fatalf("got %d completions of ric, want the max %d", len(got), autocompleteLimits.Max)
+	}
+	if got[0] != "rice" {
+		t.Errorf("got %q as the best completion of ric, want rice", got[0])
+	}
+	rank := make(map[string]int, len(got))
+	for i, name := range got {
+		rank[name] = i
+	}
+	// Names that start with ric, then names with a token that does, then the rest.
+	for _, pair := range [][2]string{{"ricotta cheese", "basmati rice"}, {"rice wine vinegar", "brown rice"}} {
+		better, worse := pair[0], pair[1]
+		if _, ok := rank[better]; !ok {
+			t.Errorf("got %q, want it to include %q", got, better)
+			continue
+		}
+		if r, ok := rank[worse]; ok && r < rank[better] {
+			t.Errorf("got %q ahead of %q", worse, better)
+		}
+	}
+
+	if got := autocompleteItems("ric", 3); len(got) != 3 {
+		t.Errorf("got %d completions with limit 3, want 3", len(got))
+	}
+
+	hiddenItems.set("toilet paper", true)
+	defer hiddenItems.set("toilet paper", false)
+	for _, name := range autocompleteItems("toi", 10) {
+		if name == "toilet paper" {
+			t.Errorf("got hidden item toilet paper as a completion")
+		}
+	}
+}
+
+func TestCleanAndValidateItemAutocompleteReq(t *testing.T) {
+	req := &ItemAutocompleteReq{UserID: "u", Prefix: "RIC"}
+	if err := cleanAndValidateItemAutocompleteReq(req); err != nil {
+		t.Fatalf("cleanAndValidateItemAutocompleteReq() = %v, want ok", err)
+	}
+	if req.Prefix != "ric" || req.Limit != autocompleteLimits.Default {
+		t.Errorf("got prefix %q and limit %d, want ric and the default %d", req.Prefix, req.Limit, autocompleteLimits.Default)
+	}
+	for _, req := range []*ItemAutocompleteReq{
+		{UserID: "u"},
+		{UserID: "u", Prefix: "ric", Limit: autocompleteLimits.Max + 1},
+		{Prefix: "ric"},
+	} {
+		if err := cleanAndValidateItemAutocompleteReq(req); err == nil {
+			t.Errorf("cleanAndValidateItemAutocompleteReq(%+v) succeeded, want error", req)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// batchLimits throttles admin batch jobs, such as revetting stores or purging kinds, so they
+// don't overwhelm storage or the Places API.
+type batchLimits struct {
+	// Size is the most entities a job handles per batch.
+	Size int
+	// Delay is the pause between batches.
+	Delay time.Duration
+	// MaxDuration is how long a job runs before it yields. A job that yields reports that it
+	// isn't done, and running it again picks up where it left off.
+	MaxDuration time.Duration
+}
+
+// jobLimits are the limits of every admin batch job, set with the BATCH_JOB_SIZE,
+// BATCH_JOB_DELAY_MS, and BATCH_JOB_MAX_DURATION_MS env variables.
+var jobLimits = batchLimits{
+	Size:        positiveIntFromEnv("BATCH_JOB_SIZE", 100),
+	Delay:       time.Duration(positiveIntFromEnv("BATCH_JOB_DELAY_MS", 200)) * time.Millisecond,
+	MaxDuration: time.Duration(positiveIntFromEnv("BATCH_JOB_MAX_DURATION_MS", 20000)) * time.Millisecond,
+}
+
+// batchNow is the clock batch jobs are timed with. Tests replace it.
+var batchNow = time.Now
+
+// BatchProgress is how far a batch job got.
+type BatchProgress struct {
+	Processed int `json:"processed"`
+	Batches   int `json:"batches"`
+	// Done is false if the job yielded before handling every entity.
+	Done bool `json:"done"`
+}
+
+// batchFunc handles the next batch of at most size entities. It returns the number of
+// entities handled and whether none are left.
+type batchFunc func(ctx context.Context, size int) (n int, done bool, err error)
+
+// runBatches calls next until it's done, pausing between batches and yielding once the
+// limits' max duration has passed or ctx is done. The name is only used to log progress.
+func runBatches(ctx context.Context, name string, limits batchLimits, next batchFunc) (*BatchProgress, error) {
+	progress := &BatchProgress{}
+	start := batchNow()
+	for {
+		n, done, err := next(ctx, limits.Size)
+		progress.Processed += n
+		progress.Batches++
+		if err != nil {
+			return progress, err
+		}
+		log.Printf("%s: batch %d handled %d entities, %d in total", name, progress.Batches, n, progress.Processed)
+		if done {
+			progress.Done = true
+			return progress, nil
+		}
+		if batchNow().Sub(start) >= limits.MaxDuration {
+			log.Printf("%s: yielding after %v", name, limits.MaxDuration)
+			return progress, nil
+		}
+		select {
+		case <-ctx.Done():
+			return progress, nil
+		case <-time.After(limits.Delay):
+		}
+	}
+}
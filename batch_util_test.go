@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunBatchesSize(t *testing.T) {
+	remaining := 25
+	var sizes []int
+	progress, err := runBatches(context.Background(), "test", batchLimits{Size: 10, MaxDuration: time.Hour}, func(ctx context.Context, size int) (int, bool, error) {
+		sizes = append(sizes, size)
+		n := size
+		if n > remaining {
+			n = remaining
+		}
+		remaining -= n
+		return n, remaining == 0, nil
+	})
+	if err != nil {
+		t.Fatalf("runBatches failed: %v", err)
+	}
+	for _, size := range sizes {
+		if size != 10 {
+			t.Errorf("got batch size %d, want 10", size)
+		}
+	}
+	if progress.Processed != 25 || progress.Batches != 3 || !progress.Done {
+		t.Errorf("got progress %+v, want 25 processed in 3 batches and done", progress)
+	}
+}
+
+func TestRunBatchesMaxDuration(t *testing.T) {
+	now := time.Unix(0, 0)
+	batchNow = func() time.Time { return now }
+	t.Cleanup(func() { batchNow = time.Now })
+
+	// Each batch takes a minute, so a job capped at 3 minutes yields after its third batch.
+	limits := batchLimits{Size: 1, MaxDuration: 3 * time.Minute}
+	progress, err := runBatches(context.Background(), "test", limits, func(ctx context.Context, size int) (int, bool, error) {
+		now = now.Add(time.Minute)
+		return size, false, nil
+	})
+	if err != nil {
+		t.Fatalf("runBatches failed: %v", err)
+	}
+	if progress.Batches != 3 || progress.Processed != 3 || progress.Done {
+		t.Errorf("got progress %+v, want 3 batches and not done", progress)
+	}
+}
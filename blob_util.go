@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// BlobStore stores uploaded files, such as report photos, by name.
+type BlobStore interface {
+	Put(ctx context.Context, name, contentType string, data []byte) error
+	// GetURL returns the URL the named blob is served from.
+	GetURL(ctx context.Context, name string) (string, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// blobs is where uploaded files are stored. The BLOB_STORE env variable picks the backend:
+// "gcs" (the default) stores them in the BLOB_BUCKET bucket, and "memory" keeps them in
+// memory, which is only meant for tests and local development.
+var blobs = blobStoreFromEnv()
+
+func blobStoreFromEnv() BlobStore {
+	switch v := os.Getenv("BLOB_STORE"); v {
+	case "", "gcs":
+		return &gcsBlobStore{bucket: os.Getenv("BLOB_BUCKET")}
+	case "memory":
+		return newMemBlobStore()
+	default:
+		log.Fatalf("BLOB_STORE env variable must be gcs or memory: %q", v)
+		return nil
+	}
+}
+
+// blobPolicy limits the files accepted for one use.
+type blobPolicy struct {
+	MaxBytes int
+	// Exts maps each accepted content type to its file extension.
+	Exts map[string]string
+}
+
+var (
+	photoBlobs = &blobPolicy{
+		MaxBytes: positiveIntFromEnv("MAX_PHOTO_BYTES", 5<<20),
+		Exts:     map[string]string{"image/jpeg": ".jpg", "image/png": ".png"},
+	}
+	receiptBlobs = &blobPolicy{
+		MaxBytes: positiveIntFromEnv("MAX_RECEIPT_BYTES", 10<<20),
+		Exts:     map[string]string{"image/jpeg": ".jpg", "image/png": ".png"},
+	}
+)
+
+// validate checks the file's size and sniffs its content type, which must be one the policy
+// accepts.
+func (p *blobPolicy) validate(data []byte) (string, error) {
+	if len(data) > p.MaxBytes {
+		return "", fmt.Errorf("file is larger than %d bytes", p.MaxBytes)
+	}
+	contentType := http.DetectContentType(data)
+	if _, ok := p.Exts[contentType]; !ok {
+		return "", fmt.Errorf("file content type %q is not supported", contentType)
+	}
+	return contentType, nil
+}
+
+// putBlob validates the file against the policy, stores it as name plus the extension of its
+// content type, and returns its URL.
+func putBlob(ctx context.Context, bs BlobStore, p *blobPolicy, name string, data []byte) (string, error) {
+	contentType, err := p.validate(data)
+	if err != nil {
+		return "", err
+	}
+	name += p.Exts[contentType]
+	if err := bs.Put(ctx, name, contentType, data); err != nil {
+		return "", err
+	}
+	return bs.GetURL(ctx, name)
+}
+
+// gcsBlobStore stores blobs in a Google Cloud Storage bucket. The bucket must be publicly
+// readable for the blob URLs to load.
+type gcsBlobStore struct {
+	bucket string
+}
+
+func (s *gcsBlobStore) service(ctx context.Context) (*storage.Service, error) {
+	if s.bucket == "" {
+		return nil, fmt.Errorf("blob bucket env variable is not set")
+	}
+	svc, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud storage client: %v", err)
+	}
+	return svc, nil
+}
+
+func (s *gcsBlobStore) Put(ctx context.Context, name, contentType string, data []byte) error {
+	svc, err := s.service(ctx)
+	if err != nil {
+		return err
+	}
+	obj := &storage.Object{Name: name, ContentType: contentType}
+	if _, err := svc.Objects.Insert(s.bucket, obj).Media(bytes.NewReader(data)).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to put blob %q in cloud storage: %v", name, err)
+	}
+	return nil
+}
+
+func (s *gcsBlobStore) GetURL(ctx context.Context, name string) (string, error) {
+	if s.bucket == "" {
+		return "", fmt.Errorf("blob bucket env variable is not set")
+	}
+	u := url.URL{Scheme: "https", Host: "storage.googleapis.com", Path: "/" + s.bucket + "/" + name}
+	return u.String(), nil
+}
+
+func (s *gcsBlobStore) Delete(ctx context.Context, name string) error {
+	svc, err := s.service(ctx)
+	if err != nil {
+		return err
+	}
+	if err := svc.Objects.Delete(s.bucket, name).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("failed to delete blob %q in cloud storage: %v", name, err)
+	}
+	return nil
+}
+
+// memBlobStore keeps blobs in memory.
+type memBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string]*memBlob
+}
+
+type memBlob struct {
+	ContentType string
+	Data        []byte
+}
+
+func newMemBlobStore() *memBlobStore {
+	return &memBlobStore{blobs: make(map[string]*memBlob)}
+}
+
+func (s *memBlobStore) Put(ctx context.Context, name, contentType string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blobs[name] = &memBlob{ContentType: contentType, Data: append([]byte{}, data...)}
+	return nil
+}
+
+func (s *memBlobStore) GetURL(ctx context.Context, name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.blobs[name]; !ok {
+		return "", fmt.Errorf("blob %q does not exist", name)
+	}
+	return "memory:///" + name, nil
+}
+
+func (s *memBlobStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.blobs, name)
+	return nil
+}
+
+// get returns the named blob, or nil if it doesn't exist.
+func (s *memBlobStore) get(name string) *memBlob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blobs[name]
+}
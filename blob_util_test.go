@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A")
+
+func TestPutBlob(t *testing.T) {
+	ctx := context.Background()
+	bs := newMemBlobStore()
+	p := &blobPolicy{MaxBytes: 64, Exts: map[string]string{"image/png": ".png"}}
+
+	url, err := putBlob(ctx, bs, p, "dir/photo", pngHeader)
+	if err != nil {
+		t.Fatalf("putBlob failed: %v", err)
+	}
+	if url != "memory:///dir/photo.png" {
+		t.Errorf("got url %q, want memory:///dir/photo.png", url)
+	}
+	b := bs.get("dir/photo.png")
+	if b == nil || b.ContentType != "image/png" || !bytes.Equal(b.Data, pngHeader) {
+		t.Fatalf("got blob %+v, want the png", b)
+	}
+
+	if err := bs.Delete(ctx, "dir/photo.png"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := bs.GetURL(ctx, "dir/photo.png"); err == nil {
+		t.Error("GetURL of a deleted blob succeeded, want error")
+	}
+}
+
+func TestBlobPolicyValidate(t *testing.T) {
+	p := &blobPolicy{MaxBytes: 64, Exts: map[string]string{"image/png": ".png"}}
+	for name, data := range map[string][]byte{
+		"text":      []byte("not an image"),
+		"jpeg":      []byte("\xFF\xD8\xFF"),
+		"oversized": append(append([]byte{}, pngHeader...), make([]byte, 64)...),
+	} {
+		if _, err := p.validate(data); err == nil {
+			t.Errorf("validate(%s) succeeded, want error", name)
+		}
+	}
+	if got, err := p.validate(pngHeader); err != nil || got != "image/png" {
+		t.Errorf("validate(png) = %q, %v, want image/png", got, err)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultChainRadiusMiles = 10.0
+	maxChainRadiusMiles     = 50.0
+)
+
+// chainRecentDays is how recent a report must be to count toward a chain's availability,
+// set with the CHAIN_RECENT_DAYS env variable.
+var chainRecentDays = positiveIntFromEnv("CHAIN_RECENT_DAYS", 7)
+
+// The curves that seenCountDecay can weigh down the seen count of older reports with in
+// availability aggregates. Stored seen counts are never changed.
+const (
+	// seenDecayNone weighs every report by its seen count.
+	seenDecayNone = "none"
+	// seenDecayLinear weighs a report down linearly until it has no weight at
+	// seenCountDecayHours old.
+	seenDecayLinear = "linear"
+	// seenDecayExponential halves a report's weight every seenCountDecayHours.
+	seenDecayExponential = "exponential"
+)
+
+// seenCountDecay is the decay curve of the seen counts in availability aggregates, set with
+// the SEEN_COUNT_DECAY env variable, and seenCountDecayHours its scale, set with the
+// SEEN_COUNT_DECAY_HOURS env variable.
+var (
+	seenCountDecay      = seenCountDecayFromEnv("SEEN_COUNT_DECAY")
+	seenCountDecayHours = positiveIntFromEnv("SEEN_COUNT_DECAY_HOURS", 72)
+)
+
+func seenCountDecayFromEnv(key string) string {
+	switch v := os.Getenv(key); v {
+	case "":
+		return seenDecayNone
+	case seenDecayNone, seenDecayLinear, seenDecayExponential:
+		return v
+	default:
+		log.Fatalf("%s env variable must be %s, %s, or %s: %q", key, seenDecayNone, seenDecayLinear, seenDecayExponential, v)
+		return ""
+	}
+}
+
+// seenWeight returns how much a report seen seenCnt times, ageSec ago, weighs in an aggregate
+// under the decay curve with a scale of decayHours. A report counts at least once.
+func seenWeight(seenCnt int, ageSec int64, curve string, decayHours int) float64 {
+	if seenCnt < 1 {
+		seenCnt = 1
+	}
+	if ageSec < 0 {
+		ageSec = 0
+	}
+	age := float64(ageSec) / float64(decayHours*secondsToHour)
+	switch curve {
+	case seenDecayLinear:
+		return float64(seenCnt) * math.Max(0, 1-age)
+	case seenDecayExponential:
+		return float64(seenCnt) * math.Pow(0.5, age)
+	default:
+		return float64(seenCnt)
+	}
+}
+
+// ******************************************
+// ** BEGIN QueryChainAvailability
+// ******************************************
+
+type QueryChainAvailabilityReq struct {
+	UserID      string  `json:"user_id"`
+	ZipCode     string  `json:"zip_code"`
+	RadiusMiles float64 `json:"radius_miles"`
+	// ItemNames limits the availability to the items. All reported items are included if
+	// it's empty.
+	ItemNames []string `json:"item_names"`
+}
+
+type QueryChainAvailabilityResp []*ChainAvailability
+
+// ChainAvailability is how often the items were reported in stock across a chain's stores in
+// a region.
+type ChainAvailability struct {
+	Chain string `json:"chain"`
+	// StoreCnt is the number of the chain's stores in the region, reported on or not.
+	StoreCnt int                      `json:"store_count"`
+	Items    []*ChainItemAvailability `json:"items"`
+}
+
+// ChainItemAvailability counts the recent reports on an item at a chain's stores. Each
+// sighting of a report counts, so a report seen by three users counts three times. The
+// in-stock rate weighs the sightings down by their age under seenCountDecay.
+type ChainItemAvailability struct {
+	ItemName    string  `json:"item_name"`
+	InStockCnt  int     `json:"in_stock_count"`
+	ReportCnt   int     `json:"report_count"`
+	InStockRate float64 `json:"in_stock_rate"`
+}
+
+// QueryChainAvailability aggregates the recent in-stock rate of each item across the stores
+// of each chain within a radius of a zip code, so users can compare chains. Stores are
+// grouped by normalizeChainName. The zip code defaults to the user's zip code.
+func QueryChainAvailability(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryChainAvailabilityReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryChainAvailabilityReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	if req.ZipCode == "" {
+		req.ZipCode = u.ZipCode
+	}
+	coords, err := zipCodeCoords(req.ZipCode)
+	if err != nil {
+		return zipCodeErrStatus(err), err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	stores, err := loadAllStores(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	var items []*Item
+	if len(req.ItemNames) > 0 {
+		found, err := getItemsInStorage(ctx, client, req.ItemNames)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		for _, name := range req.ItemNames {
+			if item, ok := found[name]; ok {
+				items = append(items, item)
+			}
+		}
+	} else if items, err = loadAllItems(ctx, client); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -chainRecentDays).Unix()
+	resp := chainAvailability(stores, items, coords, req.RadiusMiles, cutoff, now.Unix())
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryChainAvailabilityReq(req *QueryChainAvailabilityReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxChainRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxChainRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultChainRadiusMiles
+	}
+	if len(req.ItemNames) > maxReportItems {
+		return fmt.Errorf("got %d item names, want at most %d", len(req.ItemNames), maxReportItems)
+	}
+	names := make([]string, 0, len(req.ItemNames))
+	for i, name := range req.ItemNames {
+		name = strings.ToLower(name)
+		if name == "" {
+			return fmt.Errorf("item name at index %d is empty", i)
+		}
+		names = append(names, name)
+	}
+	req.ItemNames = uniqueStrings(names)
+	return nil
+}
+
+// ******************************************
+// ** END QueryChainAvailability
+// ******************************************
+
+var (
+	// chainLocationSuffix matches a location qualifier after a store's brand, such as
+	// " - Capitol Hill", " @ Westlake", or " (Broadway)".
+	chainLocationSuffix = regexp.MustCompile(`\s+(-|–|@|\().*$`)
+	// chainStoreNumber matches a store number, such as "#123", "No. 45", "Store 6", or a
+	// trailing "823".
+	chainStoreNumber = regexp.MustCompile(`(?i)(#\s*\d+|\bno\.?\s*\d+\b|\bstore\s+\d+\b|\s\d+$)`)
+)
+
+// defaultChainPatterns are the chain patterns of well-known chains whose store names carry
+// suffixes or locations the generic normalization can't tell from the brand, such as
+// "Costco Wholesale" or "Whole Foods Market".
+var defaultChainPatterns = []string{
+	`albertsons: ^albertsons\b`,
+	`costco: ^costco\b`,
+	`cvs: ^cvs\b`,
+	`fred meyer: ^fred meyer\b`,
+	`kroger: ^kroger\b`,
+	`qfc: ^(qfc|quality food centers?)\b`,
+	`rite aid: ^rite ?aid\b`,
+	`safeway: ^safeway\b`,
+	`target: ^(super ?)?target\b`,
+	`trader joes: ^trader joes\b`,
+	`walgreens: ^walgreens\b`,
+	`walmart: ^wal ?mart\b`,
+	`whole foods: ^whole foods\b`,
+	`7 eleven: ^7 ?eleven\b`,
+}
+
+// chainPattern names the chain of the stores whose normalized names match its regex.
+type chainPattern struct {
+	chain string
+	re    *regexp.Regexp
+}
+
+// chainPatterns are the chain patterns normalizeChainName tries in order. They are read from
+// the file named by the CHAIN_PATTERNS_FILE env variable, one `<chain>: <regex>` per line,
+// and default to defaultChainPatterns.
+var chainPatterns []*chainPattern
+
+func init() {
+	var err error
+	if chainPatterns, err = loadChainPatterns(os.Getenv("CHAIN_PATTERNS_FILE")); err != nil {
+		log.Fatalf("failed to load chain patterns: %v", err)
+	}
+}
+
+func loadChainPatterns(path string) ([]*chainPattern, error) {
+	var patterns []*chainPattern
+	if path == "" {
+		for _, line := range defaultChainPatterns {
+			p, err := parseChainPattern(line)
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, p)
+		}
+		return patterns, nil
+	}
+	err := scanDataFile(path, func(line string) error {
+		p, err := parseChainPattern(line)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("%s has no chain patterns", path)
+	}
+	return patterns, nil
+}
+
+// parseChainPattern parses a `<chain>: <regex>` line. The regex is matched against store
+// names as normalized before any chain pattern applies, so it sees lowercase words without
+// punctuation.
+func parseChainPattern(line string) (*chainPattern, error) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("chain pattern %q must be of the form `<chain>: <regex>`", line)
+	}
+	chain, pattern := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+	if chain == "" || pattern == "" {
+		return nil, fmt.Errorf("chain pattern %q is missing its chain or regex", line)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &chainPattern{chain: chain, re: re}, nil
+}
+
+// String returns the pattern in its `<chain>: <regex>` form.
+func (p *chainPattern) String() string {
+	return p.chain + ": " + p.re.String()
+}
+
+// normalizeChainName derives the chain of a store from its name. The name is stripped of
+// location qualifiers, store numbers, and punctuation, and lowercased, then named by the
+// first of chainPatterns it matches. "Safeway #1234 - Capitol Hill" and "SAFEWAY" are both
+// "safeway", "Costco Wholesale #123 Seattle" is "costco", and "Trader Joe's" is
+// "trader joes". A name that matches no pattern is its own chain.
+func normalizeChainName(storeName string) string {
+	name := chainLocationSuffix.ReplaceAllString(storeName, "")
+	name = chainStoreNumber.ReplaceAllString(name, " ")
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'' || r == '’' || r == '.':
+			return -1
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			return r
+		default:
+			return ' '
+		}
+	}, name)
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	for _, p := range chainPatterns {
+		if p.re.MatchString(name) {
+			return p.chain
+		}
+	}
+	return name
+}
+
+// chainAvailability aggregates the reports since cutoff at the stores within radiusMiles of
+// coords by chain and item. Chains with the most stores in the region come first, then by
+// name; items are sorted by name. Hidden items and unknown stock reports are left out, as
+// are stores whose names have no chain. The in-stock rates weigh the reports with seenWeight
+// at their age as of now.
+func chainAvailability(stores []*Store, items []*Item, coords coord, radiusMiles float64, cutoff, now int64) QueryChainAvailabilityResp {
+	chains := make(map[string]*ChainAvailability)
+	storeChains := make(map[string]string)
+	for _, st := range stores {
+		if Distance(st.Lat, st.Long, coords.Lat, coords.Long) > radiusMiles {
+			continue
+		}
+		chain := normalizeChainName(st.Name)
+		if chain == "" {
+			continue
+		}
+		storeChains[st.StoreID] = chain
+		c, ok := chains[chain]
+		if !ok {
+			c = &ChainAvailability{Chain: chain, Items: make([]*ChainItemAvailability, 0)}
+			chains[chain] = c
+		}
+		c.StoreCnt++
+	}
+
+	// The decayed weights of the reports and of the in-stock ones behind each rate.
+	type seenWeights struct {
+		total, inStock float64
+	}
+	weights := make(map[*ChainItemAvailability]*seenWeights)
+	for _, item := range items {
+		if hiddenItems.has(item.Name) {
+			continue
+		}
+		byChain := make(map[string]*ChainItemAvailability)
+		for _, sr := range item.StockReports {
+			if sr.StoreInfo == nil || sr.Unknown || sr.TimestampSec < cutoff {
+				continue
+			}
+			chain, ok := storeChains[sr.StoreInfo.StoreID]
+			if !ok {
+				continue
+			}
+			a, ok := byChain[chain]
+			if !ok {
+				a = &ChainItemAvailability{ItemName: item.Name}
+				byChain[chain] = a
+				chains[chain].Items = append(chains[chain].Items, a)
+				weights[a] = &seenWeights{}
+			}
+			seen := sr.SeenCnt
+			if seen < 1 {
+				seen = 1
+			}
+			weight := seenWeight(seen, now-sr.TimestampSec, seenCountDecay, seenCountDecayHours)
+			a.ReportCnt += seen
+			weights[a].total += weight
+			if sr.InStock {
+				a.InStockCnt += seen
+				weights[a].inStock += weight
+			}
+		}
+	}
+
+	resp := make(QueryChainAvailabilityResp, 0, len(chains))
+	for _, c := range chains {
+		for _, a := range c.Items {
+			// Under seenDecayLinear, every report may have decayed to nothing.
+			if w := weights[a]; w.total > 0 {
+				a.InStockRate = w.inStock / w.total
+			} else {
+				a.InStockRate = float64(a.InStockCnt) / float64(a.ReportCnt)
+			}
+		}
+		sort.Slice(c.Items, func(i, j int) bool {
+			return c.Items[i].ItemName < c.Items[j].ItemName
+		})
+		resp = append(resp, c)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if resp[i].StoreCnt != resp[j].StoreCnt {
+			return resp[i].StoreCnt > resp[j].StoreCnt
+		}
+		return resp[i].Chain < resp[j].Chain
+	})
+	return resp
+}
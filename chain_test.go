@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeChainName(t *testing.T) {
+	for name, want := range map[string]string{
+		"Safeway":                        "safeway",
+		"SAFEWAY #1234 - Capitol Hill":   "safeway",
+		"Safeway Store 6":                "safeway",
+		"QFC 823":                        "qfc",
+		"QFC @ Broadway":                 "qfc",
+		"Trader Joe's (Queen Anne)":      "trader joes",
+		"Trader Joe’s No. 130":           "trader joes",
+		"Fred Meyer":                     "fred meyer",
+		"7-Eleven":                       "7 eleven",
+		"7-Eleven Store 2365":            "7 eleven",
+		"Costco Wholesale #123 Seattle":  "costco",
+		"COSTCO Business Center":         "costco",
+		"Whole Foods Market":             "whole foods",
+		"Whole Foods Market - Roosevelt": "whole foods",
+		"Walmart Supercenter #5678":      "walmart",
+		"Wal-Mart Neighborhood Market":   "walmart",
+		"CVS Pharmacy #9876":             "cvs",
+		"SuperTarget":                    "target",
+		"Target (Northgate)":             "target",
+		"Ballard Market":                 "ballard market",
+		"#12":                            "",
+	} {
+		if got := normalizeChainName(name); got != want {
+			t.Errorf("normalizeChainName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestLoadChainPatterns(t *testing.T) {
+	f, err := ioutil.TempFile("", "chainPatterns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, "# Local chains.")
+	fmt.Fprintln(f, `pcc: ^(pcc|pcc community markets)\b`)
+	fmt.Fprintln(f, `costco: ^costco\b`)
+	f.Close()
+
+	patterns, err := loadChainPatterns(f.Name())
+	if err != nil {
+		t.Fatalf("loadChainPatterns() failed: %v", err)
+	}
+	orig := chainPatterns
+	chainPatterns = patterns
+	defer func() { chainPatterns = orig }()
+
+	for name, want := range map[string]string{
+		"PCC Community Markets - Fremont": "pcc",
+		"Costco Wholesale #123 Seattle":   "costco",
+		// No longer a pattern.
+		"Whole Foods Market": "whole foods market",
+	} {
+		if got := normalizeChainName(name); got != want {
+			t.Errorf("normalizeChainName(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	for _, line := range []string{"costco", ": ^costco", "costco: (", "costco:"} {
+		if _, err := parseChainPattern(line); err == nil {
+			t.Errorf("parseChainPattern(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestChainAvailability(t *testing.T) {
+	hiddenItems.set("flour", true)
+	defer hiddenItems.set("flour", false)
+
+	seattle := zipCodeToLatLong["98101"]
+	stores := []*Store{
+		{StoreID: "sw1", Name: "Safeway #1234", Lat: 47.615, Long: -122.335},
+		{StoreID: "sw2", Name: "Safeway - Capitol Hill", Lat: 47.62, Long: -122.32},
+		{StoreID: "sw3", Name: "SAFEWAY", Lat: 47.60, Long: -122.33},
+		{StoreID: "qfc1", Name: "QFC @ Broadway", Lat: 47.62, Long: -122.32},
+		{StoreID: "qfc2", Name: "QFC 823", Lat: 47.61, Long: -122.34},
+		// Too far from 98101.
+		{StoreID: "qfc3", Name: "QFC", Lat: 47.25, Long: -122.44},
+	}
+	byID := make(map[string]*Store)
+	for _, st := range stores {
+		byID[st.StoreID] = st
+	}
+	const cutoff = 1000
+	report := func(storeID string, inStock bool, seen int, ts int64) *StockReport {
+		return &StockReport{StoreInfo: byID[storeID], InStock: inStock, SeenCnt: seen, TimestampSec: ts}
+	}
+	items := []*Item{
+		{Name: "milk", StockReports: []*StockReport{
+			report("sw1", true, 1, 1500),
+			report("sw2", true, 2, 1500),
+			report("sw3", false, 1, 1500),
+			// Too old.
+			report("sw3", false, 4, 500),
+			report("qfc1", false, 1, 1500),
+			report("qfc2", true, 1, 1500),
+			// Too far.
+			report("qfc3", false, 3, 1500),
+		}},
+		{Name: "eggs", StockReports: []*StockReport{
+			report("qfc1", true, 1, 1500),
+			{StoreInfo: byID["sw1"], Unknown: true, SeenCnt: 1, TimestampSec: 1500},
+		}},
+		{Name: "flour", StockReports: []*StockReport{
+			report("sw1", true, 1, 1500),
+		}},
+	}
+
+	got := chainAvailability(stores, items, seattle, 10, cutoff, 1500)
+	want := QueryChainAvailabilityResp{
+		{Chain: "safeway", StoreCnt: 3, Items: []*ChainItemAvailability{
+			{ItemName: "milk", InStockCnt: 3, ReportCnt: 4, InStockRate: 0.75},
+		}},
+		{Chain: "qfc", StoreCnt: 2, Items: []*ChainItemAvailability{
+			{ItemName: "eggs", InStockCnt: 1, ReportCnt: 1, InStockRate: 1},
+			{ItemName: "milk", InStockCnt: 1, ReportCnt: 2, InStockRate: 0.5},
+		}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d chains, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("chain %d = %+v with items %+v, want %+v with items %+v", i, got[i], got[i].Items, want[i], want[i].Items)
+		}
+	}
+}
+
+func TestSeenWeight(t *testing.T) {
+	const hours = 10
+	for _, tc := range []struct {
+		curve  string
+		ageSec int64
+		want   float64
+	}{
+		{seenDecayNone, 0, 4},
+		{seenDecayNone, 100 * secondsToHour, 4},
+		{seenDecayLinear, 0, 4},
+		{seenDecayLinear, 5 * secondsToHour, 2},
+		{seenDecayLinear, 20 * secondsToHour, 0},
+		{seenDecayExponential, 10 * secondsToHour, 2},
+		{seenDecayExponential, 20 * secondsToHour, 1},
+	} {
+		if got := seenWeight(4, tc.ageSec, tc.curve, hours); math.Abs(got-tc.want) > 1e-9 {
+			t.Errorf("seenWeight(4, %d, %s) = %v, want %v", tc.ageSec, tc.curve, got, tc.want)
+		}
+	}
+}
+
+func TestChainAvailabilitySeenCountDecay(t *testing.T) {
+	origDecay, origHours := seenCountDecay, seenCountDecayHours
+	defer func() { seenCountDecay, seenCountDecayHours = origDecay, origHours }()
+	seenCountDecay, seenCountDecayHours = seenDecayExponential, 24
+
+	const now = 10 * secondsToDay
+	st := &Store{StoreID: "sw1", Name: "Safeway", Lat: 47.61, Long: -122.33}
+	items := []*Item{{Name: "milk", StockReports: []*StockReport{
+		// Three days old, so it weighs an eighth of the fresh report.
+		{StoreInfo: st, InStock: true, SeenCnt: 5, TimestampSec: now - 3*secondsToDay},
+		{StoreInfo: st, InStock: false, SeenCnt: 5, TimestampSec: now},
+	}}}
+
+	got := chainAvailability([]*Store{st}, items, zipCodeToLatLong["98101"], 10, 0, now)
+	if len(got) != 1 || len(got[0].Items) != 1 {
+		t.Fatalf("got %+v, want milk at safeway", got)
+	}
+	a := got[0].Items[0]
+	if a.InStockCnt != 5 || a.ReportCnt != 10 {
+		t.Errorf("got %d of %d reports in stock, want the undecayed 5 of 10", a.InStockCnt, a.ReportCnt)
+	}
+	if want := 1.0 / 9; math.Abs(a.InStockRate-want) > 1e-9 {
+		t.Errorf("got in-stock rate %v, want %v with the old report decayed", a.InStockRate, want)
+	}
+
+	seenCountDecay = seenDecayNone
+	if got := chainAvailability([]*Store{st}, items, zipCodeToLatLong["98101"], 10, 0, now); got[0].Items[0].InStockRate != 0.5 {
+		t.Errorf("got in-stock rate %v without decay, want 0.5", got[0].Items[0].InStockRate)
+	}
+}
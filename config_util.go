@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// positiveIntFromEnv returns the value of the env variable key, or def if it is unset.
+// Configuration is read once at startup, so a malformed value stops the server.
+func positiveIntFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("%s env variable must be a positive integer: %q", key, v)
+	}
+	return n
+}
+
+// Feature flags gate endpoints and behaviors so a deployment can turn them off without a
+// rebuild.
+const (
+	featureReportVisit     = "report_visit"
+	featureStoreShortages  = "store_shortages"
+	featureValidateAddress = "validate_address"
+	featureMapBox          = "map_bbox"
+	featureNearbyFeed      = "nearby_feed"
+	featureStatsCounts     = "stats_counts"
+	featureZipCodesResolve = "zipcodes_resolve"
+	// featureDedupUsersOnLoad collapses duplicate users in stock reports as items are
+	// loaded, until DedupUsersInfo has fixed them in storage.
+	featureDedupUsersOnLoad = "dedup_users_on_load"
+	// featureEmbedStoreSnapshots keeps a copy of the store on each new stock report. When
+	// disabled, new reports keep only the store ID and the store is looked up as items are
+	// loaded.
+	featureEmbedStoreSnapshots = "embed_store_snapshots"
+	// featureStockLevelNames encodes stock levels in JSON by name, like "low", rather than
+	// by number. Disable it for clients that still expect numbers.
+	featureStockLevelNames = "stock_level_names"
+	// featureDisputeReports lets an upload dispute the reports of the opposite stock state
+	// at the same store. See disputeStockReports.
+	featureDisputeReports = "dispute_reports"
+)
+
+// defaultFeatures holds whether each feature is enabled when FEATURE_FLAGS doesn't say.
+var defaultFeatures = map[string]bool{
+	featureReportVisit:         true,
+	featureStoreShortages:      true,
+	featureValidateAddress:     true,
+	featureMapBox:              true,
+	featureNearbyFeed:          true,
+	featureStatsCounts:         true,
+	featureZipCodesResolve:     true,
+	featureDedupUsersOnLoad:    true,
+	featureEmbedStoreSnapshots: true,
+	featureStockLevelNames:     true,
+	featureDisputeReports:      true,
+}
+
+// features holds whether each feature is enabled. The FEATURE_FLAGS env variable overrides
+// defaultFeatures with comma-separated `<feature>=<true|false>` pairs.
+var features = featuresFromEnv("FEATURE_FLAGS", defaultFeatures)
+
+// featureEnabled reports whether the named feature is enabled. Unknown features are not.
+func featureEnabled(name string) bool {
+	return features[name]
+}
+
+// flagged wraps the handler of an endpoint gated by the named feature. The endpoint is not
+// found while the feature is disabled.
+func flagged(name string, h http.HandlerFunc) http.HandlerFunc {
+	if _, ok := defaultFeatures[name]; !ok {
+		log.Fatalf("unknown feature %q", name)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !featureEnabled(name) {
+			http.NotFound(w, r)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// featuresFromEnv returns def overridden by the flags in the env variable key. A malformed
+// or unknown flag stops the server.
+func featuresFromEnv(key string, def map[string]bool) map[string]bool {
+	res := make(map[string]bool, len(def))
+	for name, enabled := range def {
+		res[name] = enabled
+	}
+	for _, flag := range strings.Split(os.Getenv(key), ",") {
+		flag = strings.TrimSpace(flag)
+		if flag == "" {
+			continue
+		}
+		kv := strings.SplitN(flag, "=", 2)
+		if len(kv) != 2 {
+			log.Fatalf("%s env variable has malformed flag %q, want `<feature>=<true|false>`", key, flag)
+		}
+		name := strings.TrimSpace(kv[0])
+		if _, ok := def[name]; !ok {
+			log.Fatalf("%s env variable has unknown feature %q", key, name)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(kv[1]))
+		if err != nil {
+			log.Fatalf("%s env variable has malformed flag %q, want `<feature>=<true|false>`", key, flag)
+		}
+		res[name] = enabled
+	}
+	return res
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFlaggedRouteDisabled(t *testing.T) {
+	orig := features
+	defer func() { features = orig }()
+
+	h := flagged(featureNearbyFeed, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	serve := func() int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("POST", "/feed/nearby", nil))
+		return w.Code
+	}
+
+	features = map[string]bool{featureNearbyFeed: true}
+	if code := serve(); code != http.StatusTeapot {
+		t.Errorf("enabled feature: got status %d, want the handler's %d", code, http.StatusTeapot)
+	}
+	features = map[string]bool{featureNearbyFeed: false}
+	if code := serve(); code != http.StatusNotFound {
+		t.Errorf("disabled feature: got status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestFeaturesFromEnv(t *testing.T) {
+	defer os.Unsetenv("TEST_FEATURE_FLAGS")
+	os.Setenv("TEST_FEATURE_FLAGS", "map_bbox=false, nearby_feed = true")
+
+	def := map[string]bool{featureMapBox: true, featureNearbyFeed: false, featureStatsCounts: true}
+	got := featuresFromEnv("TEST_FEATURE_FLAGS", def)
+	want := map[string]bool{featureMapBox: false, featureNearbyFeed: true, featureStatsCounts: true}
+	for name, enabled := range want {
+		if got[name] != enabled {
+			t.Errorf("feature %q enabled = %v, want %v", name, got[name], enabled)
+		}
+	}
+	if !def[featureMapBox] {
+		t.Errorf("featuresFromEnv() modified the defaults")
+	}
+	if featureEnabled("no_such_feature") {
+		t.Errorf("unknown feature is enabled")
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const csvContentType = "text/csv"
+
+// AcceptsCSV reports whether the client asked for a CSV response body via the Accept header.
+func AcceptsCSV(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == csvContentType {
+			return true
+		}
+	}
+	return false
+}
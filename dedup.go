@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// ******************************************
+// ** BEGIN DedupUsersInfo
+// ******************************************
+
+type DedupUsersInfoReq struct {
+	// Cursor resumes a dedup where the previous request left off.
+	Cursor string `json:"cursor"`
+}
+
+type DedupUsersInfoResp struct {
+	Progress *BatchProgress `json:"progress"`
+	// Fixed lists the items whose reports had duplicate users.
+	Fixed []string `json:"fixed_items"`
+	// NextCursor resumes the dedup if it yielded before every item was checked.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// DedupUsersInfo collapses the duplicate users in every item's stock reports and corrects
+// their seen counts, in batches limited by jobLimits. Reports uploaded before uploads were
+// transactional may have counted the same user more than once. It is an admin endpoint.
+func DedupUsersInfo(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req DedupUsersInfoReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	var cursor datastore.Cursor
+	if req.Cursor != "" {
+		var err error
+		if cursor, err = datastore.DecodeCursor(req.Cursor); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("cursor is invalid: %v", err)
+		}
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	resp := &DedupUsersInfoResp{Fixed: make([]string, 0)}
+	resp.Progress, err = runBatches(ctx, "dedup users info", jobLimits, func(ctx context.Context, size int) (int, bool, error) {
+		names, next, err := loadItemNameBatch(ctx, client, cursor, size)
+		if err != nil {
+			return 0, false, err
+		}
+		cursor = next
+		for _, name := range names {
+			fixed, err := dedupItemUsersInStorage(ctx, client, name)
+			if err != nil {
+				return 0, false, err
+			}
+			if fixed {
+				resp.Fixed = append(resp.Fixed, name)
+			}
+		}
+		return len(names), len(names) < size, nil
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !resp.Progress.Done {
+		resp.NextCursor = cursor.String()
+	}
+
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END DedupUsersInfo
+// ******************************************
+
+// dedupUsersInfo collapses the duplicate user IDs in each of the item's stock reports,
+// keeping each user's latest report time, and sets the seen counts to the number of
+// distinct users. It reports whether anything changed.
+func dedupUsersInfo(item *Item) bool {
+	changed := false
+	for _, sr := range item.StockReports {
+		users := make([]*User, 0, len(sr.UsersInfo))
+		byID := make(map[string]*User, len(sr.UsersInfo))
+		for _, u := range sr.UsersInfo {
+			if prev, ok := byID[u.UserID]; ok {
+				if u.TimestampSec > prev.TimestampSec {
+					prev.TimestampSec = u.TimestampSec
+				}
+				continue
+			}
+			byID[u.UserID] = u
+			users = append(users, u)
+		}
+		if len(users) != len(sr.UsersInfo) || sr.SeenCnt != len(users) {
+			sr.UsersInfo = users
+			sr.SeenCnt = len(users)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// dedupItemUsersInStorage dedups the named item's users in a transaction, so it doesn't
+// race uploads to the same item, and reports whether the item changed.
+func dedupItemUsersInStorage(ctx context.Context, client *datastore.Client, name string) (bool, error) {
+	fixed := false
+	key := nameKey(ctx, ItemKind, name)
+	if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var item Item
+		if err := tx.Get(key, &item); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil // deleted since the batch was loaded
+			}
+			return fmt.Errorf("failed to fetch item %q from storage: %v", name, err)
+		}
+		if fixed = dedupUsersInfo(&item); !fixed {
+			return nil
+		}
+		if _, err := tx.Put(key, &item); err != nil {
+			return fmt.Errorf("failed to update item %q in storage: %v", name, err)
+		}
+		return nil
+	}); err != nil {
+		return false, err
+	}
+	return fixed, nil
+}
+
+// loadItemNameBatch fetches the names of up to size items starting at the cursor and
+// returns the cursor after them.
+func loadItemNameBatch(ctx context.Context, client *datastore.Client, cursor datastore.Cursor, size int) ([]string, datastore.Cursor, error) {
+	var names []string
+	it := client.Run(ctx, newQuery(ctx, ItemKind).KeysOnly().Start(cursor).Limit(size))
+	for {
+		key, err := it.Next(nil)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, cursor, fmt.Errorf("failed to query item keys: %v", err)
+		}
+		names = append(names, key.Name)
+	}
+	next, err := it.Cursor()
+	if err != nil {
+		return nil, cursor, fmt.Errorf("failed to get item cursor: %v", err)
+	}
+	return names, next, nil
+}
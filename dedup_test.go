@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDedupUsersInfo(t *testing.T) {
+	item := &Item{
+		Name: "eggs",
+		StockReports: []*StockReport{
+			{
+				UsersInfo: []*User{
+					{UserID: "alice", TimestampSec: 100},
+					{UserID: "bob", TimestampSec: 150},
+					{UserID: "alice", TimestampSec: 200},
+					{UserID: "alice", TimestampSec: 120},
+				},
+				SeenCnt: 4,
+			},
+			{
+				UsersInfo: []*User{{UserID: "carol", TimestampSec: 100}},
+				SeenCnt:   1,
+			},
+		},
+	}
+
+	if !dedupUsersInfo(item) {
+		t.Fatal("dedupUsersInfo() = false, want true")
+	}
+	sr := item.StockReports[0]
+	if sr.SeenCnt != 2 || len(sr.UsersInfo) != 2 {
+		t.Fatalf("got seen count %d and %d users, want 2 and 2", sr.SeenCnt, len(sr.UsersInfo))
+	}
+	if u := sr.UsersInfo[0]; u.UserID != "alice" || u.TimestampSec != 200 {
+		t.Errorf("got first user %+v, want alice at her latest time 200", u)
+	}
+	if sr := item.StockReports[1]; sr.SeenCnt != 1 || len(sr.UsersInfo) != 1 {
+		t.Errorf("report without duplicates changed: %+v", sr)
+	}
+
+	if dedupUsersInfo(item) {
+		t.Error("dedupUsersInfo() of a deduped item = true, want false")
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Favorite represents a store that a user pinned. The key is derived from both IDs so a
+// user can favorite a store at most once.
+type Favorite struct {
+	UserID       string `datastore:"userID" json:"user_id"`
+	StoreID      string `datastore:"storeID" json:"store_id"`
+	TimestampSec int64  `datastore:"timestampSec" json:"timestamp_sec"`
+}
+
+// ******************************************
+// ** BEGIN AddFavorite
+// ******************************************
+
+type AddFavoriteReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+}
+
+// AddFavorite pins a store for the user.
+func AddFavorite(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req AddFavoriteReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := validateAddFavoriteReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	_, ok, err = GetStoreInStorage(ctx, req.StoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
+
+	fav := &Favorite{
+		UserID:       req.UserID,
+		StoreID:      req.StoreID,
+		TimestampSec: time.Now().Unix(),
+	}
+	if err := createFavoriteInStorage(ctx, fav); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func validateAddFavoriteReq(req *AddFavoriteReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END AddFavorite
+// ******************************************
+
+// ******************************************
+// ** BEGIN RemoveFavorite
+// ******************************************
+
+type RemoveFavoriteReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+}
+
+// RemoveFavorite unpins a store for the user. Removing a store that isn't a favorite is a no-op.
+func RemoveFavorite(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req RemoveFavoriteReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := validateRemoveFavoriteReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	if err := deleteFavoriteInStorage(ctx, req.UserID, req.StoreID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func validateRemoveFavoriteReq(req *RemoveFavoriteReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END RemoveFavorite
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryFavorites
+// ******************************************
+
+type QueryFavoritesReq struct {
+	UserID string `json:"user_id"`
+}
+
+type QueryFavoritesResp []*Store
+
+// QueryFavorites fetches the stores the user pinned, most recently pinned first.
+func QueryFavorites(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryFavoritesReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := validateQueryFavoritesReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	favs, err := getFavoritesInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := make(QueryFavoritesResp, 0, len(favs))
+	for _, fav := range favs {
+		st, ok, err := GetStoreInStorage(ctx, fav.StoreID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if !ok {
+			continue // store was removed after it was favorited
+		}
+		resp = append(resp, st)
+	}
+
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func validateQueryFavoritesReq(req *QueryFavoritesReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END QueryFavorites
+// ******************************************
+
+func favoriteKey(ctx context.Context, userID, storeID string) *datastore.Key {
+	return nameKey(ctx, FavoriteKind, userID+"/"+storeID)
+}
+
+func createFavoriteInStorage(ctx context.Context, fav *Favorite) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, err := client.Put(ctx, favoriteKey(ctx, fav.UserID, fav.StoreID), fav); err != nil {
+		return fmt.Errorf("failed to create favorite in storage: %v", err)
+	}
+	return nil
+}
+
+func deleteFavoriteInStorage(ctx context.Context, userID, storeID string) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Delete(ctx, favoriteKey(ctx, userID, storeID)); err != nil {
+		return fmt.Errorf("failed to delete favorite in storage: %v", err)
+	}
+	return nil
+}
+
+// getFavoritesInStorage fetches the user's favorites, most recently pinned first.
+func getFavoritesInStorage(ctx context.Context, userID string) ([]*Favorite, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var favs []*Favorite
+	q := newQuery(ctx, FavoriteKind).Filter("userID =", userID)
+	it := client.Run(ctx, q)
+	for {
+		var fav Favorite
+		_, err := it.Next(&fav)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query favorites: %v", err)
+		}
+		favs = append(favs, &fav)
+	}
+	// Sort in memory rather than with Order() so the query doesn't need a composite index.
+	sort.Slice(favs, func(i, j int) bool {
+		return favs[i].TimestampSec > favs[j].TimestampSec
+	})
+	return favs, nil
+}
+
+// favoriteStoreIDs returns the set of store IDs the user pinned.
+func favoriteStoreIDs(ctx context.Context, userID string) (map[string]bool, error) {
+	favs, err := getFavoritesInStorage(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool, len(favs))
+	for _, fav := range favs {
+		ids[fav.StoreID] = true
+	}
+	return ids, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultFeedRadiusMiles = 10.0
+	maxFeedRadiusMiles     = 50.0
+)
+
+// feedPageLimits are the page limits of the nearby feed, set with the FEED_DEFAULT_LIMIT and
+// FEED_MAX_LIMIT env variables.
+var feedPageLimits = pageLimitsFromEnv("FEED", pageLimits{Default: 25, Max: 100})
+
+// ******************************************
+// ** BEGIN QueryNearbyFeed
+// ******************************************
+
+type QueryNearbyFeedReq struct {
+	UserID      string  `json:"user_id"`
+	RadiusMiles float64 `json:"radius_miles"`
+	// ItemNames limits the feed to the reports of these items. Empty means every item.
+	ItemNames []string `json:"item_names"`
+	PageReq
+}
+
+type QueryNearbyFeedResp []*FeedEntry
+
+// FeedEntry is a stock report in the nearby feed. It doesn't identify the users who made
+// the report.
+type FeedEntry struct {
+	ItemName      string  `json:"itemName"`
+	StoreName     string  `json:"storeName"`
+	StoreAddr     string  `json:"storeAddress"`
+	StoreLat      float64 `json:"storeLat"`
+	StoreLng      float64 `json:"storeLong"`
+	DistanceMiles float64 `json:"distanceMiles"`
+	InStock       bool    `json:"inStock"`
+	SeenCnt       int     `json:"seenCount"`
+	SecondsAgo    int     `json:"secondsAgo"`
+	ReportedAgo   string  `json:"reportedAgo"`
+}
+
+// QueryNearbyFeed fetches the most recent stock reports, across all items, at stores within
+// a radius of the user's zip code. The most recent report comes first.
+func QueryNearbyFeed(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryNearbyFeedReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryNearbyFeedReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	coords, ok := zipCodeToLatLong[u.ZipCode]
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("zip code %q is not supported", u.ZipCode)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	items, err := loadAllItems(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp, pg := buildNearbyFeed(items, req.ItemNames, coords, req.RadiusMiles, req.PageReq, time.Now().Unix())
+	if err := EncodePage(w, req.PageReq, pg, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryNearbyFeedReq(req *QueryNearbyFeedReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	names := make([]string, 0, len(req.ItemNames))
+	for i, name := range req.ItemNames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return fmt.Errorf("item name at index %d is empty", i)
+		}
+		names = append(names, name)
+	}
+	req.ItemNames = uniqueStrings(names)
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxFeedRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxFeedRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultFeedRadiusMiles
+	}
+	return req.PageReq.cleanAndValidate(feedPageLimits)
+}
+
+// ******************************************
+// ** END QueryNearbyFeed
+// ******************************************
+
+// buildNearbyFeed returns the requested page of the stock reports at stores within
+// radiusMiles of coords, most recent first. Unknown reports are left out, as are reports of
+// items other than itemNames, unless it is empty.
+func buildNearbyFeed(items []*Item, itemNames []string, coords coord, radiusMiles float64, p PageReq, now int64) (QueryNearbyFeedResp, *Pagination) {
+	only := make(map[string]bool, len(itemNames))
+	for _, name := range itemNames {
+		only[name] = true
+	}
+	resp := make(QueryNearbyFeedResp, 0)
+	for _, item := range items {
+		if len(only) > 0 && !only[item.Name] {
+			continue
+		}
+		for _, sr := range item.StockReports {
+			if sr.StoreInfo == nil || sr.Unknown {
+				continue
+			}
+			d := Distance(sr.StoreInfo.Lat, sr.StoreInfo.Long, coords.Lat, coords.Long)
+			if d > radiusMiles {
+				continue
+			}
+			secondsAgo := int(now - sr.TimestampSec)
+			resp = append(resp, &FeedEntry{
+				ItemName:      item.Name,
+				StoreName:     sr.StoreInfo.Name,
+				StoreAddr:     sr.StoreInfo.Addr,
+				StoreLat:      sr.StoreInfo.Lat,
+				StoreLng:      sr.StoreInfo.Long,
+				DistanceMiles: d,
+				InStock:       sr.InStock,
+				SeenCnt:       sr.SeenCnt,
+				SecondsAgo:    secondsAgo,
+				ReportedAgo:   humanizeAge(secondsAgo),
+			})
+		}
+	}
+	sort.SliceStable(resp, func(i, j int) bool {
+		return resp[i].SecondsAgo < resp[j].SecondsAgo
+	})
+	start, end, pg := pageBounds(len(resp), p)
+	return resp[start:end], pg
+}
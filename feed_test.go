@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildNearbyFeed(t *testing.T) {
+	const now = 1000000
+	origin := coord{Lat: 47.6, Long: -122.3}
+	near := &Store{StoreID: "near", Name: "QFC", Lat: 47.61, Long: -122.3}
+	far := &Store{StoreID: "far", Name: "Fred Meyer", Lat: 48.6, Long: -122.3}
+	items := []*Item{
+		{Name: "eggs", StockReports: []*StockReport{
+			{StoreInfo: near, InStock: true, TimestampSec: now - 300, UsersInfo: []*User{{UserID: "alice"}}},
+			{StoreInfo: far, InStock: true, TimestampSec: now - 10},
+		}},
+		{Name: "flour", StockReports: []*StockReport{
+			{StoreInfo: near, InStock: false, TimestampSec: now - 60},
+			{StoreInfo: near, InStock: true, TimestampSec: now - 3600},
+		}},
+	}
+
+	resp, _ := buildNearbyFeed(items, nil, origin, 10, PageReq{Limit: 10}, now)
+	want := []struct {
+		item       string
+		inStock    bool
+		secondsAgo int
+	}{
+		{"flour", false, 60},
+		{"eggs", true, 300},
+		{"flour", true, 3600},
+	}
+	if len(resp) != len(want) {
+		t.Fatalf("got %d feed entries, want %d", len(resp), len(want))
+	}
+	for i, w := range want {
+		e := resp[i]
+		if e.ItemName != w.item || e.InStock != w.inStock || e.SecondsAgo != w.secondsAgo || e.StoreName != near.Name {
+			t.Errorf("feed entry %d = %+v, want %+v at %s", i, e, w, near.Name)
+		}
+	}
+
+	if resp, _ := buildNearbyFeed(items, nil, origin, 10, PageReq{Limit: 2}, now); len(resp) != 2 || resp[1].ItemName != "eggs" {
+		t.Errorf("got %d feed entries with limit 2, want the 2 most recent", len(resp))
+	}
+	if resp, _ := buildNearbyFeed(items, nil, origin, 100, PageReq{Limit: 10}, now); len(resp) != 4 || resp[0].StoreName != far.Name {
+		t.Errorf("got %d feed entries within 100 miles, want 4 starting at %s", len(resp), far.Name)
+	}
+}
+
+func TestBuildNearbyFeedItemNames(t *testing.T) {
+	const now = 1000000
+	origin := coord{Lat: 47.6, Long: -122.3}
+	near := &Store{StoreID: "near", Name: "QFC", Lat: 47.61, Long: -122.3}
+	items := []*Item{
+		{Name: "eggs", StockReports: []*StockReport{{StoreInfo: near, InStock: true, TimestampSec: now - 300}}},
+		{Name: "flour", StockReports: []*StockReport{{StoreInfo: near, InStock: true, TimestampSec: now - 60}}},
+		{Name: "rice", StockReports: []*StockReport{{StoreInfo: near, InStock: false, TimestampSec: now - 10}}},
+	}
+
+	resp, _ := buildNearbyFeed(items, []string{"eggs", "rice"}, origin, 10, PageReq{Limit: 10}, now)
+	if len(resp) != 2 || resp[0].ItemName != "rice" || resp[1].ItemName != "eggs" {
+		t.Errorf("got feed %+v, want rice then eggs", resp)
+	}
+}
+
+func TestCleanAndValidateQueryNearbyFeedReq(t *testing.T) {
+	req := &QueryNearbyFeedReq{UserID: "u", ItemNames: []string{"Eggs", "eggs", "Rice"}}
+	if err := cleanAndValidateQueryNearbyFeedReq(req); err != nil {
+		t.Fatalf("cleanAndValidateQueryNearbyFeedReq() failed: %v", err)
+	}
+	if want := []string{"eggs", "rice"}; !reflect.DeepEqual(req.ItemNames, want) {
+		t.Errorf("got item names %q, want %q", req.ItemNames, want)
+	}
+	if req.RadiusMiles != defaultFeedRadiusMiles {
+		t.Errorf("got radius %v, want the default %v", req.RadiusMiles, defaultFeedRadiusMiles)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGapsRadiusMiles = 10.0
+	maxGapsRadiusMiles     = 50.0
+)
+
+var (
+	// gapsPageLimits are the page limits of QueryItemGaps, set with the GAPS_DEFAULT_LIMIT and
+	// GAPS_MAX_LIMIT env variables.
+	gapsPageLimits = pageLimitsFromEnv("GAPS", pageLimits{Default: 20, Max: 100})
+	// gapsRecentHours is how recent an in-stock report must be for its item not to be a gap,
+	// set with the GAPS_RECENT_HOURS env variable.
+	gapsRecentHours = positiveIntFromEnv("GAPS_RECENT_HOURS", 48)
+	// maxGapsItems caps the number of most queried items in a region whose reports
+	// QueryItemGaps looks up, set with the GAPS_MAX_ITEMS env variable.
+	maxGapsItems = positiveIntFromEnv("GAPS_MAX_ITEMS", 500)
+)
+
+// gapsCountsTTL is how long QueryItemGaps reuses the item query counts before reloading them.
+const gapsCountsTTL = time.Minute
+
+var gapsQueryCounts = &queryCountsCache{ttl: gapsCountsTTL}
+
+// ******************************************
+// ** BEGIN QueryItemGaps
+// ******************************************
+
+type QueryItemGapsReq struct {
+	UserID      string  `json:"user_id"`
+	ZipCode     string  `json:"zip_code"`
+	RadiusMiles float64 `json:"radius_miles"`
+	PageReq
+}
+
+type QueryItemGapsResp []*ItemGap
+
+// ItemGap is an item that users in a region query for but that nobody reported in stock
+// there recently.
+type ItemGap struct {
+	ItemName string `json:"item_name"`
+	// QueryCnt is the number of times users in the region queried the item.
+	QueryCnt int64 `json:"query_count"`
+	// LastInStockSec is when the item was last reported in stock in the region, if ever.
+	LastInStockSec int64 `json:"last_in_stock_timestamp_sec,omitempty"`
+}
+
+// QueryItemGaps fetches the items most queried by users within a radius of a zip code that
+// have no in-stock report from a store in the radius within the last gapsRecentHours. The
+// most queried item comes first. The zip code defaults to the user's zip code. Only the
+// maxGapsItems most queried items are checked, and the query counts are cached for
+// gapsCountsTTL.
+func QueryItemGaps(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryItemGapsReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryItemGapsReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	if req.ZipCode == "" {
+		req.ZipCode = u.ZipCode
+	}
+	coords, ok := zipCodeToLatLong[req.ZipCode]
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("zip code %q is not supported", req.ZipCode)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	counts, err := gapsQueryCounts.get(ctx, time.Now(), func(ctx context.Context) ([]*ItemQueryCount, error) {
+		return loadAllItemQueryCounts(ctx, client)
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	demand := mostDemanded(regionItemDemand(counts, coords, req.RadiusMiles), maxGapsItems)
+	names := make([]string, 0, len(demand))
+	for name := range demand {
+		names = append(names, name)
+	}
+	items, err := getItemsInStorage(ctx, client, names)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	cutoff := time.Now().Add(-time.Duration(gapsRecentHours) * time.Hour).Unix()
+	gaps := itemGaps(demand, items, coords, req.RadiusMiles, cutoff)
+	start, end, pg := pageBounds(len(gaps), req.PageReq)
+	resp := gaps[start:end]
+	if err := EncodePage(w, req.PageReq, pg, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryItemGapsReq(req *QueryItemGapsReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxGapsRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxGapsRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultGapsRadiusMiles
+	}
+	return req.PageReq.cleanAndValidate(gapsPageLimits)
+}
+
+// ******************************************
+// ** END QueryItemGaps
+// ******************************************
+
+// regionItemDemand sums, for each item, the queries from zip codes within radiusMiles of
+// coords. Hidden items and zip codes without known coordinates are skipped.
+func regionItemDemand(counts []*ItemQueryCount, coords coord, radiusMiles float64) map[string]int64 {
+	demand := make(map[string]int64)
+	for _, c := range counts {
+		zc, ok := zipCodeToLatLong[c.ZipCode]
+		if !ok || hiddenItems.has(c.ItemName) {
+			continue
+		}
+		if Distance(zc.Lat, zc.Long, coords.Lat, coords.Long) > radiusMiles {
+			continue
+		}
+		demand[c.ItemName] += c.Count
+	}
+	return demand
+}
+
+// mostDemanded returns the demand of the n most queried items, ties broken by name.
+func mostDemanded(demand map[string]int64, n int) map[string]int64 {
+	if len(demand) <= n {
+		return demand
+	}
+	names := make([]string, 0, len(demand))
+	for name := range demand {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if demand[names[i]] != demand[names[j]] {
+			return demand[names[i]] > demand[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	top := make(map[string]int64, n)
+	for _, name := range names[:n] {
+		top[name] = demand[name]
+	}
+	return top
+}
+
+// itemGaps returns the demanded items without an in-stock report since cutoff from a store
+// within radiusMiles of coords, most queried first. Items missing from items were never
+// reported.
+func itemGaps(demand map[string]int64, items map[string]*Item, coords coord, radiusMiles float64, cutoff int64) []*ItemGap {
+	gaps := make([]*ItemGap, 0)
+	for name, cnt := range demand {
+		gap := &ItemGap{ItemName: name, QueryCnt: cnt}
+		if item, ok := items[name]; ok {
+			for _, sr := range item.StockReports {
+				if !sr.InStock || sr.Unknown || sr.StoreInfo == nil {
+					continue
+				}
+				if Distance(sr.StoreInfo.Lat, sr.StoreInfo.Long, coords.Lat, coords.Long) > radiusMiles {
+					continue
+				}
+				if sr.TimestampSec > gap.LastInStockSec {
+					gap.LastInStockSec = sr.TimestampSec
+				}
+			}
+		}
+		if gap.LastInStockSec < cutoff {
+			gaps = append(gaps, gap)
+		}
+	}
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].QueryCnt != gaps[j].QueryCnt {
+			return gaps[i].QueryCnt > gaps[j].QueryCnt
+		}
+		return gaps[i].ItemName < gaps[j].ItemName
+	})
+	return gaps
+}
+
+// queryCountsCache holds the most recently loaded item query counts of each namespace until
+// they are older than ttl, like countsCache.
+type queryCountsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*queryCountsCacheEntry
+}
+
+type queryCountsCacheEntry struct {
+	counts   []*ItemQueryCount
+	loadedAt time.Time
+}
+
+func (c *queryCountsCache) get(ctx context.Context, now time.Time, load func(context.Context) ([]*ItemQueryCount, error)) ([]*ItemQueryCount, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ns := namespaceFromContext(ctx)
+	if e, ok := c.entries[ns]; ok && now.Sub(e.loadedAt) < c.ttl {
+		return e.counts, nil
+	}
+	counts, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*queryCountsCacheEntry)
+	}
+	c.entries[ns] = &queryCountsCacheEntry{counts: counts, loadedAt: now}
+	return counts, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegionItemDemand(t *testing.T) {
+	hiddenItems.set("flour", true)
+	defer hiddenItems.set("flour", false)
+
+	counts := []*ItemQueryCount{
+		{ItemName: "milk", ZipCode: "98101", Count: 5},
+		{ItemName: "milk", ZipCode: "98109", Count: 2},
+		{ItemName: "eggs", ZipCode: "98101", Count: 3},
+		// Too far from 98101.
+		{ItemName: "rice", ZipCode: "98402", Count: 9},
+		{ItemName: "flour", ZipCode: "98101", Count: 4},
+		{ItemName: "bread", ZipCode: "00000", Count: 1},
+	}
+	got := regionItemDemand(counts, zipCodeToLatLong["98101"], 5)
+	want := map[string]int64{"milk": 7, "eggs": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("regionItemDemand() = %v, want %v", got, want)
+	}
+}
+
+func TestItemGaps(t *testing.T) {
+	near := &Store{StoreID: "near", Name: "QFC", Lat: 47.615, Long: -122.335}
+	far := &Store{StoreID: "far", Name: "Safeway", Lat: 47.25, Long: -122.44}
+	const cutoff = 1000
+	items := map[string]*Item{
+		// Recently in stock nearby, so not a gap.
+		"milk": {Name: "milk", StockReports: []*StockReport{
+			{StoreInfo: near, InStock: true, TimestampSec: 1500},
+		}},
+		// In stock nearby, but not recently.
+		"eggs": {Name: "eggs", StockReports: []*StockReport{
+			{StoreInfo: near, InStock: true, TimestampSec: 400},
+			{StoreInfo: near, InStock: false, TimestampSec: 1200},
+		}},
+		// Recently in stock, but only far away.
+		"rice": {Name: "rice", StockReports: []*StockReport{
+			{StoreInfo: far, InStock: true, TimestampSec: 1500},
+		}},
+		// Visited nearby but not checked.
+		"yeast": {Name: "yeast", StockReports: []*StockReport{
+			{StoreInfo: near, InStock: true, Unknown: true, TimestampSec: 1500},
+		}},
+	}
+	demand := map[string]int64{"milk": 10, "eggs": 4, "rice": 4, "yeast": 6, "bread": 2}
+
+	got := itemGaps(demand, items, zipCodeToLatLong["98101"], 5, cutoff)
+	want := []*ItemGap{
+		{ItemName: "yeast", QueryCnt: 6},
+		{ItemName: "eggs", QueryCnt: 4, LastInStockSec: 400},
+		{ItemName: "rice", QueryCnt: 4},
+		// Never reported.
+		{ItemName: "bread", QueryCnt: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("itemGaps() = %s, want %s", gapNames(got), gapNames(want))
+	}
+}
+
+func gapNames(gaps []*ItemGap) []string {
+	names := make([]string, len(gaps))
+	for i, g := range gaps {
+		names[i] = g.ItemName
+	}
+	return names
+}
+
+func TestMostDemanded(t *testing.T) {
+	demand := map[string]int64{"milk": 7, "eggs": 3, "rice": 3, "flour": 9}
+	got := mostDemanded(demand, 3)
+	want := map[string]int64{"flour": 9, "milk": 7, "eggs": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mostDemanded() = %v, want %v", got, want)
+	}
+	if got := mostDemanded(demand, 10); !reflect.DeepEqual(got, demand) {
+		t.Errorf("mostDemanded() = %v under the cap, want every item", got)
+	}
+}
+
+func TestQueryCountsCache(t *testing.T) {
+	loads := 0
+	load := func(context.Context) ([]*ItemQueryCount, error) {
+		loads++
+		return []*ItemQueryCount{{ItemName: "milk", ZipCode: "98101", Count: int64(loads)}}, nil
+	}
+	c := &queryCountsCache{ttl: time.Minute}
+	ctx := context.Background()
+	start := time.Unix(1000, 0)
+	for _, tc := range []struct {
+		now  time.Time
+		want int64
+	}{
+		{start, 1},
+		{start.Add(59 * time.Second), 1},
+		{start.Add(time.Minute), 2},
+	} {
+		got, err := c.get(ctx, tc.now, load)
+		if err != nil {
+			t.Fatalf("get() failed: %v", err)
+		}
+		if got[0].Count != tc.want {
+			t.Errorf("get() at %v returned count %d, want %d", tc.now.Sub(start), got[0].Count, tc.want)
+		}
+	}
+}
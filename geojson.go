@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+const geoJSONContentType = "application/geo+json"
+
+// ******************************************
+// ** BEGIN ExportStoresGeoJSON
+// ******************************************
+
+type ExportStoresGeoJSONReq struct {
+	UserID string `json:"user_id"`
+	// ZipCode is the center of the exported stores. It defaults to the user's zip code.
+	ZipCode     string  `json:"zip_code"`
+	RadiusMiles float64 `json:"radius_miles"`
+	// All exports every store instead of the ones near the zip code. It needs the admin key
+	// in place of the user ID.
+	All bool `json:"all"`
+}
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection of stores, per RFC 7946.
+type GeoJSONFeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []*GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is a store as a GeoJSON Feature with a point geometry.
+type GeoJSONFeature struct {
+	Type       string                  `json:"type"`
+	ID         string                  `json:"id"`
+	Geometry   *GeoJSONPoint           `json:"geometry"`
+	Properties *GeoJSONStoreProperties `json:"properties"`
+}
+
+// GeoJSONPoint is a GeoJSON Point. Its coordinates are longitude, then latitude.
+type GeoJSONPoint struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"`
+}
+
+type GeoJSONStoreProperties struct {
+	StoreID   string `json:"store_id"`
+	Name      string `json:"name"`
+	Addr      string `json:"address"`
+	StoreType string `json:"store_type,omitempty"`
+	Unvetted  bool   `json:"unvetted,omitempty"`
+}
+
+// ExportStoresGeoJSON exports the stores within a radius of a zip code as a GeoJSON
+// FeatureCollection for mapping tools. With All set, it exports every store and is an admin
+// endpoint.
+func ExportStoresGeoJSON(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ExportStoresGeoJSONReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var coords coord
+	if req.All {
+		if err := ValidateAdmin(r); err != nil {
+			return http.StatusForbidden, err
+		}
+	} else {
+		if err := cleanAndValidateExportStoresGeoJSONReq(&req); err != nil {
+			return http.StatusBadRequest, err
+		}
+		u, ok, err := GetUserInStorage(ctx, req.UserID)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+		}
+		if !ok {
+			return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+		}
+		if req.ZipCode == "" {
+			req.ZipCode = u.ZipCode
+		}
+		if coords, err = zipCodeCoords(req.ZipCode); err != nil {
+			return zipCodeErrStatus(err), err
+		}
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	stores, err := loadAllStores(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !req.All {
+		stores = storesWithinRadius(stores, coords, req.RadiusMiles)
+	}
+
+	fc := storesGeoJSON(stores)
+	if err := validateGeoJSON(fc); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to export stores as geojson: %v", err)
+	}
+	w.Header().Set("Content-Type", geoJSONContentType)
+	if err := json.NewEncoder(w).Encode(fc); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to encode response in geojson: %v", err)
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateExportStoresGeoJSONReq(req *ExportStoresGeoJSONReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxMapRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxMapRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultMapRadiusMiles
+	}
+	return nil
+}
+
+// ******************************************
+// ** END ExportStoresGeoJSON
+// ******************************************
+
+// storesGeoJSON returns the stores as a FeatureCollection sorted by store ID. Stores without
+// valid coordinates can't be placed on a map and are left out.
+func storesGeoJSON(stores []*Store) *GeoJSONFeatureCollection {
+	fc := &GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]*GeoJSONFeature, 0, len(stores))}
+	for _, st := range stores {
+		if !validCoords(st.Lat, st.Long) {
+			continue
+		}
+		fc.Features = append(fc.Features, &GeoJSONFeature{
+			Type: "Feature",
+			ID:   st.StoreID,
+			Geometry: &GeoJSONPoint{
+				Type:        "Point",
+				Coordinates: [2]float64{st.Long, st.Lat},
+			},
+			Properties: &GeoJSONStoreProperties{
+				StoreID:   st.StoreID,
+				Name:      st.Name,
+				Addr:      st.Addr,
+				StoreType: st.StoreType,
+				Unvetted:  st.Unvetted,
+			},
+		})
+	}
+	sort.Slice(fc.Features, func(i, j int) bool {
+		return fc.Features[i].ID < fc.Features[j].ID
+	})
+	return fc
+}
+
+// validCoords reports whether the coordinates are on the map and not the unset (0, 0).
+func validCoords(lat, long float64) bool {
+	if lat == 0 && long == 0 {
+		return false
+	}
+	return lat >= -90 && lat <= 90 && long >= -180 && long <= 180
+}
+
+// validateGeoJSON checks that the FeatureCollection is well-formed GeoJSON: every feature is
+// a Feature with a Point geometry whose longitude and latitude are in range.
+func validateGeoJSON(fc *GeoJSONFeatureCollection) error {
+	if fc.Type != "FeatureCollection" {
+		return fmt.Errorf("type is %q, want FeatureCollection", fc.Type)
+	}
+	if fc.Features == nil {
+		return fmt.Errorf("features are missing")
+	}
+	for i, f := range fc.Features {
+		if f.Type != "Feature" {
+			return fmt.Errorf("feature at index %d has type %q, want Feature", i, f.Type)
+		}
+		if f.Geometry == nil || f.Geometry.Type != "Point" {
+			return fmt.Errorf("feature at index %d has no point geometry", i)
+		}
+		if long, lat := f.Geometry.Coordinates[0], f.Geometry.Coordinates[1]; !validCoords(lat, long) {
+			return fmt.Errorf("feature at index %d has invalid coordinates [%v, %v]", i, long, lat)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStoresGeoJSON(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "qfc", Name: "QFC", Addr: "500 Broadway E, Seattle, WA 98102", Lat: 47.62, Long: -122.32, StoreType: "supermarket"},
+		{StoreID: "bartell", Name: "Bartell Drugs", Addr: "600 Pine St, Seattle, WA 98101", Lat: 47.61, Long: -122.33},
+		// Never located, so left off the map.
+		{StoreID: "nowhere", Name: "Corner Store"},
+	}
+	fc := storesGeoJSON(stores)
+	if err := validateGeoJSON(fc); err != nil {
+		t.Fatalf("validateGeoJSON() = %v, want ok", err)
+	}
+
+	buf, err := json.Marshal(fc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Type     string `json:"type"`
+			ID       string `json:"id"`
+			Geometry struct {
+				Type        string    `json:"type"`
+				Coordinates []float64 `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "FeatureCollection" || len(got.Features) != 2 {
+		t.Fatalf("got %s with %d features, want a FeatureCollection with 2", got.Type, len(got.Features))
+	}
+	f := got.Features[1]
+	if f.Type != "Feature" || f.ID != "qfc" || f.Geometry.Type != "Point" {
+		t.Errorf("got feature %+v, want the qfc Feature with a Point geometry", f)
+	}
+	// GeoJSON puts the longitude first.
+	if c := f.Geometry.Coordinates; len(c) != 2 || c[0] != -122.32 || c[1] != 47.62 {
+		t.Errorf("got coordinates %v, want [-122.32 47.62]", c)
+	}
+	if f.Properties["store_id"] != "qfc" || f.Properties["name"] != "QFC" || f.Properties["address"] != "500 Broadway E, Seattle, WA 98102" || f.Properties["store_type"] != "supermarket" {
+		t.Errorf("got properties %v, want the store's id, name, address, and type", f.Properties)
+	}
+
+	// An empty export is still a FeatureCollection with a features array.
+	if buf, _ := json.Marshal(storesGeoJSON(nil)); string(buf) != `{"type":"FeatureCollection","features":[]}` {
+		t.Errorf("got empty export %s, want an empty features array", buf)
+	}
+}
+
+func TestValidateGeoJSON(t *testing.T) {
+	point := func(long, lat float64) *GeoJSONFeature {
+		return &GeoJSONFeature{Type: "Feature", Geometry: &GeoJSONPoint{Type: "Point", Coordinates: [2]float64{long, lat}}}
+	}
+	for name, fc := range map[string]*GeoJSONFeatureCollection{
+		"wrong type":        {Type: "Feature", Features: []*GeoJSONFeature{}},
+		"missing features":  {Type: "FeatureCollection"},
+		"no geometry":       {Type: "FeatureCollection", Features: []*GeoJSONFeature{{Type: "Feature"}}},
+		"lat and long swap": {Type: "FeatureCollection", Features: []*GeoJSONFeature{point(47.62, -122.32)}},
+	} {
+		if err := validateGeoJSON(fc); err == nil {
+			t.Errorf("validateGeoJSON() with %s succeeded, want error", name)
+		}
+	}
+}
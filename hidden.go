@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// HiddenItem marks a catalog item as hidden. Hidden items are left out of the item tokens
+// and prefix matches, but their stock reports are kept and can still be queried by name.
+type HiddenItem struct {
+	Name         string `datastore:"name" json:"name"`
+	TimestampSec int64  `datastore:"timestampSec" json:"timestamp_sec"`
+}
+
+// hiddenItems caches the names of the hidden items. It is loaded at startup and updated by
+// HideItem. Other server instances pick up a change when they restart. The item catalog is
+// shared by every client app, so hidden items are always stored in storageNamespace.
+var hiddenItems = &hiddenItemSet{names: make(map[string]bool)}
+
+type hiddenItemSet struct {
+	mu    sync.RWMutex
+	names map[string]bool
+}
+
+func (h *hiddenItemSet) has(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.names[name]
+}
+
+func (h *hiddenItemSet) len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.names)
+}
+
+func (h *hiddenItemSet) set(name string, hidden bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hidden {
+		h.names[name] = true
+	} else {
+		delete(h.names, name)
+	}
+}
+
+func (h *hiddenItemSet) replace(names map[string]bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.names = names
+}
+
+// ******************************************
+// ** BEGIN HideItem
+// ******************************************
+
+type HideItemReq struct {
+	ItemName string `json:"item_name"`
+	// Hidden hides the item if true and shows it again if false.
+	Hidden bool `json:"hidden"`
+}
+
+// HideItem hides or shows a catalog item. It is an admin endpoint.
+func HideItem(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req HideItemReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidateHideItemReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	ctx = withNamespace(ctx, storageNamespace)
+	if req.Hidden {
+		hi := &HiddenItem{Name: req.ItemName, TimestampSec: time.Now().Unix()}
+		if err := createHiddenItemInStorage(ctx, hi); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	} else if err := deleteHiddenItemInStorage(ctx, req.ItemName); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	hiddenItems.set(req.ItemName, req.Hidden)
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateHideItemReq(req *HideItemReq) error {
+	req.ItemName = strings.ToLower(strings.TrimSpace(req.ItemName))
+	if req.ItemName == "" {
+		return fmt.Errorf("missing item name")
+	}
+	for _, name := range itemNames {
+		if name == req.ItemName {
+			return nil
+		}
+	}
+	return fmt.Errorf("item %q is not in the catalog", req.ItemName)
+}
+
+// ******************************************
+// ** END HideItem
+// ******************************************
+
+func createHiddenItemInStorage(ctx context.Context, hi *HiddenItem) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, err := client.Put(ctx, nameKey(ctx, HiddenItemKind, hi.Name), hi); err != nil {
+		return fmt.Errorf("failed to create hidden item in storage: %v", err)
+	}
+	return nil
+}
+
+func deleteHiddenItemInStorage(ctx context.Context, name string) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Delete(ctx, nameKey(ctx, HiddenItemKind, name)); err != nil {
+		return fmt.Errorf("failed to delete hidden item in storage: %v", err)
+	}
+	return nil
+}
+
+// loadHiddenItems replaces the cached hidden items with the ones in storage.
+func loadHiddenItems(ctx context.Context) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	names := make(map[string]bool)
+	it := client.Run(ctx, newQuery(ctx, HiddenItemKind))
+	for {
+		var hi HiddenItem
+		_, err := it.Next(&hi)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to query for all hidden items: %v", err)
+		}
+		names[hi.Name] = true
+	}
+	hiddenItems.replace(names)
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestHiddenItemsLeftOutOfTokens(t *testing.T) {
+	hidden := itemNames[1]
+	hiddenItems.set(hidden, true)
+	defer hiddenItems.set(hidden, false)
+
+	resp := itemTokenInfos(defaultLang)
+	if len(resp) != len(itemNames)-1 {
+		t.Fatalf("got tokens for %d items, want %d", len(resp), len(itemNames)-1)
+	}
+	for _, info := range resp {
+		if info.Name == hidden {
+			t.Fatalf("hidden item %q is in the item tokens", hidden)
+		}
+	}
+
+	hiddenItems.set(hidden, false)
+	if resp := itemTokenInfos(defaultLang); len(resp) != len(itemNames) || resp[1].Name != hidden {
+		t.Errorf("item %q is still left out of the item tokens after showing it", hidden)
+	}
+}
+
+func TestHiddenItemsLeftOutOfPrefixMatches(t *testing.T) {
+	hiddenItems.set("acorn squash", true)
+	defer hiddenItems.set("acorn squash", false)
+
+	names, _ := matchItemNames("acorn", prefixMatch)
+	for _, name := range names {
+		if name == "acorn squash" {
+			t.Errorf("hidden item %q is in the prefix matches %q", name, names)
+		}
+	}
+}
+
+func TestCleanAndValidateHideItemReq(t *testing.T) {
+	req := &HideItemReq{ItemName: " Acorn Squash "}
+	if err := cleanAndValidateHideItemReq(req); err != nil || req.ItemName != "acorn squash" {
+		t.Errorf("cleanAndValidateHideItemReq() = %v with name %q, want acorn squash", err, req.ItemName)
+	}
+	for _, name := range []string{"", "xqzvwkpj"} {
+		if err := cleanAndValidateHideItemReq(&HideItemReq{ItemName: name}); err == nil {
+			t.Errorf("cleanAndValidateHideItemReq(%q) succeeded, want error", name)
+		}
+	}
+}
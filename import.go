@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// importItemBatch is the most items updated in one storage transaction of ImportReports.
+const importItemBatch = 25
+
+// maxImportReports caps the number of reports in an ImportReports request, set with the
+// MAX_IMPORT_REPORTS env variable.
+var maxImportReports = positiveIntFromEnv("MAX_IMPORT_REPORTS", 500)
+
+// ******************************************
+// ** BEGIN ImportReports
+// ******************************************
+
+type ImportReportsReq struct {
+	Reports []*UploadReportReq `json:"reports"`
+}
+
+type ImportReportsResp struct {
+	// Imported is the number of reports that were imported in full.
+	Imported int `json:"imported"`
+	// Results are the outcome of each report, in request order.
+	Results []*ImportReportResult `json:"results"`
+}
+
+// ImportReportResult is the outcome of a report in an ImportReports request. Error is empty
+// if the report was imported.
+type ImportReportResult struct {
+	Index int    `json:"index"`
+	Error string `json:"error,omitempty"`
+}
+
+// ImportReports uploads many stock reports at once, to seed test and demo data. Each report
+// is validated like in UploadReport, and the reports on each item are applied together,
+// importItemBatch items per storage transaction. A report that fails doesn't stop the
+// others. Photos aren't imported and no webhook events are sent. It is an admin endpoint.
+func ImportReports(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ImportReportsReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if len(req.Reports) == 0 {
+		return http.StatusBadRequest, fmt.Errorf("missing reports")
+	}
+	if len(req.Reports) > maxImportReports {
+		return http.StatusBadRequest, fmt.Errorf("got %d reports, want at most %d", len(req.Reports), maxImportReports)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	errs := validateImportReports(req.Reports)
+	var userIDs, storeIDs, names []string
+	for i, rep := range req.Reports {
+		if errs[i] != nil {
+			continue
+		}
+		userIDs = append(userIDs, rep.UserID)
+		storeIDs = append(storeIDs, rep.StoreID)
+		names = append(append(append(names, rep.InStock...), rep.OutStock...), rep.Unknown...)
+	}
+	users, err := getUsersInStorage(ctx, client, uniqueStrings(userIDs))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	stores, err := getStoresInStorage(ctx, client, uniqueStrings(storeIDs))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	aliases, err := getItemAliases(ctx, client, uniqueStrings(names))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	plan := planReportImport(req.Reports, errs, users, stores, aliases)
+	failed := importItemReportsInStorage(ctx, client, plan, time.Now().Unix())
+
+	resp := &ImportReportsResp{Results: make([]*ImportReportResult, len(req.Reports))}
+	for i := range req.Reports {
+		res := &ImportReportResult{Index: i}
+		if errs[i] != nil {
+			res.Error = errs[i].Error()
+		} else if err := failed[i]; err != nil {
+			res.Error = err.Error()
+		} else {
+			resp.Imported++
+		}
+		resp.Results[i] = res
+	}
+
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END ImportReports
+// ******************************************
+
+// importedStock is an item's stock state from one of the reports being imported.
+type importedStock struct {
+	report int
+	store  *Store
+	user   *User
+	stock  *itemStock
+}
+
+// validateImportReports cleans and validates the reports like UploadReport does and returns
+// the error of each invalid report, by index.
+func validateImportReports(reports []*UploadReportReq) map[int]error {
+	errs := make(map[int]error)
+	for i, rep := range reports {
+		switch {
+		case rep == nil:
+			errs[i] = fmt.Errorf("missing report")
+		case len(rep.Photo) > 0:
+			errs[i] = fmt.Errorf("photos can't be imported")
+		default:
+			if err := cleanAndValidateUploadReportReq(rep); err != nil {
+				errs[i] = err
+			}
+		}
+	}
+	return errs
+}
+
+// planReportImport groups the item stock states of the reports by item name, in report
+// order. Reports with an error in errs are skipped; reports with an unknown user or store
+// get an error added.
+func planReportImport(reports []*UploadReportReq, errs map[int]error, users map[string]*User, stores map[string]*Store, aliases map[string]string) map[string][]*importedStock {
+	plan := make(map[string][]*importedStock)
+	for i, rep := range reports {
+		if errs[i] != nil {
+			continue
+		}
+		user, ok := users[rep.UserID]
+		if !ok {
+			errs[i] = fmt.Errorf("user id is invalid: %q", rep.UserID)
+			continue
+		}
+		store, ok := stores[rep.StoreID]
+		if !ok {
+			errs[i] = fmt.Errorf("store id is invalid: %q", rep.StoreID)
+			continue
+		}
+
+		// As in UploadReport, an aliased item in several lists is kept in the first.
+		seen := make(map[string]bool)
+		add := func(names []string, inStock, unknown bool) {
+			for _, name := range applyItemAliases(names, aliases, seen) {
+				plan[name] = append(plan[name], &importedStock{
+					report: i,
+					store:  store,
+					user:   user,
+					stock:  &itemStock{Name: name, InStock: inStock, Unknown: unknown},
+				})
+			}
+		}
+		add(rep.InStock, true, false)
+		add(rep.OutStock, false, false)
+		add(rep.Unknown, false, true)
+	}
+	return plan
+}
+
+// applyImportedStock records the imported stock states on the item.
+func applyImportedStock(item *Item, stocks []*importedStock, now int64) {
+	dedupUsersInfo(item)
+	for _, s := range stocks {
+		addStockReport(item, s.store, s.user, s.stock, now)
+	}
+}
+
+// importItemReportsInStorage applies the planned stock states to the items in storage,
+// importItemBatch items per transaction. It returns the error of each report that had an item
+// fail to update, by index.
+func importItemReportsInStorage(ctx context.Context, client *datastore.Client, plan map[string][]*importedStock, now int64) map[int]error {
+	names := make([]string, 0, len(plan))
+	for name := range plan {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failed := make(map[int]error)
+	for len(names) > 0 {
+		batch := names
+		if len(batch) > importItemBatch {
+			batch = batch[:importItemBatch]
+		}
+		names = names[len(batch):]
+
+		keys := make([]*datastore.Key, len(batch))
+		for i, name := range batch {
+			keys[i] = nameKey(ctx, ItemKind, name)
+		}
+		if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			items := make([]Item, len(batch))
+			err := tx.GetMulti(keys, items)
+			merr, isMultiErr := err.(datastore.MultiError)
+			if err != nil && !isMultiErr {
+				return err
+			}
+			for i, name := range batch {
+				if isMultiErr && merr[i] != nil {
+					if merr[i] != datastore.ErrNoSuchEntity {
+						return merr[i]
+					}
+					items[i] = Item{Name: name, StockReports: make([]*StockReport, 0)}
+				}
+				applyImportedStock(&items[i], plan[name], now)
+			}
+			_, err = tx.PutMulti(keys, items)
+			return err
+		}); err != nil {
+			err = fmt.Errorf("failed to update items in storage: %v", err)
+			for _, name := range batch {
+				for _, s := range plan[name] {
+					failed[s.report] = err
+				}
+			}
+		}
+	}
+	return failed
+}
+
+// uniqueStrings returns the non-empty strings without duplicates, in their first order.
+func uniqueStrings(strs []string) []string {
+	seen := make(map[string]bool, len(strs))
+	res := make([]string, 0, len(strs))
+	for _, s := range strs {
+		if s != "" && !seen[s] {
+			seen[s] = true
+			res = append(res, s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImportReports(t *testing.T) {
+	users := map[string]*User{"alice": {UserID: "alice"}, "bob": {UserID: "bob"}}
+	stores := map[string]*Store{
+		"s1": {StoreID: "s1", Name: "QFC"},
+		"s2": {StoreID: "s2", Name: "Safeway"},
+	}
+	aliases := map[string]string{"all purpose flour": "flour"}
+	reports := []*UploadReportReq{
+		{UserID: "alice", StoreID: "s1", InStock: []string{"Milk", "all purpose flour"}, OutStock: []string{"yeast"}},
+		{UserID: "bob", StoreID: "s1", InStock: []string{"milk"}, Unknown: []string{"flour"}},
+		{UserID: "alice", StoreID: "s2", OutStock: []string{"milk"}},
+		{UserID: "carol", StoreID: "s1", InStock: []string{"milk"}},
+		{UserID: "bob", StoreID: "gone", InStock: []string{"milk"}},
+		{UserID: "bob", StoreID: "s2"},
+		nil,
+		{UserID: "bob", StoreID: "s2", InStock: []string{"milk"}, Photo: []byte("shelf")},
+	}
+
+	errs := validateImportReports(reports)
+	plan := planReportImport(reports, errs, users, stores, aliases)
+	for i, wantErr := range []bool{false, false, false, true, true, true, true, true} {
+		if gotErr := errs[i] != nil; gotErr != wantErr {
+			t.Errorf("report %d got error %v, want error: %t", i, errs[i], wantErr)
+		}
+	}
+
+	items := make(map[string]*Item)
+	for name, stocks := range plan {
+		items[name] = &Item{Name: name}
+		applyImportedStock(items[name], stocks, 100)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want milk, flour, and yeast", len(items))
+	}
+
+	milk := items["milk"].StockReports
+	if len(milk) != 2 {
+		t.Fatalf("got %d milk reports, want 2: %+v", len(milk), milk)
+	}
+	// Alice and bob both saw milk in stock at QFC.
+	if sr := milk[0]; sr.StoreInfo.StoreID != "s1" || !sr.InStock || sr.SeenCnt != 2 {
+		t.Errorf("got milk report %+v, want in stock at s1 seen twice", sr)
+	}
+	if sr := milk[1]; sr.StoreInfo.StoreID != "s2" || sr.InStock || sr.SeenCnt != 1 {
+		t.Errorf("got milk report %+v, want out of stock at s2 seen once", sr)
+	}
+
+	// The aliased name was imported under its canonical item.
+	flour := items["flour"].StockReports
+	if len(flour) != 2 || !flour[0].InStock || !flour[1].Unknown || flour[1].UsersInfo[0].UserID != "bob" {
+		t.Errorf("got flour reports %+v, want alice's in-stock and bob's unknown report", flour)
+	}
+	if yeast := items["yeast"].StockReports; len(yeast) != 1 || yeast[0].InStock || yeast[0].TimestampSec != 100 {
+		t.Errorf("got yeast reports %+v, want one out-of-stock report", yeast)
+	}
+}
+
+func TestUniqueStrings(t *testing.T) {
+	got := uniqueStrings([]string{"b", "a", "", "b", "c", "a"})
+	want := []string{"b", "a", "c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("uniqueStrings() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ******************************************
+// ** BEGIN QueryInStockStores
+// ******************************************
+
+type QueryInStockStoresReq struct {
+	UserID   string `json:"user_id"`
+	ItemName string `json:"item_name"`
+	// RadiusMiles limits the stores to those within the radius of the user. It defaults to
+	// the user's DefaultRadiusMiles.
+	RadiusMiles float64 `json:"radius_miles"`
+	// CoordsReq optionally gives the coordinates to rank by distance from. See
+	// resolveUserCoords.
+	CoordsReq
+}
+
+// QueryInStockStores fetches the stores where an item is currently reported in stock, nearest
+// first, then most recently reported. A store counts if its latest report on the item that
+// says either way is in stock and no older than maxReportAgeDays; see currentlyInStock.
+// Unlike QueryItems, out-of-stock reports are left out, as are reports at deleted stores
+// unless deletedStoreReports is deletedStoreKeep, which doesn't check the stores.
+func QueryInStockStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryInStockStoresReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryInStockStoresReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	coords, source, err := resolveUserCoords(ctx, req.CoordsReq, u)
+	if err != nil {
+		return zipCodeErrStatus(err), err
+	}
+	w.Header().Set(coordsSourceHeader, source)
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	aliases, err := getItemAliases(ctx, client, []string{req.ItemName})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if canonical, ok := aliases[req.ItemName]; ok {
+		req.ItemName = canonical
+	}
+	name := req.ItemName
+	if req.ItemName, _ = resolveItemName(name); req.ItemName != name {
+		w.Header().Set(matchHintHeader, fmt.Sprintf("%q matched catalog item %q", name, req.ItemName))
+	}
+
+	found, err := getItemsInStorage(ctx, client, []string{req.ItemName})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	var items []*Item
+	if item, ok := found[req.ItemName]; ok && !hiddenItems.has(item.Name) {
+		items = append(items, item)
+	}
+	if err := checkDeletedStoresInStorage(ctx, client, items); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := inStockStores(items, coords, searchRadius(req.RadiusMiles, u))
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryInStockStoresReq(req *QueryInStockStoresReq) error {
+	req.ItemName = strings.ToLower(req.ItemName)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ItemName == "" {
+		return fmt.Errorf("missing item name")
+	}
+	if err := req.CoordsReq.validate(); err != nil {
+		return err
+	}
+	return validateSearchRadius(req.RadiusMiles)
+}
+
+// ******************************************
+// ** END QueryInStockStores
+// ******************************************
+
+// inStockStores returns the item infos of the stores within radiusMiles of coords where the
+// items are currently in stock, sorted with sortItems.
+func inStockStores(items []*Item, coords coord, radiusMiles float64) QueryItemsResp {
+	resp := make(QueryItemsResp, 0)
+	for _, item := range items {
+		current := &Item{Name: item.Name, StockReports: currentlyInStock(item)}
+		resp = append(resp, parseItem(current)...)
+	}
+	resp = freshItems(resp, maxReportAgeDays*secondsToDay)
+	resp = itemsWithinRadius(resp, coords, radiusMiles)
+	sortItems(resp, coords)
+	return resp
+}
+
+// currentlyInStock returns the item's latest report at each store that says whether it's in
+// stock, if that report is in stock. A store whose latest report is out of stock is left
+// out even if it was reported in stock before, as are deleted stores under
+// deletedStoreFlag.
+func currentlyInStock(item *Item) []*StockReport {
+	latest := make(map[string]*StockReport)
+	var storeIDs []string
+	for _, sr := range item.StockReports {
+		if sr.StoreInfo == nil || sr.Unknown {
+			continue
+		}
+		id := sr.StoreInfo.StoreID
+		prev, ok := latest[id]
+		if !ok {
+			storeIDs = append(storeIDs, id)
+		}
+		if !ok || sr.TimestampSec > prev.TimestampSec {
+			latest[id] = sr
+		}
+	}
+	var res []*StockReport
+	for _, id := range storeIDs {
+		if sr := latest[id]; sr.InStock && !sr.StoreDeleted {
+			res = append(res, sr)
+		}
+	}
+	return res
+}
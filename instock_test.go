@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestInStockStores(t *testing.T) {
+	now := time.Now().Unix()
+	origin := coord{Lat: 47.6, Long: -122.3}
+	near := &Store{StoreID: "near", Name: "QFC", Lat: 47.61, Long: -122.3}
+	nearer := &Store{StoreID: "nearer", Name: "Safeway", Lat: 47.601, Long: -122.3}
+	nearer2 := &Store{StoreID: "nearer2", Name: "Safeway", Lat: 47.601, Long: -122.3}
+	sold := &Store{StoreID: "sold", Name: "Fred Meyer", Lat: 47.6, Long: -122.31}
+	stale := &Store{StoreID: "stale", Name: "Bartell Drugs", Lat: 47.6, Long: -122.3}
+	far := &Store{StoreID: "far", Name: "Costco", Lat: 48.6, Long: -122.3}
+	staleSec := now - int64(maxReportAgeDays+1)*secondsToDay
+	item := &Item{Name: "flour", StockReports: []*StockReport{
+		{StoreInfo: near, InStock: true, SeenCnt: 1, TimestampSec: now - 600},
+		// An unknown report doesn't say the store sold out.
+		{StoreInfo: near, Unknown: true, SeenCnt: 1, TimestampSec: now - 60},
+		{StoreInfo: nearer, InStock: true, SeenCnt: 1, TimestampSec: now - 300},
+		{StoreInfo: nearer2, InStock: true, SeenCnt: 1, TimestampSec: now - 30},
+		// In stock, then sold out.
+		{StoreInfo: sold, InStock: true, SeenCnt: 3, TimestampSec: now - 900},
+		{StoreInfo: sold, InStock: false, SeenCnt: 1, TimestampSec: now - 120},
+		{StoreInfo: stale, InStock: true, SeenCnt: 1, TimestampSec: staleSec},
+		{StoreInfo: far, InStock: true, SeenCnt: 1, TimestampSec: now - 60},
+	}}
+
+	got := inStockStores([]*Item{item}, origin, 10)
+	// Equidistant stores rank the most recent report first.
+	want := []string{"Safeway", "Safeway", "QFC"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d stores, want %d: %+v", len(got), len(want), got)
+	}
+	for i, name := range want {
+		if got[i].StoreName != name || !got[i].InStock {
+			t.Errorf("store %d = %+v, want in stock at %s", i, got[i], name)
+		}
+	}
+	if got[0].SecondsAgo > got[1].SecondsAgo {
+		t.Errorf("got the %ds old report ahead of the %ds old one at the same distance", got[0].SecondsAgo, got[1].SecondsAgo)
+	}
+
+	if got := inStockStores([]*Item{item}, origin, 0); len(got) != 4 || got[3].StoreName != far.Name {
+		t.Errorf("got %d stores without a radius, want 4 ending with %s", len(got), far.Name)
+	}
+}
+
+func TestCurrentlyInStockDeletedStore(t *testing.T) {
+	st := &Store{StoreID: "gone", Name: "QFC"}
+	item := &Item{Name: "flour", StockReports: []*StockReport{
+		{StoreInfo: st, InStock: true, StoreDeleted: true, TimestampSec: 100},
+	}}
+	if got := currentlyInStock(item); len(got) != 0 {
+		t.Errorf("got %d reports at a deleted store, want none", len(got))
+	}
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/csv"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -16,37 +19,171 @@ import (
 )
 
 const (
-	secondsToHour = 3600
-	secondsToDay  = 3600 * 24
+	secondsToMinute = 60
+	secondsToHour   = 3600
+	secondsToDay    = 3600 * 24
 )
 
-// TODO: Clean up StockReport entities in Item storage that are >7 days old.
-
 type Item struct {
-	Name         string         `datastore:"name"`
-	StockReports []*StockReport `datastore:"stock_report"`
+	Name         string         `datastore:"name" json:"name"`
+	StockReports []*StockReport `datastore:"stock_report" json:"stock_report"`
 }
 
 type Tokens []string
 
+const defaultLang = "en"
+
 var itemNames []string
 var itemTokens []Tokens
 
+// localizedItemTokens maps a language code to each item name's tokens in that language.
+// English tokens live in itemTokens.
+var localizedItemTokens map[string]map[string]Tokens
+
+// localizedItemNames maps a language code to the space-joined tokens of each item in that
+// language, to the item name. It resolves names like "pechuga de pollo" to "chicken breast".
+var localizedItemNames map[string]map[string]string
+
+// itemIndex maps each item name to its index in itemNames.
+var itemIndex map[string]int
+
+// itemsByTokens is a reverse index from the tokenKey of each item's English tokens to the
+// item's index in itemNames. It resolves variants of an item name, such as "breast chicken"
+// for "chicken breast". Where items share tokens, the first one in the data file wins.
+var itemsByTokens map[string]int
+
 func init() {
-	f, err := os.Open("./assets/itemsAndTokens.txt")
+	// Keep ordering of item token data
+	err := scanDataFile("./assets/itemsAndTokens.txt", func(line string) error {
+		data := strings.Split(line, ":")
+		if len(data) != 2 {
+			return fmt.Errorf("want `<item name>:<tokens>`, got %q", line)
+		}
+		name := strings.TrimSpace(data[0])
+		if name == "" {
+			return fmt.Errorf("missing item name in %q", line)
+		}
+		tokens, err := parseTokens(data[1])
+		if err != nil {
+			return err
+		}
+		itemNames = append(itemNames, name)
+		itemTokens = append(itemTokens, tokens)
+		return nil
+	})
 	if err != nil {
-		log.Fatalf("failed to open items data file: %v", err)
+		log.Fatalf("failed to parse items data file: %v", err)
 	}
+	log.Println("successfully parsed item token data")
+
+	itemIndex = make(map[string]int, len(itemNames))
+	itemsByTokens = make(map[string]int, len(itemNames))
+	for i, name := range itemNames {
+		itemIndex[name] = i
+		if _, ok := itemsByTokens[tokenKey(itemTokens[i])]; !ok {
+			itemsByTokens[tokenKey(itemTokens[i])] = i
+		}
+	}
+
+	known := make(map[string]bool, len(itemNames))
+	for _, name := range itemNames {
+		known[name] = true
+	}
+	localizedItemTokens = make(map[string]map[string]Tokens)
+	localizedItemNames = make(map[string]map[string]string)
+	err = scanDataFile("./assets/itemTokensI18n.txt", func(line string) error {
+		data := strings.Split(line, ":")
+		if len(data) != 3 {
+			return fmt.Errorf("want `<language code>:<item name>:<tokens>`, got %q", line)
+		}
+		lang := strings.TrimSpace(data[0])
+		name := strings.TrimSpace(data[1])
+		if len(lang) != 2 || strings.ToLower(lang) != lang {
+			return fmt.Errorf("language code must be 2 lowercase letters, got %q", lang)
+		}
+		if !known[name] {
+			return fmt.Errorf("unknown item name %q", name)
+		}
+		tokens, err := parseTokens(data[2])
+		if err != nil {
+			return err
+		}
+		if localizedItemTokens[lang] == nil {
+			localizedItemTokens[lang] = make(map[string]Tokens)
+			localizedItemNames[lang] = make(map[string]string)
+		}
+		localizedItemTokens[lang][name] = tokens
+		localizedItemNames[lang][strings.Join(tokens, " ")] = name
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("failed to parse localized items data file: %v", err)
+	}
+	log.Println("successfully parsed localized item token data")
+}
+
+// scanDataFile calls fn with each line of the data file at path, skipping blank lines and
+// `#` comments. Errors are annotated with the line number.
+func scanDataFile(path string, fn func(line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
 	scanner := bufio.NewScanner(f)
 	scanner.Split(bufio.ScanLines)
-
-	// Keep ordering of item token data
+	lineNum := 0
 	for scanner.Scan() {
-		data := strings.Split(scanner.Text(), ":")
-		itemNames = append(itemNames, data[0])
-		itemTokens = append(itemTokens, strings.Split(data[1], ","))
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
 	}
-	log.Println("successfully parsed item token data")
+	return scanner.Err()
+}
+
+// tokenKey returns the tokens in sorted order, space-joined, so that the same tokens in any
+// order have the same key.
+func tokenKey(tokens []string) string {
+	sorted := append([]string{}, tokens...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, " ")
+}
+
+func parseTokens(s string) (Tokens, error) {
+	var tokens Tokens
+	for _, tok := range strings.Split(s, ",") {
+		if tok = strings.TrimSpace(tok); tok != "" {
+			tokens = append(tokens, tok)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("missing tokens")
+	}
+	return tokens, nil
+}
+
+// localizedTokens returns the tokens of the item at index i in lang along with the language
+// they're in. It falls back to English when the item has no tokens in lang.
+func localizedTokens(i int, lang string) (Tokens, string) {
+	if tokens, ok := localizedItemTokens[lang][itemNames[i]]; ok {
+		return tokens, lang
+	}
+	return itemTokens[i], defaultLang
+}
+
+// resolveLocalizedItemName maps an item name in lang to the item name used in storage.
+// Names that don't match a localized item are assumed to already be English.
+func resolveLocalizedItemName(name, lang string) string {
+	if canonical, ok := localizedItemNames[lang][name]; ok {
+		return canonical
+	}
+	return name
 }
 
 // ******************************************
@@ -55,6 +192,8 @@ func init() {
 
 type QueryItemTokensReq struct {
 	UserID string `json:"user_id"`
+	// Lang is the language code of the tokens to return. Defaults to English.
+	Lang string `json:"lang"`
 }
 
 type QueryItemTokensResp []*ItemTokenInfo
@@ -62,6 +201,9 @@ type QueryItemTokensResp []*ItemTokenInfo
 type ItemTokenInfo struct {
 	Name   string   `json:"name"`
 	Tokens []string `json:"tokens"`
+	// Lang is the language of Tokens. It is English if the item has no tokens in the
+	// requested language.
+	Lang string `json:"lang"`
 }
 
 func QueryItemTokens(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -88,28 +230,117 @@ func QueryItemTokens(ctx context.Context, w http.ResponseWriter, r *http.Request
 	}
 	defer client.Close()
 
+	resp := itemTokenInfos(req.Lang)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// itemTokenInfos returns the tokens of every catalog item that isn't hidden.
+func itemTokenInfos(lang string) QueryItemTokensResp {
 	var resp QueryItemTokensResp
 	for i := 0; i < len(itemNames); i++ {
+		if hiddenItems.has(itemNames[i]) {
+			continue
+		}
+		tokens, tokensLang := localizedTokens(i, lang)
 		resp = append(resp, &ItemTokenInfo{
 			Name:   itemNames[i],
-			Tokens: itemTokens[i],
+			Tokens: tokens,
+			Lang:   tokensLang,
 		})
 	}
+	return resp
+}
+
+func validateQueryItemTokensReq(req *QueryItemTokensReq) error {
+	req.Lang = strings.ToLower(strings.TrimSpace(req.Lang))
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END QueryItemTokens
+// ******************************************
+
+// ******************************************
+// ** Begin GetItemTokens
+// ******************************************
+
+type GetItemTokensReq struct {
+	UserID   string `json:"user_id"`
+	ItemName string `json:"item_name"`
+	// Lang is the language code of ItemName and of the tokens to return. Defaults to English.
+	Lang string `json:"lang"`
+}
+
+type GetItemTokensResp ItemTokenInfo
+
+// GetItemTokens fetches the tokens of a single catalog item, so clients needn't download the
+// whole catalog with QueryItemTokens. The item may be named by its tokens in the requested
+// language.
+func GetItemTokens(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req GetItemTokensReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateGetItemTokensReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	info, ok := itemTokenInfo(req.ItemName, req.Lang)
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("item %q is not in the item catalog", req.ItemName)
+	}
+	resp := GetItemTokensResp(*info)
 	if err := EncodeResp(w, &resp); err != nil {
 		return http.StatusInternalServerError, err
 	}
 	return http.StatusOK, nil
 }
 
-func validateQueryItemTokensReq(req *QueryItemTokensReq) error {
+func cleanAndValidateGetItemTokensReq(req *GetItemTokensReq) error {
+	req.ItemName = strings.ToLower(strings.TrimSpace(req.ItemName))
+	req.Lang = strings.ToLower(strings.TrimSpace(req.Lang))
 	if req.UserID == "" {
 		return fmt.Errorf("missing user id")
 	}
+	if req.ItemName == "" {
+		return fmt.Errorf("missing item name")
+	}
 	return nil
 }
 
+// itemTokenInfo returns the tokens of the catalog item named name in lang. Names that aren't
+// catalog names are looked up by their words in the itemsByTokens reverse index. Hidden
+// items aren't found.
+func itemTokenInfo(name, lang string) (*ItemTokenInfo, bool) {
+	name = resolveLocalizedItemName(name, lang)
+	i, ok := itemIndex[name]
+	if !ok {
+		i, ok = itemsByTokens[tokenKey(strings.Fields(name))]
+	}
+	if !ok || hiddenItems.has(itemNames[i]) {
+		return nil, false
+	}
+	tokens, tokensLang := localizedTokens(i, lang)
+	return &ItemTokenInfo{Name: itemNames[i], Tokens: tokens, Lang: tokensLang}, true
+}
+
 // ******************************************
-// ** END QueryItemTokens
+// ** END GetItemTokens
 // ******************************************
 
 // ******************************************
@@ -119,22 +350,117 @@ func validateQueryItemTokensReq(req *QueryItemTokensReq) error {
 type QueryItemsReq struct {
 	UserID   string `json:"user_id"`
 	ItemName string `json:"item_name"`
+	// ItemNames queries several items at once, in place of ItemName. The response is then a
+	// QueryItemsByNameResp.
+	ItemNames []string `json:"item_names"`
+	// Version selects the response format. See splitAgesVersion.
+	Version int `json:"version"`
+	// Lang is the language code of ItemName. Defaults to English.
+	Lang string `json:"lang"`
+	// Match is how ItemName is matched against the item catalog: exactMatch (default) or
+	// prefixMatch.
+	Match string `json:"match"`
+	// RadiusMiles limits the reports to those from stores within the radius of the user's zip
+	// code. It defaults to the user's DefaultRadiusMiles.
+	RadiusMiles float64 `json:"radius_miles"`
+	// AllReports includes reports seen fewer than minReportSeenCnt times.
+	AllReports bool `json:"all_reports"`
+	// CoordsReq optionally gives the coordinates to sort by distance from. See
+	// resolveUserCoords for when they are used.
+	CoordsReq
 }
 
+const (
+	exactMatch  = "exact"
+	prefixMatch = "prefix"
+
+	// matchHintHeader explains why a QueryItems match mode was not applied, or which catalog
+	// item an exact match name resolved to.
+	matchHintHeader = "X-Match-Hint"
+	// maxPrefixMatches caps the number of catalog items a prefix query fans out to.
+	maxPrefixMatches = 25
+)
+
+// maxQueryItemNames caps the number of item names in a QueryItems request, set with the
+// MAX_QUERY_ITEM_NAMES env variable.
+var maxQueryItemNames = positiveIntFromEnv("MAX_QUERY_ITEM_NAMES", 25)
+
+// minFuzzyQueryLen is the shortest item name for which matching other than exact is
+// allowed. Shorter names match nearly the whole catalog.
+var minFuzzyQueryLen = positiveIntFromEnv("MIN_FUZZY_QUERY_LEN", 3)
+
+// minReportSeenCnt is how many times a report must be seen before QueryItems includes it by
+// default, set with the MIN_REPORT_SEEN_COUNT env variable. The default of 1 includes every
+// report.
+var minReportSeenCnt = positiveIntFromEnv("MIN_REPORT_SEEN_COUNT", 1)
+
+// maxReportAgeDays is the age in days past which QueryItems leaves a stock report out, set
+// with the MAX_REPORT_AGE_DAYS env variable. Old reports say little about the shelves today.
+var maxReportAgeDays = positiveIntFromEnv("MAX_REPORT_AGE_DAYS", 7)
+
+// splitAgesVersion is the QueryItems response version from which HoursAgo and MinutesAgo
+// hold the remainder after whole days and hours, so that "1 day, 1 hour ago" is
+// DaysAgo: 1, HoursAgo: 1 rather than DaysAgo: 1, HoursAgo: 25. See totalAges.
+const splitAgesVersion = 2
+
+// catalogStatusVersion is the QueryItems response version from which the items are wrapped
+// in a QueryItemsEnvelope that says why there are none.
+const catalogStatusVersion = 3
+
 type QueryItemsResp []*ItemInfo
 
+// Catalog statuses of a queried item.
+const (
+	itemReported  = "reported"
+	itemNoReports = "no_reports"
+	itemUnknown   = "unknown"
+)
+
+// QueryItemsEnvelope is the QueryItems response from catalogStatusVersion on. Status tells
+// an item in the catalog that nobody reported yet apart from a name that isn't in the
+// catalog at all. For the latter, Suggestion is the closest catalog item name, if any.
+type QueryItemsEnvelope struct {
+	Items      QueryItemsResp `json:"items"`
+	Status     string         `json:"status"`
+	Suggestion string         `json:"suggestion,omitempty"`
+}
+
+// QueryItemsByNameResp is the QueryItems response to a request with ItemNames: the items of
+// each requested name, keyed by the name as requested but lowercased.
+type QueryItemsByNameResp map[string]QueryItemsResp
+
+// ItemInfo summarizes a stock report for the client. DaysAgo, HoursAgo, and MinutesAgo split
+// the age of the report as splitAge does, except before splitAgesVersion, where each is the
+// total age of the report in that unit. SecondsAgo is exact and ReportedAgo is meant for
+// display.
 type ItemInfo struct {
-	DaysAgo   int     `json:"daysAgo"`
-	HoursAgo  int     `json:"hoursAgo"`
-	StoreName string  `json:"storeName"`
-	StoreAddr string  `json:"storeAddress"`
-	StoreLat  float64 `json:"storeLat"`
-	StoreLng  float64 `json:"storeLong"`
-	InStock   bool    `json:"inStock"`
-	SeenCnt   int     `json:"seenCount"`
+	DaysAgo     int     `json:"daysAgo"`
+	HoursAgo    int     `json:"hoursAgo"`
+	MinutesAgo  int     `json:"minutesAgo"`
+	SecondsAgo  int     `json:"secondsAgo"`
+	ReportedAgo string  `json:"reportedAgo"`
+	StoreName   string  `json:"storeName"`
+	StoreAddr   string  `json:"storeAddress"`
+	StoreLat    float64 `json:"storeLat"`
+	StoreLng    float64 `json:"storeLong"`
+	InStock     bool    `json:"inStock"`
+	// Level is the graded stock level, if the report has one.
+	Level   StockLevel `json:"level,omitempty"`
+	SeenCnt int        `json:"seenCount"`
+	// ReporterCnt is the number of distinct users who made the report, and LastReportedSec
+	// the Unix time of the latest one. The users themselves aren't exposed.
+	ReporterCnt     int   `json:"reporterCount"`
+	LastReportedSec int64 `json:"lastReportedSec"`
+	// PhotoURL is a photo of the shelf uploaded with the report, if any.
+	PhotoURL string `json:"photoUrl,omitempty"`
+	// StoreDeleted is true for reports on stores that were deleted, under deletedStoreFlag.
+	StoreDeleted bool `json:"storeDeleted,omitempty"`
 }
 
-// QueryItems fetches the list of items in storage.
+// QueryItems fetches the list of items in storage. With exact match, names that aren't in the
+// catalog are resolved to catalog items with resolveItemName, so "tp" or "toilet papers"
+// finds "toilet paper", along with the reports uploaded as the name itself; see
+// exactMatchNames. Requests with ItemNames are answered by queryItemsByName.
 func QueryItems(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
 	var req QueryItemsReq
 	if err := DecodeReq(r.Body, &req); err != nil {
@@ -152,6 +478,11 @@ func QueryItems(ctx context.Context, w http.ResponseWriter, r *http.Request) (in
 	if !ok {
 		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
 	}
+	coords, source, err := resolveUserCoords(ctx, req.CoordsReq, u)
+	if err != nil {
+		return zipCodeErrStatus(err), err
+	}
+	w.Header().Set(coordsSourceHeader, source)
 
 	client, err := StorageClient(ctx)
 	if err != nil {
@@ -159,81 +490,781 @@ func QueryItems(ctx context.Context, w http.ResponseWriter, r *http.Request) (in
 	}
 	defer client.Close()
 
-	resp := make(QueryItemsResp, 0)
-	q := datastore.NewQuery(ItemKind).Filter("name =", req.ItemName)
-	it := client.Run(ctx, q)
-	for {
-		var t Item
-		_, err := it.Next(&t)
-		if err == iterator.Done {
-			break
+	if len(req.ItemNames) > 0 {
+		return queryItemsByName(ctx, w, client, &req, u, coords)
+	}
+
+	aliases, err := getItemAliases(ctx, client, []string{req.ItemName})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if canonical, ok := aliases[req.ItemName]; ok {
+		req.ItemName = canonical
+	}
+	resolved := true
+	asked := req.ItemName
+	if req.Match == exactMatch {
+		if req.ItemName, resolved = resolveItemName(asked); req.ItemName != asked {
+			w.Header().Set(matchHintHeader, fmt.Sprintf("%q matched catalog item %q", asked, req.ItemName))
 		}
-		if err != nil {
-			return http.StatusInternalServerError, fmt.Errorf("failed to query items: %v", err)
+	}
+
+	itemQueries.add(ctx, req.ItemName, u.ZipCode)
+
+	names, hint := matchItemNames(req.ItemName, req.Match)
+	if hint != "" {
+		w.Header().Set(matchHintHeader, hint)
+	}
+	if req.Match == exactMatch {
+		names = exactMatchNames(asked, req.ItemName)
+	}
+
+	loadItems := queryItemsInStorage
+	if strongRead(r) {
+		loadItems = lookupItemsInStorage
+	}
+	items, err := loadItems(ctx, client, names)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	// Names that aren't in the catalog may still have been reported, so they're only an error
+	// if nothing was found. Clients that get the catalog status are told in the envelope.
+	if !resolved && len(items) == 0 && req.Version < catalogStatusVersion {
+		return http.StatusNotFound, unknownItemError(req.ItemName)
+	}
+	if err := resolveStoreRefsInStorage(ctx, client, items); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := checkDeletedStoresInStorage(ctx, client, items); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := itemInfos(items, &req, u, coords)
+
+	if AcceptsCSV(r) {
+		w.Header().Set("Content-Type", csvContentType)
+		if err := writeItemsCSV(w, resp); err != nil {
+			return http.StatusInternalServerError, err
 		}
-		for _, itemInfo := range parseItem(&t) {
-			resp = append(resp, itemInfo)
+		return http.StatusOK, nil
+	}
+
+	if req.Version >= catalogStatusVersion {
+		env := catalogStatus(req.ItemName, names, resp)
+		if err := EncodeResp(w, env); err != nil {
+			return http.StatusInternalServerError, err
 		}
+		return http.StatusOK, nil
 	}
 
-	if err := sortItems(resp, u.ZipCode); err != nil {
+	if err := EncodeResp(w, &resp); err != nil {
 		return http.StatusInternalServerError, err
 	}
+	return http.StatusOK, nil
+}
 
-	if err := EncodeResp(w, &resp); err != nil {
+// queryItemsInStorage queries for the named items, in order. The query is only eventually
+// consistent, so it may miss a report uploaded moments ago.
+func queryItemsInStorage(ctx context.Context, client *datastore.Client, names []string) ([]*Item, error) {
+	var items []*Item
+	for _, name := range names {
+		q := newQuery(ctx, ItemKind).Filter("name =", name)
+		it := client.Run(ctx, q)
+		for {
+			var t Item
+			_, err := it.Next(&t)
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to query items: %v", err)
+			}
+			if featureEnabled(featureDedupUsersOnLoad) {
+				dedupUsersInfo(&t)
+			}
+			items = append(items, &t)
+		}
+	}
+	return items, nil
+}
+
+// lookupItemsInStorage looks the named items up by key, in order. Unlike queryItemsInStorage,
+// it's strongly consistent, at the cost of a batch lookup. See strongRead.
+func lookupItemsInStorage(ctx context.Context, client *datastore.Client, names []string) ([]*Item, error) {
+	found, err := getItemsInStorage(ctx, client, names)
+	if err != nil {
+		return nil, err
+	}
+	var items []*Item
+	for _, name := range names {
+		if item, ok := found[name]; ok {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// queryItemsByName answers a QueryItems request with ItemNames. Each name is resolved like
+// ItemName with exact match, and its items are sorted and filtered the same way. Names
+// without items get an empty list rather than an error.
+func queryItemsByName(ctx context.Context, w http.ResponseWriter, client *datastore.Client, req *QueryItemsReq, u *User, coords coord) (int, error) {
+	localized := make([]string, len(req.ItemNames))
+	for i, name := range req.ItemNames {
+		localized[i] = resolveLocalizedItemName(name, req.Lang)
+	}
+	aliases, err := getItemAliases(ctx, client, uniqueStrings(localized))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	resolved := make(map[string][]string, len(req.ItemNames))
+	var names []string
+	for i, name := range req.ItemNames {
+		asked := localized[i]
+		if alias, ok := aliases[asked]; ok {
+			asked = alias
+		}
+		canonical, _ := resolveItemName(asked)
+		resolved[name] = exactMatchNames(asked, canonical)
+		names = append(names, resolved[name]...)
+		itemQueries.add(ctx, canonical, u.ZipCode)
+	}
+
+	items, err := getItemsInStorage(ctx, client, uniqueStrings(names))
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	loaded := make([]*Item, 0, len(items))
+	for _, item := range items {
+		loaded = append(loaded, item)
+	}
+	if err := checkDeletedStoresInStorage(ctx, client, loaded); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := make(QueryItemsByNameResp, len(req.ItemNames))
+	for _, name := range req.ItemNames {
+		var found []*Item
+		for _, n := range resolved[name] {
+			if item, ok := items[n]; ok {
+				found = append(found, item)
+			}
+		}
+		resp[name] = itemInfos(found, req, u, coords)
+	}
+	if err := EncodeResp(w, resp); err != nil {
 		return http.StatusInternalServerError, err
 	}
 	return http.StatusOK, nil
 }
 
+// itemInfos lists the stock reports on the items for a QueryItems response: no older than
+// maxReportAgeDays, sorted by distance from coords and recency, within the search radius,
+// corroborated, and in the requested version's format.
+func itemInfos(items []*Item, req *QueryItemsReq, u *User, coords coord) QueryItemsResp {
+	resp := make(QueryItemsResp, 0)
+	for _, item := range items {
+		for _, itemInfo := range parseItem(item) {
+			resp = append(resp, itemInfo)
+		}
+	}
+
+	resp = freshItems(resp, maxReportAgeDays*secondsToDay)
+	sortItems(resp, coords)
+	resp = itemsWithinRadius(resp, coords, searchRadius(req.RadiusMiles, u))
+	resp = corroboratedItems(resp, req.minSeenCnt())
+	if req.Version < splitAgesVersion {
+		totalAges(resp)
+	}
+	return resp
+}
+
 func cleanAndValidateQueryItemsReq(req *QueryItemsReq) error {
 	req.ItemName = strings.ToLower(req.ItemName)
+	req.Lang = strings.ToLower(strings.TrimSpace(req.Lang))
 	if req.UserID == "" {
 		return fmt.Errorf("missing user id")
 	}
-	if req.ItemName == "" {
+	if len(req.ItemNames) > 0 {
+		if err := cleanAndValidateQueryItemNames(req); err != nil {
+			return err
+		}
+	} else if req.ItemName == "" {
 		return fmt.Errorf("missing item name")
+	} else {
+		req.ItemName = resolveLocalizedItemName(req.ItemName, req.Lang)
 	}
+	switch req.Match {
+	case "":
+		req.Match = exactMatch
+	case exactMatch, prefixMatch:
+	default:
+		return fmt.Errorf("unknown match mode %q", req.Match)
+	}
+	if len(req.ItemNames) > 0 && req.Match != exactMatch {
+		return fmt.Errorf("item names only support %s match", exactMatch)
+	}
+	if err := req.CoordsReq.validate(); err != nil {
+		return err
+	}
+	return validateSearchRadius(req.RadiusMiles)
+}
+
+// cleanAndValidateQueryItemNames lowercases and dedupes the item names of a QueryItems
+// request. They're localized as they're resolved, so the response is keyed by the names the
+// client knows.
+func cleanAndValidateQueryItemNames(req *QueryItemsReq) error {
+	if req.ItemName != "" {
+		return fmt.Errorf("only one of item name and item names can be set")
+	}
+	if len(req.ItemNames) > maxQueryItemNames {
+		return fmt.Errorf("got %d item names, want at most %d", len(req.ItemNames), maxQueryItemNames)
+	}
+	names := make([]string, 0, len(req.ItemNames))
+	for i, name := range req.ItemNames {
+		if name == "" {
+			return fmt.Errorf("item name at index %d is empty", i)
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	req.ItemNames = uniqueStrings(names)
 	return nil
 }
 
+// minSeenCnt is how many times a report must be seen to be included in the response.
+func (req *QueryItemsReq) minSeenCnt() int {
+	if req.AllReports {
+		return 1
+	}
+	return minReportSeenCnt
+}
+
+// corroboratedItems returns the items whose reports were seen at least minSeenCnt times, in
+// the same order.
+func corroboratedItems(resp QueryItemsResp, minSeenCnt int) QueryItemsResp {
+	if minSeenCnt <= 1 {
+		return resp
+	}
+	res := make(QueryItemsResp, 0, len(resp))
+	for _, info := range resp {
+		if info.SeenCnt >= minSeenCnt {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+// freshItems returns the items whose reports are at most maxAgeSec old, in the same order.
+func freshItems(resp QueryItemsResp, maxAgeSec int) QueryItemsResp {
+	res := make(QueryItemsResp, 0, len(resp))
+	for _, info := range resp {
+		if info.SecondsAgo <= maxAgeSec {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+// itemsWithinRadius returns the items at stores within radiusMiles of coords, in the same
+// order. A zero radius keeps every item.
+func itemsWithinRadius(resp QueryItemsResp, coords coord, radiusMiles float64) QueryItemsResp {
+	if radiusMiles == 0 {
+		return resp
+	}
+	res := make(QueryItemsResp, 0, len(resp))
+	for _, info := range resp {
+		if Distance(info.StoreLat, info.StoreLng, coords.Lat, coords.Long) <= radiusMiles {
+			res = append(res, info)
+		}
+	}
+	return res
+}
+
+// exactMatchNames returns the item names an exact match query for asked loads: the catalog
+// name it resolved to and, if that differs, asked itself. Uploads store reports under the
+// name as reported, so reports of "toilet papers" are found along with "toilet paper".
+func exactMatchNames(asked, resolved string) []string {
+	if asked == resolved {
+		return []string{resolved}
+	}
+	return []string{resolved, asked}
+}
+
+// matchItemNames returns the item names to query for the given name and match mode. If the
+// mode can't be applied, it falls back to an exact match and returns a hint saying why.
+func matchItemNames(name, mode string) ([]string, string) {
+	if mode == exactMatch {
+		return []string{name}, ""
+	}
+	if len([]rune(name)) < minFuzzyQueryLen {
+		return []string{name}, fmt.Sprintf("%s match needs at least %d characters, used exact match", mode, minFuzzyQueryLen)
+	}
+	var names []string
+	for _, itemName := range itemNames {
+		if strings.HasPrefix(itemName, name) && !hiddenItems.has(itemName) {
+			names = append(names, itemName)
+			if len(names) == maxPrefixMatches {
+				break
+			}
+		}
+	}
+	if len(names) == 0 {
+		return []string{name}, ""
+	}
+	return names, ""
+}
+
+// catalogStatus wraps the items queried for name, which matched the catalog names, in an
+// envelope that says whether name is in the item catalog.
+func catalogStatus(name string, names []string, resp QueryItemsResp) *QueryItemsEnvelope {
+	env := &QueryItemsEnvelope{Items: resp, Status: itemReported}
+	if len(resp) > 0 {
+		return env
+	}
+	for _, n := range names {
+		for _, itemName := range itemNames {
+			if n == itemName {
+				env.Status = itemNoReports
+				return env
+			}
+		}
+	}
+	env.Status = itemUnknown
+	env.Suggestion = suggestItemName(name)
+	return env
+}
+
+// suggestItemName returns the catalog item name closest to name by edit distance, or "" if
+// no item name is close enough to be a likely typo.
+func suggestItemName(name string) string {
+	maxDist := len([]rune(name)) / 3
+	if maxDist < 1 {
+		return ""
+	}
+	best, bestDist := "", maxDist+1
+	for _, itemName := range itemNames {
+		if d := editDistance(name, itemName); d < bestDist {
+			best, bestDist = itemName, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
 // ******************************************
 // ** END QueryItems
 // ******************************************
 
+// loadAllItems fetches every item entity in storage.
+func loadAllItems(ctx context.Context, client *datastore.Client) ([]*Item, error) {
+	ctx, span := startClientSpan(ctx, "datastore.query Item")
+	defer span.Finish()
+	var items []*Item
+	q := newQuery(ctx, ItemKind)
+	it := client.Run(ctx, q)
+	for {
+		var t Item
+		_, err := it.Next(&t)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			span.SetError(err)
+			return nil, fmt.Errorf("failed to query for all items: %v", err)
+		}
+		if featureEnabled(featureDedupUsersOnLoad) {
+			dedupUsersInfo(&t)
+		}
+		items = append(items, &t)
+	}
+	if err := resolveStoreRefsInStorage(ctx, client, items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// storeSnapshot returns the store info to keep on a new stock report: the whole store, or
+// only its ID when featureEmbedStoreSnapshots is disabled.
+func storeSnapshot(store *Store) *Store {
+	if featureEnabled(featureEmbedStoreSnapshots) {
+		return store
+	}
+	return &Store{StoreID: store.StoreID}
+}
+
+// How QueryItems treats stock reports on stores that were deleted, set with the
+// DELETED_STORE_REPORTS env variable. Reports that embed a snapshot of their store outlive it.
+const (
+	// deletedStoreKeep lists the reports as they are, without checking their stores.
+	deletedStoreKeep = "keep"
+	// deletedStoreFlag lists the reports with StoreDeleted set.
+	deletedStoreFlag = "flag"
+	// deletedStoreDrop leaves the reports out.
+	deletedStoreDrop = "drop"
+)
+
+var deletedStoreReports = deletedStoreReportsFromEnv("DELETED_STORE_REPORTS")
+
+func deletedStoreReportsFromEnv(key string) string {
+	switch v := os.Getenv(key); v {
+	case "":
+		return deletedStoreKeep
+	case deletedStoreKeep, deletedStoreFlag, deletedStoreDrop:
+		return v
+	default:
+		log.Fatalf("%s env variable must be %s, %s, or %s: %q", key, deletedStoreKeep, deletedStoreFlag, deletedStoreDrop, v)
+		return ""
+	}
+}
+
+// isStoreRef reports whether the store info on a stock report holds only the store ID.
+// Stores always have a name, so a snapshot never looks like a reference.
+func isStoreRef(st *Store) bool {
+	return st != nil && st.Name == ""
+}
+
+// storeRefIDs returns the distinct IDs of the stores referenced by the items' stock reports.
+func storeRefIDs(items []*Item) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		for _, sr := range item.StockReports {
+			if !isStoreRef(sr.StoreInfo) || seen[sr.StoreInfo.StoreID] {
+				continue
+			}
+			seen[sr.StoreInfo.StoreID] = true
+			ids = append(ids, sr.StoreInfo.StoreID)
+		}
+	}
+	return ids
+}
+
+// resolveStoreRefs replaces the store references in the items' stock reports with the
+// stores they refer to, so that reports read the same whether or not they embed the store.
+// References to stores that aren't in stores are left as they are.
+func resolveStoreRefs(items []*Item, stores map[string]*Store) {
+	for _, item := range items {
+		for _, sr := range item.StockReports {
+			if !isStoreRef(sr.StoreInfo) {
+				continue
+			}
+			if st, ok := stores[sr.StoreInfo.StoreID]; ok {
+				sr.StoreInfo = st
+			}
+		}
+	}
+}
+
+// resolveStoreRefsInStorage looks up the stores referenced by the items' stock reports and
+// fills them in with resolveStoreRefs.
+func resolveStoreRefsInStorage(ctx context.Context, client *datastore.Client, items []*Item) error {
+	ids := storeRefIDs(items)
+	if len(ids) == 0 {
+		return nil
+	}
+	stores, err := getStoresInStorage(ctx, client, ids)
+	if err != nil {
+		return err
+	}
+	resolveStoreRefs(items, stores)
+	return nil
+}
+
+// reportStoreIDs returns the distinct IDs of the stores of the items' stock reports.
+func reportStoreIDs(items []*Item) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		for _, sr := range item.StockReports {
+			if sr.StoreInfo == nil || seen[sr.StoreInfo.StoreID] {
+				continue
+			}
+			seen[sr.StoreInfo.StoreID] = true
+			ids = append(ids, sr.StoreInfo.StoreID)
+		}
+	}
+	return ids
+}
+
+// applyDeletedStores applies the deletedStoreReports policy to the items' stock reports whose
+// store isn't in stores.
+func applyDeletedStores(items []*Item, stores map[string]*Store, policy string) {
+	if policy == deletedStoreKeep {
+		return
+	}
+	for _, item := range items {
+		kept := item.StockReports[:0]
+		for _, sr := range item.StockReports {
+			if sr.StoreInfo != nil {
+				if _, ok := stores[sr.StoreInfo.StoreID]; !ok {
+					if policy == deletedStoreDrop {
+						continue
+					}
+					sr.StoreDeleted = true
+				}
+			}
+			kept = append(kept, sr)
+		}
+		item.StockReports = kept
+	}
+}
+
+// checkDeletedStoresInStorage looks up the stores of the items' stock reports and applies the
+// deletedStoreReports policy with applyDeletedStores. Under deletedStoreKeep nothing is
+// looked up.
+func checkDeletedStoresInStorage(ctx context.Context, client *datastore.Client, items []*Item) error {
+	if deletedStoreReports == deletedStoreKeep {
+		return nil
+	}
+	ids := reportStoreIDs(items)
+	if len(ids) == 0 {
+		return nil
+	}
+	stores, err := getStoresInStorage(ctx, client, ids)
+	if err != nil {
+		return err
+	}
+	applyDeletedStores(items, stores, deletedStoreReports)
+	return nil
+}
+
+// getStoresInStorage fetches the stores with the IDs, keyed by ID. Stores that aren't in
+// storage, such as ones that were removed, are left out.
+func getStoresInStorage(ctx context.Context, client *datastore.Client, ids []string) (map[string]*Store, error) {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = nameKey(ctx, StoreKind, id)
+	}
+	found := make([]Store, len(ids))
+	err := getMulti(ctx, client, keys, found)
+	merr, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		return nil, fmt.Errorf("failed to look up stores in storage: %v", err)
+	}
+
+	stores := make(map[string]*Store, len(ids))
+	for i, id := range ids {
+		if isMultiErr && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue // store was removed
+			}
+			return nil, fmt.Errorf("failed to look up store %q in storage: %v", id, merr[i])
+		}
+		stores[id] = &found[i]
+	}
+	return stores, nil
+}
+
 func parseItem(item *Item) []*ItemInfo {
 	var res []*ItemInfo
 	for _, stockReport := range item.StockReports {
+		if stockReport.Unknown {
+			continue // doesn't say whether the item is in stock
+		}
 		secondsAgo := int(time.Now().Unix() - stockReport.TimestampSec)
+		days, hours, minutes := splitAge(secondsAgo, ageRounding)
 		itemInfo := &ItemInfo{
-			DaysAgo:   secondsAgo / secondsToDay,
-			HoursAgo:  secondsAgo / secondsToHour,
-			StoreName: stockReport.StoreInfo.Name,
-			StoreAddr: stockReport.StoreInfo.Addr,
-			StoreLat:  stockReport.StoreInfo.Lat,
-			StoreLng:  stockReport.StoreInfo.Long,
-			InStock:   stockReport.InStock,
-			SeenCnt:   stockReport.SeenCnt,
+			DaysAgo:         days,
+			HoursAgo:        hours,
+			MinutesAgo:      minutes,
+			SecondsAgo:      secondsAgo,
+			ReportedAgo:     humanizeAge(secondsAgo),
+			StoreName:       stockReport.StoreInfo.Name,
+			StoreAddr:       stockReport.StoreInfo.Addr,
+			StoreLat:        stockReport.StoreInfo.Lat,
+			StoreLng:        stockReport.StoreInfo.Long,
+			InStock:         stockReport.InStock,
+			Level:           stockReport.Level,
+			SeenCnt:         stockReport.SeenCnt,
+			ReporterCnt:     len(stockReport.UsersInfo),
+			LastReportedSec: lastReportedSec(stockReport),
+			PhotoURL:        stockReport.PhotoURL,
+			StoreDeleted:    stockReport.StoreDeleted,
 		}
 		res = append(res, itemInfo)
 	}
 	return res
 }
 
-// Sort ItemInfo array by following priority.
-// 1. Closest distance from store to user zip code.
-// 2. Recent timestamp (time when item was seen at store)
-func sortItems(resp QueryItemsResp, zipCode string) error {
-	coords := zipCodeToLatLong[zipCode]
+// lastReportedSec returns the time of the latest user's report on the stock report, or of the
+// report itself if it doesn't record its users.
+func lastReportedSec(sr *StockReport) int64 {
+	var last int64
+	for _, u := range sr.UsersInfo {
+		if u.TimestampSec > last {
+			last = u.TimestampSec
+		}
+	}
+	if last == 0 {
+		return sr.TimestampSec
+	}
+	return last
+}
+
+// How report ages are rounded to whole units, set with the AGE_ROUNDING env variable.
+const (
+	// ageRoundDown counts the whole units that have passed, so a report 59 minutes and 50
+	// seconds old is 59 minutes old.
+	ageRoundDown = "down"
+	// ageRoundNearest rounds to the nearest unit, so the same report is 1 hour old.
+	ageRoundNearest = "nearest"
+)
+
+var ageRounding = ageRoundingFromEnv("AGE_ROUNDING")
+
+func ageRoundingFromEnv(key string) string {
+	switch v := os.Getenv(key); v {
+	case "":
+		return ageRoundDown
+	case ageRoundDown, ageRoundNearest:
+		return v
+	default:
+		log.Fatalf("%s env variable must be %s or %s: %q", key, ageRoundDown, ageRoundNearest, v)
+		return ""
+	}
+}
+
+// splitAge splits an age in seconds into whole days, hours past the days, and minutes past
+// the hours. The age is rounded to a whole minute once, before it is split, so the three
+// always add up to it: under ageRoundNearest, 23 hours, 59 minutes, and 50 seconds is
+// 1 day, 0 hours, and 0 minutes.
+func splitAge(secondsAgo int, rounding string) (days, hours, minutes int) {
+	if rounding == ageRoundNearest {
+		secondsAgo += secondsToMinute / 2
+	}
+	days = secondsAgo / secondsToDay
+	hours = secondsAgo % secondsToDay / secondsToHour
+	minutes = secondsAgo % secondsToHour / secondsToMinute
+	return days, hours, minutes
+}
+
+// totalAges rewrites HoursAgo and MinutesAgo as the total age of the report in that unit, as
+// QueryItems responses before splitAgesVersion have them.
+func totalAges(resp QueryItemsResp) {
+	for _, info := range resp {
+		info.HoursAgo += info.DaysAgo * 24
+		info.MinutesAgo += info.HoursAgo * 60
+	}
+}
+
+// humanizeAge describes an age in seconds in its largest unit under ageRounding, e.g.
+// "25 minutes ago" or "1 day ago".
+func humanizeAge(secondsAgo int) string {
+	return describeAge(secondsAgo, ageRounding)
+}
+
+// describeAge describes the age in the largest unit of its splitAge. Under ageRoundNearest,
+// that unit is rounded by the unit below it, so 1 day and 13 hours is "2 days ago".
+func describeAge(secondsAgo int, rounding string) string {
+	days, hours, minutes := splitAge(secondsAgo, rounding)
+	if rounding == ageRoundNearest {
+		switch {
+		case days > 0 && hours >= 12:
+			days++
+		case days == 0 && hours > 0 && minutes >= 30:
+			hours++
+			if hours == 24 {
+				days, hours = 1, 0
+			}
+		}
+	}
+	var n int
+	var unit string
+	switch {
+	case days > 0:
+		n, unit = days, "day"
+	case hours > 0:
+		n, unit = hours, "hour"
+	case minutes > 0:
+		n, unit = minutes, "minute"
+	default:
+		return "just now"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
+
+var itemsCSVHeader = []string{"store_name", "store_address", "store_lat", "store_long", "in_stock", "seen_count", "hours_ago"}
+
+// writeItemsCSV writes a header row followed by one row per item info. Rows are written to w
+// as they're formatted rather than buffered into a single body.
+func writeItemsCSV(w io.Writer, resp QueryItemsResp) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(itemsCSVHeader); err != nil {
+		return fmt.Errorf("failed to encode response in csv: %v", err)
+	}
+	for _, info := range resp {
+		row := []string{
+			info.StoreName,
+			info.StoreAddr,
+			strconv.FormatFloat(info.StoreLat, 'f', -1, 64),
+			strconv.FormatFloat(info.StoreLng, 'f', -1, 64),
+			strconv.FormatBool(info.InStock),
+			strconv.Itoa(info.SeenCnt),
+			// HoursAgo is the hours past the whole days from splitAgesVersion on.
+			strconv.Itoa(info.SecondsAgo / secondsToHour),
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to encode response in csv: %v", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to encode response in csv: %v", err)
+	}
+	return nil
+}
+
+// sortItems sorts the items at the stores nearest coords first, then the most recently
+// reported. Remaining ties are broken by store name, then the highest seen count, so the
+// order is the same across requests.
+func sortItems(resp QueryItemsResp, coords coord) {
 	lat := coords.Lat
 	lng := coords.Long
 	sort.Slice(resp, func(i, j int) bool {
 		d1 := Distance(resp[i].StoreLat, resp[i].StoreLng, lat, lng)
 		d2 := Distance(resp[j].StoreLat, resp[j].StoreLng, lat, lng)
-		if d1 == d2 {
-			return resp[i].HoursAgo < resp[j].HoursAgo
+		if d1 != d2 {
+			return d1 < d2
+		}
+		if resp[i].SecondsAgo != resp[j].SecondsAgo {
+			return resp[i].SecondsAgo < resp[j].SecondsAgo
 		}
-		return d1 < d2
+		if resp[i].StoreName != resp[j].StoreName {
+			return resp[i].StoreName < resp[j].StoreName
+		}
+		return resp[i].SeenCnt > resp[j].SeenCnt
 	})
-	return nil
 }
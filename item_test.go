@@ -0,0 +1,561 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteItemsCSV(t *testing.T) {
+	resp := QueryItemsResp{
+		{StoreName: "Costco", StoreAddr: "4401 4th Ave S, Seattle, WA 98134", StoreLat: 47.5638, StoreLng: -122.3287, InStock: true, SeenCnt: 3, HoursAgo: 2, SecondsAgo: 2*3600 + 59},
+		{StoreName: `Trader Joe's "Capitol Hill"`, StoreAddr: "1700 E Madison St, Seattle, WA 98122", StoreLat: 47.6166, StoreLng: -122.3115, SeenCnt: 1, DaysAgo: 1, HoursAgo: 6, SecondsAgo: 30 * 3600},
+	}
+	var buf bytes.Buffer
+	if err := writeItemsCSV(&buf, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not well-formed csv: %v", err)
+	}
+	want := [][]string{
+		itemsCSVHeader,
+		{"Costco", "4401 4th Ave S, Seattle, WA 98134", "47.5638", "-122.3287", "true", "3", "2"},
+		// The hours are the total age, even in split-age responses.
+		{`Trader Joe's "Capitol Hill"`, "1700 E Madison St, Seattle, WA 98122", "47.6166", "-122.3115", "false", "1", "30"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("got rows %q, want %q", rows, want)
+	}
+}
+
+func TestAcceptsCSV(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                            false,
+		"application/json":            false,
+		"text/csv":                    true,
+		"application/json, text/csv":  true,
+		"text/csv; charset=utf-8":     true,
+		"text/csvx, application/json": false,
+	} {
+		r := httptest.NewRequest("POST", "/item/query", nil)
+		r.Header.Set("Accept", accept)
+		if got := AcceptsCSV(r); got != want {
+			t.Errorf("AcceptsCSV(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+func TestHumanizeAge(t *testing.T) {
+	for _, tc := range []struct {
+		secondsAgo int
+		want       string
+	}{
+		{0, "just now"},
+		{59, "just now"},
+		{60, "1 minute ago"},
+		{25 * 60, "25 minutes ago"},
+		{90 * 60, "1 hour ago"},
+		{5 * 3600, "5 hours ago"},
+		{25 * 3600, "1 day ago"},
+		{3*24*3600 + 7*3600, "3 days ago"},
+	} {
+		if got := humanizeAge(tc.secondsAgo); got != tc.want {
+			t.Errorf("humanizeAge(%d) = %q, want %q", tc.secondsAgo, got, tc.want)
+		}
+	}
+}
+
+func TestDescribeAgeNearest(t *testing.T) {
+	for _, tc := range []struct {
+		secondsAgo int
+		want       string
+	}{
+		{29, "just now"},
+		{30, "1 minute ago"},
+		{59*60 + 50, "1 hour ago"},
+		{90 * 60, "2 hours ago"},
+		{23*3600 + 40*60, "1 day ago"},
+		{36 * 3600, "2 days ago"},
+	} {
+		if got := describeAge(tc.secondsAgo, ageRoundNearest); got != tc.want {
+			t.Errorf("describeAge(%d, nearest) = %q, want %q", tc.secondsAgo, got, tc.want)
+		}
+	}
+}
+
+func TestParseItemAges(t *testing.T) {
+	now := time.Now().Unix()
+	item := &Item{
+		Name: "flour",
+		StockReports: []*StockReport{
+			{StoreInfo: &Store{}, TimestampSec: now - 25*3600},
+		},
+	}
+	infos := parseItem(item)
+	if len(infos) != 1 {
+		t.Fatalf("got %d item infos, want 1", len(infos))
+	}
+	got := infos[0]
+	if got.DaysAgo != 1 || got.HoursAgo != 1 || got.ReportedAgo != "1 day ago" {
+		t.Errorf("got DaysAgo %d, HoursAgo %d, ReportedAgo %q, want 1, 1, \"1 day ago\"", got.DaysAgo, got.HoursAgo, got.ReportedAgo)
+	}
+	if got.SecondsAgo < 25*3600 || got.SecondsAgo > 25*3600+5 {
+		t.Errorf("got SecondsAgo %d, want ~%d", got.SecondsAgo, 25*3600)
+	}
+}
+
+func TestParseItemReporters(t *testing.T) {
+	item := &Item{
+		Name: "flour",
+		StockReports: []*StockReport{
+			{StoreInfo: &Store{}, InStock: true, SeenCnt: 3, TimestampSec: 500, UsersInfo: []*User{
+				{UserID: "alice", FirstName: "Alice", TimestampSec: 100},
+				{UserID: "bob", FirstName: "Bob", TimestampSec: 400},
+				{UserID: "carol", FirstName: "Carol", TimestampSec: 300},
+			}},
+			// Made before reports recorded their users.
+			{StoreInfo: &Store{}, SeenCnt: 1, TimestampSec: 200},
+		},
+	}
+	infos := parseItem(item)
+	if len(infos) != 2 {
+		t.Fatalf("got %d item infos, want 2", len(infos))
+	}
+	if got := infos[0]; got.ReporterCnt != 3 || got.LastReportedSec != 400 {
+		t.Errorf("got %d reporters last at %d, want 3 last at 400", got.ReporterCnt, got.LastReportedSec)
+	}
+	if got := infos[1]; got.ReporterCnt != 0 || got.LastReportedSec != 200 {
+		t.Errorf("got %d reporters last at %d for a report without users, want 0 at the report's 200", got.ReporterCnt, got.LastReportedSec)
+	}
+
+	buf, err := json.Marshal(infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"alice", "Bob", "user_id"} {
+		if strings.Contains(string(buf), leak) {
+			t.Errorf("item infos %s leak %q", buf, leak)
+		}
+	}
+}
+
+func TestSplitAge(t *testing.T) {
+	for _, tc := range []struct {
+		secondsAgo                  int
+		rounding                    string
+		wantDays, wantHrs, wantMins int
+	}{
+		{90 * 60, ageRoundDown, 0, 1, 30},
+		{25 * 3600, ageRoundDown, 1, 1, 0},
+		{3 * 24 * 3600, ageRoundDown, 3, 0, 0},
+		{24*3600 - 10, ageRoundDown, 0, 23, 59},
+		{24*3600 - 10, ageRoundNearest, 1, 0, 0},
+		{90*60 + 29, ageRoundNearest, 0, 1, 30},
+	} {
+		days, hours, minutes := splitAge(tc.secondsAgo, tc.rounding)
+		if days != tc.wantDays || hours != tc.wantHrs || minutes != tc.wantMins {
+			t.Errorf("splitAge(%ds, %s) = %dd %dh %dm, want %dd %dh %dm", tc.secondsAgo, tc.rounding,
+				days, hours, minutes, tc.wantDays, tc.wantHrs, tc.wantMins)
+		}
+	}
+}
+
+func TestTotalAges(t *testing.T) {
+	resp := QueryItemsResp{{DaysAgo: 1, HoursAgo: 1, MinutesAgo: 30}}
+	totalAges(resp)
+	if got := resp[0]; got.DaysAgo != 1 || got.HoursAgo != 25 || got.MinutesAgo != 25*60+30 {
+		t.Errorf("totalAges() = %dd %dh %dm, want 1d 25h %dm", got.DaysAgo, got.HoursAgo, got.MinutesAgo, 25*60+30)
+	}
+}
+
+func TestLocalizedItems(t *testing.T) {
+	if got := resolveLocalizedItemName("pechuga de pollo", "es"); got != "chicken breast" {
+		t.Errorf("resolveLocalizedItemName(%q, es) = %q, want %q", "pechuga de pollo", got, "chicken breast")
+	}
+	// English names still work when a language is given.
+	if got := resolveLocalizedItemName("chicken breast", "es"); got != "chicken breast" {
+		t.Errorf("resolveLocalizedItemName(%q, es) = %q, want %q", "chicken breast", got, "chicken breast")
+	}
+
+	for i, name := range itemNames {
+		switch name {
+		case "chicken breast":
+			tokens, lang := localizedTokens(i, "es")
+			if lang != "es" || !reflect.DeepEqual(tokens, Tokens{"pechuga", "de", "pollo"}) {
+				t.Errorf("localizedTokens(%q, es) = %q, %q, want Spanish tokens", name, tokens, lang)
+			}
+		case "absinthe":
+			tokens, lang := localizedTokens(i, "es")
+			if lang != defaultLang || !reflect.DeepEqual(tokens, itemTokens[i]) {
+				t.Errorf("localizedTokens(%q, es) = %q, %q, want English fallback", name, tokens, lang)
+			}
+		}
+	}
+}
+
+func TestItemTokenInfo(t *testing.T) {
+	tests := []struct {
+		name, lang string
+		wantName   string
+		wantTokens Tokens
+		wantLang   string
+	}{
+		{"acacia honey", "", "acacia honey", Tokens{"acacia", "honey"}, defaultLang},
+		// Variants resolve through the item tokens.
+		{"honey  acacia", "", "acacia honey", Tokens{"acacia", "honey"}, defaultLang},
+		{"pechuga de pollo", "es", "chicken breast", Tokens{"pechuga", "de", "pollo"}, "es"},
+		{"unicorn steak", "", "", nil, ""},
+	}
+	for _, tc := range tests {
+		info, ok := itemTokenInfo(tc.name, tc.lang)
+		if tc.wantName == "" {
+			if ok {
+				t.Errorf("itemTokenInfo(%q) = %+v, want not found", tc.name, info)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("itemTokenInfo(%q) not found, want %q", tc.name, tc.wantName)
+			continue
+		}
+		want := &ItemTokenInfo{Name: tc.wantName, Tokens: tc.wantTokens, Lang: tc.wantLang}
+		if !reflect.DeepEqual(info, want) {
+			t.Errorf("itemTokenInfo(%q) = %+v, want %+v", tc.name, info, want)
+		}
+	}
+}
+
+func TestScanDataFileRejectsMalformedLines(t *testing.T) {
+	f, err := ioutil.TempFile("", "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\n\nflour:flour\nno separator\n")
+	f.Close()
+
+	var lines []string
+	err = scanDataFile(f.Name(), func(line string) error {
+		if !strings.Contains(line, ":") {
+			return fmt.Errorf("bad line")
+		}
+		lines = append(lines, line)
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), ":4:") {
+		t.Errorf("scanDataFile() = %v, want error on line 4", err)
+	}
+	if len(lines) != 1 {
+		t.Errorf("got %d lines before the error, want 1", len(lines))
+	}
+
+	if _, err := parseTokens(" , "); err == nil {
+		t.Error("parseTokens() with no tokens succeeded, want error")
+	}
+}
+
+func TestMatchItemNamesMinLength(t *testing.T) {
+	orig := minFuzzyQueryLen
+	minFuzzyQueryLen = 3
+	defer func() { minFuzzyQueryLen = orig }()
+
+	// Below the minimum, prefix matching is disabled.
+	names, hint := matchItemNames("ap", prefixMatch)
+	if !reflect.DeepEqual(names, []string{"ap"}) || hint == "" {
+		t.Errorf("matchItemNames(ap, prefix) = %q, %q, want exact match with a hint", names, hint)
+	}
+
+	// At the minimum, prefix matching applies.
+	names, hint = matchItemNames("app", prefixMatch)
+	if hint != "" {
+		t.Errorf("matchItemNames(app, prefix) returned hint %q, want none", hint)
+	}
+	if len(names) < 2 || len(names) > maxPrefixMatches {
+		t.Fatalf("matchItemNames(app, prefix) matched %d items, want between 2 and %d", len(names), maxPrefixMatches)
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, "app") {
+			t.Errorf("matchItemNames(app, prefix) matched %q", name)
+		}
+	}
+
+	names, hint = matchItemNames("ap", exactMatch)
+	if !reflect.DeepEqual(names, []string{"ap"}) || hint != "" {
+		t.Errorf("matchItemNames(ap, exact) = %q, %q, want exact match without a hint", names, hint)
+	}
+}
+
+func TestCatalogStatus(t *testing.T) {
+	// A catalog item nobody reported yet.
+	env := catalogStatus("acorn squash", []string{"acorn squash"}, QueryItemsResp{})
+	if env.Status != itemNoReports || env.Suggestion != "" {
+		t.Errorf("catalogStatus(acorn squash) = %+v, want status %q without a suggestion", env, itemNoReports)
+	}
+
+	// A typo of a catalog item.
+	env = catalogStatus("acron squash", []string{"acron squash"}, QueryItemsResp{})
+	if env.Status != itemUnknown || env.Suggestion != "acorn squash" {
+		t.Errorf("catalogStatus(acron squash) = %+v, want status %q suggesting %q", env, itemUnknown, "acorn squash")
+	}
+
+	// A name nowhere near the catalog.
+	env = catalogStatus("xqzvwkpj", []string{"xqzvwkpj"}, QueryItemsResp{})
+	if env.Status != itemUnknown || env.Suggestion != "" {
+		t.Errorf("catalogStatus(xqzvwkpj) = %+v, want status %q without a suggestion", env, itemUnknown)
+	}
+
+	// Reports take precedence, even for names outside the catalog.
+	env = catalogStatus("xqzvwkpj", []string{"xqzvwkpj"}, QueryItemsResp{{InStock: true}})
+	if env.Status != itemReported || len(env.Items) != 1 {
+		t.Errorf("catalogStatus() with reports = %+v, want status %q", env, itemReported)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"flour", "flour", 0},
+		{"flour", "flor", 1},
+		{"acron", "acorn", 2},
+		{"", "eggs", 4},
+		{"piña", "pina", 1},
+	} {
+		if got := editDistance(tc.a, tc.b); got != tc.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestSortItemsTies(t *testing.T) {
+	near := zipCodeToLatLong["98101"]
+	newResp := func() QueryItemsResp {
+		return QueryItemsResp{
+			{StoreName: "Safeway", StoreLat: near.Lat, StoreLng: near.Long, SecondsAgo: 60, SeenCnt: 1},
+			{StoreName: "QFC", StoreLat: near.Lat, StoreLng: near.Long, SecondsAgo: 60, SeenCnt: 1},
+			{StoreName: "Safeway", StoreLat: near.Lat, StoreLng: near.Long, SecondsAgo: 60, SeenCnt: 3},
+			{StoreName: "Albertsons", StoreLat: near.Lat, StoreLng: near.Long, SecondsAgo: 120, SeenCnt: 1},
+		}
+	}
+	type key struct {
+		name    string
+		seenCnt int
+	}
+	want := []key{{"QFC", 1}, {"Safeway", 3}, {"Safeway", 1}, {"Albertsons", 1}}
+
+	for run := 0; run < 10; run++ {
+		resp := newResp()
+		rand.Shuffle(len(resp), func(i, j int) { resp[i], resp[j] = resp[j], resp[i] })
+		sortItems(resp, near)
+		for i, info := range resp {
+			if got := (key{info.StoreName, info.SeenCnt}); got != want[i] {
+				t.Fatalf("run %d: got %+v at %d, want %+v", run, got, i, want[i])
+			}
+		}
+	}
+}
+
+func TestStoreSnapshotModes(t *testing.T) {
+	orig := features
+	defer func() { features = orig }()
+
+	store := &Store{StoreID: "s1", Name: "Safeway", Addr: "1 Main St", Lat: 37.4, Long: -122.1}
+	stores := map[string]*Store{store.StoreID: store}
+	now := time.Now().Unix()
+	build := func(embed bool) []*ItemInfo {
+		features = map[string]bool{featureEmbedStoreSnapshots: embed}
+		item := &Item{Name: "flour"}
+		addStockReport(item, store, &User{UserID: "u1"}, &itemStock{Name: "flour", InStock: true}, now)
+		addStockReport(item, store, &User{UserID: "u2"}, &itemStock{Name: "flour", InStock: true}, now)
+		addStockReport(item, store, &User{UserID: "u3"}, &itemStock{Name: "flour"}, now)
+		if got := isStoreRef(item.StockReports[0].StoreInfo); got == embed {
+			t.Errorf("embed %v: got store ref %v, want %v", embed, got, !embed)
+		}
+		resolveStoreRefs([]*Item{item}, stores)
+		infos := parseItem(item)
+		for _, info := range infos {
+			// The ages depend on when parseItem runs.
+			info.DaysAgo, info.HoursAgo, info.MinutesAgo, info.SecondsAgo, info.ReportedAgo = 0, 0, 0, 0, ""
+		}
+		return infos
+	}
+
+	embedded, referenced := build(true), build(false)
+	if !reflect.DeepEqual(embedded, referenced) {
+		t.Errorf("embedded and referenced snapshots differ:\n%+v\n%+v", embedded, referenced)
+	}
+	if len(referenced) != 2 || referenced[0].StoreName != store.Name || referenced[0].SeenCnt != 2 {
+		t.Errorf("got %+v, want 2 reports at %q, the first seen twice", referenced, store.Name)
+	}
+}
+
+func TestResolveStoreRefsMissingStore(t *testing.T) {
+	item := &Item{StockReports: []*StockReport{
+		{StoreInfo: &Store{StoreID: "gone"}},
+		{StoreInfo: &Store{StoreID: "s1", Name: "Safeway"}},
+	}}
+	if got, want := storeRefIDs([]*Item{item}), []string{"gone"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got store ref ids %v, want %v", got, want)
+	}
+	resolveStoreRefs([]*Item{item}, map[string]*Store{})
+	if got := item.StockReports[0].StoreInfo; got.StoreID != "gone" || got.Name != "" {
+		t.Errorf("got store info %+v, want the unresolved reference", got)
+	}
+}
+
+func TestCorroboratedItems(t *testing.T) {
+	resp := QueryItemsResp{
+		{StoreName: "QFC", SeenCnt: 1},
+		{StoreName: "Safeway", SeenCnt: 3},
+		{StoreName: "PCC", SeenCnt: 2},
+	}
+	var got []string
+	for _, info := range corroboratedItems(resp, 2) {
+		got = append(got, info.StoreName)
+	}
+	if want := []string{"Safeway", "PCC"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("corroboratedItems() kept %v, want %v", got, want)
+	}
+	if got := corroboratedItems(resp, 1); len(got) != len(resp) {
+		t.Errorf("corroboratedItems() with a minimum of 1 kept %d reports, want %d", len(got), len(resp))
+	}
+}
+
+func TestQueryItemsMinSeenCnt(t *testing.T) {
+	orig := minReportSeenCnt
+	defer func() { minReportSeenCnt = orig }()
+	minReportSeenCnt = 3
+
+	if got := (&QueryItemsReq{}).minSeenCnt(); got != 3 {
+		t.Errorf("minSeenCnt() = %d, want the server minimum 3", got)
+	}
+	if got := (&QueryItemsReq{AllReports: true}).minSeenCnt(); got != 1 {
+		t.Errorf("minSeenCnt() with AllReports = %d, want 1 to include every report", got)
+	}
+}
+
+func TestApplyDeletedStores(t *testing.T) {
+	safeway := &Store{StoreID: "s1", Name: "Safeway"}
+	qfc := &Store{StoreID: "s2", Name: "QFC"}
+	// QFC was deleted after the reports were uploaded.
+	stores := map[string]*Store{safeway.StoreID: safeway}
+	query := func(policy string) []*ItemInfo {
+		item := &Item{Name: "milk", StockReports: []*StockReport{
+			{StoreInfo: safeway, InStock: true, SeenCnt: 1},
+			{StoreInfo: qfc, InStock: false, SeenCnt: 2},
+		}}
+		if got, want := reportStoreIDs([]*Item{item}), []string{"s1", "s2"}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("got report store ids %v, want %v", got, want)
+		}
+		applyDeletedStores([]*Item{item}, stores, policy)
+		return parseItem(item)
+	}
+
+	if got := query(deletedStoreKeep); len(got) != 2 || got[1].StoreName != "QFC" || got[1].StoreDeleted {
+		t.Errorf("keep: got %+v, want the QFC report as is", got)
+	}
+	if got := query(deletedStoreFlag); len(got) != 2 || got[0].StoreDeleted || got[1].StoreName != "QFC" || !got[1].StoreDeleted {
+		t.Errorf("flag: got %+v, want only the QFC report flagged", got)
+	}
+	if got := query(deletedStoreDrop); len(got) != 1 || got[0].StoreName != "Safeway" {
+		t.Errorf("drop: got %+v, want only the Safeway report", got)
+	}
+}
+
+func TestCleanAndValidateQueryItemNames(t *testing.T) {
+	req := &QueryItemsReq{UserID: "u", ItemNames: []string{"Milk", "eggs", "milk"}}
+	if err := cleanAndValidateQueryItemsReq(req); err != nil {
+		t.Fatalf("cleanAndValidateQueryItemsReq() failed: %v", err)
+	}
+	if want := []string{"milk", "eggs"}; !reflect.DeepEqual(req.ItemNames, want) || req.Match != exactMatch {
+		t.Errorf("got item names %q with match %q, want %q with exact match", req.ItemNames, req.Match, want)
+	}
+
+	tooMany := make([]string, maxQueryItemNames+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("item %d", i)
+	}
+	for _, req := range []*QueryItemsReq{
+		{UserID: "u", ItemNames: tooMany},
+		{UserID: "u", ItemName: "milk", ItemNames: []string{"eggs"}},
+		{UserID: "u", ItemNames: []string{"milk", ""}},
+		{UserID: "u", ItemNames: []string{"milk"}, Match: prefixMatch},
+	} {
+		if err := cleanAndValidateQueryItemsReq(req); err == nil {
+			t.Errorf("cleanAndValidateQueryItemsReq(%+v) succeeded, want an error", req)
+		}
+	}
+}
+
+func TestItemInfosPerItem(t *testing.T) {
+	seattle := zipCodeToLatLong["98101"]
+	near := &Store{StoreID: "near", Name: "QFC", Lat: 47.615, Long: -122.335}
+	far := &Store{StoreID: "far", Name: "Safeway", Lat: 47.68, Long: -122.32}
+	tacoma := &Store{StoreID: "tacoma", Name: "Fred Meyer", Lat: 47.25, Long: -122.44}
+	now := time.Now().Unix()
+	milk := &Item{Name: "milk", StockReports: []*StockReport{
+		{StoreInfo: far, InStock: true, SeenCnt: 1, TimestampSec: now - 60},
+		{StoreInfo: tacoma, InStock: true, SeenCnt: 1, TimestampSec: now},
+		{StoreInfo: near, InStock: false, SeenCnt: 1, TimestampSec: now - 3600},
+	}}
+	eggs := &Item{Name: "eggs", StockReports: []*StockReport{
+		{StoreInfo: near, InStock: true, SeenCnt: 1, TimestampSec: now},
+	}}
+	req := &QueryItemsReq{RadiusMiles: 10}
+	u := &User{}
+
+	var got []string
+	for _, info := range itemInfos([]*Item{milk}, req, u, seattle) {
+		got = append(got, info.StoreName)
+	}
+	if want := []string{"QFC", "Safeway"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got milk at %q, want the nearest store first and Tacoma out of range: %q", got, want)
+	}
+	if got := itemInfos([]*Item{eggs}, req, u, seattle); len(got) != 1 || got[0].StoreName != "QFC" {
+		t.Errorf("got eggs %+v, want only its own report", got)
+	}
+	if got := itemInfos(nil, req, u, seattle); got == nil || len(got) != 0 {
+		t.Errorf("got %#v for a name without items, want an empty list", got)
+	}
+}
+
+func TestFreshItems(t *testing.T) {
+	store := &Store{StoreID: "s1", Name: "Safeway"}
+	now := time.Now().Unix()
+	item := &Item{Name: "toilet paper", StockReports: []*StockReport{
+		{StoreInfo: store, InStock: true, TimestampSec: now - secondsToHour},
+		{StoreInfo: store, InStock: true, TimestampSec: now - 3*secondsToDay},
+		{StoreInfo: store, InStock: true, TimestampSec: now - 14*secondsToDay},
+	}}
+	var got []int
+	for _, info := range freshItems(parseItem(item), 7*secondsToDay) {
+		got = append(got, info.DaysAgo)
+	}
+	if want := []int{0, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got reports from %v days ago, want %v", got, want)
+	}
+}
+
+func TestExactMatchNames(t *testing.T) {
+	if got, want := exactMatchNames("toilet paper", "toilet paper"), []string{"toilet paper"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exactMatchNames() of a catalog name = %q, want %q", got, want)
+	}
+	// Reports uploaded as "toilet papers" are stored under that name.
+	if got, want := exactMatchNames("toilet papers", "toilet paper"), []string{"toilet paper", "toilet papers"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exactMatchNames() of a resolved name = %q, want %q", got, want)
+	}
+}
@@ -4,17 +4,56 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"reflect"
+	"strings"
 )
 
-// DecodeReq is a helper for decoding JSON request bodies for handlers.
+// DecodeReq is a helper for decoding JSON request bodies for handlers. Leading and trailing
+// whitespace is trimmed from the request's strings, so every endpoint treats " milk" and
+// "milk" the same. Fields tagged `trim:"-"` are left as they are.
 func DecodeReq(r io.ReadCloser, req interface{}) error {
 	if err := json.NewDecoder(r).Decode(req); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return errBodyReadTimeout
+		}
 		return fmt.Errorf("failed to decode request body in json: %v", err)
 	}
+	trimStrings(reflect.ValueOf(req))
 	return nil
 }
 
+// trimStrings trims the strings in v, following pointers, slices, and struct fields,
+// including embedded ones. Struct fields tagged `trim:"-"` are skipped.
+func trimStrings(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(strings.TrimSpace(v.String()))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			trimStrings(v.Elem())
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return // raw bytes, such as a photo
+		}
+		for i := 0; i < v.Len(); i++ {
+			trimStrings(v.Index(i))
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).Tag.Get("trim") == "-" {
+				continue
+			}
+			trimStrings(v.Field(i))
+		}
+	}
+}
+
 // EncodeResp is a helper for encoding JSON response bodies for handlers.
 func EncodeResp(w http.ResponseWriter, resp interface{}) error {
 	w.Header().Set("Content-Type", "application/json")
@@ -23,3 +62,13 @@ func EncodeResp(w http.ResponseWriter, resp interface{}) error {
 	}
 	return nil
 }
+
+// EncodeRespStatus is EncodeResp for responses with a status other than http.StatusOK.
+func EncodeRespStatus(w http.ResponseWriter, status int, resp interface{}) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return fmt.Errorf("failed to encode response in json: %v", err)
+	}
+	return nil
+}
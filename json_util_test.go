@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func decodeTestReq(t *testing.T, body string, req interface{}) {
+	t.Helper()
+	if err := DecodeReq(ioutil.NopCloser(strings.NewReader(body)), req); err != nil {
+		t.Fatalf("DecodeReq(%s) failed: %v", body, err)
+	}
+}
+
+func TestDecodeReqTrimsStrings(t *testing.T) {
+	var query QueryItemsReq
+	decodeTestReq(t, `{"user_id": " u1 ", "item_name": " milk\t", "lang": "es "}`, &query)
+	if query.UserID != "u1" || query.ItemName != "milk" || query.Lang != "es" {
+		t.Errorf("got query %+v, want trimmed strings", query)
+	}
+
+	var upload UploadReportReq
+	decodeTestReq(t, `{"user_id": "u1", "store_id": " s1", "in_stock_items": [" milk ", "eggs"], "out_stock_items": ["\nflour"], "photo": "IGE="}`, &upload)
+	if upload.StoreID != "s1" || !reflect.DeepEqual(upload.InStock, []string{"milk", "eggs"}) || !reflect.DeepEqual(upload.OutStock, []string{"flour"}) {
+		t.Errorf("got upload %+v, want trimmed strings", upload)
+	}
+	// Raw bytes are left alone.
+	if string(upload.Photo) != " a" {
+		t.Errorf("got photo %q, want it untouched", upload.Photo)
+	}
+
+	var store AddStoreReq
+	decodeTestReq(t, `{"user_id": "u1", "name": " QFC ", "address": " Broadway "}`, &store)
+	if store.Name != "QFC" || store.AddrText != "Broadway" {
+		t.Errorf("got store %+v, want trimmed strings", store)
+	}
+
+	var edit EditUserReq
+	decodeTestReq(t, `{"user_id": "u1", "first_name": " Sam", "last_name": "Wilson ", "zip_code": " 98101 "}`, &edit)
+	if edit.FirstName != "Sam" || edit.LastName != "Wilson" || edit.ZipCode != "98101" {
+		t.Errorf("got user %+v, want trimmed strings", edit)
+	}
+}
+
+func TestDecodeReqTrimExemptions(t *testing.T) {
+	type inner struct {
+		Name string `json:"name"`
+	}
+	var req struct {
+		Trimmed string   `json:"trimmed"`
+		Kept    string   `json:"kept" trim:"-"`
+		Nested  *inner   `json:"nested"`
+		List    []*inner `json:"list"`
+		PageReq
+	}
+	decodeTestReq(t, `{"trimmed": " a ", "kept": " b ", "nested": {"name": " c "}, "list": [{"name": " d "}, null]}`, &req)
+	if req.Trimmed != "a" || req.Kept != " b " || req.Nested.Name != "c" || req.List[0].Name != "d" {
+		t.Errorf("got %q, %q, %q, %q, want only the exempt field untrimmed", req.Trimmed, req.Kept, req.Nested.Name, req.List[0].Name)
+	}
+}
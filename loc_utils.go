@@ -2,8 +2,11 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"os"
 	"strconv"
 	"strings"
@@ -35,6 +38,144 @@ func init() {
 	log.Println("successfully parsed zip code data")
 }
 
+const kilometersPerMile = 1.609344
+
+// unsupportedZipCodeError is the error for a zip code that isn't in the zip code data.
+type unsupportedZipCodeError struct {
+	zipCode string
+}
+
+func (e *unsupportedZipCodeError) Error() string {
+	return fmt.Sprintf("zip code %q is not supported", e.zipCode)
+}
+
+// zipCodeCoords returns the coordinates of the zip code. Returns an unsupportedZipCodeError
+// if the zip code is not in the zip code data, rather than coordinates off the coast of
+// Africa.
+func zipCodeCoords(zipCode string) (coord, error) {
+	coords, ok := zipCodeToLatLong[zipCode]
+	if !ok {
+		return coord{}, &unsupportedZipCodeError{zipCode}
+	}
+	return coords, nil
+}
+
+// zipCodeErrStatus returns the status to reply with for err: 400 if the request named an
+// unsupported zip code, 500 otherwise.
+func zipCodeErrStatus(err error) int {
+	if _, ok := err.(*unsupportedZipCodeError); ok {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// Sources of the coordinates that QueryStores and QueryItems measure distances from.
+const (
+	// coordsFromRequest is the latitude and longitude given in the request.
+	coordsFromRequest = "request"
+	// coordsFromUser is the latitude and longitude stored for the user with EditUser.
+	coordsFromUser = "user"
+	// coordsFromZipCode is the center of the user's zip code in the zip code data.
+	coordsFromZipCode = "zip_code"
+	// coordsFromGeocode is the user's zip code geocoded with the Maps API, for zip codes that
+	// aren't in the zip code data.
+	coordsFromGeocode = "geocode"
+)
+
+// coordsSourceHeader names the source of the coordinates that distances were measured from.
+const coordsSourceHeader = "X-Coords-Source"
+
+// coordSources are the coordinate sources in order of precedence, set with the COORD_SOURCES
+// env variable as a comma-separated list. Geocoding is left out by default since each
+// lookup is a billed Maps API call.
+var coordSources = coordSourcesFromEnv("COORD_SOURCES", []string{coordsFromRequest, coordsFromUser, coordsFromZipCode})
+
+func coordSourcesFromEnv(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var sources []string
+	for _, s := range strings.Split(v, ",") {
+		switch s = strings.TrimSpace(s); s {
+		case coordsFromRequest, coordsFromUser, coordsFromZipCode, coordsFromGeocode:
+			sources = append(sources, s)
+		default:
+			log.Fatalf("%s env variable has an unknown coordinate source %q", key, s)
+		}
+	}
+	return sources
+}
+
+// CoordsReq is embedded in requests that may give the coordinates to measure distances
+// from. Both or neither of Lat and Long must be given.
+type CoordsReq struct {
+	Lat  *float64 `json:"latitude"`
+	Long *float64 `json:"longitude"`
+}
+
+func (c CoordsReq) validate() error {
+	if (c.Lat == nil) != (c.Long == nil) {
+		return fmt.Errorf("latitude and longitude must be given together")
+	}
+	if c.Lat == nil {
+		return nil
+	}
+	return validateCoords(*c.Lat, *c.Long)
+}
+
+func validateCoords(lat, lng float64) error {
+	if lat < -90 || lat > 90 {
+		return fmt.Errorf("latitude %v is not between -90 and 90", lat)
+	}
+	if lng < -180 || lng > 180 {
+		return fmt.Errorf("longitude %v is not between -180 and 180", lng)
+	}
+	return nil
+}
+
+// resolveUserCoords returns the coordinates to measure the user's distances from, taken from
+// the first of coordSources that has them, and the name of that source. It returns an
+// unsupportedZipCodeError if none does.
+func resolveUserCoords(ctx context.Context, req CoordsReq, u *User) (coord, string, error) {
+	for _, source := range coordSources {
+		switch source {
+		case coordsFromRequest:
+			if req.Lat != nil && req.Long != nil {
+				return coord{Lat: *req.Lat, Long: *req.Long}, source, nil
+			}
+		case coordsFromUser:
+			if u.hasCoords() {
+				return coord{Lat: u.Lat, Long: u.Long}, source, nil
+			}
+		case coordsFromZipCode:
+			if coords, ok := zipCodeToLatLong[u.ZipCode]; ok {
+				return coords, source, nil
+			}
+		case coordsFromGeocode:
+			if u.ZipCode == "" {
+				continue
+			}
+			coords, err := geocodeZipCode(ctx, u.ZipCode)
+			if err == nil {
+				return coords, source, nil
+			}
+			log.Printf("failed to geocode zip code %q of user %q: %v", u.ZipCode, u.UserID, err)
+		}
+	}
+	return coord{}, "", &unsupportedZipCodeError{u.ZipCode}
+}
+
+// distanceFromZipCode calculates distance in miles between a point and a zip code.
+// Returns an error if the zip code is not in the zip code data.
+func distanceFromZipCode(lat, lng float64, zipCode string) (float64, error) {
+	coords, err := zipCodeCoords(zipCode)
+	if err != nil {
+		return 0, err
+	}
+	return Distance(lat, lng, coords.Lat, coords.Long), nil
+}
+
 // Distance calculates distance in miles between two points.
 // Copied from https://www.geodatasource.com/developers/go under LGPLv3 licensing.
 // See https://choosealicense.com/licenses/gpl-3.0.
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestDistanceFromZipCode(t *testing.T) {
+	// Space Needle, about a mile from the center of 98101.
+	miles, err := distanceFromZipCode(47.6205, -122.3493, "98101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Distance(47.6205, -122.3493, 47.6114, -122.3305)
+	if math.Abs(miles-want) > 1e-9 {
+		t.Errorf("got %v miles, want %v", miles, want)
+	}
+	if miles < 0.5 || miles > 2 {
+		t.Errorf("got %v miles, want about 1", miles)
+	}
+
+	if _, err := distanceFromZipCode(47.6205, -122.3493, "00000"); err == nil {
+		t.Error("distanceFromZipCode() with unknown zip code succeeded, want error")
+	}
+}
+
+func TestSortByUnsupportedZipCode(t *testing.T) {
+	const zipCode = "00000" // not in zipCodeData.txt
+	stores := []*Store{{StoreID: "a", Lat: 47.6, Long: -122.3}, {StoreID: "b", Lat: 1, Long: 1}}
+	items := QueryItemsResp{{StoreLat: 47.6, StoreLng: -122.3}, {StoreLat: 1, StoreLng: 1}}
+
+	_, _, coordsErr := resolveUserCoords(context.Background(), CoordsReq{}, &User{ZipCode: zipCode})
+	for name, err := range map[string]error{
+		"sortStoresByDistance": sortStoresByDistance(stores, zipCode),
+		"resolveUserCoords":    coordsErr,
+	} {
+		if err == nil {
+			t.Errorf("%s() with an unsupported zip code succeeded, want error", name)
+			continue
+		}
+		if status := zipCodeErrStatus(err); status != http.StatusBadRequest {
+			t.Errorf("%s() failed with status %d, want %d", name, status, http.StatusBadRequest)
+		}
+	}
+	if stores[0].StoreID != "a" || items[0].StoreLat != 47.6 {
+		t.Errorf("sorted by an unsupported zip code, want the order unchanged")
+	}
+	if status := zipCodeErrStatus(fmt.Errorf("storage is down")); status != http.StatusInternalServerError {
+		t.Errorf("zipCodeErrStatus() of another error = %d, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+func TestResolveUserCoords(t *testing.T) {
+	orig := coordSources
+	defer func() { coordSources = orig }()
+	origGeocode := geocodeZipCode
+	defer func() { geocodeZipCode = origGeocode }()
+	geocoded := coord{Lat: 21.3, Long: -157.8}
+	geocodeZipCode = func(ctx context.Context, zipCode string) (coord, error) {
+		if zipCode == "00001" {
+			return geocoded, nil
+		}
+		return coord{}, &unsupportedZipCodeError{zipCode}
+	}
+
+	lat, lng := 47.65, -122.35
+	req := CoordsReq{Lat: &lat, Long: &lng}
+	user := &User{UserID: "u", ZipCode: "98101", Lat: 47.62, Long: -122.32}
+	zip := zipCodeToLatLong["98101"]
+	ctx := context.Background()
+
+	coordSources = []string{coordsFromRequest, coordsFromUser, coordsFromZipCode, coordsFromGeocode}
+	for _, tc := range []struct {
+		desc       string
+		req        CoordsReq
+		user       *User
+		want       coord
+		wantSource string
+	}{
+		{"request wins", req, user, coord{lat, lng}, coordsFromRequest},
+		{"user without request", CoordsReq{}, user, coord{47.62, -122.32}, coordsFromUser},
+		{"zip code without user coords", CoordsReq{}, &User{ZipCode: "98101"}, zip, coordsFromZipCode},
+		{"geocode for a zip code not in the data", CoordsReq{}, &User{ZipCode: "00001"}, geocoded, coordsFromGeocode},
+	} {
+		got, source, err := resolveUserCoords(ctx, tc.req, tc.user)
+		if err != nil || got != tc.want || source != tc.wantSource {
+			t.Errorf("%s: resolveUserCoords() = %v, %q, %v, want %v, %q", tc.desc, got, source, err, tc.want, tc.wantSource)
+		}
+	}
+
+	// The configured precedence decides between sources that all have coordinates.
+	coordSources = []string{coordsFromZipCode, coordsFromRequest}
+	if got, source, _ := resolveUserCoords(ctx, req, user); got != zip || source != coordsFromZipCode {
+		t.Errorf("resolveUserCoords() with zip codes first = %v, %q, want the zip code", got, source)
+	}
+	// Sources that aren't configured aren't used.
+	if _, _, err := resolveUserCoords(ctx, CoordsReq{}, &User{ZipCode: "00001"}); zipCodeErrStatus(err) != http.StatusBadRequest {
+		t.Errorf("resolveUserCoords() without geocoding = %v, want an unsupported zip code error", err)
+	}
+}
+
+func TestCoordSourcesFromEnv(t *testing.T) {
+	def := []string{coordsFromZipCode}
+	os.Setenv("TEST_COORD_SOURCES", "")
+	defer os.Unsetenv("TEST_COORD_SOURCES")
+	if got := coordSourcesFromEnv("TEST_COORD_SOURCES", def); len(got) != 1 || got[0] != coordsFromZipCode {
+		t.Errorf("coordSourcesFromEnv() unset = %v, want the default %v", got, def)
+	}
+	os.Setenv("TEST_COORD_SOURCES", "geocode, zip_code")
+	if got := coordSourcesFromEnv("TEST_COORD_SOURCES", def); len(got) != 2 || got[0] != coordsFromGeocode || got[1] != coordsFromZipCode {
+		t.Errorf("coordSourcesFromEnv() = %v, want [geocode zip_code]", got)
+	}
+}
+
+func TestCoordsReqValidate(t *testing.T) {
+	lat, lng, bad := 47.6, -122.3, 200.0
+	for _, tc := range []struct {
+		req    CoordsReq
+		wantOK bool
+	}{
+		{CoordsReq{}, true},
+		{CoordsReq{Lat: &lat, Long: &lng}, true},
+		{CoordsReq{Lat: &lat}, false},
+		{CoordsReq{Lat: &bad, Long: &lng}, false},
+		{CoordsReq{Lat: &lat, Long: &bad}, false},
+	} {
+		if err := tc.req.validate(); (err == nil) != tc.wantOK {
+			t.Errorf("validate() of %+v = %v, want ok: %t", tc.req, err, tc.wantOK)
+		}
+	}
+}
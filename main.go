@@ -11,19 +11,79 @@ import (
 )
 
 func main() {
+	if err := loadHiddenItems(context.Background()); err != nil {
+		log.Printf("failed to load hidden items, all items are shown: %v", err)
+	}
+	go itemQueries.run(queryCountFlushInterval, nil)
+
 	r := mux.NewRouter()
 	// TODO: Set up admin endpoints.
 	r.HandleFunc("/user/setup", userSetupHandler)
 	r.HandleFunc("/user/edit", userEditHandler)
 	r.HandleFunc("/user/delete", userDeleteHandler)
 	r.HandleFunc("/user/query", userQueryHandler)
+	r.HandleFunc("/user/by-external", userByExternalHandler)
+	r.HandleFunc("/user/onboarding", userOnboardingHandler)
+	r.HandleFunc("/user/reports", userReportsHandler)
+	r.HandleFunc("/user/favorites/add", userFavoritesAddHandler)
+	r.HandleFunc("/user/favorites/remove", userFavoritesRemoveHandler)
+	r.HandleFunc("/user/favorites/query", userFavoritesQueryHandler)
 	r.HandleFunc("/item/query", itemQueryHandler)
-	r.HandleFunc("/item/tokens/query", itemTokensQueryHandler)
+	r.HandleFunc("/item/instock", itemInStockHandler)
+	r.HandleFunc("/item/autocomplete", itemAutocompleteHandler)
+	r.HandleFunc("/item/timeseries", itemTimeSeriesHandler)
+	r.HandleFunc("/item/gaps", itemGapsHandler)
+	r.HandleFunc("/shopping/nearest", shoppingNearestHandler)
+	r.HandleFunc("/store/region", storeRegionHandler)
+	r.HandleFunc("/chain/availability", chainAvailabilityHandler)
+	r.HandleFunc("/item/tokens/query", cacheable(itemTokensQueryHandler))
+	r.HandleFunc("/item/tokens/get", cacheable(itemTokensGetHandler))
 	r.HandleFunc("/store/query", storeQueryHandler)
 	r.HandleFunc("/store/add", storeAddHandler)
+	r.HandleFunc("/store/edit", storeEditHandler)
+	r.HandleFunc("/store/delete", storeDeleteHandler)
+	r.HandleFunc("/store/validate-address", flagged(featureValidateAddress, storeValidateAddressHandler))
+	r.HandleFunc("/store/distance", storeDistanceHandler)
+	r.HandleFunc("/store/export.geojson", storeExportGeoJSONHandler)
+	r.HandleFunc("/store/shortages", flagged(featureStoreShortages, storeShortagesHandler))
 	r.HandleFunc("/report/upload", reportUploadHandler)
+	r.HandleFunc("/report/visit", flagged(featureReportVisit, reportVisitHandler))
+	r.HandleFunc("/report/delete", reportDeleteHandler)
 	r.HandleFunc("/receipt/parse", receiptParseHandler)
-	hr := cors.Default().Handler(r)
+	r.HandleFunc("/map/stores", mapStoresHandler)
+	r.HandleFunc("/map/bbox", flagged(featureMapBox, mapBoxHandler))
+	r.HandleFunc("/feed/nearby", flagged(featureNearbyFeed, feedNearbyHandler))
+	r.HandleFunc("/stats/counts", flagged(featureStatsCounts, statsCountsHandler))
+	r.HandleFunc("/stats/item-queries", statsItemQueriesHandler)
+	r.HandleFunc("/zipcodes/resolve", flagged(featureZipCodesResolve, cacheable(zipCodesResolveHandler)))
+	r.HandleFunc("/telemetry/error", telemetryErrorHandler)
+	r.HandleFunc("/webhook/subscribe", webhookSubscribeHandler)
+	r.HandleFunc("/webhook/unsubscribe", webhookUnsubscribeHandler)
+	r.HandleFunc("/webhook/list", webhookListHandler)
+	r.HandleFunc("/region/subscribe", regionSubscribeHandler)
+	r.HandleFunc("/region/unsubscribe", regionUnsubscribeHandler)
+	r.HandleFunc("/admin/item/alias", adminItemAliasHandler)
+	r.HandleFunc("/admin/purge", adminPurgeHandler)
+	r.HandleFunc("/admin/item/raw", adminItemRawHandler)
+	r.HandleFunc("/admin/item/hide", adminItemHideHandler)
+	r.HandleFunc("/admin/config", adminConfigHandler)
+	r.HandleFunc("/admin/store/revet", adminStoreRevetHandler)
+	r.HandleFunc("/admin/store/backfill-types", adminStoreBackfillTypesHandler)
+	r.HandleFunc("/admin/item/dedup-users", adminItemDedupUsersHandler)
+	r.HandleFunc("/admin/report/reassign", adminReportReassignHandler)
+	r.HandleFunc("/admin/report/import", adminReportImportHandler)
+	r.HandleFunc("/admin/report/prune", adminReportPruneHandler)
+	r.HandleFunc("/admin/debug/sort", adminDebugSortHandler)
+	r.HandleFunc("/admin/maintenance", adminMaintenanceHandler)
+	r.Use(tracingMiddleware)
+	r.Use(requestTimeoutMiddleware)
+	r.Use(maintenanceMiddleware)
+	r.Use(clientMiddleware)
+	r.Use(noCacheMiddleware)
+	// Browser clients have to be allowed to send the custom request headers.
+	hr := cors.New(cors.Options{
+		AllowedHeaders: []string{"Origin", "Accept", "Content-Type", "X-Requested-With", requestTimeoutHeader, clientIDHeader, adminKeyHeader, traceparentHeader, readConsistencyHeader},
+	}).Handler(r)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -31,124 +91,745 @@ func main() {
 		log.Printf("Defaulting to port %s", port)
 	}
 
+	srv := newServer(":"+port, hr)
 	log.Printf("Listening on port %s", port)
-	if err := http.ListenAndServe(":"+port, hr); err != nil {
+	if err := srv.ListenAndServe(); err != nil {
 		log.Fatal(err)
 	}
 }
 
 func userSetupHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	if status, err := SetupUser(ctx, w, r); err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
 	}
 }
 
 func userEditHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	if status, err := EditUser(ctx, w, r); err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
 	}
 }
 
 func userDeleteHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	if status, err := DeleteUser(ctx, w, r); err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
 	}
 }
 
 func userQueryHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	if status, err := QueryUser(ctx, w, r); err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
+	}
+}
+
+func userByExternalHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := QueryUserByExternalID(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func userFavoritesAddHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if status, err := AddFavorite(ctx, w, r); err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func userFavoritesRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if status, err := RemoveFavorite(ctx, w, r); err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func userFavoritesQueryHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if status, err := QueryFavorites(ctx, w, r); err != nil {
+		writeError(ctx, w, status, err)
 	}
 }
 
 func itemQueryHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	status, err := QueryItems(ctx, w, r)
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
+	}
+}
+
+func itemInStockHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryInStockStores(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func itemAutocompleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := ItemAutocomplete(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
 	}
 }
 
 func itemTokensQueryHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	status, err := QueryItemTokens(ctx, w, r)
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
+	}
+}
+
+func itemTokensGetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := GetItemTokens(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
 	}
 }
 
 func storeQueryHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	status, err := QueryStores(ctx, w, r)
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
 	}
 }
 
 func storeAddHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	status, err := AddStore(ctx, w, r)
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeEditHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := EditStore(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := DeleteStore(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeValidateAddressHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := ValidateAddress(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeDistanceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryStoreDistance(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeShortagesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryStoreShortages(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
 	}
 }
 
 func reportUploadHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	status, err := UploadReport(ctx, w, r)
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
+	}
+}
+
+func reportVisitHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := UploadVisit(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeExportGeoJSONHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := ExportStoresGeoJSON(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func reportDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := DeleteReport(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
 	}
 }
 
 func receiptParseHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := r.Context()
 	if r.Method != "POST" {
 		http.NotFound(w, r)
 		return
 	}
 	status, err := ParseReceipt(ctx, w, r)
 	if err != nil {
-		http.Error(w, err.Error(), status)
+		writeError(ctx, w, status, err)
+	}
+}
+
+func mapStoresHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryMapStores(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func mapBoxHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryStoresInBox(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func feedNearbyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryNearbyFeed(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func statsCountsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryStatsCounts(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func statsItemQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryItemQueryStats(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func zipCodesResolveHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := ResolveZipCodes(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func webhookSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := SubscribeWebhook(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func webhookUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := UnsubscribeWebhook(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func regionSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := SubscribeRegion(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func regionUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := UnsubscribeRegion(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func webhookListHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := ListWebhooks(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminItemAliasHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := AliasItem(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminPurgeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := PurgeKinds(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminItemRawHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := QueryRawItem(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminReportImportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := ImportReports(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminDebugSortHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := DebugSortStores(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminItemHideHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := HideItem(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := SetMaintenance(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := QueryConfig(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminStoreRevetHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := RevetStores(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminStoreBackfillTypesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := BackfillStoreTypes(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func userOnboardingHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if status, err := QueryOnboarding(ctx, w, r); err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func userReportsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryUserReports(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminItemDedupUsersHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := DedupUsersInfo(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminReportPruneHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := PruneReports(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func adminReportReassignHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	if err := ValidateAdmin(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	status, err := ReassignReport(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func telemetryErrorHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := ReportClientError(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func itemTimeSeriesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryItemTimeSeries(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func shoppingNearestHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryShoppingNearest(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func storeRegionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryRegionStores(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func chainAvailabilityHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryChainAvailability(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func itemGapsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != "POST" {
+		http.NotFound(w, r)
+		return
+	}
+	status, err := QueryItemGaps(ctx, w, r)
+	if err != nil {
+		writeError(ctx, w, status, err)
 	}
 }
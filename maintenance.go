@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// maintenanceRetryAfterSec is the Retry-After sent with writes rejected during maintenance,
+// set with the MAINTENANCE_RETRY_AFTER_SEC env variable.
+var maintenanceRetryAfterSec = positiveIntFromEnv("MAINTENANCE_RETRY_AFTER_SEC", 300)
+
+// writeEndpoints are the endpoints paused during maintenance. Reads and admin endpoints keep
+// serving, so operators can still run migrations.
+var writeEndpoints = map[string]bool{
+	"/user/setup":            true,
+	"/user/edit":             true,
+	"/user/delete":           true,
+	"/user/favorites/add":    true,
+	"/user/favorites/remove": true,
+	"/store/add":             true,
+	"/store/edit":            true,
+	"/store/delete":          true,
+	"/report/upload":         true,
+	"/report/visit":          true,
+	"/report/delete":         true,
+	"/webhook/subscribe":     true,
+	"/webhook/unsubscribe":   true,
+	"/region/subscribe":      true,
+	"/region/unsubscribe":    true,
+}
+
+// maintenance holds whether the server is in maintenance mode. It starts from the
+// MAINTENANCE_MODE env variable and is toggled by SetMaintenance. A toggle only applies to the
+// server instance that handled it; set the env variable to pause writes on every instance.
+var maintenance = &maintenanceMode{on: boolFromEnv("MAINTENANCE_MODE", false)}
+
+type maintenanceMode struct {
+	mu sync.RWMutex
+	on bool
+}
+
+func (m *maintenanceMode) enabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.on
+}
+
+func (m *maintenanceMode) set(on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.on = on
+}
+
+// boolFromEnv returns the value of the env variable key, or def if it is unset. A malformed
+// value stops the server.
+func boolFromEnv(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("%s env variable must be true or false: %q", key, v)
+	}
+	return b
+}
+
+// maintenanceMiddleware rejects requests to writeEndpoints with 503 and a Retry-After header
+// while the server is in maintenance mode.
+func maintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenance.enabled() && writeEndpoints[r.URL.Path] {
+			w.Header().Set("Retry-After", strconv.Itoa(maintenanceRetryAfterSec))
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, "the server is in maintenance, writes are paused", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ******************************************
+// ** BEGIN SetMaintenance
+// ******************************************
+
+type SetMaintenanceReq struct {
+	// Enabled pauses writes if true and resumes them if false.
+	Enabled bool `json:"enabled"`
+}
+
+type SetMaintenanceResp struct {
+	Enabled bool `json:"enabled"`
+}
+
+// SetMaintenance turns maintenance mode on or off on this server instance. It is an admin
+// endpoint.
+func SetMaintenance(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req SetMaintenanceReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	maintenance.set(req.Enabled)
+	log.Printf("maintenance mode set to %t", req.Enabled)
+
+	if err := EncodeResp(w, &SetMaintenanceResp{Enabled: maintenance.enabled()}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END SetMaintenance
+// ******************************************
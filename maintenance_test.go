@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMiddleware(t *testing.T) {
+	defer maintenance.set(maintenance.enabled())
+
+	h := maintenanceMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		return w
+	}
+	writes := []string{"/user/setup", "/user/edit", "/store/add", "/report/upload"}
+	reads := []string{"/item/query", "/store/query", "/user/query", "/admin/maintenance"}
+
+	maintenance.set(true)
+	for _, path := range writes {
+		w := serve(path)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s during maintenance: got status %d, want %d", path, w.Code, http.StatusServiceUnavailable)
+		}
+		if got := w.Header().Get("Retry-After"); got != strconv.Itoa(maintenanceRetryAfterSec) {
+			t.Errorf("%s during maintenance: got Retry-After %q, want %d", path, got, maintenanceRetryAfterSec)
+		}
+		if !strings.Contains(w.Body.String(), "maintenance") {
+			t.Errorf("%s during maintenance: got body %q, want it to mention maintenance", path, w.Body.String())
+		}
+	}
+	for _, path := range reads {
+		if w := serve(path); w.Code != http.StatusOK {
+			t.Errorf("%s during maintenance: got status %d, want %d", path, w.Code, http.StatusOK)
+		}
+	}
+
+	maintenance.set(false)
+	for _, path := range writes {
+		if w := serve(path); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+			t.Errorf("%s after maintenance: got status %d, want %d without Retry-After", path, w.Code, http.StatusOK)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultMapRadiusMiles = 10.0
+	maxMapRadiusMiles     = 50.0
+)
+
+// mapPageLimits are the page limits of the map endpoints, set with the MAP_DEFAULT_LIMIT and
+// MAP_MAX_LIMIT env variables.
+var mapPageLimits = pageLimitsFromEnv("MAP", pageLimits{Default: 50, Max: 200})
+
+// ******************************************
+// ** BEGIN QueryMapStores
+// ******************************************
+
+type QueryMapStoresReq struct {
+	UserID      string  `json:"user_id"`
+	ZipCode     string  `json:"zip_code"`
+	RadiusMiles float64 `json:"radius_miles"`
+	PageReq
+}
+
+type QueryMapStoresResp []*MapStoreInfo
+
+// MapStoreInfo is a store annotated with the number of items whose most recent report
+// at the store is in stock or out of stock. It's meant for color-coding map pins.
+type MapStoreInfo struct {
+	*Store
+	DistanceMiles float64 `json:"distanceMiles"`
+	InStockCnt    int     `json:"inStockCount"`
+	OutStockCnt   int     `json:"outStockCount"`
+	// LastReportSec is when any item was last reported at the store, including items the
+	// user couldn't check.
+	LastReportSec int64 `json:"lastReportTimestampSec,omitempty"`
+}
+
+// stockCounts holds the number of items currently in stock and out of stock at a store and
+// when the store was last reported on.
+type stockCounts struct {
+	InStock       int
+	OutStock      int
+	LastReportSec int64
+}
+
+// QueryMapStores fetches the stores within a radius of a zip code along with their item
+// availability counts. The zip code defaults to the user's zip code.
+func QueryMapStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryMapStoresReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryMapStoresReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	if req.ZipCode == "" {
+		req.ZipCode = u.ZipCode
+	}
+	coords, ok := zipCodeToLatLong[req.ZipCode]
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("zip code %q is not supported", req.ZipCode)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	stores, err := loadAllStores(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	items, err := loadAllItems(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp, pg := buildMapStores(stores, aggregateStoreStock(items), coords, req.RadiusMiles, req.PageReq)
+	if err := EncodePage(w, req.PageReq, pg, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryMapStoresReq(req *QueryMapStoresReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxMapRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxMapRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultMapRadiusMiles
+	}
+	return req.PageReq.cleanAndValidate(mapPageLimits)
+}
+
+// ******************************************
+// ** END QueryMapStores
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryStoresInBox
+// ******************************************
+
+type QueryStoresInBoxReq struct {
+	UserID  string  `json:"user_id"`
+	MinLat  float64 `json:"min_lat"`
+	MaxLat  float64 `json:"max_lat"`
+	MinLong float64 `json:"min_long"`
+	MaxLong float64 `json:"max_long"`
+	PageReq
+}
+
+type QueryStoresInBoxResp []*Store
+
+// QueryStoresInBox fetches the stores inside a latitude/longitude bounding box, such as the
+// visible area of a map, nearest the center of the box first.
+func QueryStoresInBox(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryStoresInBoxReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryStoresInBoxReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	stores, err := loadAllStores(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp, pg := storesInBox(stores, &req)
+	if err := EncodePage(w, req.PageReq, pg, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryStoresInBoxReq(req *QueryStoresInBoxReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.MinLat < -90 || req.MaxLat > 90 {
+		return fmt.Errorf("latitudes must be between -90 and 90")
+	}
+	if req.MinLong < -180 || req.MaxLong > 180 {
+		return fmt.Errorf("longitudes must be between -180 and 180")
+	}
+	if req.MinLat >= req.MaxLat {
+		return fmt.Errorf("min latitude must be less than max latitude")
+	}
+	// Boxes crossing the antimeridian aren't supported.
+	if req.MinLong >= req.MaxLong {
+		return fmt.Errorf("min longitude must be less than max longitude")
+	}
+	return req.PageReq.cleanAndValidate(mapPageLimits)
+}
+
+// storesInBox returns the requested page of the stores inside the request's box, nearest the
+// center of the box first.
+func storesInBox(stores []*Store, req *QueryStoresInBoxReq) (QueryStoresInBoxResp, *Pagination) {
+	centerLat := (req.MinLat + req.MaxLat) / 2
+	centerLong := (req.MinLong + req.MaxLong) / 2
+	resp := make(QueryStoresInBoxResp, 0)
+	dists := make(map[*Store]float64)
+	for _, st := range stores {
+		if st.Lat < req.MinLat || st.Lat > req.MaxLat || st.Long < req.MinLong || st.Long > req.MaxLong {
+			continue
+		}
+		dists[st] = Distance(st.Lat, st.Long, centerLat, centerLong)
+		resp = append(resp, st)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return dists[resp[i]] < dists[resp[j]]
+	})
+	start, end, pg := pageBounds(len(resp), req.PageReq)
+	return resp[start:end], pg
+}
+
+// ******************************************
+// ** END QueryStoresInBox
+// ******************************************
+
+// aggregateStoreStock counts, for each store ID, the items whose most recent stock report
+// at that store is in stock or out of stock. Unknown reports only count toward when the
+// store was last reported on.
+func aggregateStoreStock(items []*Item) map[string]*stockCounts {
+	counts := make(map[string]*stockCounts)
+	get := func(storeID string) *stockCounts {
+		c, ok := counts[storeID]
+		if !ok {
+			c = &stockCounts{}
+			counts[storeID] = c
+		}
+		return c
+	}
+	for _, item := range items {
+		for _, sr := range item.StockReports {
+			if sr.StoreInfo == nil {
+				continue
+			}
+			if c := get(sr.StoreInfo.StoreID); sr.TimestampSec > c.LastReportSec {
+				c.LastReportSec = sr.TimestampSec
+			}
+		}
+		for storeID, sr := range latestStockReports(item) {
+			c := get(storeID)
+			if sr.InStock {
+				c.InStock++
+			} else {
+				c.OutStock++
+			}
+		}
+	}
+	return counts
+}
+
+// latestStockReports maps each store ID to the item's most recent stock report at that
+// store. An item may have both an in-stock and an out-of-stock report for the same store;
+// only the most recent one reflects the current state. Unknown reports are skipped since
+// they don't say whether the item is in stock.
+func latestStockReports(item *Item) map[string]*StockReport {
+	latest := make(map[string]*StockReport)
+	for _, sr := range item.StockReports {
+		if sr.StoreInfo == nil || sr.Unknown {
+			continue
+		}
+		storeID := sr.StoreInfo.StoreID
+		if prev, ok := latest[storeID]; !ok || sr.TimestampSec > prev.TimestampSec {
+			latest[storeID] = sr
+		}
+	}
+	return latest
+}
+
+// buildMapStores annotates the stores within radiusMiles of coords with their stock counts,
+// sorted by distance, and returns the requested page of them.
+func buildMapStores(stores []*Store, counts map[string]*stockCounts, coords coord, radiusMiles float64, p PageReq) (QueryMapStoresResp, *Pagination) {
+	resp := make(QueryMapStoresResp, 0)
+	for _, st := range stores {
+		d := Distance(st.Lat, st.Long, coords.Lat, coords.Long)
+		if d > radiusMiles {
+			continue
+		}
+		info := &MapStoreInfo{Store: st, DistanceMiles: d}
+		if c, ok := counts[st.StoreID]; ok {
+			info.InStockCnt = c.InStock
+			info.OutStockCnt = c.OutStock
+			info.LastReportSec = c.LastReportSec
+		}
+		resp = append(resp, info)
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		return resp[i].DistanceMiles < resp[j].DistanceMiles
+	})
+	start, end, pg := pageBounds(len(resp), p)
+	return resp[start:end], pg
+}
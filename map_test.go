@@ -0,0 +1,169 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestAggregateStoreStock(t *testing.T) {
+	kirkland := &Store{StoreID: "kirkland"}
+	seattle := &Store{StoreID: "seattle"}
+	items := []*Item{
+		{
+			Name: "toilet paper",
+			StockReports: []*StockReport{
+				{StoreInfo: kirkland, InStock: true, TimestampSec: 100},
+				// The newer out-of-stock report overrides the older in-stock one.
+				{StoreInfo: seattle, InStock: true, TimestampSec: 100},
+				{StoreInfo: seattle, InStock: false, TimestampSec: 200},
+			},
+		},
+		{
+			Name: "hand sanitizer",
+			StockReports: []*StockReport{
+				{StoreInfo: kirkland, InStock: false, TimestampSec: 100},
+				{StoreInfo: seattle, InStock: false, TimestampSec: 100},
+				{StoreInfo: seattle, InStock: true, TimestampSec: 300},
+			},
+		},
+		{
+			Name: "flour",
+			StockReports: []*StockReport{
+				{StoreInfo: kirkland, InStock: true, TimestampSec: 100},
+			},
+		},
+	}
+
+	counts := aggregateStoreStock(items)
+	want := map[string]stockCounts{
+		"kirkland": {InStock: 2, OutStock: 1, LastReportSec: 100},
+		"seattle":  {InStock: 1, OutStock: 1, LastReportSec: 300},
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("got counts for %d stores, want %d", len(counts), len(want))
+	}
+	for storeID, w := range want {
+		got, ok := counts[storeID]
+		if !ok {
+			t.Fatalf("missing counts for store %q", storeID)
+		}
+		if *got != w {
+			t.Errorf("store %q: got %+v, want %+v", storeID, *got, w)
+		}
+	}
+}
+
+func TestBuildMapStores(t *testing.T) {
+	origin := coord{Lat: 47.6, Long: -122.3}
+	stores := []*Store{
+		{StoreID: "far", Lat: 47.9, Long: -122.3},
+		{StoreID: "near", Lat: 47.61, Long: -122.3},
+		{StoreID: "mid", Lat: 47.65, Long: -122.3},
+	}
+	counts := map[string]*stockCounts{
+		"near": {InStock: 3, OutStock: 1},
+	}
+
+	resp, _ := buildMapStores(stores, counts, origin, 10, PageReq{Limit: 50})
+	if len(resp) != 2 {
+		t.Fatalf("got %d stores within radius, want 2", len(resp))
+	}
+	if resp[0].StoreID != "near" || resp[1].StoreID != "mid" {
+		t.Errorf("got order %q, %q, want near, mid", resp[0].StoreID, resp[1].StoreID)
+	}
+	if resp[0].InStockCnt != 3 || resp[0].OutStockCnt != 1 {
+		t.Errorf("got counts %d/%d for near store, want 3/1", resp[0].InStockCnt, resp[0].OutStockCnt)
+	}
+
+	resp, _ = buildMapStores(stores, counts, origin, 50, PageReq{Limit: 1})
+	if len(resp) != 1 || resp[0].StoreID != "near" {
+		t.Errorf("limit not applied: got %d stores", len(resp))
+	}
+}
+
+func TestStoresInBox(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "north", Lat: 47.8, Long: -122.3},
+		{StoreID: "edge", Lat: 47.7, Long: -122.2},
+		{StoreID: "center", Lat: 47.6, Long: -122.3},
+		{StoreID: "east", Lat: 47.6, Long: -121.9},
+		{StoreID: "south", Lat: 47.41, Long: -122.3},
+	}
+	req := &QueryStoresInBoxReq{MinLat: 47.4, MaxLat: 47.8, MinLong: -122.4, MaxLong: -122.2, PageReq: PageReq{Limit: 10}}
+
+	resp, _ := storesInBox(stores, req)
+	var got []string
+	for _, st := range resp {
+		got = append(got, st.StoreID)
+	}
+	// Nearest the center of the box first; stores on the boundary are inside.
+	want := []string{"center", "edge", "south", "north"}
+	if len(got) != len(want) {
+		t.Fatalf("got stores %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got stores %v, want %v", got, want)
+		}
+	}
+
+	req.Limit = 1
+	if resp, _ := storesInBox(stores, req); len(resp) != 1 || resp[0].StoreID != "center" {
+		t.Errorf("got %d stores with limit 1, want only center", len(resp))
+	}
+}
+
+func TestCleanAndValidateQueryStoresInBoxReq(t *testing.T) {
+	req := &QueryStoresInBoxReq{UserID: "user", MinLat: 47.4, MaxLat: 47.8, MinLong: -122.4, MaxLong: -122.2}
+	if err := cleanAndValidateQueryStoresInBoxReq(req); err != nil {
+		t.Fatalf("cleanAndValidateQueryStoresInBoxReq() failed: %v", err)
+	}
+	if req.Limit != mapPageLimits.Default {
+		t.Errorf("got limit %d, want default %d", req.Limit, mapPageLimits.Default)
+	}
+
+	for _, bad := range []*QueryStoresInBoxReq{
+		{MinLat: 47.4, MaxLat: 47.8, MinLong: -122.4, MaxLong: -122.2},
+		{UserID: "user", MinLat: 47.8, MaxLat: 47.4, MinLong: -122.4, MaxLong: -122.2},
+		{UserID: "user", MinLat: 47.4, MaxLat: 47.8, MinLong: -122.2, MaxLong: -122.4},
+		{UserID: "user", MinLat: -91, MaxLat: 47.8, MinLong: -122.4, MaxLong: -122.2},
+		{UserID: "user", MinLat: 47.4, MaxLat: 47.8, MinLong: -122.4, MaxLong: 181},
+		{UserID: "user", MinLat: 47.4, MaxLat: 47.8, MinLong: -122.4, MaxLong: -122.2, PageReq: PageReq{Limit: mapPageLimits.Max + 1}},
+		{UserID: "user", MinLat: 47.4, MaxLat: 47.8, MinLong: -122.4, MaxLong: -122.2, PageReq: PageReq{Offset: -1}},
+	} {
+		if err := cleanAndValidateQueryStoresInBoxReq(bad); err == nil {
+			t.Errorf("cleanAndValidateQueryStoresInBoxReq(%+v) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestUnknownReports(t *testing.T) {
+	store := &Store{StoreID: "store"}
+	items := []*Item{
+		{
+			Name: "flour",
+			StockReports: []*StockReport{
+				{StoreInfo: store, InStock: false, TimestampSec: 100},
+				// The newer unknown report doesn't override the out-of-stock verdict.
+				{StoreInfo: store, Unknown: true, TimestampSec: 500},
+			},
+		},
+		{
+			Name: "yeast",
+			StockReports: []*StockReport{
+				{StoreInfo: store, Unknown: true, TimestampSec: 400},
+			},
+		},
+	}
+
+	counts := aggregateStoreStock(items)
+	if want := (stockCounts{OutStock: 1, LastReportSec: 500}); counts["store"] == nil || *counts["store"] != want {
+		t.Errorf("got counts %+v, want %+v", counts["store"], want)
+	}
+	shortages := storeShortages(items, "store", 600, 3600)
+	if len(shortages) != 1 || shortages[0].ItemName != "flour" || shortages[0].SecondsAgo != 500 {
+		t.Errorf("got shortages %+v, want only flour reported 500 seconds ago", shortages)
+	}
+	if infos := parseItem(items[1]); len(infos) != 0 {
+		t.Errorf("got %d item infos for an item with only unknown reports, want 0", len(infos))
+	}
+}
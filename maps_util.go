@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
 
 	"googlemaps.github.io/maps"
 )
@@ -10,9 +12,83 @@ import (
 // MapsClient returns a new client to Google Maps APIs
 func MapsClient() (*maps.Client, error) {
 	apiKey := os.Getenv("MAPS_CLIENT_API_KEY") // See GCP console for API key
-	c, err := maps.NewClient(maps.WithAPIKey(apiKey))
+	c, err := maps.NewClient(maps.WithAPIKey(apiKey), maps.WithHTTPClient(outboundClient))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create maps client: %v", err)
 	}
 	return c, nil
 }
+
+// placesClient is the part of the maps client used to look up stores. It lets tests fake
+// Places responses.
+type placesClient interface {
+	FindPlaceFromText(ctx context.Context, r *maps.FindPlaceFromTextRequest) (maps.FindPlaceFromTextResponse, error)
+	PlaceDetails(ctx context.Context, r *maps.PlaceDetailsRequest) (maps.PlaceDetailsResult, error)
+}
+
+// PlacesClient returns a new client to the Google Places API that records a span around
+// each call.
+func PlacesClient() (placesClient, error) {
+	c, err := MapsClient()
+	if err != nil {
+		return nil, err
+	}
+	return &tracedPlaces{places: c}, nil
+}
+
+// tracedPlaces records a span around each call to the wrapped Places client.
+type tracedPlaces struct {
+	places placesClient
+}
+
+func (p *tracedPlaces) FindPlaceFromText(ctx context.Context, r *maps.FindPlaceFromTextRequest) (maps.FindPlaceFromTextResponse, error) {
+	ctx, span := startClientSpan(ctx, "places.FindPlaceFromText")
+	defer span.Finish()
+	resp, err := p.places.FindPlaceFromText(ctx, r)
+	span.SetError(err)
+	return resp, err
+}
+
+func (p *tracedPlaces) PlaceDetails(ctx context.Context, r *maps.PlaceDetailsRequest) (maps.PlaceDetailsResult, error) {
+	ctx, span := startClientSpan(ctx, "places.PlaceDetails")
+	defer span.Finish()
+	resp, err := p.places.PlaceDetails(ctx, r)
+	span.SetError(err)
+	return resp, err
+}
+
+// geocodeZipCode looks up the coordinates of a US zip code with the Maps API. It's a variable
+// so that tests can fake the lookup.
+var geocodeZipCode = mapsGeocodeZipCode
+
+// geocodedZipCodes caches the coordinates found by mapsGeocodeZipCode, keyed by zip code.
+var geocodedZipCodes sync.Map
+
+func mapsGeocodeZipCode(ctx context.Context, zipCode string) (coord, error) {
+	if c, ok := geocodedZipCodes.Load(zipCode); ok {
+		return c.(coord), nil
+	}
+	client, err := MapsClient()
+	if err != nil {
+		return coord{}, err
+	}
+	ctx, span := startClientSpan(ctx, "maps.Geocode")
+	defer span.Finish()
+	results, err := client.Geocode(ctx, &maps.GeocodingRequest{
+		Components: map[maps.Component]string{
+			maps.ComponentPostalCode: zipCode,
+			maps.ComponentCountry:    "US",
+		},
+	})
+	if err != nil {
+		span.SetError(err)
+		return coord{}, fmt.Errorf("failed to geocode zip code %q: %v", zipCode, err)
+	}
+	if len(results) == 0 {
+		return coord{}, &unsupportedZipCodeError{zipCode}
+	}
+	loc := results[0].Geometry.Location
+	c := coord{Lat: loc.Lat, Long: loc.Lng}
+	geocodedZipCodes.Store(zipCode, c)
+	return c, nil
+}
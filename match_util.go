@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Credits for how well a word matches an item token. Receipts abbreviate and OCR misreads,
+// so words that nearly match still count for something.
+const (
+	exactTokenCredit  = 1.0
+	prefixTokenCredit = 0.8
+	typoTokenCredit   = 0.8
+	// minPrefixWordLen is the shortest word that matches the tokens it is a prefix of, such
+	// as "bana" for "banana".
+	minPrefixWordLen = 3
+	// minTypoTokenLen is the shortest token that matches words one edit away from it.
+	minTypoTokenLen = 5
+	// minItemNameMatchScore is the lowest score with which an item name that isn't in the
+	// catalog resolves to a catalog item, such as "toilet papers" to "toilet paper".
+	minItemNameMatchScore = 0.75
+	// minNearItemMatchScore is the lowest score of a near match listed for an item name that
+	// doesn't resolve.
+	minNearItemMatchScore = 0.4
+	// maxNearItemMatches caps the near matches listed for an item name that doesn't resolve.
+	maxNearItemMatches = 5
+)
+
+// itemAbbreviations maps common shopping list shorthand to catalog item names.
+var itemAbbreviations = map[string]string{
+	"evoo": "extra virgin olive oil",
+	"oj":   "orange juice",
+	"pb":   "peanut butter",
+	"tp":   "toilet paper",
+}
+
+// matchWords splits text into lowercase words, dropping numbers and single characters, which
+// are usually prices, quantities, and codes.
+func matchWords(text string) []string {
+	var words []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(w)) < 2 || strings.IndexFunc(w, unicode.IsLetter) < 0 {
+			continue
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// tokenCredit returns how well the word matches the item token.
+func tokenCredit(word, token string) float64 {
+	if word == token {
+		return exactTokenCredit
+	}
+	if len([]rune(word)) >= minPrefixWordLen && strings.HasPrefix(token, word) {
+		return prefixTokenCredit
+	}
+	if len([]rune(token)) >= minTypoTokenLen && word[0] == token[0] && abs(len(word)-len(token)) <= 1 && editDistance(word, token) == 1 {
+		return typoTokenCredit
+	}
+	return 0
+}
+
+// scoreTokens returns how well the words match the item tokens, from 0 to 1. Each token is
+// credited for the word that matches it best, and the score is the Dice coefficient of the
+// credited tokens, so that words left over lower it as much as tokens left unmatched.
+func scoreTokens(words []string, tokens Tokens) float64 {
+	if len(words) == 0 || len(tokens) == 0 {
+		return 0
+	}
+	matched := 0.0
+	for _, tok := range tokens {
+		best := 0.0
+		for _, w := range words {
+			if c := tokenCredit(w, tok); c > best {
+				best = c
+			}
+		}
+		matched += best
+	}
+	return 2 * matched / float64(len(words)+len(tokens))
+}
+
+// bestItemMatch returns the visible catalog item whose tokens best match the words along with
+// its score. Ties go to the item with more tokens, then to the one listed first.
+func bestItemMatch(words []string) (string, float64) {
+	best, bestScore, bestTokens := "", 0.0, 0
+	for i, name := range itemNames {
+		score := scoreTokens(words, itemTokens[i])
+		if score == 0 || hiddenItems.has(name) {
+			continue
+		}
+		if score > bestScore || (score == bestScore && len(itemTokens[i]) > bestTokens) {
+			best, bestScore, bestTokens = name, score, len(itemTokens[i])
+		}
+	}
+	return best, bestScore
+}
+
+// resolveItemName resolves an item name to a catalog item name. Catalog names resolve to
+// themselves; other names resolve to the item they abbreviate, the item with the same tokens
+// in any order, or the item whose tokens best match their words, if it scores at least
+// minItemNameMatchScore. Hidden items are only resolved to by their own name. The name is
+// returned as is, with ok false, if it doesn't resolve.
+func resolveItemName(name string) (string, bool) {
+	if _, ok := itemIndex[name]; ok {
+		return name, true
+	}
+	if canonical, ok := itemAbbreviations[name]; ok && !hiddenItems.has(canonical) {
+		return canonical, true
+	}
+	words := matchWords(name)
+	if i, ok := itemsByTokens[tokenKey(words)]; ok && !hiddenItems.has(itemNames[i]) {
+		return itemNames[i], true
+	}
+	if best, score := bestItemMatch(words); score >= minItemNameMatchScore {
+		return best, true
+	}
+	return name, false
+}
+
+// nearItemMatches returns the visible catalog items whose tokens match the words of the
+// item name with a score of at least minNearItemMatchScore, best first, up to
+// maxNearItemMatches.
+func nearItemMatches(name string) []string {
+	type match struct {
+		name  string
+		score float64
+	}
+	words := matchWords(name)
+	var matches []match
+	for i, itemName := range itemNames {
+		score := scoreTokens(words, itemTokens[i])
+		if score < minNearItemMatchScore || hiddenItems.has(itemName) {
+			continue
+		}
+		matches = append(matches, match{itemName, score})
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	if len(matches) > maxNearItemMatches {
+		matches = matches[:maxNearItemMatches]
+	}
+	names := make([]string, len(matches))
+	for i, m := range matches {
+		names[i] = m.name
+	}
+	return names
+}
+
+// unknownItemError returns the error for an item name that doesn't resolve to a catalog item,
+// listing its near matches.
+func unknownItemError(name string) error {
+	near := nearItemMatches(name)
+	if len(near) == 0 {
+		return fmt.Errorf("item %q is not in the item catalog", name)
+	}
+	quoted := make([]string, len(near))
+	for i, n := range near {
+		quoted[i] = fmt.Sprintf("%q", n)
+	}
+	return fmt.Errorf("item %q is not in the item catalog, near matches: %s", name, strings.Join(quoted, ", "))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchWords(t *testing.T) {
+	got := matchWords("ORG Bananas 2 @ $0.59/lb 4011")
+	want := []string{"org", "bananas", "lb"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("matchWords() = %q, want %q", got, want)
+	}
+}
+
+func TestScoreTokens(t *testing.T) {
+	for _, tc := range []struct {
+		words  []string
+		tokens Tokens
+		want   float64
+	}{
+		{[]string{"honey"}, Tokens{"honey"}, 1},
+		{[]string{"honey"}, Tokens{"acacia", "honey"}, 2.0 / 3},
+		{[]string{"chick"}, Tokens{"chicken"}, prefixTokenCredit},
+		{[]string{"chiken"}, Tokens{"chicken"}, typoTokenCredit},
+		{[]string{"ch"}, Tokens{"chicken"}, 0},
+		{[]string{"milk"}, Tokens{"bread"}, 0},
+	} {
+		if got := scoreTokens(tc.words, tc.tokens); got != tc.want {
+			t.Errorf("scoreTokens(%q, %q) = %v, want %v", tc.words, tc.tokens, got, tc.want)
+		}
+	}
+}
+
+func TestResolveItemName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"toilet paper", "toilet paper", true},
+		{"tp", "toilet paper", true},
+		{"toilet papers", "toilet paper", true},
+		{"paper toilet", "toilet paper", true},
+		{"unicorn meat", "unicorn meat", false},
+	} {
+		if got, ok := resolveItemName(tc.name); got != tc.want || ok != tc.ok {
+			t.Errorf("resolveItemName(%q) = %q, %t, want %q, %t", tc.name, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	hiddenItems.set("toilet paper", true)
+	defer hiddenItems.set("toilet paper", false)
+	if got, ok := resolveItemName("tp"); ok {
+		t.Errorf("resolveItemName(%q) = %q with the item hidden, want no match", "tp", got)
+	}
+}
+
+func TestUnknownItemError(t *testing.T) {
+	near := nearItemMatches("unicorn meat")
+	if len(near) == 0 || len(near) > maxNearItemMatches {
+		t.Fatalf("got near matches %q, want 1 to %d", near, maxNearItemMatches)
+	}
+	if near[0] != "meat" {
+		t.Errorf("got best near match %q, want %q", near[0], "meat")
+	}
+	err := unknownItemError("unicorn meat")
+	if !strings.Contains(err.Error(), `"unicorn meat"`) || !strings.Contains(err.Error(), `"meat"`) {
+		t.Errorf("got error %q, want it to name the item and its near matches", err)
+	}
+
+	if err := unknownItemError("zzzz"); err.Error() != `item "zzzz" is not in the item catalog` {
+		t.Errorf("got error %q for a name without near matches", err)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultBlockedCIDRs are the networks the server refuses to send outbound requests to:
+// loopback, private, link-local (including the 169.254.169.254 metadata server), and
+// unspecified addresses. Override with a comma-separated OUTBOUND_BLOCKED_CIDRS env variable.
+var defaultBlockedCIDRs = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+var (
+	outboundBlockedNetworks []*net.IPNet
+	// outboundClient must be used for every outbound HTTP request the server initiates.
+	outboundClient *http.Client
+)
+
+func init() {
+	cidrs := defaultBlockedCIDRs
+	if v := os.Getenv("OUTBOUND_BLOCKED_CIDRS"); v != "" {
+		cidrs = strings.Split(v, ",")
+	}
+	networks, err := parseCIDRs(cidrs)
+	if err != nil {
+		log.Fatalf("failed to parse outbound blocklist: %v", err)
+	}
+	outboundBlockedNetworks = networks
+	outboundClient = newOutboundClient(outboundBlockedNetworks)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// newOutboundClient returns an HTTP client that only speaks http(s) and refuses to connect
+// to addresses in the blocked networks. The address is checked after DNS resolution, right
+// before connecting, so a hostname cannot be rebound to an internal address after validation.
+func newOutboundClient(blocked []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("outbound request to %q is not allowed: not an ip address", host)
+			}
+			if ipBlocked(ip, blocked) {
+				return fmt.Errorf("outbound request to %s is not allowed", ip)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &outboundTransport{
+			// No proxy since a proxy would connect to the target on our behalf and bypass
+			// the address check.
+			base: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 10 * time.Second,
+				MaxIdleConns:        100,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+type outboundTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip rejects non-http(s) URLs, including those reached through redirects.
+func (t *outboundTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := validateOutboundScheme(req.URL); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+func validateOutboundScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("outbound request scheme %q is not allowed", u.Scheme)
+	}
+	return nil
+}
+
+func ipBlocked(ip net.IP, blocked []*net.IPNet) bool {
+	for _, network := range blocked {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOutboundClientBlocksInternalTargets(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer local.Close()
+
+	client := newOutboundClient(outboundBlockedNetworks)
+	for _, target := range []string{
+		"http://169.254.169.254/computeMetadata/v1/",
+		"http://169.254.10.10/",
+		"http://localhost" + strings.TrimPrefix(local.URL, "http://127.0.0.1"),
+		local.URL,
+		"http://[::1]/",
+		"file:///etc/passwd",
+	} {
+		resp, err := client.Get(target)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("GET %s succeeded, want blocked", target)
+		}
+	}
+}
+
+func TestOutboundClientConfigurableBlocklist(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer local.Close()
+
+	blocked, err := parseCIDRs([]string{"169.254.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := newOutboundClient(blocked)
+	resp, err := client.Get(local.URL)
+	if err != nil {
+		t.Fatalf("GET %s = %v, want allowed when loopback is not blocked", local.URL, err)
+	}
+	resp.Body.Close()
+
+	if resp, err := client.Get("http://169.254.169.254/"); err == nil {
+		resp.Body.Close()
+		t.Error("GET 169.254.169.254 succeeded, want blocked")
+	}
+}
+
+func TestParseCIDRsRejectsInvalid(t *testing.T) {
+	if _, err := parseCIDRs([]string{"10.0.0.0/8", "not-a-cidr"}); err == nil {
+		t.Error("parseCIDRs() succeeded, want error")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// paginatedVersion is the first response version that wraps the results of a paginated
+// endpoint with their pagination metadata. Earlier versions respond with the bare results.
+const paginatedVersion = 2
+
+// pageLimits are the default and max number of results per page of an endpoint.
+type pageLimits struct {
+	Default int `json:"default"`
+	Max     int `json:"max"`
+}
+
+// pageLimitsFromEnv returns the page limits set with the <prefix>_DEFAULT_LIMIT and
+// <prefix>_MAX_LIMIT env variables. A default above the max stops the server.
+func pageLimitsFromEnv(prefix string, def pageLimits) pageLimits {
+	l := pageLimits{
+		Default: positiveIntFromEnv(prefix+"_DEFAULT_LIMIT", def.Default),
+		Max:     positiveIntFromEnv(prefix+"_MAX_LIMIT", def.Max),
+	}
+	if l.Default > l.Max {
+		log.Fatalf("%s_DEFAULT_LIMIT env variable must not be above %s_MAX_LIMIT", prefix, prefix)
+	}
+	return l
+}
+
+// PageReq is embedded in the requests of paginated endpoints.
+type PageReq struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// Version 2 and later wrap the results with their pagination metadata.
+	Version int `json:"version"`
+}
+
+// cleanAndValidate checks the requested page and defaults its limit.
+func (p *PageReq) cleanAndValidate(limits pageLimits) error {
+	if p.Limit < 0 || p.Limit > limits.Max {
+		return fmt.Errorf("limit must be between 0 and %d", limits.Max)
+	}
+	if p.Limit == 0 {
+		p.Limit = limits.Default
+	}
+	if p.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+	return nil
+}
+
+// Pagination describes the page of results in a response.
+type Pagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// Total is the number of results across all pages. It's left out when counting them
+	// would be expensive.
+	Total *int `json:"total,omitempty"`
+}
+
+// pageBounds returns the bounds, within n results, of the requested page and its metadata.
+func pageBounds(n int, p PageReq) (start, end int, pg *Pagination) {
+	start = p.Offset
+	if start > n {
+		start = n
+	}
+	// Clamping the offset first keeps a huge offset from overflowing the end.
+	end = start + p.Limit
+	if p.Limit > n-start {
+		end = n
+	}
+	return start, end, &Pagination{Limit: p.Limit, Offset: p.Offset, Total: &n}
+}
+
+// EncodePage encodes the page of results, wrapped with its pagination metadata if the
+// request asked for a paginated version.
+func EncodePage(w http.ResponseWriter, p PageReq, pg *Pagination, results interface{}) error {
+	if p.Version < paginatedVersion {
+		return EncodeResp(w, results)
+	}
+	return EncodeResp(w, &struct {
+		*Pagination
+		Results interface{} `json:"results"`
+	}{pg, results})
+}
+
+// offsetCursorPrefix marks the cursors made by encodeOffsetCursor.
+const offsetCursorPrefix = "offset:"
+
+// encodeOffsetCursor returns an opaque cursor for the page of results starting at offset,
+// for endpoints that page through results sorted in memory.
+func encodeOffsetCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(offsetCursorPrefix + strconv.Itoa(offset)))
+}
+
+// decodeOffsetCursor returns the offset in a cursor made by encodeOffsetCursor.
+func decodeOffsetCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), offsetCursorPrefix) {
+		return 0, fmt.Errorf("cursor %q is invalid", cursor)
+	}
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(b), offsetCursorPrefix))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("cursor %q is invalid", cursor)
+	}
+	return offset, nil
+}
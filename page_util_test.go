@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPagination(t *testing.T) {
+	origin := coord{Lat: 47.6, Long: -122.3}
+	var stores []*Store
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		stores = append(stores, &Store{StoreID: id, Lat: 47.6 + float64(i)*0.01, Long: -122.3})
+	}
+
+	var got []string
+	for offset := 0; ; offset += 2 {
+		page, pg := buildMapStores(stores, nil, origin, 10, PageReq{Limit: 2, Offset: offset})
+		if pg.Limit != 2 || pg.Offset != offset || pg.Total == nil || *pg.Total != len(stores) {
+			t.Fatalf("got pagination %+v at offset %d, want limit 2 and total %d", pg, offset, len(stores))
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, st := range page {
+			got = append(got, st.StoreID)
+		}
+	}
+	if want := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("paged through stores %v, want %v", got, want)
+	}
+}
+
+func TestPageBounds(t *testing.T) {
+	for _, tc := range []struct {
+		p                  PageReq
+		wantStart, wantEnd int
+	}{
+		{PageReq{Limit: 2, Offset: 0}, 0, 2},
+		{PageReq{Limit: 2, Offset: 4}, 4, 5},
+		{PageReq{Limit: 2, Offset: 7}, 5, 5},
+		{PageReq{Limit: 10, Offset: math.MaxInt64}, 5, 5},
+	} {
+		start, end, _ := pageBounds(5, tc.p)
+		if start != tc.wantStart || end != tc.wantEnd {
+			t.Errorf("pageBounds(5, %+v) = %d, %d; want %d, %d", tc.p, start, end, tc.wantStart, tc.wantEnd)
+		}
+	}
+
+	// A hand-built cursor can carry the same offset.
+	if page := storesPage(make(QueryStoresResp, 5), 10, math.MaxInt64); len(page.Stores) != 0 || page.NextCursor != "" {
+		t.Errorf("got page %+v past the end, want it empty with no next cursor", page)
+	}
+}
+
+func TestEncodePage(t *testing.T) {
+	results := []string{"c", "d"}
+	total := 5
+	pg := &Pagination{Limit: 2, Offset: 2, Total: &total}
+
+	w := httptest.NewRecorder()
+	if err := EncodePage(w, PageReq{Limit: 2, Offset: 2}, pg, results); err != nil {
+		t.Fatalf("EncodePage() failed: %v", err)
+	}
+	var bare []string
+	if err := json.Unmarshal(w.Body.Bytes(), &bare); err != nil || len(bare) != 2 {
+		t.Errorf("got %s for version 0, want the bare results", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	if err := EncodePage(w, PageReq{Limit: 2, Offset: 2, Version: paginatedVersion}, pg, results); err != nil {
+		t.Fatalf("EncodePage() failed: %v", err)
+	}
+	var wrapped struct {
+		Limit   int      `json:"limit"`
+		Offset  int      `json:"offset"`
+		Total   int      `json:"total"`
+		Results []string `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &wrapped); err != nil {
+		t.Fatalf("failed to decode %s: %v", w.Body, err)
+	}
+	if wrapped.Limit != 2 || wrapped.Offset != 2 || wrapped.Total != 5 || len(wrapped.Results) != 2 {
+		t.Errorf("got %s for version %d, want the results wrapped with their pagination", w.Body, paginatedVersion)
+	}
+}
+
+func TestOffsetCursor(t *testing.T) {
+	for _, offset := range []int{0, 7, 1000} {
+		got, err := decodeOffsetCursor(encodeOffsetCursor(offset))
+		if err != nil || got != offset {
+			t.Errorf("decodeOffsetCursor(encodeOffsetCursor(%d)) = %d, %v", offset, got, err)
+		}
+	}
+	for _, cursor := range []string{"not base64!", "b2Zmc2V0Oi0x" /* offset:-1 */, "Zm9vOjE" /* foo:1 */} {
+		if _, err := decodeOffsetCursor(cursor); err == nil {
+			t.Errorf("decodeOffsetCursor(%q) succeeded, want error", cursor)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// saveReportPhoto stores a photo taken at the store and returns its URL.
+func saveReportPhoto(ctx context.Context, bs BlobStore, storeID string, data []byte) (string, error) {
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate photo id: %v", err)
+	}
+	return putBlob(ctx, bs, photoBlobs, fmt.Sprintf("reports/%s/%s", storeID, uid.String()), data)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestReportPhoto(t *testing.T) {
+	bs := newMemBlobStore()
+	url, err := saveReportPhoto(context.Background(), bs, "store", pngHeader)
+	if err != nil {
+		t.Fatalf("saveReportPhoto failed: %v", err)
+	}
+	if !strings.HasPrefix(url, "memory:///reports/store/") || !strings.HasSuffix(url, ".png") {
+		t.Errorf("got photo url %q, want a png under reports/store/", url)
+	}
+	if _, err := saveReportPhoto(context.Background(), bs, "store", []byte("not an image")); err == nil {
+		t.Error("saveReportPhoto(text) succeeded, want error")
+	}
+
+	store := &Store{StoreID: "store"}
+	item := &Item{Name: "eggs"}
+	addStockReport(item, store, &User{UserID: "alice"}, &itemStock{InStock: true, PhotoURL: url}, 100)
+	// A later report without a photo keeps the earlier photo.
+	addStockReport(item, store, &User{UserID: "bob"}, &itemStock{InStock: true}, 200)
+	addStockReport(item, store, &User{UserID: "bob"}, &itemStock{InStock: false}, 300)
+
+	infos := parseItem(item)
+	if len(infos) != 2 {
+		t.Fatalf("got %d item infos, want 2", len(infos))
+	}
+	if infos[0].PhotoURL != url {
+		t.Errorf("got photo url %q for the report with a photo, want %q", infos[0].PhotoURL, url)
+	}
+	if infos[1].PhotoURL != "" {
+		t.Errorf("got photo url %q for the report without a photo, want none", infos[1].PhotoURL)
+	}
+}
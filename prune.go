@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// pruneReportAgeDays is the age in days past which PruneReports deletes stock reports by
+// default, set with the PRUNE_REPORT_AGE_DAYS env variable. It is longer than
+// maxReportAgeDays so that the item time series keep some history.
+var pruneReportAgeDays = positiveIntFromEnv("PRUNE_REPORT_AGE_DAYS", 30)
+
+// ******************************************
+// ** BEGIN PruneReports
+// ******************************************
+
+type PruneReportsReq struct {
+	// OlderThanDays overrides pruneReportAgeDays.
+	OlderThanDays int `json:"older_than_days"`
+	// Cursor resumes a prune where the previous request left off.
+	Cursor string `json:"cursor"`
+}
+
+type PruneReportsResp struct {
+	Progress *BatchProgress `json:"progress"`
+	// ItemsUpdated is the number of items that had reports removed and kept others.
+	ItemsUpdated int `json:"items_updated"`
+	// ItemsDeleted is the number of items that had every report removed.
+	ItemsDeleted   int `json:"items_deleted"`
+	ReportsRemoved int `json:"reports_removed"`
+	// NextCursor resumes the prune if it yielded before every item was checked.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// PruneReports deletes the stock reports older than a number of days from storage with
+// PruneStaleReports. QueryItems already leaves them out, but they'd otherwise stay on their
+// items forever. It is an admin endpoint.
+func PruneReports(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req PruneReportsReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if req.OlderThanDays < 0 {
+		return http.StatusBadRequest, fmt.Errorf("older than days must be positive: %d", req.OlderThanDays)
+	}
+	if req.OlderThanDays == 0 {
+		req.OlderThanDays = pruneReportAgeDays
+	}
+	var cursor datastore.Cursor
+	if req.Cursor != "" {
+		var err error
+		if cursor, err = datastore.DecodeCursor(req.Cursor); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("cursor is invalid: %v", err)
+		}
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	olderThan := time.Duration(req.OlderThanDays) * secondsToDay * time.Second
+	resp, err := PruneStaleReports(ctx, client, olderThan, cursor)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END PruneReports
+// ******************************************
+
+// PruneStaleReports removes the stock reports older than olderThan from every item, starting
+// at the cursor, in batches limited by jobLimits. Each item is updated in its own transaction,
+// so the prune doesn't race uploads, and items left without reports are deleted.
+func PruneStaleReports(ctx context.Context, client *datastore.Client, olderThan time.Duration, cursor datastore.Cursor) (*PruneReportsResp, error) {
+	cutoff := time.Now().Add(-olderThan).Unix()
+	resp := &PruneReportsResp{}
+	var err error
+	resp.Progress, err = runBatches(ctx, "prune stale reports", jobLimits, func(ctx context.Context, size int) (int, bool, error) {
+		names, next, err := loadItemNameBatch(ctx, client, cursor, size)
+		if err != nil {
+			return 0, false, err
+		}
+		cursor = next
+		for _, name := range names {
+			removed, deleted, err := pruneItemReportsInStorage(ctx, client, name, cutoff)
+			if err != nil {
+				return 0, false, err
+			}
+			resp.ReportsRemoved += removed
+			switch {
+			case deleted:
+				resp.ItemsDeleted++
+			case removed > 0:
+				resp.ItemsUpdated++
+			}
+		}
+		return len(names), len(names) < size, nil
+	})
+	if err != nil {
+		return resp, err
+	}
+	if !resp.Progress.Done {
+		resp.NextCursor = cursor.String()
+	}
+	return resp, nil
+}
+
+// pruneItemReports removes the item's stock reports from before cutoff and returns how many
+// it removed.
+func pruneItemReports(item *Item, cutoff int64) int {
+	kept := item.StockReports[:0]
+	for _, sr := range item.StockReports {
+		if sr.TimestampSec >= cutoff {
+			kept = append(kept, sr)
+		}
+	}
+	removed := len(item.StockReports) - len(kept)
+	item.StockReports = kept
+	return removed
+}
+
+// pruneItemReportsInStorage prunes the named item's reports in a transaction and returns how
+// many it removed and whether the item was deleted for having none left.
+func pruneItemReportsInStorage(ctx context.Context, client *datastore.Client, name string, cutoff int64) (int, bool, error) {
+	removed, deleted := 0, false
+	key := nameKey(ctx, ItemKind, name)
+	if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		removed, deleted = 0, false
+		var item Item
+		if err := tx.Get(key, &item); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil // deleted since the batch was loaded
+			}
+			return fmt.Errorf("failed to fetch item %q from storage: %v", name, err)
+		}
+		if removed = pruneItemReports(&item, cutoff); removed == 0 {
+			return nil
+		}
+		if len(item.StockReports) == 0 {
+			deleted = true
+			if err := tx.Delete(key); err != nil {
+				return fmt.Errorf("failed to delete item %q in storage: %v", name, err)
+			}
+			return nil
+		}
+		if _, err := tx.Put(key, &item); err != nil {
+			return fmt.Errorf("failed to update item %q in storage: %v", name, err)
+		}
+		return nil
+	}); err != nil {
+		return 0, false, err
+	}
+	return removed, deleted, nil
+}
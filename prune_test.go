@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPruneItemReports(t *testing.T) {
+	item := &Item{Name: "flour", StockReports: []*StockReport{
+		{StoreInfo: &Store{StoreID: "s1"}, TimestampSec: 50},
+		{StoreInfo: &Store{StoreID: "s2"}, TimestampSec: 150},
+		{StoreInfo: &Store{StoreID: "s3"}, TimestampSec: 99},
+		{StoreInfo: &Store{StoreID: "s4"}, TimestampSec: 100},
+	}}
+	if removed := pruneItemReports(item, 100); removed != 2 {
+		t.Errorf("pruneItemReports() = %d, want 2", removed)
+	}
+	var kept []string
+	for _, sr := range item.StockReports {
+		kept = append(kept, sr.StoreInfo.StoreID)
+	}
+	if len(kept) != 2 || kept[0] != "s2" || kept[1] != "s4" {
+		t.Errorf("kept reports at %v, want s2 and s4 in order", kept)
+	}
+
+	if removed := pruneItemReports(item, 100); removed != 0 {
+		t.Errorf("pruneItemReports() again = %d, want 0", removed)
+	}
+	if removed := pruneItemReports(item, 1000); removed != 2 || len(item.StockReports) != 0 {
+		t.Errorf("pruneItemReports() past every report = %d leaving %d, want 2 leaving none", removed, len(item.StockReports))
+	}
+}
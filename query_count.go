@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+// Item queries are counted in memory and flushed to storage in the background, so that
+// QueryItems doesn't wait on a write. Counts buffered when the instance stops are lost; they
+// are for demand analytics, not billing.
+
+// queryCountFlushBatch is the most counters written in one storage transaction.
+const queryCountFlushBatch = 25
+
+var (
+	// queryCountFlushInterval is how often buffered item query counts are flushed, set with
+	// the QUERY_COUNT_FLUSH_INTERVAL_MS env variable.
+	queryCountFlushInterval = time.Duration(positiveIntFromEnv("QUERY_COUNT_FLUSH_INTERVAL_MS", 60000)) * time.Millisecond
+	// queryCountMaxPending is how many counters may be buffered before they are flushed early,
+	// set with the QUERY_COUNT_MAX_PENDING env variable.
+	queryCountMaxPending = positiveIntFromEnv("QUERY_COUNT_MAX_PENDING", 500)
+)
+
+// itemQueries counts the server's item queries.
+var itemQueries = &queryCounter{maxPending: queryCountMaxPending, write: writeItemQueryCounts}
+
+// ItemQueryCount is the number of times users in a zip code queried an item. Its key is
+// itemQueryCountKey(zip code, item name).
+type ItemQueryCount struct {
+	ItemName string `datastore:"itemName" json:"item_name"`
+	ZipCode  string `datastore:"zipCode" json:"zip_code"`
+	Count    int64  `datastore:"count" json:"count"`
+}
+
+func itemQueryCountKey(zipCode, itemName string) string {
+	// Item names never hold a colon; it separates fields in the item data files.
+	return zipCode + ":" + itemName
+}
+
+// queryCountKey identifies a counter across namespaces.
+type queryCountKey struct {
+	namespace string
+	zipCode   string
+	itemName  string
+}
+
+// queryCounter buffers item query counts until they are flushed with write. Once maxPending
+// counters are buffered, they are flushed right away in the background.
+type queryCounter struct {
+	maxPending int
+	// write returns the keys of the counts it failed to write.
+	write func(counts map[queryCountKey]int64) ([]queryCountKey, error)
+
+	mu       sync.Mutex
+	pending  map[queryCountKey]int64
+	flushing bool
+}
+
+// add counts a query for the catalog item by a user in the zip code. Names that aren't
+// catalog items aren't counted, so that arbitrary queries don't create counters.
+func (c *queryCounter) add(ctx context.Context, itemName, zipCode string) {
+	if _, ok := itemIndex[itemName]; !ok || zipCode == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending == nil {
+		c.pending = make(map[queryCountKey]int64)
+	}
+	c.pending[queryCountKey{namespaceFromContext(ctx), zipCode, itemName}]++
+	if len(c.pending) >= c.maxPending && !c.flushing {
+		c.flushing = true
+		go func() {
+			c.flush()
+			c.mu.Lock()
+			c.flushing = false
+			c.mu.Unlock()
+		}()
+	}
+}
+
+// flush writes the buffered counts. Counts that fail to be written are put back to be
+// retried with the next flush. Those that were written aren't, so they're counted once.
+func (c *queryCounter) flush() {
+	c.mu.Lock()
+	counts := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	if failed, err := c.write(counts); err != nil {
+		log.Printf("failed to flush %d of %d item query counts, retrying with the next flush: %v", len(failed), len(counts), err)
+		c.mu.Lock()
+		if c.pending == nil {
+			c.pending = make(map[queryCountKey]int64)
+		}
+		for _, k := range failed {
+			c.pending[k] += counts[k]
+		}
+		c.mu.Unlock()
+	}
+}
+
+// run flushes the buffered counts every interval until stop is closed, then flushes once
+// more.
+func (c *queryCounter) run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-stop:
+			c.flush()
+			return
+		}
+	}
+}
+
+// writeItemQueryCounts adds the counts to the counters in storage, queryCountFlushBatch
+// counters per transaction. Since each transaction commits on its own, it returns the keys
+// of the counts that weren't written along with the first error.
+func writeItemQueryCounts(counts map[queryCountKey]int64) ([]queryCountKey, error) {
+	byNamespace := make(map[string][]queryCountKey)
+	for k := range counts {
+		byNamespace[k.namespace] = append(byNamespace[k.namespace], k)
+	}
+	var failed []queryCountKey
+	var firstErr error
+	for ns, keys := range byNamespace {
+		ctx := withNamespace(context.Background(), ns)
+		client, err := StorageClient(ctx)
+		if err != nil {
+			failed = append(failed, keys...)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		unwritten, err := writeItemQueryCountsInStorage(ctx, client, keys, counts)
+		client.Close()
+		if err != nil {
+			failed = append(failed, unwritten...)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// writeItemQueryCountsInStorage writes the counts of keys in batches, stopping at the first
+// batch that fails. It returns the keys of that batch and the ones after it.
+func writeItemQueryCountsInStorage(ctx context.Context, client *datastore.Client, keys []queryCountKey, counts map[queryCountKey]int64) ([]queryCountKey, error) {
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > queryCountFlushBatch {
+			batch = batch[:queryCountFlushBatch]
+		}
+
+		dsKeys := make([]*datastore.Key, len(batch))
+		for i, k := range batch {
+			dsKeys[i] = nameKey(ctx, ItemQueryCountKind, itemQueryCountKey(k.zipCode, k.itemName))
+		}
+		if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			entities := make([]ItemQueryCount, len(batch))
+			err := tx.GetMulti(dsKeys, entities)
+			merr, isMultiErr := err.(datastore.MultiError)
+			if err != nil && !isMultiErr {
+				return err
+			}
+			for i, k := range batch {
+				if isMultiErr && merr[i] != nil && merr[i] != datastore.ErrNoSuchEntity {
+					return merr[i]
+				}
+				entities[i].ItemName, entities[i].ZipCode = k.itemName, k.zipCode
+				entities[i].Count += counts[k]
+			}
+			_, err = tx.PutMulti(dsKeys, entities)
+			return err
+		}); err != nil {
+			return keys, fmt.Errorf("failed to update item query counts in storage: %v", err)
+		}
+		keys = keys[len(batch):]
+	}
+	return nil, nil
+}
+
+func loadAllItemQueryCounts(ctx context.Context, client *datastore.Client) ([]*ItemQueryCount, error) {
+	ctx, span := startClientSpan(ctx, "datastore.query ItemQueryCount")
+	defer span.Finish()
+	var counts []*ItemQueryCount
+	it := client.Run(ctx, newQuery(ctx, ItemQueryCountKind))
+	for {
+		var c ItemQueryCount
+		_, err := it.Next(&c)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			span.SetError(err)
+			return nil, fmt.Errorf("failed to query for all item query counts: %v", err)
+		}
+		counts = append(counts, &c)
+	}
+	return counts, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordedWrites is a queryCounter write func that records what it's asked to write. When
+// err is set, it fails to write the counts of the failed keys, or of every key if none are.
+type recordedWrites struct {
+	mu     sync.Mutex
+	writes []map[queryCountKey]int64
+	err    error
+	failed []queryCountKey
+	done   chan struct{}
+}
+
+func (rw *recordedWrites) write(counts map[queryCountKey]int64) ([]queryCountKey, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.writes = append(rw.writes, counts)
+	if rw.done != nil {
+		rw.done <- struct{}{}
+	}
+	if rw.err == nil {
+		return nil, nil
+	}
+	if rw.failed != nil {
+		return rw.failed, rw.err
+	}
+	var failed []queryCountKey
+	for k := range counts {
+		failed = append(failed, k)
+	}
+	return failed, rw.err
+}
+
+func TestQueryCounterAdd(t *testing.T) {
+	rw := &recordedWrites{}
+	c := &queryCounter{maxPending: 100, write: rw.write}
+	ctx := context.Background()
+	staging := withNamespace(ctx, "staging")
+
+	c.add(ctx, "milk", "98101")
+	c.add(ctx, "milk", "98101")
+	c.add(ctx, "milk", "98109")
+	c.add(staging, "milk", "98101")
+	c.add(ctx, "unicorn steak", "98101") // not in the catalog
+	c.add(ctx, "bread", "")              // no zip code
+	c.flush()
+
+	ns := namespaceFromContext(ctx)
+	want := []map[queryCountKey]int64{{
+		{ns, "98101", "milk"}:        2,
+		{ns, "98109", "milk"}:        1,
+		{"staging", "98101", "milk"}: 1,
+	}}
+	if !reflect.DeepEqual(rw.writes, want) {
+		t.Errorf("got writes %v, want %v", rw.writes, want)
+	}
+
+	// Nothing is left to write.
+	c.flush()
+	if len(rw.writes) != 1 {
+		t.Errorf("got %d writes after flushing an empty buffer, want 1", len(rw.writes))
+	}
+}
+
+func TestQueryCounterFlushRetriesFailedCounts(t *testing.T) {
+	rw := &recordedWrites{err: fmt.Errorf("storage is down")}
+	c := &queryCounter{maxPending: 100, write: rw.write}
+	ctx := context.Background()
+	key := queryCountKey{namespaceFromContext(ctx), "98101", "milk"}
+
+	c.add(ctx, "milk", "98101")
+	c.flush()
+	rw.err = nil
+	c.add(ctx, "milk", "98101")
+	c.flush()
+
+	if len(rw.writes) != 2 || rw.writes[1][key] != 2 {
+		t.Errorf("got writes %v, want the failed count retried with the new one", rw.writes)
+	}
+}
+
+func TestQueryCounterFlushRetriesOnlyUnwrittenCounts(t *testing.T) {
+	ctx := context.Background()
+	milk := queryCountKey{namespaceFromContext(ctx), "98101", "milk"}
+	bread := queryCountKey{namespaceFromContext(ctx), "98101", "bread"}
+	rw := &recordedWrites{err: fmt.Errorf("storage is down"), failed: []queryCountKey{bread}}
+	c := &queryCounter{maxPending: 100, write: rw.write}
+
+	c.add(ctx, "milk", "98101")
+	c.add(ctx, "bread", "98101")
+	c.flush()
+	rw.err, rw.failed = nil, nil
+	c.flush()
+
+	want := map[queryCountKey]int64{bread: 1}
+	if len(rw.writes) != 2 || !reflect.DeepEqual(rw.writes[1], want) {
+		t.Errorf("got writes %v, want only the unwritten bread count retried", rw.writes)
+	}
+	if rw.writes[0][milk] != 1 {
+		t.Errorf("got first write %v, want milk written", rw.writes[0])
+	}
+}
+
+func TestQueryCounterFlushesWhenFull(t *testing.T) {
+	rw := &recordedWrites{done: make(chan struct{}, 1)}
+	c := &queryCounter{maxPending: 2, write: rw.write}
+	ctx := context.Background()
+
+	c.add(ctx, "milk", "98101")
+	c.add(ctx, "milk", "98101") // still one counter
+	select {
+	case <-rw.done:
+		t.Fatalf("flushed with one counter buffered, want a flush at two")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	c.add(ctx, "bread", "98101")
+	select {
+	case <-rw.done:
+	case <-time.After(time.Second):
+		t.Fatalf("not flushed with two counters buffered")
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.writes) != 1 || len(rw.writes[0]) != 2 {
+		t.Errorf("got writes %v, want both counters written at once", rw.writes)
+	}
+}
+
+func TestQueryCounterRun(t *testing.T) {
+	rw := &recordedWrites{done: make(chan struct{}, 2)}
+	c := &queryCounter{maxPending: 100, write: rw.write}
+	ctx := context.Background()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		c.run(10*time.Millisecond, stop)
+		close(stopped)
+	}()
+
+	c.add(ctx, "milk", "98101")
+	select {
+	case <-rw.done:
+	case <-time.After(time.Second):
+		t.Fatalf("buffered counts not flushed on the interval")
+	}
+
+	// Stopping flushes what's left.
+	c.add(ctx, "bread", "98101")
+	close(stop)
+	<-stopped
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	total := 0
+	for _, w := range rw.writes {
+		total += len(w)
+	}
+	if total != 2 {
+		t.Errorf("got writes %v, want both counters written by the time run returns", rw.writes)
+	}
+}
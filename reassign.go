@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/datastore"
+)
+
+// ******************************************
+// ** BEGIN ReassignReport
+// ******************************************
+
+type ReassignReportReq struct {
+	ItemName    string `json:"item_name"`
+	UserID      string `json:"user_id"`
+	FromStoreID string `json:"from_store_id"`
+	ToStoreID   string `json:"to_store_id"`
+}
+
+type ReassignReportResp struct {
+	// Moved is the number of the user's stock reports that moved to the target store.
+	Moved int `json:"moved_reports"`
+}
+
+// ReassignReport moves a user's reports on an item from one store to another, for reports
+// that were attached to the wrong store, such as a duplicate or mis-vetted one. Moved reports
+// merge into matching reports at the target store. It is an admin endpoint.
+func ReassignReport(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ReassignReportReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidateReassignReportReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	to, ok, err := GetStoreInStorage(ctx, req.ToStoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.ToStoreID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	aliases, err := getItemAliases(ctx, client, []string{req.ItemName})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if canonical, ok := aliases[req.ItemName]; ok {
+		req.ItemName = canonical
+	}
+
+	resp := &ReassignReportResp{}
+	key := nameKey(ctx, ItemKind, req.ItemName)
+	if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		resp.Moved = 0 // the transaction may be retried
+		var item Item
+		if err := tx.Get(key, &item); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch item %q from storage: %v", req.ItemName, err)
+		}
+		dedupUsersInfo(&item)
+		if resp.Moved = reassignUserReports(&item, req.UserID, req.FromStoreID, to); resp.Moved == 0 {
+			return nil
+		}
+		if _, err := tx.Put(key, &item); err != nil {
+			return fmt.Errorf("failed to update item %q in storage: %v", req.ItemName, err)
+		}
+		return nil
+	}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if resp.Moved == 0 {
+		return http.StatusBadRequest, fmt.Errorf("user %q has no report on item %q at store %q", req.UserID, req.ItemName, req.FromStoreID)
+	}
+
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateReassignReportReq(req *ReassignReportReq) error {
+	req.ItemName = strings.ToLower(strings.TrimSpace(req.ItemName))
+	if req.ItemName == "" {
+		return fmt.Errorf("missing item name")
+	}
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.FromStoreID == "" || req.ToStoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	if req.FromStoreID == req.ToStoreID {
+		return fmt.Errorf("from and to store ids are the same")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END ReassignReport
+// ******************************************
+
+// reassignUserReports moves the user's stock reports on the item at the fromID store to the
+// to store and returns how many moved. A report only the user saw moves whole. Otherwise the
+// user is split off into a report of their own, and the photo stays with the other users'
+// report since it isn't known who took it. Moved reports are merged with mergeStockReports.
+func reassignUserReports(item *Item, userID, fromID string, to *Store) int {
+	var kept, moved []*StockReport
+	for _, sr := range item.StockReports {
+		if sr.StoreInfo == nil || sr.StoreInfo.StoreID != fromID {
+			kept = append(kept, sr)
+			continue
+		}
+		var user *User
+		others := make([]*User, 0, len(sr.UsersInfo))
+		for _, u := range sr.UsersInfo {
+			if u.UserID == userID {
+				user = u
+			} else {
+				others = append(others, u)
+			}
+		}
+		if user == nil {
+			kept = append(kept, sr)
+			continue
+		}
+		if len(others) == 0 {
+			sr.StoreInfo = storeSnapshot(to)
+			moved = append(moved, sr)
+			continue
+		}
+
+		sr.UsersInfo = others
+		sr.SeenCnt = len(others)
+		sr.TimestampSec = 0
+		for _, u := range others {
+			if u.TimestampSec > sr.TimestampSec {
+				sr.TimestampSec = u.TimestampSec
+			}
+		}
+		kept = append(kept, sr)
+		moved = append(moved, &StockReport{
+			UsersInfo:    []*User{user},
+			StoreInfo:    storeSnapshot(to),
+			TimestampSec: user.TimestampSec,
+			InStock:      sr.InStock,
+			Unknown:      sr.Unknown,
+			Level:        sr.Level,
+			SeenCnt:      1,
+		})
+	}
+	item.StockReports = mergeStockReports(kept, moved)
+	return len(moved)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestReassignUserReports(t *testing.T) {
+	from := &Store{StoreID: "dup", Name: "Safeway (duplicate)"}
+	to := &Store{StoreID: "s1", Name: "Safeway"}
+	item := &Item{
+		Name: "flour",
+		StockReports: []*StockReport{
+			{
+				StoreInfo:    from,
+				InStock:      true,
+				UsersInfo:    []*User{{UserID: "alice", TimestampSec: 100}, {UserID: "bob", TimestampSec: 200}},
+				SeenCnt:      2,
+				TimestampSec: 200,
+				PhotoURL:     "memory:///shelf.png",
+			},
+			{
+				StoreInfo:    from,
+				UsersInfo:    []*User{{UserID: "alice", TimestampSec: 300}},
+				SeenCnt:      1,
+				TimestampSec: 300,
+			},
+			{
+				StoreInfo:    to,
+				InStock:      true,
+				UsersInfo:    []*User{{UserID: "carol", TimestampSec: 150}},
+				SeenCnt:      1,
+				TimestampSec: 150,
+			},
+		},
+	}
+
+	if got := reassignUserReports(item, "alice", from.StoreID, to); got != 2 {
+		t.Fatalf("reassignUserReports() = %d, want 2", got)
+	}
+	if len(item.StockReports) != 3 {
+		t.Fatalf("got %d stock reports, want 3: %+v", len(item.StockReports), item.StockReports)
+	}
+
+	// Bob stays at the old store without alice, along with the photo.
+	if sr := item.StockReports[0]; sr.StoreInfo.StoreID != from.StoreID || sr.SeenCnt != 1 || sr.UsersInfo[0].UserID != "bob" || sr.TimestampSec != 200 || sr.PhotoURL == "" {
+		t.Errorf("got old store report %+v, want bob's alone with the photo", sr)
+	}
+	// Alice's in-stock report merges into carol's at the target store.
+	if sr := item.StockReports[1]; sr.StoreInfo.StoreID != to.StoreID || !sr.InStock || sr.SeenCnt != 2 || sr.TimestampSec != 150 {
+		t.Errorf("got merged report %+v, want carol and alice in stock at %q", sr, to.StoreID)
+	}
+	// Alice's out-of-stock report had no other users and moves whole.
+	if sr := item.StockReports[2]; sr.StoreInfo.StoreID != to.StoreID || sr.InStock || sr.SeenCnt != 1 || sr.TimestampSec != 300 {
+		t.Errorf("got moved report %+v, want alice out of stock at %q", sr, to.StoreID)
+	}
+
+	if got := reassignUserReports(item, "alice", from.StoreID, to); got != 0 {
+		t.Errorf("reassignUserReports() again = %d, want 0", got)
+	}
+}
+
+func TestCleanAndValidateReassignReportReq(t *testing.T) {
+	req := &ReassignReportReq{ItemName: " Flour ", UserID: "u", FromStoreID: "a", ToStoreID: "b"}
+	if err := cleanAndValidateReassignReportReq(req); err != nil {
+		t.Fatalf("cleanAndValidateReassignReportReq() = %v, want nil", err)
+	}
+	if req.ItemName != "flour" {
+		t.Errorf("got item name %q, want %q", req.ItemName, "flour")
+	}
+	req = &ReassignReportReq{ItemName: "flour", UserID: "u", FromStoreID: "a", ToStoreID: "a"}
+	if err := cleanAndValidateReassignReportReq(req); err == nil {
+		t.Error("cleanAndValidateReassignReportReq() with the same stores = nil, want error")
+	}
+}
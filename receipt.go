@@ -2,11 +2,182 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strings"
+
+	vision "google.golang.org/api/vision/v1"
+)
+
+const (
+	receiptUserIDField = "user_id"
+	receiptImageField  = "image"
+	// receiptFormOverhead is how much larger than the image a receipt upload may be, to
+	// leave room for the rest of the multipart form.
+	receiptFormOverhead = 1 << 20
+	// minReceiptMatchConfidence is the lowest confidence with which a receipt line is
+	// matched to an item.
+	minReceiptMatchConfidence = 0.5
 )
 
-// ParseReceipt parses a receipt for the list of items.
+// ocrClient recognizes the text in an image.
+type ocrClient interface {
+	DetectText(ctx context.Context, image []byte) (string, error)
+}
+
+// receiptOCR recognizes the text on receipts.
+var receiptOCR ocrClient = &visionOCR{}
+
+// ******************************************
+// ** BEGIN ParseReceipt
+// ******************************************
+
+type ParseReceiptResp struct {
+	// RawLines are the lines of text recognized on the receipt, top to bottom.
+	RawLines []string `json:"raw_lines"`
+	// MatchedItems are the lines that matched catalog items, meant to pre-fill an in-stock
+	// report.
+	MatchedItems []ReceiptMatch `json:"matched_items"`
+	// Unmatched are the lines that didn't match any item.
+	Unmatched []string `json:"unmatched"`
+}
+
+// ReceiptMatch is a receipt line along with the catalog item it best matches. Confidence
+// ranges from minReceiptMatchConfidence to 1.
+type ReceiptMatch struct {
+	Line       string  `json:"line"`
+	ItemName   string  `json:"item_name"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ParseReceipt recognizes the text on a photo of a receipt. The request is a multipart form
+// with the user_id and a JPEG or PNG image no larger than receiptBlobs allows.
 func ParseReceipt(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
-	return http.StatusNotImplemented, fmt.Errorf("not supported yet")
+	userID, image, err := readReceiptUpload(w, r)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, userID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", userID)
+	}
+
+	text, err := receiptOCR.DetectText(ctx, image)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := &ParseReceiptResp{RawLines: receiptLines(text)}
+	resp.MatchedItems, resp.Unmatched = matchReceiptLines(resp.RawLines)
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// readReceiptUpload reads the user ID and the receipt image from the multipart form and
+// checks the image against receiptBlobs.
+func readReceiptUpload(w http.ResponseWriter, r *http.Request) (string, []byte, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", nil, fmt.Errorf("request must be a multipart form")
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, int64(receiptBlobs.MaxBytes+receiptFormOverhead))
+	if err := r.ParseMultipartForm(int64(receiptBlobs.MaxBytes)); err != nil {
+		return "", nil, fmt.Errorf("failed to read multipart form: %v", err)
+	}
+
+	userID := strings.TrimSpace(r.FormValue(receiptUserIDField))
+	if userID == "" {
+		return "", nil, fmt.Errorf("missing user id")
+	}
+
+	f, hdr, err := r.FormFile(receiptImageField)
+	if err != nil {
+		return "", nil, fmt.Errorf("missing receipt image: %v", err)
+	}
+	defer f.Close()
+	if ct := hdr.Header.Get("Content-Type"); ct != "" {
+		if _, ok := receiptBlobs.Exts[ct]; !ok {
+			return "", nil, fmt.Errorf("receipt image content type %q is not supported", ct)
+		}
+	}
+	image, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read receipt image: %v", err)
+	}
+	if _, err := receiptBlobs.validate(image); err != nil {
+		return "", nil, fmt.Errorf("receipt image is invalid: %v", err)
+	}
+	return userID, image, nil
+}
+
+// receiptLines splits the recognized text into its non-blank lines.
+func receiptLines(text string) []string {
+	lines := make([]string, 0)
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// matchReceiptLines matches each receipt line to the catalog item whose tokens it best
+// matches, and returns the lines that matched no item well enough separately.
+func matchReceiptLines(lines []string) ([]ReceiptMatch, []string) {
+	matches := make([]ReceiptMatch, 0)
+	unmatched := make([]string, 0)
+	for _, line := range lines {
+		name, score := bestItemMatch(matchWords(line))
+		if score < minReceiptMatchConfidence {
+			unmatched = append(unmatched, line)
+			continue
+		}
+		matches = append(matches, ReceiptMatch{Line: line, ItemName: name, Confidence: score})
+	}
+	return matches, unmatched
+}
+
+// ******************************************
+// ** END ParseReceipt
+// ******************************************
+
+// visionOCR recognizes text with the Cloud Vision API.
+type visionOCR struct{}
+
+func (v *visionOCR) DetectText(ctx context.Context, image []byte) (string, error) {
+	svc, err := vision.NewService(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to create cloud vision client: %v", err)
+	}
+	req := &vision.BatchAnnotateImagesRequest{
+		Requests: []*vision.AnnotateImageRequest{{
+			Image: &vision.Image{Content: base64.StdEncoding.EncodeToString(image)},
+			// Document text detection is tuned for dense text such as receipts.
+			Features: []*vision.Feature{{Type: "DOCUMENT_TEXT_DETECTION"}},
+		}},
+	}
+	resp, err := svc.Images.Annotate(req).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to detect text with cloud vision: %v", err)
+	}
+	if len(resp.Responses) == 0 {
+		return "", nil
+	}
+	res := resp.Responses[0]
+	if res.Error != nil {
+		return "", fmt.Errorf("failed to detect text with cloud vision: %s", res.Error.Message)
+	}
+	if res.FullTextAnnotation == nil {
+		return "", nil // no text on the image
+	}
+	return res.FullTextAnnotation.Text, nil
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+func newReceiptRequest(t *testing.T, userID, contentType string, image []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if userID != "" {
+		if err := mw.WriteField(receiptUserIDField, userID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if image != nil {
+		hdr := make(textproto.MIMEHeader)
+		hdr.Set("Content-Disposition", `form-data; name="image"; filename="receipt"`)
+		hdr.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(image)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("POST", "/receipt/parse", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestReadReceiptUpload(t *testing.T) {
+	origMax := receiptBlobs.MaxBytes
+	defer func() { receiptBlobs.MaxBytes = origMax }()
+	receiptBlobs.MaxBytes = 64
+
+	r := newReceiptRequest(t, "u1", "image/png", pngHeader)
+	userID, image, err := readReceiptUpload(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatalf("readReceiptUpload() = %v, want nil", err)
+	}
+	if userID != "u1" || !bytes.Equal(image, pngHeader) {
+		t.Errorf("got user %q and image %q, want u1 and the png", userID, image)
+	}
+
+	for _, tc := range []struct {
+		desc string
+		r    *http.Request
+	}{
+		{"missing user", newReceiptRequest(t, "", "image/png", pngHeader)},
+		{"missing image", newReceiptRequest(t, "u1", "", nil)},
+		{"unsupported content type", newReceiptRequest(t, "u1", "application/pdf", pngHeader)},
+		{"image isn't a png", newReceiptRequest(t, "u1", "image/png", []byte("not an image"))},
+		{"image too large", newReceiptRequest(t, "u1", "image/png", append(pngHeader, make([]byte, 64)...))},
+		{"not multipart", httptest.NewRequest("POST", "/receipt/parse", bytes.NewBufferString(`{"user_id":"u1"}`))},
+	} {
+		if _, _, err := readReceiptUpload(httptest.NewRecorder(), tc.r); err == nil {
+			t.Errorf("%s: readReceiptUpload() = nil, want error", tc.desc)
+		}
+	}
+}
+
+func TestReceiptLines(t *testing.T) {
+	got := receiptLines("SAFEWAY\n\n  FLOUR 2LB  3.99\r\nEGGS 12CT\n")
+	want := []string{"SAFEWAY", "FLOUR 2LB  3.99", "EGGS 12CT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("receiptLines() = %q, want %q", got, want)
+	}
+	if got := receiptLines(""); got == nil || len(got) != 0 {
+		t.Errorf("receiptLines(\"\") = %#v, want an empty slice", got)
+	}
+}
+
+func TestMatchReceiptLines(t *testing.T) {
+	lines := []string{"SAFEWAY #1234", "ACACIA HONEY 12OZ  7.99", "HONEY 3.49", "BANANAS 1.29", "SUBTOTAL 12.77"}
+	matches, unmatched := matchReceiptLines(lines)
+
+	want := map[string]string{
+		"ACACIA HONEY 12OZ  7.99": "acacia honey",
+		"HONEY 3.49":              "honey",
+		"BANANAS 1.29":            "banana",
+	}
+	if len(matches) != len(want) {
+		t.Fatalf("got %d matches, want %d: %+v", len(matches), len(want), matches)
+	}
+	for _, m := range matches {
+		if m.ItemName != want[m.Line] {
+			t.Errorf("line %q matched %q, want %q", m.Line, m.ItemName, want[m.Line])
+		}
+		if m.Confidence < minReceiptMatchConfidence || m.Confidence > 1 {
+			t.Errorf("line %q has confidence %v, want between %v and 1", m.Line, m.Confidence, minReceiptMatchConfidence)
+		}
+	}
+	if wantUnmatched := []string{"SAFEWAY #1234", "SUBTOTAL 12.77"}; !reflect.DeepEqual(unmatched, wantUnmatched) {
+		t.Errorf("got unmatched %q, want %q", unmatched, wantUnmatched)
+	}
+}
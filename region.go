@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxRegionZipCodes caps the number of zip codes in a region query, set with the
+// MAX_REGION_ZIP_CODES env variable.
+var maxRegionZipCodes = positiveIntFromEnv("MAX_REGION_ZIP_CODES", 25)
+
+// ******************************************
+// ** BEGIN QueryRegionStores
+// ******************************************
+
+type QueryRegionStoresReq struct {
+	UserID      string   `json:"user_id"`
+	ZipCodes    []string `json:"zip_codes"`
+	RadiusMiles float64  `json:"radius_miles"`
+}
+
+type QueryRegionStoresResp []*RegionStoreInfo
+
+// RegionStoreInfo is a store in a region along with the region's zip codes whose radius
+// covers it. DistanceMiles is the distance to the nearest of them.
+type RegionStoreInfo struct {
+	*Store
+	ZipCodes      []string `json:"zipCodes"`
+	DistanceMiles float64  `json:"distanceMiles"`
+}
+
+// QueryRegionStores fetches the stores within a radius of any of a region's zip codes, each
+// store once, nearest first.
+func QueryRegionStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryRegionStoresReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryRegionStoresReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	stores, err := loadAllStores(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := regionStores(stores, req.ZipCodes, req.RadiusMiles)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryRegionStoresReq(req *QueryRegionStoresReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	zipCodes := make([]string, 0, len(req.ZipCodes))
+	seen := make(map[string]bool, len(req.ZipCodes))
+	for i, zipCode := range req.ZipCodes {
+		zipCode = strings.TrimSpace(zipCode)
+		if err := validateZipCode(zipCode); err != nil {
+			return fmt.Errorf("zip code at index %d is invalid: %v", i, err)
+		}
+		if _, ok := zipCodeToLatLong[zipCode]; !ok {
+			return fmt.Errorf("zip code %q is not supported", zipCode)
+		}
+		if seen[zipCode] {
+			continue
+		}
+		seen[zipCode] = true
+		zipCodes = append(zipCodes, zipCode)
+	}
+	if len(zipCodes) == 0 {
+		return fmt.Errorf("missing zip codes")
+	}
+	if len(zipCodes) > maxRegionZipCodes {
+		return fmt.Errorf("region has %d zip codes, the max is %d", len(zipCodes), maxRegionZipCodes)
+	}
+	req.ZipCodes = zipCodes
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxMapRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxMapRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultMapRadiusMiles
+	}
+	return nil
+}
+
+// ******************************************
+// ** END QueryRegionStores
+// ******************************************
+
+// regionStores returns the stores within radiusMiles of any of the zip codes, annotated with
+// the zip codes that cover them in the order given. They're sorted nearest first, then by ID.
+func regionStores(stores []*Store, zipCodes []string, radiusMiles float64) QueryRegionStoresResp {
+	resp := make(QueryRegionStoresResp, 0)
+	for _, st := range stores {
+		var info *RegionStoreInfo
+		for _, zipCode := range zipCodes {
+			coords := zipCodeToLatLong[zipCode]
+			d := Distance(st.Lat, st.Long, coords.Lat, coords.Long)
+			if d > radiusMiles {
+				continue
+			}
+			if info == nil {
+				info = &RegionStoreInfo{Store: st, DistanceMiles: d}
+				resp = append(resp, info)
+			} else if d < info.DistanceMiles {
+				info.DistanceMiles = d
+			}
+			info.ZipCodes = append(info.ZipCodes, zipCode)
+		}
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if resp[i].DistanceMiles != resp[j].DistanceMiles {
+			return resp[i].DistanceMiles < resp[j].DistanceMiles
+		}
+		return resp[i].StoreID < resp[j].StoreID
+	})
+	return resp
+}
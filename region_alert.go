@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	regionRestockEvent = "region.restock"
+	// defaultRegionAlertRadiusMiles is the radius of a region subscription that doesn't give
+	// one, for users without a DefaultRadiusMiles.
+	defaultRegionAlertRadiusMiles = 5.0
+)
+
+// RegionSubscription subscribes a user to the restocks within a radius of a zip code. It is
+// keyed by the user ID, so a user has at most one region and subscribing again replaces it.
+type RegionSubscription struct {
+	UserID      string  `datastore:"userID" json:"user_id"`
+	ZipCode     string  `datastore:"zipCode" json:"zip_code"`
+	Lat         float64 `datastore:"lat,noindex" json:"latitude"`
+	Long        float64 `datastore:"long,noindex" json:"longitude"`
+	RadiusMiles float64 `datastore:"radiusMiles,noindex" json:"radius_miles"`
+	// ItemNames limits the alerts to restocks of these catalog items. Empty means any item.
+	ItemNames    []string `datastore:"itemNames,noindex" json:"item_names"`
+	TimestampSec int64    `datastore:"timestampSec" json:"timestamp_sec"`
+}
+
+// RegionAlert is the JSON payload a Notifier delivers to a region subscriber when items it
+// watches are reported in stock within its region. It does not identify the uploader.
+type RegionAlert struct {
+	Type          string   `json:"type"`
+	StoreID       string   `json:"store_id"`
+	StoreName     string   `json:"store_name"`
+	StoreAddr     string   `json:"store_address"`
+	DistanceMiles float64  `json:"distance_miles"`
+	InStock       []string `json:"in_stock_items"`
+	TimestampSec  int64    `json:"timestamp_sec"`
+}
+
+// Notifier delivers the region alerts of an upload, keyed by the user to alert.
+type Notifier interface {
+	Notify(ctx context.Context, alerts map[string]*RegionAlert) error
+}
+
+// notifier delivers the region alerts. Tests swap it for a fake.
+var notifier Notifier = webhookNotifier{}
+
+// ******************************************
+// ** BEGIN SubscribeRegion
+// ******************************************
+
+type SubscribeRegionReq struct {
+	UserID string `json:"user_id"`
+	// ZipCode is the center of the region. It defaults to the user's zip code.
+	ZipCode string `json:"zip_code"`
+	// RadiusMiles defaults to the user's DefaultRadiusMiles, or
+	// defaultRegionAlertRadiusMiles if the user has none.
+	RadiusMiles float64  `json:"radius_miles"`
+	ItemNames   []string `json:"item_names"`
+}
+
+// SubscribeRegion subscribes the user to alerts for the items reported in stock at stores
+// within a radius of a zip code. Alerts go out through notifier as reports are uploaded; see
+// dispatchRegionAlerts.
+func SubscribeRegion(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req SubscribeRegionReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateSubscribeRegionReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	if req.ZipCode == "" {
+		req.ZipCode = u.ZipCode
+	}
+	coords, err := zipCodeCoords(req.ZipCode)
+	if err != nil {
+		return zipCodeErrStatus(err), err
+	}
+
+	sub := &RegionSubscription{
+		UserID:       req.UserID,
+		ZipCode:      req.ZipCode,
+		Lat:          coords.Lat,
+		Long:         coords.Long,
+		RadiusMiles:  regionAlertRadius(req.RadiusMiles, u),
+		ItemNames:    req.ItemNames,
+		TimestampSec: time.Now().Unix(),
+	}
+	if err := createRegionSubscriptionInStorage(ctx, sub); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err := EncodeResp(w, sub); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateSubscribeRegionReq(req *SubscribeRegionReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	if err := validateSearchRadius(req.RadiusMiles); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(req.ItemNames))
+	for i, name := range req.ItemNames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return fmt.Errorf("item name at index %d is empty", i)
+		}
+		canonical, ok := resolveItemName(name)
+		if !ok {
+			return fmt.Errorf("item %q is not in the catalog", name)
+		}
+		names = append(names, canonical)
+	}
+	req.ItemNames = uniqueStrings(names)
+	return nil
+}
+
+// regionAlertRadius returns the radius of a region subscription. Unlike searchRadius, it
+// never means no limit, which would alert on every restock.
+func regionAlertRadius(reqRadius float64, u *User) float64 {
+	if radius := searchRadius(reqRadius, u); radius > 0 {
+		return radius
+	}
+	return defaultRegionAlertRadiusMiles
+}
+
+// ******************************************
+// ** END SubscribeRegion
+// ******************************************
+
+// ******************************************
+// ** BEGIN UnsubscribeRegion
+// ******************************************
+
+type UnsubscribeRegionReq struct {
+	UserID string `json:"user_id"`
+}
+
+// UnsubscribeRegion removes the user's region subscription, if any.
+func UnsubscribeRegion(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req UnsubscribeRegionReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if req.UserID == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing user id")
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	if err := deleteRegionSubscriptionInStorage(ctx, req.UserID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END UnsubscribeRegion
+// ******************************************
+
+// regionAlerts returns the alerts for the items reported in stock at the store, keyed by the
+// subscribers whose region covers the store and who watch any of the items. The uploader
+// isn't alerted to their own report, and hidden items are left out.
+func regionAlerts(subs []*RegionSubscription, st *Store, inStock []string, uploaderID string, now int64) map[string]*RegionAlert {
+	alerts := make(map[string]*RegionAlert)
+	for _, sub := range subs {
+		if sub.UserID == uploaderID {
+			continue
+		}
+		dist := Distance(sub.Lat, sub.Long, st.Lat, st.Long)
+		if dist > sub.RadiusMiles {
+			continue
+		}
+		watched := make(map[string]bool, len(sub.ItemNames))
+		for _, name := range sub.ItemNames {
+			watched[name] = true
+		}
+		var items []string
+		for _, name := range inStock {
+			if hiddenItems.has(name) || (len(watched) > 0 && !watched[name]) {
+				continue
+			}
+			items = append(items, name)
+		}
+		if len(items) == 0 {
+			continue
+		}
+		sort.Strings(items)
+		alerts[sub.UserID] = &RegionAlert{
+			Type:          regionRestockEvent,
+			StoreID:       st.StoreID,
+			StoreName:     st.Name,
+			StoreAddr:     st.Addr,
+			DistanceMiles: dist,
+			InStock:       items,
+			TimestampSec:  now,
+		}
+	}
+	return alerts
+}
+
+// dispatchRegionAlerts notifies the region subscribers of the items reported in stock at the
+// store with n. Like dispatchReportEvent, it is meant to be run in its own goroutine with a
+// context that outlives the upload request; failures are logged and never surface to the
+// uploader.
+func dispatchRegionAlerts(ctx context.Context, n Notifier, st *Store, inStock []string, uploaderID string) {
+	if len(inStock) == 0 {
+		return
+	}
+	subs, err := loadAllRegionSubscriptions(ctx)
+	if err != nil {
+		log.Printf("failed to load region subscriptions: %v", err)
+		return
+	}
+	notifyRegionSubscribers(ctx, n, subs, st, inStock, uploaderID)
+}
+
+// notifyRegionSubscribers sends the regionAlerts with n, all at once.
+func notifyRegionSubscribers(ctx context.Context, n Notifier, subs []*RegionSubscription, st *Store, inStock []string, uploaderID string) {
+	alerts := regionAlerts(subs, st, inStock, uploaderID, time.Now().Unix())
+	if len(alerts) == 0 {
+		return
+	}
+	if err := n.Notify(ctx, alerts); err != nil {
+		log.Printf("failed to notify %d users of region restock at store %q: %v", len(alerts), st.StoreID, err)
+	}
+}
+
+// webhookNotifier delivers region alerts to the webhooks the users subscribed, signed like
+// report events. The webhooks are loaded once per upload, however many users are alerted.
+type webhookNotifier struct{}
+
+func (webhookNotifier) Notify(ctx context.Context, alerts map[string]*RegionAlert) error {
+	secret := os.Getenv("WEBHOOK_SECRET") // See GCP console for secret
+	if secret == "" {
+		return fmt.Errorf("webhook secret env variable is not set")
+	}
+	webhooks, err := loadAllWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	payloads := make(map[string][]byte, len(alerts))
+	for userID, alert := range alerts {
+		payload, err := json.Marshal(alert)
+		if err != nil {
+			return fmt.Errorf("failed to encode region alert: %v", err)
+		}
+		payloads[userID] = payload
+	}
+	for _, wh := range webhooks {
+		payload, ok := payloads[wh.UserID]
+		if !ok {
+			continue
+		}
+		go func(wh *Webhook, payload []byte) {
+			if err := sendWebhookEvent(wh.CallbackURL, payload, secret); err != nil {
+				log.Printf("failed to deliver region alert to webhook %q: %v", wh.WebhookID, err)
+			}
+		}(wh, payload)
+	}
+	return nil
+}
+
+func createRegionSubscriptionInStorage(ctx context.Context, sub *RegionSubscription) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	key := regionSubscriptionKey(ctx, sub.UserID)
+	if _, err := client.Put(ctx, key, sub); err != nil {
+		return fmt.Errorf("failed to create region subscription in storage: %v", err)
+	}
+	return nil
+}
+
+func deleteRegionSubscriptionInStorage(ctx context.Context, userID string) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Delete(ctx, regionSubscriptionKey(ctx, userID)); err != nil {
+		return fmt.Errorf("failed to delete region subscription in storage: %v", err)
+	}
+	return nil
+}
+
+func loadAllRegionSubscriptions(ctx context.Context) ([]*RegionSubscription, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var subs []*RegionSubscription
+	it := client.Run(ctx, newQuery(ctx, RegionSubscriptionKind))
+	for {
+		var sub RegionSubscription
+		_, err := it.Next(&sub)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query for all region subscriptions: %v", err)
+		}
+		subs = append(subs, &sub)
+	}
+	return subs, nil
+}
+
+// regionSubscriptionKey returns the key of the user's region subscription.
+func regionSubscriptionKey(ctx context.Context, userID string) *datastore.Key {
+	return nameKey(ctx, RegionSubscriptionKind, userID)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type fakeNotifier struct {
+	mu     sync.Mutex
+	calls  int
+	alerts map[string]*RegionAlert
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, alerts map[string]*RegionAlert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.calls++
+	for userID, alert := range alerts {
+		n.alerts[userID] = alert
+	}
+	return nil
+}
+
+func TestNotifyRegionSubscribers(t *testing.T) {
+	hiddenItems.set("flour", true)
+	defer hiddenItems.set("flour", false)
+
+	seattle := zipCodeToLatLong["98101"]
+	st := &Store{StoreID: "qfc", Name: "QFC", Addr: "500 Broadway E, Seattle, WA 98102", Lat: 47.62, Long: -122.32}
+	subs := []*RegionSubscription{
+		{UserID: "near", Lat: seattle.Lat, Long: seattle.Long, RadiusMiles: 5, ItemNames: []string{"toilet paper"}},
+		{UserID: "any item", Lat: seattle.Lat, Long: seattle.Long, RadiusMiles: 5},
+		// Too far from the store.
+		{UserID: "far", Lat: seattle.Lat, Long: seattle.Long, RadiusMiles: 0.5, ItemNames: []string{"toilet paper"}},
+		// Watches none of the restocked items.
+		{UserID: "other item", Lat: seattle.Lat, Long: seattle.Long, RadiusMiles: 5, ItemNames: []string{"rice"}},
+		{UserID: "uploader", Lat: seattle.Lat, Long: seattle.Long, RadiusMiles: 5},
+	}
+	n := &fakeNotifier{alerts: make(map[string]*RegionAlert)}
+	notifyRegionSubscribers(context.Background(), n, subs, st, []string{"toilet paper", "flour", "eggs"}, "uploader")
+
+	var got []string
+	for userID := range n.alerts {
+		got = append(got, userID)
+	}
+	if len(n.alerts) != 2 || n.alerts["near"] == nil || n.alerts["any item"] == nil {
+		t.Fatalf("notified %q, want near and any item", got)
+	}
+	if n.calls != 1 {
+		t.Errorf("got %d Notify calls, want every alert sent at once", n.calls)
+	}
+	alert := n.alerts["near"]
+	if alert.Type != regionRestockEvent || alert.StoreID != "qfc" || alert.DistanceMiles <= 0.5 || alert.DistanceMiles > 5 {
+		t.Errorf("got alert %+v, want a restock at qfc within 5 miles", alert)
+	}
+	if want := []string{"toilet paper"}; !reflect.DeepEqual(alert.InStock, want) {
+		t.Errorf("got in-stock items %q for near, want %q", alert.InStock, want)
+	}
+	if want := []string{"eggs", "toilet paper"}; !reflect.DeepEqual(n.alerts["any item"].InStock, want) {
+		t.Errorf("got in-stock items %q for any item, want %q", n.alerts["any item"].InStock, want)
+	}
+}
+
+func TestCleanAndValidateSubscribeRegionReq(t *testing.T) {
+	req := &SubscribeRegionReq{UserID: "u", ZipCode: " 98101 ", ItemNames: []string{"Toilet Paper", "toilet paper"}}
+	if err := cleanAndValidateSubscribeRegionReq(req); err != nil {
+		t.Fatalf("cleanAndValidateSubscribeRegionReq() failed: %v", err)
+	}
+	if req.ZipCode != "98101" || !reflect.DeepEqual(req.ItemNames, []string{"toilet paper"}) {
+		t.Errorf("got zip code %q and items %q, want them cleaned and deduped", req.ZipCode, req.ItemNames)
+	}
+	for _, req := range []*SubscribeRegionReq{
+		{ZipCode: "98101"},
+		{UserID: "u", RadiusMiles: maxSearchRadiusMiles + 1},
+		{UserID: "u", ItemNames: []string{""}},
+		{UserID: "u", ItemNames: []string{"zzzz qqqq"}},
+	} {
+		if err := cleanAndValidateSubscribeRegionReq(req); err == nil {
+			t.Errorf("cleanAndValidateSubscribeRegionReq(%+v) succeeded, want error", req)
+		}
+	}
+
+	if got := regionAlertRadius(0, &User{}); got != defaultRegionAlertRadiusMiles {
+		t.Errorf("regionAlertRadius() = %v without a user default, want %v", got, defaultRegionAlertRadiusMiles)
+	}
+	if got := regionAlertRadius(0, &User{DefaultRadiusMiles: 3}); got != 3 {
+		t.Errorf("regionAlertRadius() = %v, want the user default 3", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegionStores(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "tacoma", Lat: 47.25, Long: -122.44},
+		{StoreID: "kirkland", Lat: 47.70, Long: -122.19},
+		{StoreID: "downtown", Lat: 47.615, Long: -122.335},
+	}
+	// 98101 and 98109 are under 2 miles apart, so their radii overlap downtown.
+	resp := regionStores(stores, []string{"98109", "98101", "98033"}, 3)
+
+	if len(resp) != 2 {
+		t.Fatalf("got %d stores, want 2 with each store once: %+v", len(resp), resp)
+	}
+	if got := resp[0]; got.StoreID != "downtown" || !reflect.DeepEqual(got.ZipCodes, []string{"98109", "98101"}) {
+		t.Errorf("got first store %q covered by %q, want downtown covered by 98109 and 98101", got.StoreID, got.ZipCodes)
+	}
+	if d := Distance(47.615, -122.335, zipCodeToLatLong["98101"].Lat, zipCodeToLatLong["98101"].Long); resp[0].DistanceMiles != d {
+		t.Errorf("got distance %v, want %v to the nearest zip code", resp[0].DistanceMiles, d)
+	}
+	if got := resp[1]; got.StoreID != "kirkland" || !reflect.DeepEqual(got.ZipCodes, []string{"98033"}) {
+		t.Errorf("got second store %q covered by %q, want kirkland covered by 98033", got.StoreID, got.ZipCodes)
+	}
+}
+
+func TestCleanAndValidateQueryRegionStoresReq(t *testing.T) {
+	req := &QueryRegionStoresReq{UserID: "u", ZipCodes: []string{"98101", " 98101", "98109"}}
+	if err := cleanAndValidateQueryRegionStoresReq(req); err != nil {
+		t.Fatalf("cleanAndValidateQueryRegionStoresReq() = %v", err)
+	}
+	if !reflect.DeepEqual(req.ZipCodes, []string{"98101", "98109"}) || req.RadiusMiles != defaultMapRadiusMiles {
+		t.Errorf("got zip codes %q and radius %v, want them deduped with the default radius", req.ZipCodes, req.RadiusMiles)
+	}
+
+	orig := maxRegionZipCodes
+	defer func() { maxRegionZipCodes = orig }()
+	maxRegionZipCodes = 1
+	for _, zipCodes := range [][]string{nil, {"98101", "98109"}, {"00000"}, {"abc"}} {
+		req := &QueryRegionStoresReq{UserID: "u", ZipCodes: zipCodes}
+		if err := cleanAndValidateQueryRegionStoresReq(req); err == nil {
+			t.Errorf("cleanAndValidateQueryRegionStoresReq(%q) = nil, want error", zipCodes)
+		}
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -12,34 +17,147 @@ import (
 
 // StockReport represents the report entity. It is NOT stored as an entity in storage. Rather it is stored as a field of the item entity.
 type StockReport struct {
-	UsersInfo    []*User `datastore:"user_info"`
-	StoreInfo    *Store  `datastore:"store_info"`
-	TimestampSec int64   `datastore:"timestamp_sec"`
-	InStock      bool    `datastore:"in_stock"`
-	SeenCnt      int     `datastore:"seen_cnt"`
+	UsersInfo    []*User `datastore:"user_info" json:"user_info"`
+	StoreInfo    *Store  `datastore:"store_info" json:"store_info"`
+	TimestampSec int64   `datastore:"timestamp_sec" json:"timestamp_sec"`
+	InStock      bool    `datastore:"in_stock" json:"in_stock"`
+	// Unknown is true for reports that the user visited the store but couldn't check the
+	// item. They count toward when the store was last seen, but not toward stock verdicts.
+	Unknown bool `datastore:"unknown" json:"unknown,omitempty"`
+	// Level is the graded stock level. It is StockLevelNone for reports that only say
+	// whether the item is in stock.
+	Level   StockLevel `datastore:"level" json:"level"`
+	SeenCnt int        `datastore:"seen_cnt" json:"seen_cnt"`
+	// PhotoURL is the most recent photo of the shelf uploaded with the report, if any.
+	PhotoURL string `datastore:"photo_url,noindex" json:"photo_url,omitempty"`
+	// StoreDeleted is set as items are loaded on reports whose store was deleted. It isn't
+	// stored. See deletedStoreReports.
+	StoreDeleted bool `datastore:"-" json:"store_deleted,omitempty"`
+}
+
+// StockLevel grades how much of an item a store has.
+type StockLevel int
+
+const (
+	StockLevelNone StockLevel = iota
+	StockLevelOut
+	StockLevelLow
+	StockLevelMedium
+	StockLevelHigh
+)
+
+var stockLevelNames = map[StockLevel]string{
+	StockLevelNone:   "",
+	StockLevelOut:    "out",
+	StockLevelLow:    "low",
+	StockLevelMedium: "medium",
+	StockLevelHigh:   "high",
+}
+
+func (l StockLevel) String() string {
+	return stockLevelNames[l]
+}
+
+// MarshalJSON encodes the level by name, or by number if featureStockLevelNames is disabled.
+// StockLevelNone is the empty name.
+func (l StockLevel) MarshalJSON() ([]byte, error) {
+	if !featureEnabled(featureStockLevelNames) {
+		return json.Marshal(int(l))
+	}
+	name, ok := stockLevelNames[l]
+	if !ok {
+		return nil, fmt.Errorf("unknown stock level %d", int(l))
+	}
+	return json.Marshal(name)
+}
+
+// UnmarshalJSON decodes a level encoded by name or by number, whichever way it was encoded.
+func (l *StockLevel) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		var n int
+		if err := json.Unmarshal(b, &n); err != nil {
+			return fmt.Errorf("stock level must be a name or a number, got %s", b)
+		}
+		if _, ok := stockLevelNames[StockLevel(n)]; !ok {
+			return fmt.Errorf("unknown stock level %d", n)
+		}
+		*l = StockLevel(n)
+		return nil
+	}
+	if name == "" {
+		*l = StockLevelNone
+		return nil
+	}
+	level, err := parseStockLevel(name)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// parseStockLevel parses a graded stock level name. The empty name is not a graded level.
+func parseStockLevel(s string) (StockLevel, error) {
+	for l, name := range stockLevelNames {
+		if l != StockLevelNone && name == s {
+			return l, nil
+		}
+	}
+	return StockLevelNone, fmt.Errorf("unknown stock level %q", s)
 }
 
 // ******************************************
 // ** Begin UploadReport
 // ******************************************
 
+var (
+	// maxReportItemLen caps the length of each item name in a report, set with the
+	// MAX_REPORT_ITEM_LEN env variable.
+	maxReportItemLen = positiveIntFromEnv("MAX_REPORT_ITEM_LEN", 100)
+	// maxReportItems caps the number of distinct items in a report across its in-stock,
+	// out-of-stock, and unknown lists, set with the MAX_REPORT_ITEMS env variable.
+	maxReportItems = positiveIntFromEnv("MAX_REPORT_ITEMS", 100)
+)
+
+// reportBodyOverhead is how much larger than the base64-encoded photo a report upload may
+// be, to leave room for the rest of the JSON.
+const reportBodyOverhead = 1 << 20
+
 type UploadReportReq struct {
 	UserID   string   `json:"user_id"`
 	StoreID  string   `json:"store_id"`
 	InStock  []string `json:"in_stock_items"`
 	OutStock []string `json:"out_stock_items"`
+	// Unknown lists the items the user couldn't check during the visit.
+	Unknown []string `json:"unknown_items"`
+	// Photo is an optional base64-encoded JPEG or PNG of the shelf.
+	Photo []byte `json:"photo"`
+	// Quantities optionally grades how much of the in-stock items the store has, as "low",
+	// "medium", or "high", keyed by item name. In-stock items without one are reported
+	// without a level.
+	Quantities map[string]string `json:"quantities"`
 }
 
-// UploadReport updates each item in the in-stock list and out-stock list in the request
-// with the stock report data.
+// UploadReport updates each item in the in-stock, out-stock, and unknown lists in the
+// request with the stock report data. A photo included with the report is attached to each item,
+// and the quantity of an in-stock item, if any, is recorded as the report's level.
+// A report on an item disputes the store's reports of the opposite stock state; see
+// disputeStockReports.
 func UploadReport(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
 	var req UploadReportReq
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadReportBytes())
 	if err := DecodeReq(r.Body, &req); err != nil {
 		return http.StatusBadRequest, err
 	}
 	if err := cleanAndValidateUploadReportReq(&req); err != nil {
 		return http.StatusBadRequest, err
 	}
+	if len(req.Photo) > 0 {
+		if _, err := photoBlobs.validate(req.Photo); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("photo is invalid: %v", err)
+		}
+	}
 
 	user, ok, err := GetUserInStorage(ctx, req.UserID)
 	if err != nil {
@@ -49,10 +167,13 @@ func UploadReport(ctx context.Context, w http.ResponseWriter, r *http.Request) (
 		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
 	}
 
-	store, err := GetStoreInStorage(ctx, req.StoreID)
+	store, ok, err := GetStoreInStorage(ctx, req.StoreID)
 	if err != nil {
 		return http.StatusInternalServerError, err
 	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
 
 	client, err := StorageClient(ctx)
 	if err != nil {
@@ -60,87 +181,243 @@ func UploadReport(ctx context.Context, w http.ResponseWriter, r *http.Request) (
 	}
 	defer client.Close()
 
-	if err := handleUploadToItems(ctx, client, store, user, req.InStock, true); err != nil {
+	// Route aliased item names to their canonical items. As in cleanAndValidateUploadReportReq,
+	// an item in several lists is kept in the first of the in-stock, out-stock, and unknown
+	// lists.
+	names := append(append(append([]string{}, req.InStock...), req.OutStock...), req.Unknown...)
+	aliases, err := getItemAliases(ctx, client, names)
+	if err != nil {
 		return http.StatusInternalServerError, err
 	}
+	levels := quantityLevels(req.InStock, req.Quantities, aliases)
+	seen := make(map[string]bool)
+	req.InStock = applyItemAliases(req.InStock, aliases, seen)
+	req.OutStock = applyItemAliases(req.OutStock, aliases, seen)
+	req.Unknown = applyItemAliases(req.Unknown, aliases, seen)
+
+	var photoURL string
+	if len(req.Photo) > 0 {
+		if photoURL, err = saveReportPhoto(ctx, blobs, store.StoreID, req.Photo); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	}
 
-	if err := handleUploadToItems(ctx, client, store, user, req.OutStock, false); err != nil {
+	items := make([]*itemStock, 0, len(req.InStock)+len(req.OutStock)+len(req.Unknown))
+	for _, name := range req.InStock {
+		items = append(items, &itemStock{Name: name, InStock: true, Level: levels[name], PhotoURL: photoURL})
+	}
+	for _, name := range req.OutStock {
+		items = append(items, &itemStock{Name: name, InStock: false, PhotoURL: photoURL})
+	}
+	for _, name := range req.Unknown {
+		items = append(items, &itemStock{Name: name, Unknown: true})
+	}
+	if err := handleUploadToItems(ctx, client, store, user, items); err != nil {
 		return http.StatusInternalServerError, err
 	}
 
+	go dispatchReportEvent(detachedContext(ctx), &ReportEvent{
+		Type:         reportUploadedEvent,
+		StoreID:      store.StoreID,
+		StoreName:    store.Name,
+		StoreAddr:    store.Addr,
+		InStock:      req.InStock,
+		OutStock:     req.OutStock,
+		Unknown:      req.Unknown,
+		TimestampSec: time.Now().Unix(),
+	})
+	go dispatchRegionAlerts(detachedContext(ctx), notifier, store, req.InStock, user.UserID)
+
 	return http.StatusOK, nil
 }
 
-func handleUploadToItems(ctx context.Context, client *datastore.Client, store *Store, user *User, itemNames []string, checkInStock bool) error {
+// itemStock is the stock state reported for a single item.
+type itemStock struct {
+	Name     string
+	InStock  bool
+	Unknown  bool
+	Level    StockLevel
+	PhotoURL string
+}
+
+// uploadWorkers is the number of item transactions handleUploadToItems runs at once, set with
+// the UPLOAD_WORKERS env variable. Set it to 1 to run them one at a time.
+var uploadWorkers = positiveIntFromEnv("UPLOAD_WORKERS", 8)
+
+func handleUploadToItems(ctx context.Context, client *datastore.Client, store *Store, user *User, items []*itemStock) error {
+	ctx, span := startClientSpan(ctx, "datastore.update Item")
+	defer span.Finish()
+	span.SetAttr("items", strconv.Itoa(len(items)))
 	now := time.Now().Unix()
-	errFreq := 0
-	var errResult error
 
-	// For each item in itemNames, update item using name as key from storage. If item doesn't exist, create item
-	// in storage.
-	for _, itemName := range itemNames {
-		// RunInTransaction guarantees that the get-then-put datastore operation is atomic.
-		if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+	// For each reported item, update item using name as key from storage. If item doesn't exist, create item
+	// in storage. Each item has its own transaction, so they run concurrently.
+	errFreq, errResult := runItemUploads(ctx, items, uploadWorkers, func(ctx context.Context, is *itemStock) error {
+		// RunInTransaction guarantees that the get-then-put datastore operation is atomic. Both
+		// must go through tx: if a double-submitted report races this one, one transaction
+		// fails to commit and is retried, and the retry sees the user already in UsersInfo.
+		_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
 			var item Item
-			key := datastore.NameKey(ItemKind, itemName, nil)
-			if err := client.Get(ctx, key, &item); err != nil {
+			key := nameKey(ctx, ItemKind, is.Name)
+			if err := tx.Get(key, &item); err != nil {
 				if err != datastore.ErrNoSuchEntity {
-					return fmt.Errorf("failed to fetch item %q from storage: %v", itemName, err)
+					return fmt.Errorf("failed to fetch item %q from storage: %v", is.Name, err)
 				}
-				item.Name = itemName
+				item.Name = is.Name
 				item.StockReports = make([]*StockReport, 0)
 			}
-			// Iterate through the item's stock reports to see if there is already one for the same
-			// store. If so, just increment the seen count and timestamp rather than creating an entirely new report.
-			for _, sr := range item.StockReports {
-				if sr.StoreInfo.StoreID == store.StoreID && sr.InStock == checkInStock {
-					// However, if it's the same user reporting it, do not increment the seenCnt.
-					userAlreadyReported := false
-					for _, u := range sr.UsersInfo {
-						if u.UserID == user.UserID {
-							userAlreadyReported = true
-							break
-						}
-					}
-					if !userAlreadyReported {
-						sr.SeenCnt++
-						sr.UsersInfo = append(sr.UsersInfo, &User{UserID: user.UserID, TimestampSec: now})
-					}
-					sr.TimestampSec = now
-					if _, err := client.Put(ctx, key, &item); err != nil {
-						return fmt.Errorf("failed to update item %q in storage with an existing stock report %v: %v", itemName, sr, err)
-					}
-					return nil
-				}
-			}
-			sr := &StockReport{
-				UsersInfo:    []*User{{UserID: user.UserID, TimestampSec: now}},
-				StoreInfo:    store,
-				TimestampSec: now,
-				InStock:      checkInStock,
-				SeenCnt:      1,
+			// Fix reports that counted a user more than once before uploads were
+			// transactional, so the seen count below starts from distinct users.
+			dedupUsersInfo(&item)
+			if featureEnabled(featureDisputeReports) {
+				disputeStockReports(&item, store, user, is)
 			}
-			item.StockReports = append(item.StockReports, sr)
-			if _, err := client.Put(ctx, key, &item); err != nil {
-				return fmt.Errorf("failed to update item %q in storage with new stock report %v: %v", itemName, sr, err)
+			sr := addStockReport(&item, store, user, is, now)
+			if _, err := tx.Put(key, &item); err != nil {
+				return fmt.Errorf("failed to update item %q in storage with stock report %v: %v", is.Name, sr, err)
 			}
 			return nil
-		}); err != nil {
-			// Rather than returning an error once a transaction fails, try to run all transactions for items
-			// and report the first error and number of errors at the end.
-			errFreq++
-			if errResult == nil {
-				errResult = err
-			}
-		}
-	}
+		})
+		return err
+	})
 
 	if errResult != nil {
+		span.SetError(errResult)
 		return fmt.Errorf("Encountered %d failures, recorded the first one: %v", errFreq, errResult)
 	}
 	return nil
 }
 
+// runItemUploads runs update on each item with up to workers at once. Rather than stopping
+// once an update fails, it runs them all and returns the number of failures and the error of
+// the first item, in the items' order, that failed.
+func runItemUploads(ctx context.Context, items []*itemStock, workers int, update func(context.Context, *itemStock) error) (int, error) {
+	errs := make([]error, len(items))
+	if workers <= 1 {
+		for i, is := range items {
+			errs[i] = update(ctx, is)
+		}
+	} else {
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for i, is := range items {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, is *itemStock) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				errs[i] = update(ctx, is)
+			}(i, is)
+		}
+		wg.Wait()
+	}
+
+	errFreq := 0
+	var errResult error
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		errFreq++
+		if errResult == nil {
+			errResult = err
+		}
+	}
+	return errFreq, errResult
+}
+
+// addStockReport records the user's report on the item and returns the stock report that
+// holds it.
+func addStockReport(item *Item, store *Store, user *User, is *itemStock, now int64) *StockReport {
+	// Iterate through the item's stock reports to see if there is already one for the same
+	// store and stock state. If so, just increment the seen count and timestamp rather than creating an entirely new report.
+	// The level isn't part of the stock state: the latest report's level replaces the
+	// earlier one, so a store has one in-stock report however its level changes.
+	for _, sr := range item.StockReports {
+		if sr.StoreInfo.StoreID == store.StoreID && sr.InStock == is.InStock && sr.Unknown == is.Unknown {
+			// However, if it's the same user reporting it, do not increment the seenCnt.
+			userAlreadyReported := false
+			for _, u := range sr.UsersInfo {
+				if u.UserID == user.UserID {
+					userAlreadyReported = true
+					break
+				}
+			}
+			if !userAlreadyReported {
+				sr.SeenCnt++
+				sr.UsersInfo = append(sr.UsersInfo, &User{UserID: user.UserID, TimestampSec: now})
+			}
+			sr.TimestampSec = now
+			sr.Level = is.Level
+			if is.PhotoURL != "" {
+				sr.PhotoURL = is.PhotoURL
+			}
+			return sr
+		}
+	}
+	sr := &StockReport{
+		UsersInfo:    []*User{{UserID: user.UserID, TimestampSec: now}},
+		StoreInfo:    storeSnapshot(store),
+		TimestampSec: now,
+		InStock:      is.InStock,
+		Unknown:      is.Unknown,
+		Level:        is.Level,
+		SeenCnt:      1,
+		PhotoURL:     is.PhotoURL,
+	}
+	item.StockReports = append(item.StockReports, sr)
+	return sr
+}
+
+// disputeStockReports applies the user's report on the item against the reports of the
+// opposite stock state at the same store, so that stale reports can be corrected:
+//
+// Each opposing report loses one of the users who confirmed it: the user themselves if they
+// had, since they changed their mind, and otherwise the one who confirmed it longest ago. Its
+// seen count drops with it, and once no users are left the report is removed, flipping the
+// store's state to the new report. Unknown reports neither dispute nor are disputed.
+//
+// It returns the number of reports removed.
+func disputeStockReports(item *Item, store *Store, user *User, is *itemStock) int {
+	if is.Unknown {
+		return 0
+	}
+	removed := 0
+	kept := item.StockReports[:0]
+	for _, sr := range item.StockReports {
+		if sr.StoreInfo == nil || sr.StoreInfo.StoreID != store.StoreID || sr.Unknown || sr.InStock == is.InStock {
+			kept = append(kept, sr)
+			continue
+		}
+		drop := -1
+		for i, u := range sr.UsersInfo {
+			if u.UserID == user.UserID {
+				drop = i
+				break
+			}
+			if drop < 0 || u.TimestampSec < sr.UsersInfo[drop].TimestampSec {
+				drop = i
+			}
+		}
+		if drop >= 0 {
+			sr.UsersInfo = append(sr.UsersInfo[:drop], sr.UsersInfo[drop+1:]...)
+		}
+		if sr.SeenCnt--; sr.SeenCnt <= 0 || len(sr.UsersInfo) == 0 {
+			removed++
+			continue
+		}
+		kept = append(kept, sr)
+	}
+	item.StockReports = kept
+	return removed
+}
+
+// maxUploadReportBytes is the largest report upload body, one with a photo of
+// photoBlobs.MaxBytes, so that an oversized photo is rejected before it is read into memory.
+func maxUploadReportBytes() int64 {
+	return int64(base64.StdEncoding.EncodedLen(photoBlobs.MaxBytes) + reportBodyOverhead)
+}
+
 func cleanAndValidateUploadReportReq(req *UploadReportReq) error {
 	if req.UserID == "" {
 		return fmt.Errorf("missing user id")
@@ -148,43 +425,418 @@ func cleanAndValidateUploadReportReq(req *UploadReportReq) error {
 	if req.StoreID == "" {
 		return fmt.Errorf("missing store id")
 	}
-	if len(req.InStock) == 0 && len(req.OutStock) == 0 {
-		return fmt.Errorf("in-stock and out-of-stock items are both empty")
+	if len(req.InStock) == 0 && len(req.OutStock) == 0 && len(req.Unknown) == 0 {
+		return fmt.Errorf("in-stock, out-of-stock, and unknown items are all empty")
 	}
 	// An edge case is if the same item appears multiple times in the inStock array,
 	// in the outStock array, and/or in both arrays. Prune duplicates in each array.
 	// In case of both arrays, we bias the item in the inStock array. It will not
-	// appear in the outStock array.
+	// appear in the outStock array. Likewise, an item that is in stock or out of stock
+	// is dropped from the unknown array.
+	//
+	// Every list is checked in full so that the error lists all of the offending items
+	// rather than only the first.
 	seen := make(map[string]bool)
-	inStock := make([]string, 0)
-	outStock := make([]string, 0)
-	for i := range req.InStock {
-		item := strings.ToLower(strings.TrimSpace(req.InStock[i]))
-		if item == "" {
-			return fmt.Errorf("in-stock item at index %d is empty", i)
-		}
-		if _, ok := seen[item]; ok {
-			continue
+	var problems []string
+	cleanItems := func(label string, items []string) []string {
+		res := make([]string, 0)
+		var empty, tooLong, excess []int
+		for i := range items {
+			item := strings.ToLower(strings.TrimSpace(items[i]))
+			switch {
+			case item == "":
+				empty = append(empty, i)
+				continue
+			case len([]rune(item)) > maxReportItemLen:
+				tooLong = append(tooLong, i)
+				continue
+			}
+			if _, ok := seen[item]; ok {
+				continue
+			}
+			seen[item] = true
+			if len(seen) > maxReportItems {
+				excess = append(excess, i)
+				continue
+			}
+			res = append(res, item)
 		}
-		seen[item] = true
-		inStock = append(inStock, item)
-	}
-	for i := range req.OutStock {
-		item := strings.ToLower(strings.TrimSpace(req.OutStock[i]))
-		if item == "" {
-			return fmt.Errorf("out-of-stock item at index %d is empty", i)
+		if len(empty) > 0 {
+			problems = append(problems, fmt.Sprintf("%s %s empty", label, indexList(empty)))
 		}
-		if _, ok := seen[item]; ok {
-			continue
+		if len(tooLong) > 0 {
+			problems = append(problems, fmt.Sprintf("%s %s longer than %d characters", label, indexList(tooLong), maxReportItemLen))
 		}
-		seen[item] = true
-		outStock = append(outStock, item)
+		if len(excess) > 0 {
+			problems = append(problems, fmt.Sprintf("%s %s over the limit of %d items per report", label, indexList(excess), maxReportItems))
+		}
+		return res
+	}
+	inStock := cleanItems("in-stock", req.InStock)
+	outStock := cleanItems("out-of-stock", req.OutStock)
+	unknown := cleanItems("unknown", req.Unknown)
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	quantities, problems := cleanQuantities(req.Quantities, inStock)
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
 	}
 	req.InStock = inStock
 	req.OutStock = outStock
+	req.Unknown = unknown
+	req.Quantities = quantities
 	return nil
 }
 
+// cleanQuantities normalizes the item names and levels of the report's quantities and
+// returns the problems with them. Quantities must be graded levels other than "out" and be
+// for the in-stock items.
+func cleanQuantities(quantities map[string]string, inStock []string) (map[string]string, []string) {
+	if len(quantities) == 0 {
+		return nil, nil
+	}
+	isInStock := make(map[string]bool, len(inStock))
+	for _, name := range inStock {
+		isInStock[name] = true
+	}
+	names := make([]string, 0, len(quantities))
+	for name := range quantities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := make(map[string]string, len(quantities))
+	var problems []string
+	for _, name := range names {
+		q := quantities[name]
+		item := strings.ToLower(strings.TrimSpace(name))
+		level, err := parseStockLevel(strings.ToLower(strings.TrimSpace(q)))
+		switch {
+		case err != nil || level == StockLevelOut:
+			problems = append(problems, fmt.Sprintf("quantity %q of %q is not low, medium, or high", q, name))
+		case !isInStock[item]:
+			problems = append(problems, fmt.Sprintf("quantity of %q is for an item that isn't reported in stock", name))
+		default:
+			res[item] = level.String()
+		}
+	}
+	return res, problems
+}
+
+// quantityLevels returns the stock levels of the cleaned quantities keyed by canonical item
+// name. If several in-stock names of an item are aliases, the level of the first is kept, as
+// applyItemAliases keeps its report.
+func quantityLevels(inStock []string, quantities map[string]string, aliases map[string]string) map[string]StockLevel {
+	levels := make(map[string]StockLevel)
+	seen := make(map[string]bool)
+	for _, name := range inStock {
+		canonical := name
+		if c, ok := aliases[name]; ok {
+			canonical = c
+		}
+		if seen[canonical] {
+			continue
+		}
+		seen[canonical] = true
+		if q, ok := quantities[name]; ok {
+			// Already validated by cleanQuantities.
+			levels[canonical], _ = parseStockLevel(q)
+		}
+	}
+	return levels
+}
+
+// indexList describes the item indexes for an error message, such as "item at index 1 is" or
+// "items at indexes 1, 4 are".
+func indexList(indexes []int) string {
+	if len(indexes) == 1 {
+		return fmt.Sprintf("item at index %d is", indexes[0])
+	}
+	strs := make([]string, len(indexes))
+	for i, idx := range indexes {
+		strs[i] = strconv.Itoa(idx)
+	}
+	return fmt.Sprintf("items at indexes %s are", strings.Join(strs, ", "))
+}
+
 // ******************************************
 // ** END UploadReport
 // ******************************************
+
+// ******************************************
+// ** BEGIN UploadVisit
+// ******************************************
+
+type UploadVisitReq struct {
+	UserID  string       `json:"user_id"`
+	StoreID string       `json:"store_id"`
+	Items   []*VisitItem `json:"items"`
+}
+
+// VisitItem is the graded stock level of an item seen during a store visit.
+type VisitItem struct {
+	Item  string `json:"item"`
+	Level string `json:"level"`
+}
+
+// UploadVisit records the graded stock level of each item seen during a single store visit.
+func UploadVisit(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req UploadVisitReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	items, err := cleanAndValidateUploadVisitReq(&req)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	user, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	store, ok, err := GetStoreInStorage(ctx, req.StoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	names := make([]string, len(items))
+	for i, is := range items {
+		names[i] = is.Name
+	}
+	aliases, err := getItemAliases(ctx, client, names)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	items = applyItemStockAliases(items, aliases)
+
+	if err := handleUploadToItems(ctx, client, store, user, items); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	ev := &ReportEvent{
+		Type:         reportUploadedEvent,
+		StoreID:      store.StoreID,
+		StoreName:    store.Name,
+		StoreAddr:    store.Addr,
+		InStock:      make([]string, 0),
+		OutStock:     make([]string, 0),
+		TimestampSec: time.Now().Unix(),
+	}
+	for _, is := range items {
+		if is.InStock {
+			ev.InStock = append(ev.InStock, is.Name)
+		} else {
+			ev.OutStock = append(ev.OutStock, is.Name)
+		}
+	}
+	go dispatchReportEvent(detachedContext(ctx), ev)
+	go dispatchRegionAlerts(detachedContext(ctx), notifier, store, ev.InStock, user.UserID)
+
+	return http.StatusOK, nil
+}
+
+// cleanAndValidateUploadVisitReq normalizes the visit's item names and levels. If an item
+// appears more than once, its first level is kept.
+func cleanAndValidateUploadVisitReq(req *UploadVisitReq) ([]*itemStock, error) {
+	if req.UserID == "" {
+		return nil, fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return nil, fmt.Errorf("missing store id")
+	}
+	if len(req.Items) == 0 {
+		return nil, fmt.Errorf("visit items are empty")
+	}
+	seen := make(map[string]bool)
+	items := make([]*itemStock, 0, len(req.Items))
+	for i, vi := range req.Items {
+		if vi == nil {
+			return nil, fmt.Errorf("visit item at index %d is empty", i)
+		}
+		name := strings.ToLower(strings.TrimSpace(vi.Item))
+		if name == "" {
+			return nil, fmt.Errorf("visit item at index %d is empty", i)
+		}
+		level, err := parseStockLevel(strings.ToLower(strings.TrimSpace(vi.Level)))
+		if err != nil {
+			return nil, fmt.Errorf("visit item at index %d: %v", i, err)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		// Out of stock is a stock state, not a level, like the out-of-stock items of
+		// UploadReport, so that both record the same report.
+		if level == StockLevelOut {
+			items = append(items, &itemStock{Name: name, InStock: false})
+			continue
+		}
+		items = append(items, &itemStock{Name: name, InStock: true, Level: level})
+	}
+	return items, nil
+}
+
+// applyItemStockAliases replaces aliased item names with their canonical names, dropping
+// items whose canonical name was already reported.
+func applyItemStockAliases(items []*itemStock, aliases map[string]string) []*itemStock {
+	seen := make(map[string]bool)
+	res := make([]*itemStock, 0, len(items))
+	for _, is := range items {
+		if canonical, ok := aliases[is.Name]; ok {
+			is.Name = canonical
+		}
+		if seen[is.Name] {
+			continue
+		}
+		seen[is.Name] = true
+		res = append(res, is)
+	}
+	return res
+}
+
+// ******************************************
+// ** END UploadVisit
+// ******************************************
+
+// ******************************************
+// ** BEGIN DeleteReport
+// ******************************************
+
+type DeleteReportReq struct {
+	UserID   string `json:"user_id"`
+	StoreID  string `json:"store_id"`
+	ItemName string `json:"item_name"`
+}
+
+// errNotReporter is returned when retracting a report the user didn't make.
+var errNotReporter = fmt.Errorf("user did not report the item at the store")
+
+// DeleteReport retracts the user's reports on an item at a store, so that a mistaken report
+// can be undone. The user is removed from each of the store's reports that they made, and
+// reports that no users are left on are removed with retractStockReports. An item left
+// without reports is deleted. The store may have been deleted since the report was made.
+func DeleteReport(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req DeleteReportReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidateDeleteReportReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	aliases, err := getItemAliases(ctx, client, []string{req.ItemName})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if canonical, ok := aliases[req.ItemName]; ok {
+		req.ItemName = canonical
+	}
+
+	key := nameKey(ctx, ItemKind, req.ItemName)
+	if _, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var item Item
+		if err := tx.Get(key, &item); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return errNotReporter
+			}
+			return fmt.Errorf("failed to fetch item %q from storage: %v", req.ItemName, err)
+		}
+		if retractStockReports(&item, req.StoreID, req.UserID) == 0 {
+			return errNotReporter
+		}
+		if len(item.StockReports) == 0 {
+			if err := tx.Delete(key); err != nil {
+				return fmt.Errorf("failed to delete item %q in storage: %v", req.ItemName, err)
+			}
+			return nil
+		}
+		if _, err := tx.Put(key, &item); err != nil {
+			return fmt.Errorf("failed to update item %q in storage: %v", req.ItemName, err)
+		}
+		return nil
+	}); err == errNotReporter {
+		return http.StatusForbidden, err
+	} else if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateDeleteReportReq(req *DeleteReportReq) error {
+	req.StoreID = strings.TrimSpace(req.StoreID)
+	req.ItemName = strings.ToLower(strings.TrimSpace(req.ItemName))
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	if req.ItemName == "" {
+		return fmt.Errorf("missing item name")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END DeleteReport
+// ******************************************
+
+// retractStockReports removes the user from the item's reports at the store that they made,
+// decrementing each report's seen count, and removes the reports that no users are left on.
+// It returns the number of reports the user was removed from.
+func retractStockReports(item *Item, storeID, userID string) int {
+	retracted := 0
+	kept := item.StockReports[:0]
+	for _, sr := range item.StockReports {
+		if sr.StoreInfo == nil || sr.StoreInfo.StoreID != storeID {
+			kept = append(kept, sr)
+			continue
+		}
+		users := sr.UsersInfo[:0]
+		for _, u := range sr.UsersInfo {
+			if u.UserID != userID {
+				users = append(users, u)
+			}
+		}
+		if len(users) == len(sr.UsersInfo) {
+			kept = append(kept, sr)
+			continue
+		}
+		retracted++
+		sr.UsersInfo = users
+		if sr.SeenCnt--; sr.SeenCnt <= 0 || len(sr.UsersInfo) == 0 {
+			continue
+		}
+		kept = append(kept, sr)
+	}
+	item.StockReports = kept
+	return retracted
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCleanAndValidateUploadVisitReq(t *testing.T) {
+	req := &UploadVisitReq{
+		UserID:  "user",
+		StoreID: "store",
+		Items: []*VisitItem{
+			{Item: " Toilet Paper ", Level: "low"},
+			{Item: "flour", Level: "OUT"},
+			{Item: "eggs", Level: "high"},
+			{Item: "toilet paper", Level: "high"}, // duplicate, first level wins
+			{Item: "milk", Level: "medium"},
+		},
+	}
+	items, err := cleanAndValidateUploadVisitReq(req)
+	if err != nil {
+		t.Fatalf("cleanAndValidateUploadVisitReq() failed: %v", err)
+	}
+	want := []*itemStock{
+		{Name: "toilet paper", InStock: true, Level: StockLevelLow},
+		{Name: "flour", InStock: false},
+		{Name: "eggs", InStock: true, Level: StockLevelHigh},
+		{Name: "milk", InStock: true, Level: StockLevelMedium},
+	}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("cleanAndValidateUploadVisitReq() = %+v, want %+v", items, want)
+	}
+
+	for _, bad := range []*UploadVisitReq{
+		{StoreID: "store", Items: []*VisitItem{{Item: "eggs", Level: "low"}}},
+		{UserID: "user", Items: []*VisitItem{{Item: "eggs", Level: "low"}}},
+		{UserID: "user", StoreID: "store"},
+		{UserID: "user", StoreID: "store", Items: []*VisitItem{{Item: " ", Level: "low"}}},
+		{UserID: "user", StoreID: "store", Items: []*VisitItem{{Item: "eggs", Level: "plenty"}}},
+		{UserID: "user", StoreID: "store", Items: []*VisitItem{{Item: "eggs"}}},
+	} {
+		if _, err := cleanAndValidateUploadVisitReq(bad); err == nil {
+			t.Errorf("cleanAndValidateUploadVisitReq(%+v) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestAddStockReportMixedLevels(t *testing.T) {
+	store := &Store{StoreID: "store"}
+	alice := &User{UserID: "alice"}
+	bob := &User{UserID: "bob"}
+	item := &Item{Name: "eggs"}
+
+	addStockReport(item, store, alice, &itemStock{InStock: true, Level: StockLevelLow}, 100)
+	addStockReport(item, store, bob, &itemStock{InStock: true, Level: StockLevelLow}, 200)
+	addStockReport(item, store, bob, &itemStock{InStock: true, Level: StockLevelHigh}, 300)
+	addStockReport(item, store, alice, &itemStock{InStock: false}, 400)
+	// A plain in-stock report merges with graded ones too, and the latest level wins.
+	addStockReport(item, store, alice, &itemStock{InStock: true}, 500)
+
+	if len(item.StockReports) != 2 {
+		t.Fatalf("got %d stock reports, want 2", len(item.StockReports))
+	}
+	want := []struct {
+		level   StockLevel
+		inStock bool
+		seenCnt int
+		ts      int64
+	}{
+		{StockLevelNone, true, 2, 500},
+		{StockLevelNone, false, 1, 400},
+	}
+	for i, w := range want {
+		sr := item.StockReports[i]
+		if sr.Level != w.level || sr.InStock != w.inStock || sr.SeenCnt != w.seenCnt || sr.TimestampSec != w.ts {
+			t.Errorf("stock report %d = {level: %v, inStock: %v, seenCnt: %d, ts: %d}, want %+v", i, sr.Level, sr.InStock, sr.SeenCnt, sr.TimestampSec, w)
+		}
+	}
+}
+
+func TestStockLevelJSON(t *testing.T) {
+	orig := features
+	defer func() { features = orig }()
+
+	levels := []StockLevel{StockLevelNone, StockLevelOut, StockLevelLow, StockLevelMedium, StockLevelHigh}
+	for _, byName := range []bool{true, false} {
+		features = map[string]bool{featureStockLevelNames: byName}
+		for _, l := range levels {
+			b, err := json.Marshal(l)
+			if err != nil {
+				t.Fatalf("json.Marshal(%v) failed: %v", l, err)
+			}
+			want := strconv.Itoa(int(l))
+			if byName {
+				want = strconv.Quote(l.String())
+			}
+			if string(b) != want {
+				t.Errorf("json.Marshal(%v) with names=%v = %s, want %s", l, byName, b, want)
+			}
+			var got StockLevel
+			if err := json.Unmarshal(b, &got); err != nil || got != l {
+				t.Errorf("json.Unmarshal(%s) = %v, %v, want %v", b, got, err, l)
+			}
+		}
+	}
+
+	for _, bad := range []string{`"lots"`, `9`, `true`} {
+		var l StockLevel
+		if err := json.Unmarshal([]byte(bad), &l); err == nil {
+			t.Errorf("json.Unmarshal(%s) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseStockLevel(t *testing.T) {
+	for _, l := range []StockLevel{StockLevelOut, StockLevelLow, StockLevelMedium, StockLevelHigh} {
+		got, err := parseStockLevel(l.String())
+		if err != nil || got != l {
+			t.Errorf("parseStockLevel(%q) = %v, %v, want %v", l.String(), got, err, l)
+		}
+	}
+	if _, err := parseStockLevel(""); err == nil {
+		t.Errorf("parseStockLevel(\"\") succeeded, want error")
+	}
+}
+
+func TestAddStockReportSameUserCountedOnce(t *testing.T) {
+	store := &Store{StoreID: "store"}
+	user := &User{UserID: "user"}
+	item := &Item{Name: "eggs"}
+
+	// Transactions that commit one after the other see each other's writes.
+	addStockReport(item, store, user, &itemStock{InStock: true}, 100)
+	addStockReport(item, store, user, &itemStock{InStock: true}, 101)
+
+	if len(item.StockReports) != 1 {
+		t.Fatalf("got %d stock reports, want 1", len(item.StockReports))
+	}
+	sr := item.StockReports[0]
+	if sr.SeenCnt != 1 || len(sr.UsersInfo) != 1 || sr.TimestampSec != 101 {
+		t.Errorf("got seen count %d, %d users, timestamp %d, want 1, 1, 101", sr.SeenCnt, len(sr.UsersInfo), sr.TimestampSec)
+	}
+}
+
+func TestCleanAndValidateUploadReportReqUnknown(t *testing.T) {
+	req := &UploadReportReq{
+		UserID:   "user",
+		StoreID:  "store",
+		InStock:  []string{"eggs"},
+		OutStock: []string{"flour"},
+		Unknown:  []string{" Yeast ", "eggs", "flour"},
+	}
+	if err := cleanAndValidateUploadReportReq(req); err != nil {
+		t.Fatalf("cleanAndValidateUploadReportReq() failed: %v", err)
+	}
+	// An item reported in or out of stock isn't also unknown.
+	if want := []string{"yeast"}; !reflect.DeepEqual(req.Unknown, want) {
+		t.Errorf("got unknown items %q, want %q", req.Unknown, want)
+	}
+
+	// A visit may only record unknown items.
+	if err := cleanAndValidateUploadReportReq(&UploadReportReq{UserID: "user", StoreID: "store", Unknown: []string{"yeast"}}); err != nil {
+		t.Errorf("cleanAndValidateUploadReportReq(unknown only) failed: %v", err)
+	}
+	if err := cleanAndValidateUploadReportReq(&UploadReportReq{UserID: "user", StoreID: "store", Unknown: []string{" "}}); err == nil {
+		t.Error("cleanAndValidateUploadReportReq(empty unknown item) succeeded, want error")
+	}
+}
+
+func TestCleanAndValidateUploadReportReqLimits(t *testing.T) {
+	origLen, origCnt := maxReportItemLen, maxReportItems
+	defer func() { maxReportItemLen, maxReportItems = origLen, origCnt }()
+	maxReportItemLen, maxReportItems = 10, 3
+
+	req := &UploadReportReq{
+		UserID:   "user",
+		StoreID:  "store",
+		InStock:  []string{"eggs", "all purpose flour", "milk", "eggs", "whole wheat bread"},
+		OutStock: []string{"yeast", "butter", ""},
+		Unknown:  []string{"sugar"},
+	}
+	err := cleanAndValidateUploadReportReq(req)
+	if err == nil {
+		t.Fatal("cleanAndValidateUploadReportReq() succeeded, want error")
+	}
+	for _, want := range []string{
+		"in-stock items at indexes 1, 4 are longer than 10 characters",
+		"out-of-stock item at index 2 is empty",
+		"out-of-stock item at index 1 is over the limit of 3 items",
+		"unknown item at index 0 is over the limit of 3 items",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+
+	// Duplicates don't count toward the limit.
+	req = &UploadReportReq{UserID: "user", StoreID: "store", InStock: []string{"eggs", "milk", "Eggs"}, OutStock: []string{"eggs", "yeast"}}
+	if err := cleanAndValidateUploadReportReq(req); err != nil {
+		t.Errorf("cleanAndValidateUploadReportReq(duplicates) failed: %v", err)
+	}
+}
+
+func TestUploadReportBodyTooLarge(t *testing.T) {
+	defer func(n int) { photoBlobs.MaxBytes = n }(photoBlobs.MaxBytes)
+	photoBlobs.MaxBytes = 64
+
+	photo := strings.Repeat("A", int(maxUploadReportBytes()))
+	body := fmt.Sprintf(`{"user_id": "u", "store_id": "s", "in_stock_items": ["milk"], "photo": %q}`, photo)
+	r := httptest.NewRequest("POST", "/report/upload", strings.NewReader(body))
+	status, err := UploadReport(context.Background(), httptest.NewRecorder(), r)
+	if status != http.StatusBadRequest || err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("UploadReport() = %d, %v; want %d and a body too large error", status, err, http.StatusBadRequest)
+	}
+}
+
+func TestCleanAndValidateUploadReportReqQuantities(t *testing.T) {
+	req := &UploadReportReq{
+		UserID:     "user",
+		StoreID:    "store",
+		InStock:    []string{"Eggs", "milk", "flour"},
+		Quantities: map[string]string{" EGGS": "Low", "milk": "high"},
+	}
+	if err := cleanAndValidateUploadReportReq(req); err != nil {
+		t.Fatalf("cleanAndValidateUploadReportReq() failed: %v", err)
+	}
+	if want := map[string]string{"eggs": "low", "milk": "high"}; !reflect.DeepEqual(req.Quantities, want) {
+		t.Errorf("got quantities %v, want %v", req.Quantities, want)
+	}
+
+	// Existing clients don't send quantities.
+	req = &UploadReportReq{UserID: "user", StoreID: "store", InStock: []string{"eggs"}}
+	if err := cleanAndValidateUploadReportReq(req); err != nil || req.Quantities != nil {
+		t.Errorf("cleanAndValidateUploadReportReq(no quantities) = %v with quantities %v, want ok without", err, req.Quantities)
+	}
+
+	for _, quantities := range []map[string]string{
+		{"eggs": "plenty"},
+		{"eggs": "out"},
+		{"eggs": ""},
+		{"eggs": "2"},
+		// Not in stock.
+		{"yeast": "low"},
+	} {
+		req := &UploadReportReq{UserID: "user", StoreID: "store", InStock: []string{"eggs"}, OutStock: []string{"yeast"}, Quantities: quantities}
+		if err := cleanAndValidateUploadReportReq(req); err == nil {
+			t.Errorf("cleanAndValidateUploadReportReq() with quantities %v succeeded, want error", quantities)
+		}
+	}
+}
+
+func TestQuantityLevels(t *testing.T) {
+	aliases := map[string]string{"all purpose flour": "flour", "ap flour": "flour"}
+	got := quantityLevels(
+		[]string{"eggs", "all purpose flour", "ap flour", "milk"},
+		map[string]string{"eggs": "medium", "all purpose flour": "low", "ap flour": "high"},
+		aliases,
+	)
+	want := map[string]StockLevel{"eggs": StockLevelMedium, "flour": StockLevelLow}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("quantityLevels() = %v, want %v", got, want)
+	}
+}
+
+func TestAddStockReportUnknownKeptApart(t *testing.T) {
+	store := &Store{StoreID: "store"}
+	item := &Item{Name: "eggs"}
+	addStockReport(item, store, &User{UserID: "alice"}, &itemStock{InStock: false}, 100)
+	addStockReport(item, store, &User{UserID: "bob"}, &itemStock{Unknown: true}, 200)
+
+	if len(item.StockReports) != 2 {
+		t.Fatalf("got %d stock reports, want 2", len(item.StockReports))
+	}
+	if sr := item.StockReports[0]; sr.Unknown || sr.TimestampSec != 100 {
+		t.Errorf("unknown report merged into the out-of-stock report: %+v", sr)
+	}
+}
+
+func TestDisputeStockReports(t *testing.T) {
+	store := &Store{StoreID: "store"}
+	other := &Store{StoreID: "other"}
+	alice, bob, carol := &User{UserID: "alice"}, &User{UserID: "bob"}, &User{UserID: "carol"}
+	upload := func(item *Item, store *Store, user *User, is *itemStock, now int64) int {
+		removed := disputeStockReports(item, store, user, is)
+		addStockReport(item, store, user, is, now)
+		return removed
+	}
+	type state struct {
+		store   string
+		inStock bool
+		seenCnt int
+	}
+	net := func(item *Item) []state {
+		var res []state
+		for _, sr := range item.StockReports {
+			res = append(res, state{sr.StoreInfo.StoreID, sr.InStock, sr.SeenCnt})
+			if len(sr.UsersInfo) != sr.SeenCnt {
+				t.Errorf("report %+v has %d users, want its seen count", sr, len(sr.UsersInfo))
+			}
+		}
+		return res
+	}
+
+	// An out-of-stock report flips a report that only one user saw in stock.
+	item := &Item{Name: "toilet paper"}
+	upload(item, store, alice, &itemStock{InStock: true}, 100)
+	upload(item, other, alice, &itemStock{InStock: true}, 100)
+	if removed := upload(item, store, bob, &itemStock{InStock: false}, 200); removed != 1 {
+		t.Errorf("got %d reports removed, want the in-stock report removed", removed)
+	}
+	if got, want := net(item), []state{{"other", true, 1}, {"store", false, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after in stock then out of stock, got %+v, want %+v", got, want)
+	}
+
+	// A report confirmed by two users only loses the oldest confirmation.
+	item = &Item{Name: "flour"}
+	upload(item, store, alice, &itemStock{InStock: true}, 100)
+	upload(item, store, bob, &itemStock{InStock: true}, 150)
+	upload(item, store, carol, &itemStock{InStock: false}, 200)
+	if got, want := net(item), []state{{"store", true, 1}, {"store", false, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after two in stock then out of stock, got %+v, want %+v", got, want)
+	}
+	if got := item.StockReports[0].UsersInfo[0].UserID; got != "bob" {
+		t.Errorf("got in-stock report confirmed by %q, want bob, the latest", got)
+	}
+	// Bob changes their mind, which removes their own confirmation.
+	upload(item, store, bob, &itemStock{InStock: false}, 250)
+	if got, want := net(item), []state{{"store", false, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after bob changed their mind, got %+v, want %+v", got, want)
+	}
+
+	// Unknown reports don't dispute.
+	if removed := upload(item, store, alice, &itemStock{Unknown: true}, 300); removed != 0 || len(item.StockReports) != 2 {
+		t.Errorf("an unknown report removed %d reports leaving %d, want none removed", removed, len(item.StockReports))
+	}
+}
+
+func TestRetractStockReports(t *testing.T) {
+	store := &Store{StoreID: "store"}
+	other := &Store{StoreID: "other"}
+	item := &Item{Name: "eggs"}
+	addStockReport(item, store, &User{UserID: "alice"}, &itemStock{Name: "eggs", InStock: true}, 100)
+	addStockReport(item, store, &User{UserID: "bob"}, &itemStock{Name: "eggs", InStock: true}, 200)
+	addStockReport(item, store, &User{UserID: "carol"}, &itemStock{Name: "eggs", InStock: false}, 300)
+	addStockReport(item, other, &User{UserID: "alice"}, &itemStock{Name: "eggs", InStock: true}, 400)
+
+	// Alice's report is one of two on the in-stock report, which stays for bob.
+	if n := retractStockReports(item, "store", "alice"); n != 1 {
+		t.Errorf("retracted %d of alice's reports at the store, want 1", n)
+	}
+	if len(item.StockReports) != 3 {
+		t.Fatalf("got %d reports, want 3", len(item.StockReports))
+	}
+	if sr := item.StockReports[0]; sr.SeenCnt != 1 || len(sr.UsersInfo) != 1 || sr.UsersInfo[0].UserID != "bob" {
+		t.Errorf("got in-stock report seen %d times by %+v, want once by bob", sr.SeenCnt, sr.UsersInfo)
+	}
+	if sr := item.StockReports[2]; sr.StoreInfo.StoreID != "other" || sr.SeenCnt != 1 {
+		t.Errorf("got report %+v, want alice's report at the other store untouched", sr)
+	}
+
+	// Carol's report is the only one of its stock state, so it's removed.
+	if n := retractStockReports(item, "store", "carol"); n != 1 {
+		t.Errorf("retracted %d of carol's reports, want 1", n)
+	}
+	if len(item.StockReports) != 2 || !item.StockReports[0].InStock || !item.StockReports[1].InStock {
+		t.Errorf("got reports %+v, want carol's out-of-stock report removed", item.StockReports)
+	}
+
+	// Nothing left to retract.
+	if n := retractStockReports(item, "store", "carol"); n != 0 {
+		t.Errorf("retracted %d reports carol no longer has, want 0", n)
+	}
+	if n := retractStockReports(item, "store", "dave"); n != 0 {
+		t.Errorf("retracted %d reports of a user who made none, want 0", n)
+	}
+}
+
+func TestRunItemUploads(t *testing.T) {
+	items := make([]*itemStock, 10)
+	for i := range items {
+		items[i] = &itemStock{Name: fmt.Sprintf("item%d", i)}
+	}
+	for _, workers := range []int{1, 4} {
+		var mu sync.Mutex
+		updated := make(map[string]bool)
+		errFreq, err := runItemUploads(context.Background(), items, workers, func(ctx context.Context, is *itemStock) error {
+			mu.Lock()
+			updated[is.Name] = true
+			mu.Unlock()
+			// The later failure finishes first, but the error of the earlier item is kept.
+			switch is.Name {
+			case "item3":
+				time.Sleep(10 * time.Millisecond)
+				return fmt.Errorf("failed item3")
+			case "item7":
+				return fmt.Errorf("failed item7")
+			}
+			return nil
+		})
+		if len(updated) != len(items) {
+			t.Errorf("%d workers updated %d items, want all %d despite failures", workers, len(updated), len(items))
+		}
+		if errFreq != 2 || err == nil || err.Error() != "failed item3" {
+			t.Errorf("%d workers got %d failures and %v, want 2 and the first item's error", workers, errFreq, err)
+		}
+	}
+}
+
+// benchmarkReportUpload uploads a 50-item report whose item transactions each take a
+// millisecond round trip to storage.
+func benchmarkReportUpload(b *testing.B, workers int) {
+	items := make([]*itemStock, 50)
+	for i := range items {
+		items[i] = &itemStock{Name: fmt.Sprintf("item%d", i), InStock: true}
+	}
+	update := func(ctx context.Context, is *itemStock) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		runItemUploads(context.Background(), items, workers, update)
+	}
+}
+
+func BenchmarkReportUploadSerial(b *testing.B)     { benchmarkReportUpload(b, 1) }
+func BenchmarkReportUploadConcurrent(b *testing.B) { benchmarkReportUpload(b, uploadWorkers) }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const requestTimeoutHeader = "X-Request-Timeout-Ms"
+
+// maxRequestTimeout caps the deadline a client can ask for with the X-Request-Timeout-Ms
+// header. It is also the deadline of requests that don't set the header.
+var maxRequestTimeout = time.Duration(positiveIntFromEnv("MAX_REQUEST_TIMEOUT_MS", 30000)) * time.Millisecond
+
+// requestTimeoutMiddleware bounds each request's context by the timeout requested in the
+// X-Request-Timeout-Ms header, capped at maxRequestTimeout. Handlers must use r.Context()
+// so that datastore and Maps calls give up once the deadline passes.
+func requestTimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout, err := parseRequestTimeout(r.Header.Get(requestTimeoutHeader))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The read timeouts of the server, so that clients that trickle in a request can't tie up
+// connections and handlers. readHeaderTimeout bounds reading the headers and readTimeout the
+// whole request, including the handler's reads of the body, set with the
+// READ_HEADER_TIMEOUT_MS and READ_TIMEOUT_MS env variables.
+var (
+	readHeaderTimeout = time.Duration(positiveIntFromEnv("READ_HEADER_TIMEOUT_MS", 5000)) * time.Millisecond
+	readTimeout       = time.Duration(positiveIntFromEnv("READ_TIMEOUT_MS", 30000)) * time.Millisecond
+)
+
+// errBodyReadTimeout is returned by DecodeReq if the client was still sending the request
+// body when readTimeout passed. writeError replies to it with 408.
+var errBodyReadTimeout = errors.New("request body read timed out")
+
+// newServer returns the server for the handler, with the read timeouts.
+func newServer(addr string, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+	}
+}
+
+func parseRequestTimeout(v string) (time.Duration, error) {
+	if v == "" {
+		return maxRequestTimeout, nil
+	}
+	ms, err := strconv.Atoi(v)
+	if err != nil || ms <= 0 {
+		return 0, fmt.Errorf("%s header must be a positive integer", requestTimeoutHeader)
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	if timeout > maxRequestTimeout {
+		timeout = maxRequestTimeout
+	}
+	return timeout, nil
+}
+
+// staticCacheMaxAge is how long clients may cache the responses of endpoints whose data
+// changes slowly, such as the item tokens, set with the STATIC_CACHE_MAX_AGE_SEC env
+// variable.
+var staticCacheMaxAge = time.Duration(positiveIntFromEnv("STATIC_CACHE_MAX_AGE_SEC", 3600)) * time.Second
+
+// noCacheMiddleware marks every response as not cacheable. Endpoints wrapped by cacheable
+// override it.
+func noCacheMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cacheable wraps the handler of an endpoint whose responses may be cached by the client for
+// staticCacheMaxAge. The endpoints are POSTs whose responses depend on the request body,
+// which shared caches don't key on, so the responses are private. Error responses are still
+// not cacheable; see writeError.
+func cacheable(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(staticCacheMaxAge.Seconds())))
+		h(w, r)
+	}
+}
+
+// writeError replies with the handler's error and status, with 504 if the request's deadline
+// passed while handling it, or with 408 if the client was too slow to send the request body.
+func writeError(ctx context.Context, w http.ResponseWriter, status int, err error) {
+	if ctx.Err() == context.DeadlineExceeded {
+		status = http.StatusGatewayTimeout
+	} else if err == errBodyReadTimeout {
+		status = http.StatusRequestTimeout
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Error(w, err.Error(), status)
+}
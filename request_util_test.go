@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowBackend stands in for a handler whose storage call takes a second.
+func slowBackend(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	select {
+	case <-ctx.Done():
+		return http.StatusInternalServerError, fmt.Errorf("failed to query storage: %v", ctx.Err())
+	case <-time.After(time.Second):
+		return http.StatusOK, nil
+	}
+}
+
+func slowHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if status, err := slowBackend(ctx, w, r); err != nil {
+		writeError(ctx, w, status, err)
+	}
+}
+
+func TestRequestTimeoutHeader(t *testing.T) {
+	h := requestTimeoutMiddleware(http.HandlerFunc(slowHandler))
+
+	for _, tc := range []struct {
+		header string
+		want   int
+	}{
+		{"20", http.StatusGatewayTimeout},
+		{"abc", http.StatusBadRequest},
+		{"-5", http.StatusBadRequest},
+	} {
+		req := httptest.NewRequest("POST", "/item/query", nil)
+		req.Header.Set(requestTimeoutHeader, tc.header)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("header %q: got status %d, want %d", tc.header, rec.Code, tc.want)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("header %q: request took %v, want it cut off early", tc.header, elapsed)
+		}
+	}
+}
+
+func TestParseRequestTimeoutCapped(t *testing.T) {
+	got, err := parseRequestTimeout(fmt.Sprint(maxRequestTimeout.Milliseconds() * 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != maxRequestTimeout {
+		t.Errorf("got timeout %v, want it capped at %v", got, maxRequestTimeout)
+	}
+
+	got, err = parseRequestTimeout("")
+	if err != nil || got != maxRequestTimeout {
+		t.Errorf("got timeout %v, %v for missing header, want %v", got, err, maxRequestTimeout)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	fail := func(w http.ResponseWriter, r *http.Request) {
+		writeError(r.Context(), w, http.StatusBadRequest, fmt.Errorf("bad request"))
+	}
+
+	for _, tc := range []struct {
+		name string
+		h    http.HandlerFunc
+		want string
+	}{
+		{"static", cacheable(ok), fmt.Sprintf("private, max-age=%d", int(staticCacheMaxAge.Seconds()))},
+		{"static error", cacheable(fail), "no-store"},
+		{"dynamic", ok, "no-store"},
+	} {
+		w := httptest.NewRecorder()
+		noCacheMiddleware(tc.h).ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+		if got := w.Header().Get("Cache-Control"); got != tc.want {
+			t.Errorf("%s: got Cache-Control %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	orig := readTimeout
+	defer func() { readTimeout = orig }()
+	readTimeout = 200 * time.Millisecond
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		var req QueryUserReq
+		if err := DecodeReq(r.Body, &req); err != nil {
+			writeError(ctx, w, http.StatusBadRequest, err)
+		}
+	})
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config = newServer("", h)
+	srv.Start()
+	defer srv.Close()
+
+	// The client sends the headers and part of the body, then stalls.
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	start := time.Now()
+	fmt.Fprint(conn, "POST /user/query HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\n{\"user_id\": \"")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read response to a stalled body: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("got status %d for a stalled body, want %d", resp.StatusCode, http.StatusRequestTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("stalled body took %v to cut off, want about %v", elapsed, readTimeout)
+	}
+
+	resp, err = http.Post(srv.URL+"/user/query", "application/json", strings.NewReader(`{"user_id": "u"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d for a prompt body, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+	"googlemaps.github.io/maps"
+)
+
+// revetDelay is the pause between Places lookups so a revet doesn't exhaust the quota.
+var revetDelay = 100 * time.Millisecond
+
+// ******************************************
+// ** BEGIN RevetStores
+// ******************************************
+
+type RevetStoresReq struct {
+	// Cursor resumes a revet where the previous request left off.
+	Cursor string `json:"cursor"`
+}
+
+type RevetStoresResp struct {
+	Progress *BatchProgress  `json:"progress"`
+	Changes  []*StoreChange  `json:"changes"`
+	Failures []*StoreFailure `json:"failures"`
+	// NextCursor resumes the revet if it yielded before every store was checked.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// StoreChange is a store whose Places details changed since it was vetted.
+type StoreChange struct {
+	StoreID string `json:"store_id"`
+	Before  *Store `json:"before"`
+	After   *Store `json:"after"`
+}
+
+type StoreFailure struct {
+	StoreID string `json:"store_id"`
+	Error   string `json:"error"`
+}
+
+// RevetStores looks up the stores in Places by their place ID, in batches limited by
+// jobLimits, and updates the stores whose name, address, or coordinates changed. It is an
+// admin endpoint. Stock reports keep the store info they were made with.
+func RevetStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req RevetStoresReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	var cursor datastore.Cursor
+	if req.Cursor != "" {
+		var err error
+		if cursor, err = datastore.DecodeCursor(req.Cursor); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("cursor is invalid: %v", err)
+		}
+	}
+
+	places, err := PlacesClient()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	resp := &RevetStoresResp{
+		Changes:  make([]*StoreChange, 0),
+		Failures: make([]*StoreFailure, 0),
+	}
+	resp.Progress, err = runBatches(ctx, "revet stores", jobLimits, func(ctx context.Context, size int) (int, bool, error) {
+		stores, cursors, err := loadStoreBatch(ctx, client, cursor, size)
+		if err != nil {
+			return 0, false, err
+		}
+		// Only move past the stores that were looked up, so that a revet cut short by the
+		// request deadline resumes with the rest of the batch.
+		changes, failures, checked := revetStoreBatch(ctx, places, stores, revetDelay)
+		if checked > 0 {
+			cursor = cursors[checked-1]
+		}
+		for _, c := range changes {
+			if _, err := client.Put(ctx, nameKey(ctx, StoreKind, c.StoreID), c.After); err != nil {
+				failures = append(failures, &StoreFailure{
+					StoreID: c.StoreID,
+					Error:   fmt.Sprintf("failed to update store in storage: %v", err),
+				})
+			}
+		}
+		resp.Changes = append(resp.Changes, changes...)
+		resp.Failures = append(resp.Failures, failures...)
+		return checked, checked == len(stores) && len(stores) < size, nil
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !resp.Progress.Done {
+		resp.NextCursor = cursor.String()
+	}
+
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END RevetStores
+// ******************************************
+
+// revetStoreBatch looks up each store in Places, pausing delay between lookups, and returns
+// the stores that changed, the ones that couldn't be looked up, and how many of the stores it
+// checked before ctx was done. Unvetted stores aren't in Places and are skipped.
+func revetStoreBatch(ctx context.Context, places placesClient, stores []*Store, delay time.Duration) ([]*StoreChange, []*StoreFailure, int) {
+	var changes []*StoreChange
+	var failures []*StoreFailure
+	lookups := 0
+	for i, st := range stores {
+		if st.Unvetted {
+			continue
+		}
+		lookups++
+		if lookups > 1 {
+			select {
+			case <-ctx.Done():
+				return changes, failures, i
+			case <-time.After(delay):
+			}
+		}
+		after, err := revetStore(ctx, places, st)
+		if err != nil {
+			failures = append(failures, &StoreFailure{StoreID: st.StoreID, Error: err.Error()})
+			continue
+		}
+		if *after != *st {
+			changes = append(changes, &StoreChange{StoreID: st.StoreID, Before: st, After: after})
+		}
+	}
+	return changes, failures, len(stores)
+}
+
+// loadStoreBatch fetches up to size stores starting at the cursor, along with the cursor
+// after each of them, so that a job that stops partway through the batch can resume after
+// the last store it handled.
+func loadStoreBatch(ctx context.Context, client *datastore.Client, cursor datastore.Cursor, size int) ([]*Store, []datastore.Cursor, error) {
+	var stores []*Store
+	var cursors []datastore.Cursor
+	it := client.Run(ctx, newQuery(ctx, StoreKind).Start(cursor).Limit(size))
+	for {
+		var st Store
+		_, err := it.Next(&st)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to query stores: %v", err)
+		}
+		after, err := it.Cursor()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get store cursor: %v", err)
+		}
+		stores = append(stores, &st)
+		cursors = append(cursors, after)
+	}
+	return stores, cursors, nil
+}
+
+// revetStore returns the store with its current name, address, and coordinates in Places.
+func revetStore(ctx context.Context, places placesClient, st *Store) (*Store, error) {
+	details, err := places.PlaceDetails(ctx, &maps.PlaceDetailsRequest{
+		PlaceID: storePlaceID(st),
+		Fields: []maps.PlaceDetailsFieldMask{
+			maps.PlaceDetailsFieldMaskName,
+			maps.PlaceDetailsFieldMaskFormattedAddress,
+			maps.PlaceDetailsFieldMaskGeometry,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up place: %v", err)
+	}
+	return &Store{
+		StoreID:   st.StoreID,
+		Name:      details.Name,
+		Addr:      strings.TrimSuffix(details.FormattedAddress, ", United States"),
+		Lat:       details.Geometry.Location.Lat,
+		Long:      details.Geometry.Location.Lng,
+		PlaceID:   st.PlaceID,
+		StoreType: st.StoreType,
+	}, nil
+}
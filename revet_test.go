@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"googlemaps.github.io/maps"
+)
+
+// fakePlaces serves place details from a map keyed by place ID, and the found candidates for
+// any text search.
+type fakePlaces struct {
+	details map[string]maps.PlaceDetailsResult
+	found   *maps.FindPlaceFromTextResponse
+}
+
+func (f *fakePlaces) FindPlaceFromText(ctx context.Context, r *maps.FindPlaceFromTextRequest) (maps.FindPlaceFromTextResponse, error) {
+	if f.found == nil {
+		return maps.FindPlaceFromTextResponse{}, fmt.Errorf("not implemented")
+	}
+	return *f.found, nil
+}
+
+func (f *fakePlaces) PlaceDetails(ctx context.Context, r *maps.PlaceDetailsRequest) (maps.PlaceDetailsResult, error) {
+	d, ok := f.details[r.PlaceID]
+	if !ok {
+		return maps.PlaceDetailsResult{}, fmt.Errorf("NOT_FOUND")
+	}
+	return d, nil
+}
+
+func placeDetails(name, addr string, lat, lng float64) maps.PlaceDetailsResult {
+	var d maps.PlaceDetailsResult
+	d.Name = name
+	d.FormattedAddress = addr + ", United States"
+	d.Geometry.Location = maps.LatLng{Lat: lat, Lng: lng}
+	return d
+}
+
+func TestRevetStoreBatch(t *testing.T) {
+	places := &fakePlaces{details: map[string]maps.PlaceDetailsResult{
+		"same":    placeDetails("QFC", "500 Broadway E, Seattle, WA 98102", 47.62, -122.32),
+		"renamed": placeDetails("Safeway Fuel Station", "1410 E John St, Seattle, WA 98112", 47.62, -122.31),
+		"moved":   placeDetails("Trader Joe's", "1700 Madison St, Seattle, WA 98122", 47.61, -122.31),
+	}}
+	stores := []*Store{
+		{StoreID: "same", Name: "QFC", Addr: "500 Broadway E, Seattle, WA 98102", Lat: 47.62, Long: -122.32},
+		{StoreID: "renamed", Name: "Safeway", Addr: "1410 E John St, Seattle, WA 98112", Lat: 47.62, Long: -122.31},
+		{StoreID: "moved", Name: "Trader Joe's", Addr: "1700 E Madison St, Seattle, WA 98122", Lat: 47.6, Long: -122.3},
+		{StoreID: "closed", Name: "Bartell Drugs", Addr: "600 Pine St, Seattle, WA 98101", Lat: 47.61, Long: -122.33},
+		// Not in Places, so not looked up.
+		{StoreID: "b3e1c2d4", Name: "Pike Place Market Stall", Addr: "85 Pike St, Seattle, WA 98101", Lat: 47.61, Long: -122.34, Unvetted: true},
+	}
+
+	changes, failures, checked := revetStoreBatch(context.Background(), places, stores, 0)
+	if checked != len(stores) {
+		t.Errorf("checked %d stores, want all %d", checked, len(stores))
+	}
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want 2", len(changes))
+	}
+	if c := changes[0]; c.StoreID != "renamed" || c.After.Name != "Safeway Fuel Station" || c.Before.Name != "Safeway" {
+		t.Errorf("got change %+v -> %+v, want the renamed store", c.Before, c.After)
+	}
+	if c := changes[1]; c.StoreID != "moved" || c.After.Addr != "1700 Madison St, Seattle, WA 98122" || c.After.Lat != 47.61 {
+		t.Errorf("got change %+v -> %+v, want the moved store", c.Before, c.After)
+	}
+	if len(failures) != 1 || failures[0].StoreID != "closed" {
+		t.Errorf("got failures %+v, want only the closed store", failures)
+	}
+}
+
+func TestRevetStoreBatchCanceled(t *testing.T) {
+	places := &fakePlaces{details: map[string]maps.PlaceDetailsResult{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Only the first store is looked up; its lookup fails since the fake doesn't know it.
+	_, failures, checked := revetStoreBatch(ctx, places, []*Store{{StoreID: "a"}, {StoreID: "b"}}, time.Hour)
+	if len(failures) != 1 {
+		t.Errorf("looked up %d stores after the request was canceled, want 1", len(failures))
+	}
+	// The cursor only moves past the first store, so the next request revets the second.
+	if checked != 1 {
+		t.Errorf("checked %d stores after the request was canceled, want 1", checked)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+// maxShoppingListItems caps the number of items in a shopping list query, set with the
+// MAX_SHOPPING_LIST_ITEMS env variable.
+var maxShoppingListItems = positiveIntFromEnv("MAX_SHOPPING_LIST_ITEMS", 50)
+
+// ******************************************
+// ** BEGIN QueryShoppingNearest
+// ******************************************
+
+type QueryShoppingNearestReq struct {
+	UserID    string   `json:"user_id"`
+	ItemNames []string `json:"item_names"`
+	// ZipCode defaults to the user's zip code.
+	ZipCode string `json:"zip_code"`
+}
+
+type QueryShoppingNearestResp []*NearestInStock
+
+// NearestInStock is the store nearest the user where an item's most recent report says it's
+// in stock. Store is null if the item isn't reported in stock anywhere.
+type NearestInStock struct {
+	ItemName      string  `json:"itemName"`
+	Store         *Store  `json:"store"`
+	DistanceMiles float64 `json:"distanceMiles"`
+	SeenCnt       int     `json:"seenCount"`
+	SecondsAgo    int     `json:"secondsAgo"`
+	ReportedAgo   string  `json:"reportedAgo"`
+}
+
+// QueryShoppingNearest fetches, for each item in a shopping list, the nearest store where it's
+// in stock. It loads the listed items in one batch rather than querying each one like
+// QueryItems does. The results are in the order of the list.
+func QueryShoppingNearest(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryShoppingNearestReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryShoppingNearestReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	if req.ZipCode == "" {
+		req.ZipCode = u.ZipCode
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	aliases, err := getItemAliases(ctx, client, req.ItemNames)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	canonical := make([]string, len(req.ItemNames))
+	for i, name := range req.ItemNames {
+		canonical[i] = name
+		if c, ok := aliases[name]; ok {
+			canonical[i] = c
+		}
+	}
+	items, err := getItemsInStorage(ctx, client, canonical)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp, err := nearestInStock(req.ItemNames, canonical, items, req.ZipCode, lookupZipCode, time.Now().Unix())
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryShoppingNearestReq(req *QueryShoppingNearestReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(req.ItemNames))
+	seen := make(map[string]bool, len(req.ItemNames))
+	for _, name := range req.ItemNames {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("missing item names")
+	}
+	if len(names) > maxShoppingListItems {
+		return fmt.Errorf("shopping list has %d items, the max is %d", len(names), maxShoppingListItems)
+	}
+	req.ItemNames = names
+	return nil
+}
+
+// ******************************************
+// ** END QueryShoppingNearest
+// ******************************************
+
+// lookupZipCode returns the coordinates of the zip code.
+func lookupZipCode(zipCode string) (coord, bool) {
+	coords, ok := zipCodeToLatLong[zipCode]
+	return coords, ok
+}
+
+// nearestInStock finds, for each listed item, the nearest store whose latest report on the
+// item says it's in stock. names are the listed names and canonical the names the items are
+// stored under. The zip code is resolved to coordinates once for the whole list. Stores at
+// the same distance are ordered by the most recent report.
+func nearestInStock(names, canonical []string, items map[string]*Item, zipCode string, resolve func(string) (coord, bool), now int64) (QueryShoppingNearestResp, error) {
+	coords, ok := resolve(zipCode)
+	if !ok {
+		return nil, fmt.Errorf("zip code %q is not supported", zipCode)
+	}
+
+	resp := make(QueryShoppingNearestResp, 0, len(names))
+	for i, name := range names {
+		res := &NearestInStock{ItemName: name}
+		resp = append(resp, res)
+		item, ok := items[canonical[i]]
+		if !ok {
+			continue
+		}
+		var best *StockReport
+		for _, sr := range latestStockReports(item) {
+			if !sr.InStock {
+				continue
+			}
+			d := Distance(sr.StoreInfo.Lat, sr.StoreInfo.Long, coords.Lat, coords.Long)
+			if best == nil || d < res.DistanceMiles || (d == res.DistanceMiles && sr.TimestampSec > best.TimestampSec) {
+				best = sr
+				res.DistanceMiles = d
+			}
+		}
+		if best == nil {
+			continue
+		}
+		secondsAgo := int(now - best.TimestampSec)
+		res.Store = best.StoreInfo
+		res.SeenCnt = best.SeenCnt
+		res.SecondsAgo = secondsAgo
+		res.ReportedAgo = humanizeAge(secondsAgo)
+	}
+	return resp, nil
+}
+
+// getItemsInStorage fetches the named items, maxGetMultiKeys per lookup, keyed by name. Names without an
+// item are left out.
+func getItemsInStorage(ctx context.Context, client *datastore.Client, names []string) (map[string]*Item, error) {
+	ctx, span := startClientSpan(ctx, "datastore.get Item")
+	defer span.Finish()
+	keys := make([]*datastore.Key, len(names))
+	for i, name := range names {
+		keys[i] = nameKey(ctx, ItemKind, name)
+	}
+	found := make([]Item, len(names))
+	err := getMulti(ctx, client, keys, found)
+	merr, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		span.SetError(err)
+		return nil, fmt.Errorf("failed to get items from storage: %v", err)
+	}
+
+	items := make(map[string]*Item, len(names))
+	var loaded []*Item
+	for i, name := range names {
+		if isMultiErr && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue // nobody reported the item yet
+			}
+			return nil, fmt.Errorf("failed to get item %q from storage: %v", name, merr[i])
+		}
+		if featureEnabled(featureDedupUsersOnLoad) {
+			dedupUsersInfo(&found[i])
+		}
+		items[name] = &found[i]
+		loaded = append(loaded, &found[i])
+	}
+	if err := resolveStoreRefsInStorage(ctx, client, loaded); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
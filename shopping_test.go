@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNearestInStock(t *testing.T) {
+	home := coord{Lat: 47.61, Long: -122.33}
+	near := &Store{StoreID: "near", Name: "Near", Lat: 47.62, Long: -122.33}
+	far := &Store{StoreID: "far", Name: "Far", Lat: 47.80, Long: -122.33}
+	now := int64(10000)
+	items := map[string]*Item{
+		"flour": {Name: "flour", StockReports: []*StockReport{
+			{StoreInfo: far, InStock: true, TimestampSec: 9000, SeenCnt: 3},
+			{StoreInfo: near, InStock: true, TimestampSec: 8000, SeenCnt: 1},
+		}},
+		"eggs": {Name: "eggs", StockReports: []*StockReport{
+			{StoreInfo: far, InStock: true, TimestampSec: 9000, SeenCnt: 2},
+			// The near store's latest report says eggs are out of stock.
+			{StoreInfo: near, InStock: true, TimestampSec: 8000},
+			{StoreInfo: near, TimestampSec: 9500},
+		}},
+		"yeast": {Name: "yeast", StockReports: []*StockReport{
+			{StoreInfo: near, TimestampSec: 9000},
+		}},
+	}
+
+	resolutions := 0
+	resolve := func(zipCode string) (coord, bool) {
+		resolutions++
+		return home, zipCode == "98101"
+	}
+	names := []string{"flour", "eggs", "yeast", "bread flour"}
+	canonical := []string{"flour", "eggs", "yeast", "flour"}
+	resp, err := nearestInStock(names, canonical, items, "98101", resolve, now)
+	if err != nil {
+		t.Fatalf("nearestInStock() = %v", err)
+	}
+	if resolutions != 1 {
+		t.Errorf("resolved the zip code %d times, want once for the whole list", resolutions)
+	}
+
+	got := make([]string, len(resp))
+	for i, res := range resp {
+		storeID := "none"
+		if res.Store != nil {
+			storeID = res.Store.StoreID
+		}
+		got[i] = fmt.Sprintf("%s@%s", res.ItemName, storeID)
+	}
+	if want := "flour@near eggs@far yeast@none bread flour@near"; strings.Join(got, " ") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, " "), want)
+	}
+	if res := resp[1]; res.SeenCnt != 2 || res.SecondsAgo != 1000 || res.DistanceMiles == 0 {
+		t.Errorf("got eggs %+v, want the far store's report from 1000 seconds ago", res)
+	}
+
+	if _, err := nearestInStock(names, canonical, items, "00000", resolve, now); err == nil {
+		t.Error("nearestInStock() with an unsupported zip code = nil, want error")
+	}
+}
+
+func TestCleanAndValidateQueryShoppingNearestReq(t *testing.T) {
+	req := &QueryShoppingNearestReq{UserID: "u", ItemNames: []string{" Flour", "flour", "", "eggs"}}
+	if err := cleanAndValidateQueryShoppingNearestReq(req); err != nil {
+		t.Fatalf("cleanAndValidateQueryShoppingNearestReq() = %v", err)
+	}
+	if got := strings.Join(req.ItemNames, ","); got != "flour,eggs" {
+		t.Errorf("got item names %q, want %q", got, "flour,eggs")
+	}
+
+	orig := maxShoppingListItems
+	defer func() { maxShoppingListItems = orig }()
+	maxShoppingListItems = 1
+	req = &QueryShoppingNearestReq{UserID: "u", ItemNames: []string{"flour", "eggs"}}
+	if err := cleanAndValidateQueryShoppingNearestReq(req); err == nil {
+		t.Error("cleanAndValidateQueryShoppingNearestReq() over the cap = nil, want error")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsCountsTTL is how long QueryStatsCounts serves cached counts before recounting.
+const statsCountsTTL = time.Minute
+
+var statsCounts = &countsCache{ttl: statsCountsTTL}
+
+// statsPageLimits are the page limits of the stats endpoints, set with the
+// STATS_DEFAULT_LIMIT and STATS_MAX_LIMIT env variables.
+var statsPageLimits = pageLimitsFromEnv("STATS", pageLimits{Default: 25, Max: 100})
+
+// ******************************************
+// ** BEGIN QueryStatsCounts
+// ******************************************
+
+type QueryStatsCountsResp struct {
+	Users  int64 `json:"users"`
+	Stores int64 `json:"stores"`
+	Items  int64 `json:"items"`
+}
+
+// QueryStatsCounts fetches the total number of users, stores, and items. Counts are cached
+// for statsCountsTTL since they change slowly.
+func QueryStatsCounts(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	resp, err := statsCounts.get(ctx, time.Now(), loadStatsCounts)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END QueryStatsCounts
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryItemQueryStats
+// ******************************************
+
+type QueryItemQueryStatsReq struct {
+	// ZipCode limits the counts to queries by users in the zip code. All queries count if it
+	// is empty.
+	ZipCode string `json:"zip_code"`
+	PageReq
+}
+
+type QueryItemQueryStatsResp []*ItemQueryStat
+
+// ItemQueryStat is the number of times users queried an item.
+type ItemQueryStat struct {
+	ItemName string `json:"item_name"`
+	QueryCnt int64  `json:"query_count"`
+}
+
+// QueryItemQueryStats fetches the items users query most, most queried first. Counts are
+// flushed to storage periodically, so the latest queries may not be counted yet.
+func QueryItemQueryStats(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryItemQueryStatsReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryItemQueryStatsReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	counts, err := loadAllItemQueryCounts(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	stats := itemQueryStats(counts, req.ZipCode)
+	start, end, pg := pageBounds(len(stats), req.PageReq)
+	resp := stats[start:end]
+	if err := EncodePage(w, req.PageReq, pg, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryItemQueryStatsReq(req *QueryItemQueryStatsReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	return req.PageReq.cleanAndValidate(statsPageLimits)
+}
+
+// itemQueryStats totals the counts of each item, from users in zipCode if it isn't empty,
+// most queried first. Hidden items are left out.
+func itemQueryStats(counts []*ItemQueryCount, zipCode string) QueryItemQueryStatsResp {
+	totals := make(map[string]int64)
+	for _, c := range counts {
+		if (zipCode != "" && c.ZipCode != zipCode) || hiddenItems.has(c.ItemName) {
+			continue
+		}
+		totals[c.ItemName] += c.Count
+	}
+	stats := make(QueryItemQueryStatsResp, 0, len(totals))
+	for name, n := range totals {
+		stats = append(stats, &ItemQueryStat{ItemName: name, QueryCnt: n})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].QueryCnt != stats[j].QueryCnt {
+			return stats[i].QueryCnt > stats[j].QueryCnt
+		}
+		return stats[i].ItemName < stats[j].ItemName
+	})
+	return stats
+}
+
+// ******************************************
+// ** END QueryItemQueryStats
+// ******************************************
+
+func loadStatsCounts(ctx context.Context) (*QueryStatsCountsResp, error) {
+	var resp QueryStatsCountsResp
+	for _, c := range []struct {
+		kind string
+		dst  *int64
+	}{
+		{UserKind, &resp.Users},
+		{StoreKind, &resp.Stores},
+		{ItemKind, &resp.Items},
+	} {
+		n, err := countKind(ctx, c.kind)
+		if err != nil {
+			return nil, err
+		}
+		*c.dst = n
+	}
+	return &resp, nil
+}
+
+// countKind returns the number of entities of the kind in storage.
+//
+// The datastore client this server is pinned to predates aggregation queries, so the count
+// is taken with a keys-only query: storage returns keys in batches and no entity is read or
+// decoded. Once the client is upgraded, this should run an aggregation count query and keep
+// the keys-only count as the fallback for the emulator.
+func countKind(ctx context.Context, kind string) (int64, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	n, err := client.Count(ctx, newQuery(ctx, kind).KeysOnly())
+	if err != nil {
+		return 0, fmt.Errorf("failed to count %s entities in storage: %v", kind, err)
+	}
+	return int64(n), nil
+}
+
+// countsCache holds the most recently loaded counts of each namespace until they are older
+// than ttl.
+type countsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*countsCacheEntry
+}
+
+type countsCacheEntry struct {
+	counts   *QueryStatsCountsResp
+	loadedAt time.Time
+}
+
+func (c *countsCache) get(ctx context.Context, now time.Time, load func(context.Context) (*QueryStatsCountsResp, error)) (*QueryStatsCountsResp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ns := namespaceFromContext(ctx)
+	if e, ok := c.entries[ns]; ok && now.Sub(e.loadedAt) < c.ttl {
+		return e.counts, nil
+	}
+	counts, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*countsCacheEntry)
+	}
+	c.entries[ns] = &countsCacheEntry{counts: counts, loadedAt: now}
+	return counts, nil
+}
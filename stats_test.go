@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestCountsCache(t *testing.T) {
+	loads := 0
+	load := func(context.Context) (*QueryStatsCountsResp, error) {
+		loads++
+		return &QueryStatsCountsResp{Users: int64(loads), Stores: 2, Items: 3}, nil
+	}
+	c := &countsCache{ttl: time.Minute}
+	ctx := context.Background()
+	start := time.Unix(1000, 0)
+
+	for _, tc := range []struct {
+		now       time.Time
+		wantUsers int64
+	}{
+		{start, 1},
+		{start.Add(59 * time.Second), 1}, // still cached
+		{start.Add(time.Minute), 2},      // expired, reloaded
+		{start.Add(90 * time.Second), 2},
+	} {
+		got, err := c.get(ctx, tc.now, load)
+		if err != nil {
+			t.Fatalf("get() failed: %v", err)
+		}
+		if got.Users != tc.wantUsers {
+			t.Errorf("get() at %v returned users = %d, want %d", tc.now.Sub(start), got.Users, tc.wantUsers)
+		}
+	}
+}
+
+func TestCountsCachePerNamespace(t *testing.T) {
+	load := func(ctx context.Context) (*QueryStatsCountsResp, error) {
+		if namespaceFromContext(ctx) == "seattle" {
+			return &QueryStatsCountsResp{Users: 1}, nil
+		}
+		return &QueryStatsCountsResp{Users: 2}, nil
+	}
+	c := &countsCache{ttl: time.Minute}
+	now := time.Unix(1000, 0)
+
+	seattle, err := c.get(withNamespace(context.Background(), "seattle"), now, load)
+	if err != nil {
+		t.Fatalf("get() failed: %v", err)
+	}
+	portland, err := c.get(withNamespace(context.Background(), "portland"), now, load)
+	if err != nil {
+		t.Fatalf("get() failed: %v", err)
+	}
+	if seattle.Users != 1 || portland.Users != 2 {
+		t.Errorf("got users %d and %d, want each namespace's own count 1 and 2", seattle.Users, portland.Users)
+	}
+}
+
+func TestItemQueryStats(t *testing.T) {
+	hiddenItems.set("flour", true)
+	defer hiddenItems.set("flour", false)
+
+	counts := []*ItemQueryCount{
+		{ItemName: "milk", ZipCode: "98101", Count: 2},
+		{ItemName: "milk", ZipCode: "98109", Count: 3},
+		{ItemName: "eggs", ZipCode: "98101", Count: 5},
+		{ItemName: "bread", ZipCode: "98109", Count: 1},
+		{ItemName: "flour", ZipCode: "98101", Count: 9},
+	}
+
+	got := itemQueryStats(counts, "")
+	want := QueryItemQueryStatsResp{{"eggs", 5}, {"milk", 5}, {"bread", 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("itemQueryStats() = %v, want %v", got, want)
+	}
+
+	got = itemQueryStats(counts, "98101")
+	want = QueryItemQueryStatsResp{{"eggs", 5}, {"milk", 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("itemQueryStats(98101) = %v, want %v", got, want)
+	}
+}
+
+// TestCountKind counts entities in the datastore emulator, in a namespace of its own so that
+// the counts are exact. Run the emulator with --consistency=1.0 so the counts see every put.
+func TestCountKind(t *testing.T) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" || os.Getenv("PROJECT_ID") == "" {
+		t.Skip("set DATASTORE_EMULATOR_HOST and PROJECT_ID to run against the datastore emulator")
+	}
+	ctx := withNamespace(context.Background(), fmt.Sprintf("count-kind-test-%d", time.Now().UnixNano()))
+	client, err := StorageClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if n, err := countKind(ctx, UserKind); err != nil || n != 0 {
+		t.Fatalf("countKind() in an empty namespace = %d, %v; want 0", n, err)
+	}
+
+	var keys []*datastore.Key
+	var users []*User
+	for i := 0; i < 3; i++ {
+		u := &User{UserID: fmt.Sprintf("user%d", i), ZipCode: "98101"}
+		keys = append(keys, nameKey(ctx, UserKind, u.UserID))
+		users = append(users, u)
+	}
+	storeKey := nameKey(ctx, StoreKind, "store")
+	keys = append(keys, storeKey)
+	if _, err := client.PutMulti(ctx, keys[:len(users)], users); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Put(ctx, storeKey, &Store{StoreID: "store", Name: "QFC"}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.DeleteMulti(ctx, keys)
+
+	counts, err := loadStatsCounts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (QueryStatsCountsResp{Users: 3, Stores: 1}); *counts != want {
+		t.Errorf("got counts %+v, want %+v", *counts, want)
+	}
+}
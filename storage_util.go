@@ -3,17 +3,132 @@ package main
 import (
 	"context"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"reflect"
+	"strings"
 
 	"cloud.google.com/go/datastore"
 )
 
 const (
-	UserKind  = "User"
-	StoreKind = "Store"
-	ItemKind  = "Item"
+	UserKind               = "User"
+	StoreKind              = "Store"
+	ItemKind               = "Item"
+	ItemAliasKind          = "ItemAlias"
+	WebhookKind            = "Webhook"
+	FavoriteKind           = "Favorite"
+	HiddenItemKind         = "HiddenItem"
+	ClientErrorKind        = "ClientError"
+	ItemQueryCountKind     = "ItemQueryCount"
+	ExternalUserIDKind     = "ExternalUserID"
+	RegionSubscriptionKind = "RegionSubscription"
 )
 
+// storageNamespace is the datastore namespace that holds all of the server's entities, set
+// with the DATASTORE_NAMESPACE env variable. It isolates deployments, such as staging and
+// prod, that share a project. The default namespace is used if it is unset.
+var storageNamespace = os.Getenv("DATASTORE_NAMESPACE")
+
+// readConsistencyHeader asks for strongly consistent reads with strongConsistency, on the
+// endpoints in strongReadSupported.
+const (
+	readConsistencyHeader = "X-Read-Consistency"
+	strongConsistency     = "strong"
+)
+
+// strongReadSupported are the endpoints that can trade latency for strongly consistent reads,
+// by looking entities up by key instead of querying for them. /item/query does so for exact
+// and prefix matches. Endpoints that only look entities up by key, such as /user/query and /item/query with
+// item_names, are always strongly consistent. Others only query, so they can't be.
+var strongReadSupported = map[string]bool{
+	"/item/query": true,
+}
+
+// strongReadEndpoints always read strongly, whether or not requests ask to, set with the
+// comma-separated paths in the STRONG_READ_ENDPOINTS env variable. Tests that read right after
+// writing can set it to not flake.
+var strongReadEndpoints = strongReadEndpointsFromEnv("STRONG_READ_ENDPOINTS")
+
+// strongReadEndpointsFromEnv returns the set of comma-separated paths in the env variable key.
+// A path that isn't in strongReadSupported stops the server.
+func strongReadEndpointsFromEnv(key string) map[string]bool {
+	paths := make(map[string]bool)
+	for _, path := range strings.Split(os.Getenv(key), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		if !strongReadSupported[path] {
+			log.Fatalf("%s env variable has endpoint %q, which doesn't support strong reads", key, path)
+		}
+		paths[path] = true
+	}
+	return paths
+}
+
+// strongRead reports whether the request should read strongly: its endpoint supports it, and
+// either is in strongReadEndpoints or the request asked with readConsistencyHeader.
+func strongRead(r *http.Request) bool {
+	if !strongReadSupported[r.URL.Path] {
+		return false
+	}
+	return strongReadEndpoints[r.URL.Path] || strings.EqualFold(r.Header.Get(readConsistencyHeader), strongConsistency)
+}
+
+// nameKey returns the key of the named entity of the kind in the request's namespace. Use it
+// instead of datastore.NameKey.
+func nameKey(ctx context.Context, kind, name string) *datastore.Key {
+	key := datastore.NameKey(kind, name, nil)
+	key.Namespace = namespaceFromContext(ctx)
+	return key
+}
+
+// newQuery returns a query for the kind in the request's namespace. Use it instead of
+// datastore.NewQuery.
+func newQuery(ctx context.Context, kind string) *datastore.Query {
+	return datastore.NewQuery(kind).Namespace(namespaceFromContext(ctx))
+}
+
+// maxGetMultiKeys is the most keys datastore looks up in one GetMulti.
+const maxGetMultiKeys = 1000
+
+// multiGetter looks entities up by key, like *datastore.Client.
+type multiGetter interface {
+	GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error
+}
+
+// getMulti is GetMulti for any number of keys, looked up maxGetMultiKeys at a time. As with
+// GetMulti, dst is a slice as long as keys, and the error is a MultiError as long as keys if
+// only some of the lookups failed.
+func getMulti(ctx context.Context, client multiGetter, keys []*datastore.Key, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	var merr datastore.MultiError
+	for start := 0; start < len(keys); start += maxGetMultiKeys {
+		end := start + maxGetMultiKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err := client.GetMulti(ctx, keys[start:end], v.Slice(start, end).Interface())
+		if err == nil {
+			continue
+		}
+		chunkErr, ok := err.(datastore.MultiError)
+		if !ok {
+			return err
+		}
+		if merr == nil {
+			merr = make(datastore.MultiError, len(keys))
+		}
+		copy(merr[start:end], chunkErr)
+	}
+	if merr != nil {
+		return merr
+	}
+	return nil
+}
+
 // StorageClient returns a storage client instance.
 func StorageClient(ctx context.Context) (*datastore.Client, error) {
 	// TODO: Reuse storage client for all calls rather than invoking it for each one.
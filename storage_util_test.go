@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+)
+
+func TestNameKeyNamespace(t *testing.T) {
+	orig := storageNamespace
+	defer func() { storageNamespace = orig }()
+
+	ctx := context.Background()
+	storageNamespace = "staging"
+	staging := nameKey(ctx, UserKind, "user")
+	storageNamespace = "prod"
+	prod := nameKey(ctx, UserKind, "user")
+
+	if staging.Namespace != "staging" || prod.Namespace != "prod" {
+		t.Fatalf("got namespaces %q and %q, want staging and prod", staging.Namespace, prod.Namespace)
+	}
+	// Entities written under one namespace can't be fetched with the key of another.
+	if staging.Equal(prod) {
+		t.Errorf("keys in different namespaces are equal")
+	}
+}
+
+func TestStrongRead(t *testing.T) {
+	orig := strongReadEndpoints
+	defer func() { strongReadEndpoints = orig }()
+	strongReadEndpoints = map[string]bool{}
+
+	req := func(path, consistency string) *http.Request {
+		r := httptest.NewRequest("POST", path, nil)
+		if consistency != "" {
+			r.Header.Set(readConsistencyHeader, consistency)
+		}
+		return r
+	}
+	for _, tc := range []struct {
+		path, consistency string
+		want              bool
+	}{
+		{"/item/query", "", false},
+		{"/item/query", "strong", true},
+		{"/item/query", "Strong", true},
+		{"/item/query", "eventual", false},
+		// Queries for every store can't be strongly consistent.
+		{"/store/query", "strong", false},
+	} {
+		if got := strongRead(req(tc.path, tc.consistency)); got != tc.want {
+			t.Errorf("strongRead(%s with %q) = %t, want %t", tc.path, tc.consistency, got, tc.want)
+		}
+	}
+
+	strongReadEndpoints = map[string]bool{"/item/query": true}
+	if !strongRead(req("/item/query", "")) {
+		t.Errorf("strongRead(/item/query) = false with the endpoint configured, want true")
+	}
+}
+
+// fakeMultiGetter finds the entities whose key names are in found, like GetMulti, and fails
+// lookups of more than maxGetMultiKeys keys.
+type fakeMultiGetter struct {
+	found map[string]bool
+	calls int
+}
+
+func (g *fakeMultiGetter) GetMulti(ctx context.Context, keys []*datastore.Key, dst interface{}) error {
+	g.calls++
+	if len(keys) > maxGetMultiKeys {
+		return fmt.Errorf("got %d keys, want at most %d", len(keys), maxGetMultiKeys)
+	}
+	items := dst.([]Item)
+	merr := make(datastore.MultiError, len(keys))
+	missing := false
+	for i, key := range keys {
+		if !g.found[key.Name] {
+			merr[i] = datastore.ErrNoSuchEntity
+			missing = true
+			continue
+		}
+		items[i].Name = key.Name
+	}
+	if missing {
+		return merr
+	}
+	return nil
+}
+
+func TestGetMultiChunks(t *testing.T) {
+	ctx := context.Background()
+	n := 2*maxGetMultiKeys + 1
+	keys := make([]*datastore.Key, n)
+	for i := range keys {
+		keys[i] = nameKey(ctx, ItemKind, fmt.Sprintf("item %d", i))
+	}
+	// Only the last key's entity is missing.
+	g := &fakeMultiGetter{found: make(map[string]bool)}
+	for _, key := range keys[:n-1] {
+		g.found[key.Name] = true
+	}
+
+	items := make([]Item, n)
+	err := getMulti(ctx, g, keys, items)
+	if g.calls != 3 {
+		t.Errorf("got %d GetMulti calls for %d keys, want 3", g.calls, n)
+	}
+	merr, ok := err.(datastore.MultiError)
+	if !ok || len(merr) != n {
+		t.Fatalf("getMulti() = %v, want a MultiError for each of the %d keys", err, n)
+	}
+	for i, err := range merr[:n-1] {
+		if err != nil || items[i].Name != keys[i].Name {
+			t.Fatalf("got item %+v with error %v at index %d, want %q", items[i], err, i, keys[i].Name)
+		}
+	}
+	if merr[n-1] != datastore.ErrNoSuchEntity {
+		t.Errorf("got error %v for the missing item, want ErrNoSuchEntity", merr[n-1])
+	}
+
+	g.found[keys[n-1].Name] = true
+	if err := getMulti(ctx, g, keys, make([]Item, n)); err != nil {
+		t.Errorf("getMulti() = %v with every item found, want nil", err)
+	}
+}
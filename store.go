@@ -1,25 +1,87 @@
 package main
 
 import (
+	"container/heap"
 	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/datastore"
+	"github.com/google/uuid"
 	"google.golang.org/api/iterator"
 	"googlemaps.github.io/maps"
 )
 
-var (
-	validAddress *regexp.Regexp
-)
+// defaultAddressPattern accepts addresses of the form `<street>, <city>, <state> <zip code>`.
+// Like the original format, neither the street nor the city may contain commas.
+const defaultAddressPattern = `^(?P<street>[^,]+), (?P<city>[^,]+), (?P<state>[A-Za-z]{2,}) (?P<zip>[0-9]{5,})$`
+
+// addressGroups are the named groups every address pattern must capture.
+var addressGroups = []string{"street", "city", "state", "zip"}
+
+// addressPatterns are the accepted address formats, tried in order. They are read from the
+// file named by the ADDRESS_PATTERNS_FILE env variable, one regex per line, and default to
+// defaultAddressPattern.
+var addressPatterns []*regexp.Regexp
 
 func init() {
-	validAddress = regexp.MustCompile("^.+, .+, [A-Za-z]{2,} [0-9]{5,}$")
+	var err error
+	if addressPatterns, err = loadAddressPatterns(os.Getenv("ADDRESS_PATTERNS_FILE")); err != nil {
+		log.Fatalf("failed to load address patterns: %v", err)
+	}
+}
+
+func loadAddressPatterns(path string) ([]*regexp.Regexp, error) {
+	if path == "" {
+		re, err := compileAddressPattern(defaultAddressPattern)
+		if err != nil {
+			return nil, err
+		}
+		return []*regexp.Regexp{re}, nil
+	}
+	var patterns []*regexp.Regexp
+	err := scanDataFile(path, func(line string) error {
+		re, err := compileAddressPattern(line)
+		if err != nil {
+			return err
+		}
+		patterns = append(patterns, re)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("%s has no address patterns", path)
+	}
+	return patterns, nil
+}
+
+// compileAddressPattern compiles an address pattern and checks that it captures each of
+// addressGroups.
+func compileAddressPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, name := range re.SubexpNames() {
+		names[name] = true
+	}
+	for _, group := range addressGroups {
+		if !names[group] {
+			return nil, fmt.Errorf("address pattern %q is missing the %q group", pattern, group)
+		}
+	}
+	return re, nil
 }
 
 // See https://developers.google.com/places/web-service/supported_types#table1 for all place types.
@@ -41,6 +103,26 @@ type Store struct {
 	Addr    string  `datastore:"addr" json:"address"`
 	Lat     float64 `datastore:"lat" json:"latitude"`
 	Long    float64 `datastore:"long" json:"longitude"`
+	// PlaceID is the store's Google Places ID. Stores vetted before it was recorded don't
+	// have one; see storePlaceID.
+	PlaceID string `datastore:"placeID" json:"place_id"`
+	// Unvetted is set for stores added with explicit coordinates instead of being vetted
+	// with Places. They have no Places ID and are keyed by a random store ID.
+	Unvetted bool `datastore:"unvetted,noindex" json:"unvetted,omitempty"`
+	// StoreType is the store's most specific Places type in relevantStoreTypes, such as
+	// "supermarket" or "pharmacy". Stores vetted before it was recorded don't have one until
+	// BackfillStoreTypes runs, and unvetted stores never do.
+	StoreType string `datastore:"storeType" json:"store_type,omitempty"`
+}
+
+// storePlaceID returns the store's Google Places ID. Vetted stores are keyed by their Places
+// ID, so it falls back to the store ID for stores that predate PlaceID. Unvetted stores have
+// none.
+func storePlaceID(st *Store) string {
+	if st.PlaceID != "" || st.Unvetted {
+		return st.PlaceID
+	}
+	return st.StoreID
 }
 
 // ******************************************
@@ -49,13 +131,54 @@ type Store struct {
 
 type QueryStoresReq struct {
 	UserID string `json:"user_id"`
+	// FavoritesFirst lists the user's favorite stores ahead of the rest. Both groups
+	// stay sorted by distance.
+	FavoritesFirst bool `json:"favorites_first"`
+	// AllowUnknownZip lists the stores by name if there are no coordinates for the user,
+	// such as when the user's zip code isn't in the zip code data, rather than sorting them
+	// by distance from an unknown location. The sortHintHeader response header flags that
+	// distance sorting was unavailable.
+	AllowUnknownZip bool `json:"allow_unknown_zip"`
+	// RadiusMiles limits the stores to those within the radius of the user's coordinates. It
+	// defaults to the user's DefaultRadiusMiles. Stores listed by name aren't limited.
+	RadiusMiles float64 `json:"radius_miles"`
+	// CoordsReq optionally gives the coordinates to sort by distance from. See
+	// resolveUserCoords for when they are used.
+	CoordsReq
+	// PageSize, if set, pages the stores and wraps them in a QueryStoresPage. Cursor is the
+	// NextCursor of the previous page, or empty for the first page.
+	PageSize int    `json:"page_size"`
+	Cursor   string `json:"cursor"`
+
+	// offset is the index of the first store of the page, decoded from Cursor.
+	offset int
+}
+
+// storesPageLimits are the page sizes of QueryStores, set with the STORES_DEFAULT_LIMIT and
+// STORES_MAX_LIMIT env variables. The default applies to requests with only a cursor.
+var storesPageLimits = pageLimitsFromEnv("STORES", pageLimits{Default: 10, Max: 100})
+
+// QueryStoresPage is the QueryStores response to paged requests, with the same pagination
+// metadata as other paged endpoints. NextCursor is empty on the last page.
+//
+// Stores are sorted by distance in memory rather than by storage, so a paged request sorts
+// every store and the cursor encodes the offset of the next page. Stores added or removed
+// between requests shift the later pages.
+type QueryStoresPage struct {
+	*Pagination
+	Stores     QueryStoresResp `json:"stores"`
+	NextCursor string          `json:"next_cursor"`
 }
 
+// sortHintHeader explains why QueryStores didn't sort the stores by distance.
+const sortHintHeader = "X-Sort-Hint"
+
 type QueryStoresResp []*QueryStoreInfo
 
 type QueryStoreInfo struct {
 	*Store
 	*Address
+	Favorite bool `json:"favorite"`
 }
 
 type Address struct {
@@ -72,7 +195,7 @@ func QueryStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (i
 		return http.StatusBadRequest, err
 	}
 
-	if err := validateQueryStoresReq(req); err != nil {
+	if err := cleanAndValidateQueryStoresReq(&req); err != nil {
 		return http.StatusBadRequest, err
 	}
 
@@ -90,22 +213,39 @@ func QueryStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (i
 	}
 	defer client.Close()
 
+	coords, source, coordsErr := resolveUserCoords(ctx, req.CoordsReq, u)
+	if coordsErr != nil && !req.AllowUnknownZip {
+		return zipCodeErrStatus(coordsErr), coordsErr
+	}
+
 	var stores []*Store
-	q := datastore.NewQuery(StoreKind)
-	it := client.Run(ctx, q)
-	for {
-		var st Store
-		_, err := it.Next(&st)
-		if err == iterator.Done {
-			break
+	switch {
+	case coordsErr != nil:
+		if stores, err = loadAllStores(ctx, client); err != nil {
+			return http.StatusInternalServerError, err
 		}
-		if err != nil {
-			return http.StatusInternalServerError, fmt.Errorf("failed to query for all stores: %v", err)
+		w.Header().Set(sortHintHeader, sortStoresWithoutCoords(stores, coordsErr))
+		if queryStoresLimit > 0 && !req.paged() && len(stores) > queryStoresLimit {
+			stores = stores[:queryStoresLimit]
 		}
-		stores = append(stores, &st)
+	case queryStoresLimit > 0 && !req.paged():
+		// Keep only the nearest stores as they're loaded rather than sorting all of them.
+		if stores, err = loadNearestStores(ctx, client, coords, queryStoresLimit); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	default:
+		if stores, err = loadAllStores(ctx, client); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		sortStoresByCoords(stores, coords)
+	}
+	if coordsErr == nil {
+		w.Header().Set(coordsSourceHeader, source)
+		stores = storesWithinRadius(stores, coords, searchRadius(req.RadiusMiles, u))
 	}
 
-	if err := sortStoresByDistance(stores, u.ZipCode); err != nil {
+	favs, err := favoriteStoreIDs(ctx, u.UserID)
+	if err != nil {
 		return http.StatusInternalServerError, err
 	}
 
@@ -113,37 +253,113 @@ func QueryStores(ctx context.Context, w http.ResponseWriter, r *http.Request) (i
 	for _, st := range stores {
 		addr, err := parseAddressComponents(st.Addr)
 		if err != nil {
-			log.Fatalf("failed to parse address %q: %v", st.Addr, err)
+			log.Printf("failed to parse address %q of store %q: %v", st.Addr, st.StoreID, err)
 			continue
 		}
-		resp = append(resp, &QueryStoreInfo{Store: st, Address: addr})
+		resp = append(resp, &QueryStoreInfo{Store: st, Address: addr, Favorite: favs[st.StoreID]})
+	}
+	if req.FavoritesFirst {
+		orderFavoritesFirst(resp)
 	}
 
+	if req.paged() {
+		if err := EncodeResp(w, storesPage(resp, req.PageSize, req.offset)); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return http.StatusOK, nil
+	}
 	if err := EncodeResp(w, &resp); err != nil {
 		return http.StatusInternalServerError, err
 	}
 	return http.StatusOK, nil
 }
 
-func validateQueryStoresReq(req QueryStoresReq) error {
+func cleanAndValidateQueryStoresReq(req *QueryStoresReq) error {
 	if req.UserID == "" {
 		return fmt.Errorf("missing user id")
 	}
-	return nil
+	if err := req.CoordsReq.validate(); err != nil {
+		return err
+	}
+	if req.PageSize < 0 || req.PageSize > storesPageLimits.Max {
+		return fmt.Errorf("page size must be between 0 and %d", storesPageLimits.Max)
+	}
+	if req.Cursor != "" {
+		offset, err := decodeOffsetCursor(req.Cursor)
+		if err != nil {
+			return err
+		}
+		req.offset = offset
+		if req.PageSize == 0 {
+			req.PageSize = storesPageLimits.Default
+		}
+	}
+	return validateSearchRadius(req.RadiusMiles)
+}
+
+// paged reports whether the request asked for a page of stores.
+func (req *QueryStoresReq) paged() bool {
+	return req.PageSize > 0
+}
+
+// storesPage returns the pageSize stores starting at offset, with the cursor of the next
+// page if there are more.
+func storesPage(resp QueryStoresResp, pageSize, offset int) *QueryStoresPage {
+	start, end, pg := pageBounds(len(resp), PageReq{Limit: pageSize, Offset: offset})
+	page := &QueryStoresPage{Pagination: pg, Stores: resp[start:end]}
+	if end < len(resp) {
+		page.NextCursor = encodeOffsetCursor(end)
+	}
+	return page
+}
+
+// storesWithinRadius returns the stores within radiusMiles of coords, in the same order. A
+// zero radius keeps every store.
+func storesWithinRadius(stores []*Store, coords coord, radiusMiles float64) []*Store {
+	if radiusMiles == 0 {
+		return stores
+	}
+	res := make([]*Store, 0, len(stores))
+	for _, st := range stores {
+		if Distance(st.Lat, st.Long, coords.Lat, coords.Long) <= radiusMiles {
+			res = append(res, st)
+		}
+	}
+	return res
+}
+
+// orderFavoritesFirst moves favorite stores ahead of the rest while keeping the relative
+// (distance) order within each group.
+func orderFavoritesFirst(resp QueryStoresResp) {
+	sort.SliceStable(resp, func(i, j int) bool {
+		return resp[i].Favorite && !resp[j].Favorite
+	})
 }
 
+// parseAddressComponents splits the address with the first of addressPatterns it matches.
 func parseAddressComponents(address string) (*Address, error) {
-	if !validAddress.MatchString(address) {
+	for _, re := range addressPatterns {
+		m := re.FindStringSubmatch(address)
+		if m == nil {
+			continue
+		}
+		groups := make(map[string]string)
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				groups[name] = strings.TrimSpace(m[i])
+			}
+		}
+		return &Address{
+			Street:  groups["street"],
+			City:    groups["city"],
+			State:   groups["state"],
+			ZipCode: groups["zip"],
+		}, nil
+	}
+	if len(addressPatterns) == 1 && addressPatterns[0].String() == defaultAddressPattern {
 		return nil, fmt.Errorf("address does not follow standard format `<street>, <city>, <state> <zip code>`")
 	}
-	components := strings.Split(address, ", ")
-	stateAndZipCode := strings.Split(components[2], " ")
-	return &Address{
-		Street:  strings.TrimSpace(components[0]),
-		City:    strings.TrimSpace(components[1]),
-		State:   strings.TrimSpace(stateAndZipCode[0]),
-		ZipCode: strings.TrimSpace(stateAndZipCode[1]),
-	}, nil
+	return nil, fmt.Errorf("address does not follow any accepted format")
 }
 
 // ******************************************
@@ -158,6 +374,13 @@ type AddStoreReq struct {
 	UserID   string `json:"user_id"`
 	Name     string `json:"name"`
 	AddrText string `json:"address"`
+	// PlaceID, if set, is the Places ID of the store, picked from the candidates returned
+	// when the name and address matched several places. The store is vetted as that place.
+	PlaceID string `json:"place_id"`
+	// Lat and Long, if both set, are the store's coordinates. The store is then added as
+	// given instead of being vetted with Places, for stores that Places doesn't know.
+	Lat  *float64 `json:"latitude"`
+	Long *float64 `json:"longitude"`
 }
 
 // TODO: Return vetted store name and address in response so that client can get it and show it in UI.
@@ -165,6 +388,25 @@ type AddStoreResp struct {
 	StoreID string `json:"store_id"`
 }
 
+// AddStoreCandidatesResp is the AddStore response when the store info matches several places,
+// sent with http.StatusConflict. The client has the user pick one and adds the store again
+// with its place ID.
+type AddStoreCandidatesResp struct {
+	Error      string            `json:"error"`
+	Candidates []*StoreCandidate `json:"candidates"`
+}
+
+// StoreCandidate is a place that matched the store info.
+type StoreCandidate struct {
+	Name    string `json:"name"`
+	Addr    string `json:"address"`
+	PlaceID string `json:"place_id"`
+}
+
+// AddStore vets the store with Places and adds it. Adding a store that already exists returns
+// the existing store's ID; see getStoreAtPlaceInStorage. A store added with
+// explicit coordinates skips vetting and gets a new store ID, unless a store of the same chain
+// is already at those coordinates; see duplicateStore.
 func AddStore(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
 	var req AddStoreReq
 	if err := DecodeReq(r.Body, &req); err != nil {
@@ -188,13 +430,43 @@ func AddStore(ctx context.Context, w http.ResponseWriter, r *http.Request) (int,
 		Addr: req.AddrText,
 	}
 
-	client, err := MapsClient()
-	if err != nil {
-		return http.StatusInternalServerError, err
-	}
-
-	if err := vetStoreInfo(ctx, client, st); err != nil {
-		return http.StatusBadRequest, err
+	if req.Lat != nil {
+		dup, err := findDuplicateStoreInStorage(ctx, st.Name, *req.Lat, *req.Long)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if dup != nil {
+			st = dup
+		} else if err := unvettedStore(st, *req.Lat, *req.Long); err != nil {
+			return http.StatusInternalServerError, err
+		}
+	} else {
+		client, err := PlacesClient()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if req.PlaceID != "" {
+			err = vetStorePlace(ctx, client, st, req.PlaceID)
+		} else {
+			err = vetStoreInfo(ctx, client, st)
+		}
+		if amb, ok := err.(*ambiguousStoreError); ok {
+			resp := &AddStoreCandidatesResp{Error: amb.Error(), Candidates: amb.candidates}
+			if err := EncodeRespStatus(w, http.StatusConflict, resp); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			return http.StatusConflict, nil
+		}
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		existing, ok, err := getStoreAtPlaceInStorage(ctx, st.PlaceID)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if ok {
+			st.StoreID = existing.StoreID
+		}
 	}
 
 	if status, err := createStoreInStorage(ctx, st); err != nil {
@@ -223,131 +495,966 @@ func cleanAndValidateAddStoreReq(req *AddStoreReq) error {
 	if req.AddrText == "" {
 		return fmt.Errorf("missing store address text")
 	}
+	if (req.Lat == nil) != (req.Long == nil) {
+		return fmt.Errorf("latitude and longitude must be given together")
+	}
+	if req.Lat != nil && req.PlaceID != "" {
+		return fmt.Errorf("place id and coordinates can't be given together")
+	}
+	if req.Lat != nil {
+		if err := validateCoords(*req.Lat, *req.Long); err != nil {
+			return err
+		}
+		// Without Places to format it, the address must already be in an accepted format
+		// for QueryStores to list the store.
+		if _, err := parseAddressComponents(req.AddrText); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// ******************************************
-// ** END AddStore
-// ******************************************
+// unvettedStore fills in the store added with explicit coordinates under a new store ID.
+func unvettedStore(st *Store, lat, lng float64) error {
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return fmt.Errorf("failed to generate store id: %v", err)
+	}
+	log.Printf("store `%q %q` added unvetted at (%f, %f)", st.Name, st.Addr, lat, lng)
+	st.StoreID = uid.String()
+	st.Lat = lat
+	st.Long = lng
+	st.Unvetted = true
+	return nil
+}
 
-// GetStoreInStorage fetches the store with key = storeID in storage.
-// Returns a non-nil error if storage client experienced a failure.
-func GetStoreInStorage(ctx context.Context, storeID string) (*Store, error) {
+// duplicateStoreRadiusMiles is how close a store of the same chain must be to a store added
+// with explicit coordinates to be taken as the same store.
+const duplicateStoreRadiusMiles = 0.05
+
+// duplicateStore returns the first of the stores that is of the same chain as the store name,
+// by normalizeChainName, within duplicateStoreRadiusMiles of the coordinates, or nil if none
+// is. Names with no chain never match.
+func duplicateStore(stores []*Store, name string, lat, lng float64) *Store {
+	chain := normalizeChainName(name)
+	if chain == "" {
+		return nil
+	}
+	for _, st := range stores {
+		if Distance(st.Lat, st.Long, lat, lng) > duplicateStoreRadiusMiles {
+			continue
+		}
+		if normalizeChainName(st.Name) == chain {
+			return st
+		}
+	}
+	return nil
+}
+
+// findDuplicateStoreInStorage scans the stores in storage for a duplicateStore. Stores added
+// with explicit coordinates are rare, so the scan is not worth an index.
+func findDuplicateStoreInStorage(ctx context.Context, name string, lat, lng float64) (*Store, error) {
 	client, err := StorageClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Close()
 
-	var st Store
-	key := datastore.NameKey(StoreKind, storeID, nil)
-	if err := client.Get(ctx, key, &st); err != nil {
-		return nil, fmt.Errorf("failed to get store from storage: %v", err)
+	stores, err := loadAllStores(ctx, client)
+	if err != nil {
+		return nil, err
 	}
-	return &st, nil
+	return duplicateStore(stores, name, lat, lng), nil
 }
 
-func createStoreInStorage(ctx context.Context, st *Store) (int, error) {
-	client, err := StorageClient(ctx)
-	if err != nil {
-		return http.StatusInternalServerError, err
+// ******************************************
+// ** END AddStore
+// ******************************************
+
+// ******************************************
+// ** BEGIN EditStore
+// ******************************************
+
+type EditStoreReq struct {
+	UserID   string `json:"user_id"`
+	StoreID  string `json:"store_id"`
+	Name     string `json:"name"`
+	AddrText string `json:"address"`
+}
+
+// EditStoreResp is the store as vetted by Places, for the client to confirm the change.
+type EditStoreResp struct {
+	*Store
+}
+
+// EditStore corrects a store's name and address. The new name and address are vetted with
+// Places like in AddStore, and the store keeps its store ID so that favorites and stock
+// reports still refer to it. The store can't be moved to a place that another store is
+// already at.
+func EditStore(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req EditStoreReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
 	}
-	defer client.Close()
 
-	key := datastore.NameKey(StoreKind, st.StoreID, nil)
+	if err := cleanAndValidateEditStoreReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
 
-	// Fetch the store from storage to see if it already exists. We could just put the store
-	// in storage and that would prevent duplicates but read operations are much
-	// cheaper than write operations in Datastore. This raises the bar in case users
-	// try to add the same store repeatedly.
-	var tmp Store
-	err = client.Get(ctx, key, &tmp)
-	if err == nil {
-		// Check to see if the store entity in storage is equivalent. If not, the entity
-		// needs to be updated.
-		if tmp.Name == st.Name && tmp.Addr == st.Addr {
-			return http.StatusBadRequest, fmt.Errorf("store already exists")
-		}
-	} else if err != datastore.ErrNoSuchEntity {
-		return http.StatusInternalServerError, fmt.Errorf("failed to look up store in storage: %v", err)
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
 	}
-	if _, err = client.Put(ctx, key, st); err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("failed to add store in storage: %v", err)
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
 	}
-	return 0, nil
-}
 
-// vetStoreInfo vets the storeInfo before adding it to Storage.
-// 1. calls the Google Maps Places API with a query `<storeInfo.name> <storeInfo.address>`.
-// 2. Places API returns the fully qualified name, address, lat, and long of the candidate
-//    place that matches.
-//    Only one candidate place can be returned, otherwise an error is returned with string
-//    output of the candidate places.
-// 3. calls the Places API again to get details of the candidate place. If the candidate
-//    does not have a relevant label (see relevantStoreTypes variable), the candidate
-//    is rejected and an error is returned.
-// 4. overrides storeInfo fields with those returned by Places API
-func vetStoreInfo(ctx context.Context, client *maps.Client, storeInfo *Store) error {
-	placesQueryInput := fmt.Sprintf("%s %s", storeInfo.Name, storeInfo.Addr)
+	_, ok, err = GetStoreInStorage(ctx, req.StoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
 
-	findPlaceReq := &maps.FindPlaceFromTextRequest{
-		InputType: maps.FindPlaceFromTextInputTypeTextQuery,
-		Input:     placesQueryInput,
-		Fields: []maps.PlaceSearchFieldMask{
-			maps.PlaceSearchFieldMaskFormattedAddress,
-			maps.PlaceSearchFieldMaskName,
-			maps.PlaceSearchFieldMaskPlaceID,
-			maps.PlaceSearchFieldMaskGeometry,
-		},
+	client, err := PlacesClient()
+	if err != nil {
+		return http.StatusInternalServerError, err
 	}
-	findPlaceResp, err := client.FindPlaceFromText(ctx, findPlaceReq)
+	st, err := vetStoreEdit(ctx, client, req.StoreID, req.Name, req.AddrText)
 	if err != nil {
-		return err
+		return http.StatusBadRequest, err
 	}
 
-	if len(findPlaceResp.Candidates) != 1 {
-		log.Printf("the store info `%q %q` returned %d matches", storeInfo.Name, storeInfo.Addr, len(findPlaceResp.Candidates))
-		errMsg := fmt.Sprintf("found %d store(s) that matched the given store information, but only 1 store can match.\n", len(findPlaceResp.Candidates))
-		for i, cand := range findPlaceResp.Candidates {
-			errMsg += fmt.Sprintf("%d: %s %s\n", i+1, cand.Name, cand.FormattedAddress)
-		}
-		return fmt.Errorf(errMsg)
+	if other, ok, err := getStoreAtPlaceInStorage(ctx, st.PlaceID); err != nil {
+		return http.StatusInternalServerError, err
+	} else if ok && other.StoreID != st.StoreID {
+		return http.StatusBadRequest, fmt.Errorf("store info `%q %q` matches the existing store %q", st.Name, st.Addr, other.StoreID)
 	}
 
-	placeID := findPlaceResp.Candidates[0].PlaceID
-	vettedName := findPlaceResp.Candidates[0].Name
-	vettedAddr := strings.TrimSuffix(findPlaceResp.Candidates[0].FormattedAddress, ", United States")
-	lat := findPlaceResp.Candidates[0].Geometry.Location.Lat
-	lng := findPlaceResp.Candidates[0].Geometry.Location.Lng
-
-	detailsReq := &maps.PlaceDetailsRequest{
-		PlaceID: placeID,
-		Fields:  []maps.PlaceDetailsFieldMask{maps.PlaceDetailsFieldMaskTypes},
+	if status, err := createStoreInStorage(ctx, st); err != nil {
+		return status, err
 	}
-	detailsResp, err := client.PlaceDetails(ctx, detailsReq)
-	for _, placeType := range detailsResp.Types {
-		if _, ok := relevantStoreTypes[placeType]; ok {
-			break
-		}
-		return fmt.Errorf("could not verify store info `%q %q` as a real grocery store", vettedName, vettedAddr)
+
+	if err := EncodeResp(w, &EditStoreResp{Store: st}); err != nil {
+		return http.StatusInternalServerError, err
 	}
+	return http.StatusOK, nil
+}
 
-	log.Printf("store `%q %q` vetted and changed to `%q %q (%f, %f)`", storeInfo.Name, storeInfo.Addr, vettedName, vettedAddr, lat, lng)
-	storeInfo.StoreID = placeID
-	storeInfo.Name = vettedName
-	storeInfo.Addr = vettedAddr
-	storeInfo.Lat = lat
-	storeInfo.Long = lng
+func cleanAndValidateEditStoreReq(req *EditStoreReq) error {
+	req.StoreID = strings.TrimSpace(req.StoreID)
+	req.Name = strings.TrimSpace(req.Name)
+	req.AddrText = strings.TrimSpace(req.AddrText)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	if req.Name == "" {
+		return fmt.Errorf("missing store name")
+	}
+	if req.AddrText == "" {
+		return fmt.Errorf("missing store address text")
+	}
 	return nil
 }
 
-func sortStoresByDistance(stores []*Store, zipCode string) error {
-	coords := zipCodeToLatLong[zipCode]
-	lat := coords.Lat
-	lng := coords.Long
-	sort.Slice(stores, func(i, j int) bool {
-		return Distance(stores[i].Lat, stores[i].Long, lat, lng) <
-			Distance(stores[j].Lat, stores[j].Long, lat, lng)
-	})
-	return nil
+// vetStoreEdit vets the new name and address of the store with storeID and returns the
+// store with the vetted info under the same store ID.
+func vetStoreEdit(ctx context.Context, places placesClient, storeID, name, addr string) (*Store, error) {
+	st := &Store{Name: name, Addr: addr}
+	if err := vetStoreInfo(ctx, places, st); err != nil {
+		return nil, err
+	}
+	st.StoreID = storeID
+	return st, nil
+}
+
+// ******************************************
+// ** END EditStore
+// ******************************************
+
+// ******************************************
+// ** BEGIN DeleteStore
+// ******************************************
+
+type DeleteStoreReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+}
+
+// DeleteStore removes a store, such as one the Places API resolved to the wrong place. Stock
+// reports that embed a copy of the store keep it, so items still list those reports unless
+// deletedStoreReports says otherwise; reports that only reference the store by ID (see
+// featureEmbedStoreSnapshots) can no longer be resolved to its name and address. Favorites
+// of the store are skipped once it's gone.
+func DeleteStore(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req DeleteStoreReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := validateDeleteStoreReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	_, ok, err = GetStoreInStorage(ctx, req.StoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
+
+	if err := deleteStoreInStorage(ctx, req.StoreID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func validateDeleteStoreReq(req *DeleteStoreReq) error {
+	req.StoreID = strings.TrimSpace(req.StoreID)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END DeleteStore
+// ******************************************
+
+// ******************************************
+// ** BEGIN ValidateAddress
+// ******************************************
+
+type ValidateAddressReq struct {
+	UserID   string `json:"user_id"`
+	AddrText string `json:"address"`
+	// Lookup also searches Places for the address and returns the matches. Unlike AddStore,
+	// the address doesn't have to match exactly one place.
+	Lookup bool `json:"lookup"`
+}
+
+type ValidateAddressResp struct {
+	*Address
+	Matches []string `json:"matches,omitempty"`
+}
+
+// ValidateAddress checks that an address can be used to add a store, without adding it.
+// It responds with the parsed address components, or a 400 saying what is wrong with the
+// address.
+func ValidateAddress(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ValidateAddressReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateValidateAddressReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	addr, err := parseAddressComponents(req.AddrText)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	resp := &ValidateAddressResp{Address: addr}
+
+	if req.Lookup {
+		client, err := PlacesClient()
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		if resp.Matches, err = lookupAddress(ctx, client, req.AddrText); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("failed to look up address: %v", err)
+		}
+	}
+
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateValidateAddressReq(req *ValidateAddressReq) error {
+	req.AddrText = strings.TrimSpace(req.AddrText)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.AddrText == "" {
+		return fmt.Errorf("missing address text")
+	}
+	return nil
+}
+
+// lookupAddress returns the formatted addresses of the places matching the address text.
+func lookupAddress(ctx context.Context, client placesClient, address string) ([]string, error) {
+	findPlaceResp, err := client.FindPlaceFromText(ctx, &maps.FindPlaceFromTextRequest{
+		InputType: maps.FindPlaceFromTextInputTypeTextQuery,
+		Input:     address,
+		Fields:    []maps.PlaceSearchFieldMask{maps.PlaceSearchFieldMaskFormattedAddress},
+	})
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]string, 0, len(findPlaceResp.Candidates))
+	for _, cand := range findPlaceResp.Candidates {
+		matches = append(matches, strings.TrimSuffix(cand.FormattedAddress, ", United States"))
+	}
+	return matches, nil
+}
+
+// ******************************************
+// ** END ValidateAddress
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryStoreDistance
+// ******************************************
+
+type QueryStoreDistanceReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+	ZipCode string `json:"zip_code"`
+}
+
+type QueryStoreDistanceResp struct {
+	Miles      float64 `json:"miles"`
+	Kilometers float64 `json:"kilometers"`
+}
+
+// QueryStoreDistance computes the distance between a store and a zip code.
+func QueryStoreDistance(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryStoreDistanceReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryStoreDistanceReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	st, ok, err := GetStoreInStorage(ctx, req.StoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
+
+	miles, err := distanceFromZipCode(st.Lat, st.Long, req.ZipCode)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	resp := &QueryStoreDistanceResp{
+		Miles:      miles,
+		Kilometers: miles * kilometersPerMile,
+	}
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryStoreDistanceReq(req *QueryStoreDistanceReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	if req.ZipCode == "" {
+		return fmt.Errorf("missing zip code")
+	}
+	return validateZipCode(req.ZipCode)
+}
+
+// ******************************************
+// ** END QueryStoreDistance
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryStoreShortages
+// ******************************************
+
+const (
+	defaultShortageMaxAgeHours = 72
+	maxShortageMaxAgeHours     = 24 * 7
+)
+
+type QueryStoreShortagesReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+	// MaxAgeHours bounds how old an out-of-stock report can be. Defaults to
+	// defaultShortageMaxAgeHours.
+	MaxAgeHours int `json:"max_age_hours"`
+}
+
+type QueryStoreShortagesResp []*ShortageInfo
+
+// ShortageInfo is an item whose most recent report at a store is out of stock.
+type ShortageInfo struct {
+	ItemName    string `json:"itemName"`
+	SecondsAgo  int    `json:"secondsAgo"`
+	ReportedAgo string `json:"reportedAgo"`
+	SeenCnt     int    `json:"seenCount"`
+}
+
+// QueryStoreShortages fetches the items recently reported out of stock at a store, most
+// recently reported first.
+func QueryStoreShortages(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryStoreShortagesReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryStoreShortagesReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	_, ok, err = GetStoreInStorage(ctx, req.StoreID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("store id is invalid: %q", req.StoreID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	// Stock reports are embedded in item entities, so every item has to be scanned.
+	items, err := loadAllItems(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	maxAgeSec := int64(req.MaxAgeHours) * secondsToHour
+	resp := storeShortages(items, req.StoreID, time.Now().Unix(), maxAgeSec)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryStoreShortagesReq(req *QueryStoreShortagesReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.StoreID == "" {
+		return fmt.Errorf("missing store id")
+	}
+	if req.MaxAgeHours < 0 || req.MaxAgeHours > maxShortageMaxAgeHours {
+		return fmt.Errorf("max age must be between 0 and %d hours", maxShortageMaxAgeHours)
+	}
+	if req.MaxAgeHours == 0 {
+		req.MaxAgeHours = defaultShortageMaxAgeHours
+	}
+	return nil
+}
+
+// storeShortages returns the items whose most recent report at the store is out of stock
+// and no older than maxAgeSec, most recently reported first.
+func storeShortages(items []*Item, storeID string, now, maxAgeSec int64) QueryStoreShortagesResp {
+	resp := make(QueryStoreShortagesResp, 0)
+	for _, item := range items {
+		sr, ok := latestStockReports(item)[storeID]
+		if !ok || sr.InStock || now-sr.TimestampSec > maxAgeSec {
+			continue
+		}
+		secondsAgo := int(now - sr.TimestampSec)
+		resp = append(resp, &ShortageInfo{
+			ItemName:    item.Name,
+			SecondsAgo:  secondsAgo,
+			ReportedAgo: humanizeAge(secondsAgo),
+			SeenCnt:     sr.SeenCnt,
+		})
+	}
+	sort.SliceStable(resp, func(i, j int) bool {
+		return resp[i].SecondsAgo < resp[j].SecondsAgo
+	})
+	return resp
+}
+
+// ******************************************
+// ** END QueryStoreShortages
+// ******************************************
+
+// loadAllStores fetches every store entity in storage.
+func loadAllStores(ctx context.Context, client *datastore.Client) ([]*Store, error) {
+	ctx, span := startClientSpan(ctx, "datastore.query Store")
+	defer span.Finish()
+	var stores []*Store
+	q := newQuery(ctx, StoreKind)
+	it := client.Run(ctx, q)
+	for {
+		var st Store
+		_, err := it.Next(&st)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			span.SetError(err)
+			return nil, fmt.Errorf("failed to query for all stores: %v", err)
+		}
+		stores = append(stores, &st)
+	}
+	return stores, nil
+}
+
+// GetStoreInStorage fetches the store with key = storeID in storage.
+// Returns a non-nil error if storage client experienced a failure.
+// If no error, returns true/false to indicate that storeID exists or not.
+func GetStoreInStorage(ctx context.Context, storeID string) (*Store, bool, error) {
+	ctx, span := startClientSpan(ctx, "datastore.get Store")
+	defer span.Finish()
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer client.Close()
+
+	var st Store
+	key := nameKey(ctx, StoreKind, storeID)
+	if err := client.Get(ctx, key, &st); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, false, nil // storeID does not exist
+		}
+		span.SetError(err)
+		return nil, false, fmt.Errorf("failed to get store from storage: %v", err)
+	}
+	return &st, true, nil
+}
+
+// getStoreAtPlaceInStorage returns the store at the Places ID. Stores are keyed by their
+// Places ID, except for those moved to another place by EditStore, which keep their store ID
+// and are found by their PlaceID instead.
+func getStoreAtPlaceInStorage(ctx context.Context, placeID string) (*Store, bool, error) {
+	if st, ok, err := GetStoreInStorage(ctx, placeID); err != nil || ok {
+		return st, ok, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer client.Close()
+
+	var stores []*Store
+	q := newQuery(ctx, StoreKind).Filter("placeID =", placeID).Limit(1)
+	if _, err := client.GetAll(ctx, q, &stores); err != nil {
+		return nil, false, fmt.Errorf("failed to query for store at place %q: %v", placeID, err)
+	}
+	if len(stores) == 0 {
+		return nil, false, nil
+	}
+	return stores[0], true, nil
+}
+
+// createStoreInStorage puts the store in storage, unless the same store is already there.
+func createStoreInStorage(ctx context.Context, st *Store) (int, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	key := nameKey(ctx, StoreKind, st.StoreID)
+
+	// Stores are keyed by their Places ID, so adding a store that was already added finds
+	// the same entity. Fetch it first: we could just put the store in storage and that
+	// would prevent duplicates but read operations are much cheaper than write operations
+	// in Datastore. This keeps repeated adds of the same store cheap.
+	var tmp Store
+	err = client.Get(ctx, key, &tmp)
+	if err == nil {
+		// Check to see if the store entity in storage is equivalent. If not, the entity
+		// needs to be updated.
+		if tmp == *st {
+			return 0, nil
+		}
+	} else if err != datastore.ErrNoSuchEntity {
+		return http.StatusInternalServerError, fmt.Errorf("failed to look up store in storage: %v", err)
+	}
+	if _, err = client.Put(ctx, key, st); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to add store in storage: %v", err)
+	}
+	return 0, nil
+}
+
+func deleteStoreInStorage(ctx context.Context, storeID string) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	key := nameKey(ctx, StoreKind, storeID)
+	if err := client.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete store in storage: %v", err)
+	}
+	return nil
+}
+
+// vetStoreInfo vets the storeInfo before adding it to Storage.
+// 1. calls the Google Maps Places API with a query `<storeInfo.name> <storeInfo.address>`.
+// 2. Places API returns the fully qualified name, address, lat, and long of the candidate
+//    place that matches.
+//    Only one candidate place can be returned. If there are none, an error asks the user
+//    to check the store info; if there are several, an ambiguousStoreError lists the
+//    candidate places.
+// 3. calls the Places API again to get details of the candidate place. If the candidate
+//    has none of the relevant labels (see relevantStoreTypes variable), the candidate
+//    is rejected and an error is returned.
+// 4. overrides storeInfo fields with those returned by Places API
+func vetStoreInfo(ctx context.Context, client placesClient, storeInfo *Store) error {
+	placesQueryInput := fmt.Sprintf("%s %s", storeInfo.Name, storeInfo.Addr)
+
+	findPlaceReq := &maps.FindPlaceFromTextRequest{
+		InputType: maps.FindPlaceFromTextInputTypeTextQuery,
+		Input:     placesQueryInput,
+		Fields: []maps.PlaceSearchFieldMask{
+			maps.PlaceSearchFieldMaskFormattedAddress,
+			maps.PlaceSearchFieldMaskName,
+			maps.PlaceSearchFieldMaskPlaceID,
+			maps.PlaceSearchFieldMaskGeometry,
+		},
+	}
+	findPlaceResp, err := client.FindPlaceFromText(ctx, findPlaceReq)
+	if err != nil {
+		return err
+	}
+
+	if len(findPlaceResp.Candidates) == 0 {
+		log.Printf("the store info `%q %q` returned no matches", storeInfo.Name, storeInfo.Addr)
+		return fmt.Errorf("no matching store found for `%s %s`, check that the store name and address are correct", storeInfo.Name, storeInfo.Addr)
+	}
+	if len(findPlaceResp.Candidates) > 1 {
+		log.Printf("the store info `%q %q` returned %d matches", storeInfo.Name, storeInfo.Addr, len(findPlaceResp.Candidates))
+		amb := &ambiguousStoreError{}
+		for _, cand := range findPlaceResp.Candidates {
+			amb.candidates = append(amb.candidates, &StoreCandidate{
+				Name:    cand.Name,
+				Addr:    strings.TrimSuffix(cand.FormattedAddress, ", United States"),
+				PlaceID: cand.PlaceID,
+			})
+		}
+		return amb
+	}
+
+	placeID := findPlaceResp.Candidates[0].PlaceID
+	vettedName := findPlaceResp.Candidates[0].Name
+	vettedAddr := strings.TrimSuffix(findPlaceResp.Candidates[0].FormattedAddress, ", United States")
+	lat := findPlaceResp.Candidates[0].Geometry.Location.Lat
+	lng := findPlaceResp.Candidates[0].Geometry.Location.Lng
+
+	detailsReq := &maps.PlaceDetailsRequest{
+		PlaceID: placeID,
+		Fields:  []maps.PlaceDetailsFieldMask{maps.PlaceDetailsFieldMaskTypes},
+	}
+	detailsResp, err := client.PlaceDetails(ctx, detailsReq)
+	if err != nil {
+		return fmt.Errorf("failed to look up place %q: %v", placeID, err)
+	}
+	storeType := relevantStoreType(detailsResp.Types)
+	if storeType == "" {
+		return fmt.Errorf("could not verify store info `%q %q` as a real grocery store", vettedName, vettedAddr)
+	}
+
+	log.Printf("store `%q %q` vetted and changed to `%q %q (%f, %f)`", storeInfo.Name, storeInfo.Addr, vettedName, vettedAddr, lat, lng)
+	storeInfo.StoreID = placeID
+	storeInfo.PlaceID = placeID
+	storeInfo.Name = vettedName
+	storeInfo.Addr = vettedAddr
+	storeInfo.Lat = lat
+	storeInfo.Long = lng
+	storeInfo.StoreType = storeType
+	return nil
+}
+
+// ambiguousStoreError is returned by vetStoreInfo when the store info matches several places.
+type ambiguousStoreError struct {
+	candidates []*StoreCandidate
+}
+
+func (e *ambiguousStoreError) Error() string {
+	msg := fmt.Sprintf("found %d store(s) that matched the given store information, but only 1 store can match.\n", len(e.candidates))
+	for i, cand := range e.candidates {
+		msg += fmt.Sprintf("%d: %s %s\n", i+1, cand.Name, cand.Addr)
+	}
+	return msg
+}
+
+// vetStorePlace vets the store as the place with placeID, such as a candidate the user
+// picked after vetStoreInfo found several, and overrides storeInfo fields with the place's.
+func vetStorePlace(ctx context.Context, client placesClient, storeInfo *Store, placeID string) error {
+	details, err := client.PlaceDetails(ctx, &maps.PlaceDetailsRequest{
+		PlaceID: placeID,
+		Fields: []maps.PlaceDetailsFieldMask{
+			maps.PlaceDetailsFieldMaskName,
+			maps.PlaceDetailsFieldMaskFormattedAddress,
+			maps.PlaceDetailsFieldMaskGeometry,
+			maps.PlaceDetailsFieldMaskTypes,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to look up place %q: %v", placeID, err)
+	}
+	vettedAddr := strings.TrimSuffix(details.FormattedAddress, ", United States")
+	storeType := relevantStoreType(details.Types)
+	if storeType == "" {
+		return fmt.Errorf("could not verify store info `%q %q` as a real grocery store", details.Name, vettedAddr)
+	}
+
+	log.Printf("store `%q %q` vetted as place %q: `%q %q`", storeInfo.Name, storeInfo.Addr, placeID, details.Name, vettedAddr)
+	storeInfo.StoreID = placeID
+	storeInfo.PlaceID = placeID
+	storeInfo.Name = details.Name
+	storeInfo.Addr = vettedAddr
+	storeInfo.Lat = details.Geometry.Location.Lat
+	storeInfo.Long = details.Geometry.Location.Lng
+	storeInfo.StoreType = storeType
+	return nil
+}
+
+// relevantStoreType returns the first of the place types that is in relevantStoreTypes, or
+// "" if none is. Places lists the most specific types first.
+func relevantStoreType(types []string) string {
+	for _, t := range types {
+		if relevantStoreTypes[t] {
+			return t
+		}
+	}
+	return ""
+}
+
+// sortStoresWithoutCoords sorts the stores by name for a user without coordinates and returns
+// the sortHintHeader value saying why, given the resolveUserCoords error.
+func sortStoresWithoutCoords(stores []*Store, coordsErr error) string {
+	sortStoresByName(stores)
+	return fmt.Sprintf("%v, sorted by name", coordsErr)
+}
+
+// sortStoresByName sorts the stores by name, then ID.
+func sortStoresByName(stores []*Store) {
+	sort.Slice(stores, func(i, j int) bool {
+		if stores[i].Name != stores[j].Name {
+			return stores[i].Name < stores[j].Name
+		}
+		return stores[i].StoreID < stores[j].StoreID
+	})
+}
+
+// sortStoresByDistance sorts the stores nearest the zip code first. Stores at the same
+// distance are ordered by name, then ID, so the order is the same across requests. It fails
+// if the zip code isn't supported.
+func sortStoresByDistance(stores []*Store, zipCode string) error {
+	coords, err := zipCodeCoords(zipCode)
+	if err != nil {
+		return err
+	}
+	sortStoresByCoords(stores, coords)
+	return nil
+}
+
+// sortStoresByCoords sorts the stores nearest coords first, breaking ties like
+// sortStoresByDistance.
+func sortStoresByCoords(stores []*Store, coords coord) {
+	sort.Sort(&storesByDistance{
+		stores: stores,
+		dists:  storeDistances(stores, coords.Lat, coords.Long, distanceWorkers),
+	})
+}
+
+// storesByDistance sorts stores by their precomputed distances.
+type storesByDistance struct {
+	stores []*Store
+	dists  []float64
+}
+
+func (s *storesByDistance) Len() int { return len(s.stores) }
+
+func (s *storesByDistance) Less(i, j int) bool {
+	return storeCloser(s.stores[i], s.dists[i], s.stores[j], s.dists[j])
+}
+
+// storeCloser reports whether store a at distance da sorts before store b at distance db.
+func storeCloser(a *Store, da float64, b *Store, db float64) bool {
+	if da != db {
+		return da < db
+	}
+	if a.Name != b.Name {
+		return a.Name < b.Name
+	}
+	return a.StoreID < b.StoreID
+}
+
+func (s *storesByDistance) Swap(i, j int) {
+	s.stores[i], s.stores[j] = s.stores[j], s.stores[i]
+	s.dists[i], s.dists[j] = s.dists[j], s.dists[i]
+}
+
+// parallelDistanceThreshold is the number of stores from which storeDistances splits the
+// work across goroutines. Below it, the goroutines cost more than they save.
+const parallelDistanceThreshold = 5000
+
+// queryStoresLimit caps the number of stores QueryStores returns, nearest first, set with the
+// QUERY_STORES_LIMIT env variable. Zero, the default, returns every store.
+var queryStoresLimit = func() int {
+	if os.Getenv("QUERY_STORES_LIMIT") == "" {
+		return 0
+	}
+	return positiveIntFromEnv("QUERY_STORES_LIMIT", 0)
+}()
+
+// nearestStores keeps the n stores nearest a point out of the stores added to it, in a
+// max-heap with the farthest kept store on top. Adding a store takes O(log n), so picking
+// the nearest n of m stores takes O(m log n) rather than the O(m log m) of sorting them all.
+type nearestStores struct {
+	n    int
+	lat  float64
+	lng  float64
+	heap storesByDistance
+}
+
+func newNearestStores(n int, lat, lng float64) *nearestStores {
+	return &nearestStores{n: n, lat: lat, lng: lng}
+}
+
+func (ns *nearestStores) add(st *Store) {
+	d := Distance(st.Lat, st.Long, ns.lat, ns.lng)
+	if len(ns.heap.stores) < ns.n {
+		heap.Push((*farthestFirst)(&ns.heap), &storeDist{st, d})
+		return
+	}
+	if storeCloser(st, d, ns.heap.stores[0], ns.heap.dists[0]) {
+		ns.heap.stores[0], ns.heap.dists[0] = st, d
+		heap.Fix((*farthestFirst)(&ns.heap), 0)
+	}
+}
+
+// sorted returns the kept stores, nearest first.
+func (ns *nearestStores) sorted() []*Store {
+	sort.Sort(&ns.heap)
+	return ns.heap.stores
+}
+
+type storeDist struct {
+	st *Store
+	d  float64
+}
+
+// farthestFirst orders stores in reverse of storesByDistance, for use with container/heap.
+type farthestFirst storesByDistance
+
+func (h *farthestFirst) Len() int           { return len(h.stores) }
+func (h *farthestFirst) Less(i, j int) bool { return (*storesByDistance)(h).Less(j, i) }
+func (h *farthestFirst) Swap(i, j int)      { (*storesByDistance)(h).Swap(i, j) }
+
+func (h *farthestFirst) Push(x interface{}) {
+	sd := x.(*storeDist)
+	h.stores = append(h.stores, sd.st)
+	h.dists = append(h.dists, sd.d)
+}
+
+func (h *farthestFirst) Pop() interface{} {
+	last := len(h.stores) - 1
+	sd := &storeDist{h.stores[last], h.dists[last]}
+	h.stores, h.dists = h.stores[:last], h.dists[:last]
+	return sd
+}
+
+// loadNearestStores streams the stores in storage and returns the n nearest coords, nearest
+// first, without holding the rest in memory.
+func loadNearestStores(ctx context.Context, client *datastore.Client, coords coord, n int) ([]*Store, error) {
+	ctx, span := startClientSpan(ctx, "datastore.query Store")
+	defer span.Finish()
+	nearest := newNearestStores(n, coords.Lat, coords.Long)
+	it := client.Run(ctx, newQuery(ctx, StoreKind))
+	for {
+		var st Store
+		_, err := it.Next(&st)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			span.SetError(err)
+			return nil, fmt.Errorf("failed to query for all stores: %v", err)
+		}
+		nearest.add(&st)
+	}
+	return nearest.sorted(), nil
+}
+
+// distanceWorkers is the number of goroutines storeDistances uses for large store sets.
+var distanceWorkers = positiveIntFromEnv("DISTANCE_WORKERS", runtime.NumCPU())
+
+// storeDistances returns the distance in miles from each store to the point.
+func storeDistances(stores []*Store, lat, lng float64, workers int) []float64 {
+	dists := make([]float64, len(stores))
+	if len(stores) < parallelDistanceThreshold || workers <= 1 {
+		for i, st := range stores {
+			dists[i] = Distance(st.Lat, st.Long, lat, lng)
+		}
+		return dists
+	}
+
+	chunk := (len(stores) + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < len(stores); start += chunk {
+		end := start + chunk
+		if end > len(stores) {
+			end = len(stores)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				dists[i] = Distance(stores[i].Lat, stores[i].Long, lat, lng)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	return dists
 }
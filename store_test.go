@@ -0,0 +1,613 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/datastore"
+	"googlemaps.github.io/maps"
+)
+
+func TestLoadStoreWithoutPlaceID(t *testing.T) {
+	// A store saved before PlaceID was added.
+	props := []datastore.Property{
+		{Name: "storeID", Value: "ChIJ-legacy"},
+		{Name: "name", Value: "QFC"},
+		{Name: "addr", Value: "500 Broadway E, Seattle, WA 98102"},
+		{Name: "lat", Value: 47.62},
+		{Name: "long", Value: -122.32},
+	}
+	var st Store
+	if err := datastore.LoadStruct(&st, props); err != nil {
+		t.Fatalf("LoadStruct() = %v", err)
+	}
+	if st.Name != "QFC" || st.PlaceID != "" {
+		t.Errorf("got store %+v, want QFC without a place id", st)
+	}
+	if got := storePlaceID(&st); got != "ChIJ-legacy" {
+		t.Errorf("storePlaceID() = %q, want the store id", got)
+	}
+}
+
+func TestVetStoreInfoCandidates(t *testing.T) {
+	ctx := context.Background()
+	st := &Store{Name: "Costco", Addr: "Nowhere"}
+	places := &fakePlaces{found: &maps.FindPlaceFromTextResponse{}}
+	err := vetStoreInfo(ctx, places, st)
+	if err == nil || !strings.HasPrefix(err.Error(), "no matching store found") {
+		t.Errorf("vetStoreInfo() with no candidates = %v, want a no matching store error", err)
+	}
+
+	places.found.Candidates = []maps.PlacesSearchResult{
+		{Name: "Costco", FormattedAddress: "8629 120th Ave NE, Kirkland, WA 98033, United States", PlaceID: "ChIJ-kirkland"},
+		{Name: "Costco", FormattedAddress: "4401 4th Ave S, Seattle, WA 98134", PlaceID: "ChIJ-sodo"},
+	}
+	err = vetStoreInfo(ctx, places, st)
+	if err == nil || !strings.Contains(err.Error(), "found 2 store(s)") || !strings.Contains(err.Error(), "Kirkland") {
+		t.Errorf("vetStoreInfo() with 2 candidates = %v, want an error listing them", err)
+	}
+	amb, ok := err.(*ambiguousStoreError)
+	if !ok {
+		t.Fatalf("vetStoreInfo() with 2 candidates returned %T, want *ambiguousStoreError", err)
+	}
+	want := []*StoreCandidate{
+		{Name: "Costco", Addr: "8629 120th Ave NE, Kirkland, WA 98033", PlaceID: "ChIJ-kirkland"},
+		{Name: "Costco", Addr: "4401 4th Ave S, Seattle, WA 98134", PlaceID: "ChIJ-sodo"},
+	}
+	if !reflect.DeepEqual(amb.candidates, want) {
+		t.Errorf("got candidates %+v, want %+v", amb.candidates, want)
+	}
+}
+
+func TestVetStorePlace(t *testing.T) {
+	ctx := context.Background()
+	d := placeDetails("Costco", "4401 4th Ave S, Seattle, WA 98134", 47.56, -122.33)
+	d.Types = []string{"supermarket"}
+	places := &fakePlaces{details: map[string]maps.PlaceDetailsResult{"ChIJ-sodo": d}}
+
+	st := &Store{Name: "Costco", Addr: "Seattle"}
+	if err := vetStorePlace(ctx, places, st, "ChIJ-sodo"); err != nil {
+		t.Fatalf("vetStorePlace() failed: %v", err)
+	}
+	want := Store{StoreID: "ChIJ-sodo", Name: "Costco", Addr: "4401 4th Ave S, Seattle, WA 98134", Lat: 47.56, Long: -122.33, PlaceID: "ChIJ-sodo", StoreType: "supermarket"}
+	if *st != want {
+		t.Errorf("vetStorePlace() set the store to %+v, want %+v", *st, want)
+	}
+
+	if err := vetStorePlace(ctx, places, &Store{}, "ChIJ-unknown"); err == nil {
+		t.Errorf("vetStorePlace() with an unknown place succeeded, want error")
+	}
+	d.Types = []string{"restaurant"}
+	places.details["ChIJ-sodo"] = d
+	if err := vetStorePlace(ctx, places, &Store{}, "ChIJ-sodo"); err == nil {
+		t.Errorf("vetStorePlace() with a restaurant succeeded, want error")
+	}
+}
+
+func TestVetStoreEdit(t *testing.T) {
+	ctx := context.Background()
+	var candidate maps.PlacesSearchResult
+	candidate.PlaceID = "ChIJ-new"
+	candidate.Name = "QFC"
+	candidate.FormattedAddress = "1600 E Olive Way, Seattle, WA 98102, United States"
+	candidate.Geometry.Location = maps.LatLng{Lat: 47.62, Lng: -122.32}
+	places := &fakePlaces{
+		found:   &maps.FindPlaceFromTextResponse{Candidates: []maps.PlacesSearchResult{candidate}},
+		details: map[string]maps.PlaceDetailsResult{"ChIJ-new": {Types: []string{"supermarket"}}},
+	}
+
+	st, err := vetStoreEdit(ctx, places, "ChIJ-old", "qfc", "olive way")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Store{StoreID: "ChIJ-old", Name: "QFC", Addr: "1600 E Olive Way, Seattle, WA 98102", Lat: 47.62, Long: -122.32, PlaceID: "ChIJ-new", StoreType: "supermarket"}
+	if *st != *want {
+		t.Errorf("vetStoreEdit() = %+v, want the vetted info under the same store id %+v", st, want)
+	}
+
+	places.details["ChIJ-new"] = maps.PlaceDetailsResult{Types: []string{"restaurant"}}
+	if _, err := vetStoreEdit(ctx, places, "ChIJ-old", "qfc", "olive way"); err == nil {
+		t.Errorf("vetStoreEdit() to a restaurant succeeded, want error")
+	}
+}
+
+func TestQueryStoreInfoPlaceID(t *testing.T) {
+	info := &QueryStoreInfo{Store: &Store{StoreID: "s1", PlaceID: "ChIJ-place"}}
+	b, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"place_id":"ChIJ-place"`) {
+		t.Errorf("got %s, want place_id", b)
+	}
+}
+
+func TestOrderFavoritesFirst(t *testing.T) {
+	// Already sorted by distance.
+	resp := QueryStoresResp{
+		{Store: &Store{StoreID: "a"}},
+		{Store: &Store{StoreID: "b"}, Favorite: true},
+		{Store: &Store{StoreID: "c"}},
+		{Store: &Store{StoreID: "d"}, Favorite: true},
+	}
+	orderFavoritesFirst(resp)
+
+	want := []string{"b", "d", "a", "c"}
+	for i, id := range want {
+		if resp[i].StoreID != id {
+			t.Fatalf("got store %q at index %d, want %q", resp[i].StoreID, i, id)
+		}
+	}
+}
+
+func TestStoreShortages(t *testing.T) {
+	const now = 1000000
+	store := &Store{StoreID: "store"}
+	other := &Store{StoreID: "other"}
+	items := []*Item{
+		{Name: "flour", StockReports: []*StockReport{
+			{StoreInfo: store, InStock: false, TimestampSec: now - 3600, SeenCnt: 2},
+		}},
+		{Name: "eggs", StockReports: []*StockReport{
+			{StoreInfo: store, InStock: true, TimestampSec: now - 60},
+		}},
+		// Restocked since the out-of-stock report.
+		{Name: "milk", StockReports: []*StockReport{
+			{StoreInfo: store, InStock: false, TimestampSec: now - 7200},
+			{StoreInfo: store, InStock: true, TimestampSec: now - 600},
+		}},
+		// Sold out since the in-stock report.
+		{Name: "yeast", StockReports: []*StockReport{
+			{StoreInfo: store, InStock: true, TimestampSec: now - 7200},
+			{StoreInfo: store, InStock: false, TimestampSec: now - 60, SeenCnt: 1},
+		}},
+		// Too old.
+		{Name: "rice", StockReports: []*StockReport{
+			{StoreInfo: store, InStock: false, TimestampSec: now - 2*secondsToDay},
+		}},
+		// Out of stock at another store.
+		{Name: "pasta", StockReports: []*StockReport{
+			{StoreInfo: other, InStock: false, TimestampSec: now - 60},
+		}},
+	}
+
+	got := storeShortages(items, "store", now, secondsToDay)
+	want := []ShortageInfo{
+		{ItemName: "yeast", SecondsAgo: 60, ReportedAgo: humanizeAge(60), SeenCnt: 1},
+		{ItemName: "flour", SecondsAgo: 3600, ReportedAgo: humanizeAge(3600), SeenCnt: 2},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d shortages, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("shortage %d = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
+
+func TestSortStoresByDistanceTies(t *testing.T) {
+	near := zipCodeToLatLong["98101"]
+	newStores := func() []*Store {
+		return []*Store{
+			{StoreID: "far", Name: "Albertsons", Lat: near.Lat + 1, Long: near.Long},
+			{StoreID: "b", Name: "Safeway", Lat: near.Lat, Long: near.Long},
+			{StoreID: "c", Name: "QFC", Lat: near.Lat, Long: near.Long},
+			{StoreID: "a", Name: "Safeway", Lat: near.Lat, Long: near.Long},
+		}
+	}
+	want := []string{"c", "a", "b", "far"}
+
+	// The order of equidistant stores must not depend on the order they were loaded in.
+	for run := 0; run < 10; run++ {
+		stores := newStores()
+		rand.Shuffle(len(stores), func(i, j int) { stores[i], stores[j] = stores[j], stores[i] })
+		if err := sortStoresByDistance(stores, "98101"); err != nil {
+			t.Fatalf("sortStoresByDistance() failed: %v", err)
+		}
+		for i, st := range stores {
+			if st.StoreID != want[i] {
+				t.Fatalf("run %d: got store %q at %d, want %q", run, st.StoreID, i, want[i])
+			}
+		}
+	}
+}
+
+func TestSortStoresUnknownZip(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "b", Name: "Safeway", Lat: 47.6, Long: -122.3},
+		{StoreID: "c", Name: "Albertsons", Lat: 1, Long: 1},
+		{StoreID: "a", Name: "Safeway", Lat: 47.7, Long: -122.3},
+	}
+	_, _, err := resolveUserCoords(context.Background(), CoordsReq{}, &User{ZipCode: "00000"})
+	if err == nil {
+		t.Fatalf("resolveUserCoords() with an unknown zip code succeeded, want error")
+	}
+	if hint := sortStoresWithoutCoords(stores, err); !strings.Contains(hint, "00000") {
+		t.Errorf("sortStoresWithoutCoords() returned hint %q, want it to name the zip code", hint)
+	}
+	want := []string{"c", "a", "b"}
+	for i, st := range stores {
+		if st.StoreID != want[i] {
+			t.Fatalf("got store %q at %d, want %q", st.StoreID, i, want[i])
+		}
+	}
+}
+
+func TestSortStoresByCoords(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "c", Name: "Albertsons", Lat: 1, Long: 1},
+		{StoreID: "b", Name: "Safeway", Lat: 47.6, Long: -122.3},
+		{StoreID: "a", Name: "Safeway", Lat: 47.7, Long: -122.3},
+	}
+	// From coordinates that aren't a zip code center, such as ones given in the request.
+	sortStoresByCoords(stores, coord{Lat: 47.71, Long: -122.3})
+	want := []string{"a", "b", "c"}
+	for i, st := range stores {
+		if st.StoreID != want[i] {
+			t.Fatalf("got store %q at %d, want %q", st.StoreID, i, want[i])
+		}
+	}
+}
+
+func randomStores(n int) []*Store {
+	r := rand.New(rand.NewSource(1))
+	stores := make([]*Store, n)
+	for i := range stores {
+		stores[i] = &Store{StoreID: fmt.Sprint(i), Lat: 25 + r.Float64()*24, Long: -124 + r.Float64()*57}
+	}
+	return stores
+}
+
+func TestStoreDistancesParallelMatchesSerial(t *testing.T) {
+	stores := randomStores(parallelDistanceThreshold * 2)
+	serial := storeDistances(stores, 47.6, -122.3, 1)
+	for _, workers := range []int{2, 3, 8} {
+		parallel := storeDistances(stores, 47.6, -122.3, workers)
+		for i := range serial {
+			if parallel[i] != serial[i] {
+				t.Fatalf("%d workers: distance %d = %v, want %v", workers, i, parallel[i], serial[i])
+			}
+		}
+	}
+}
+
+func benchmarkStoreDistances(b *testing.B, workers int) {
+	stores := randomStores(50000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		storeDistances(stores, 47.6, -122.3, workers)
+	}
+}
+
+func BenchmarkStoreDistancesSerial(b *testing.B)   { benchmarkStoreDistances(b, 1) }
+func BenchmarkStoreDistancesParallel(b *testing.B) { benchmarkStoreDistances(b, runtime.NumCPU()) }
+
+func TestNearestStoresMatchesSort(t *testing.T) {
+	stores := randomStores(1000)
+	// Stores at the same spot are ordered by name, then ID.
+	stores = append(stores,
+		&Store{StoreID: "tie-b", Name: "QFC", Lat: 47.6, Long: -122.3},
+		&Store{StoreID: "tie-a", Name: "QFC", Lat: 47.6, Long: -122.3},
+		&Store{StoreID: "tie-c", Name: "Albertsons", Lat: 47.6, Long: -122.3},
+	)
+	sorted := append([]*Store{}, stores...)
+	if err := sortStoresByDistance(sorted, "98101"); err != nil {
+		t.Fatal(err)
+	}
+
+	coords := zipCodeToLatLong["98101"]
+	for _, n := range []int{1, 2, 10, len(stores) + 5} {
+		ns := newNearestStores(n, coords.Lat, coords.Long)
+		for _, st := range stores {
+			ns.add(st)
+		}
+		got := ns.sorted()
+		want := sorted
+		if n < len(want) {
+			want = want[:n]
+		}
+		if len(got) != len(want) {
+			t.Fatalf("n=%d: got %d stores, want %d", n, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("n=%d: got store %q at %d, want %q", n, got[i].StoreID, i, want[i].StoreID)
+			}
+		}
+	}
+}
+
+func BenchmarkNearestStoresSort(b *testing.B) {
+	stores := randomStores(10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sorted := append([]*Store{}, stores...)
+		sortStoresByDistance(sorted, "98101")
+		_ = sorted[:10]
+	}
+}
+
+func BenchmarkNearestStoresHeap(b *testing.B) {
+	stores := randomStores(10000)
+	coords := zipCodeToLatLong["98101"]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ns := newNearestStores(10, coords.Lat, coords.Long)
+		for _, st := range stores {
+			ns.add(st)
+		}
+		ns.sorted()
+	}
+}
+
+func TestParseAddressComponents(t *testing.T) {
+	addr, err := parseAddressComponents("400 Broad St, Seattle, WA 98109")
+	if err != nil {
+		t.Fatalf("parseAddressComponents() failed: %v", err)
+	}
+	want := Address{Street: "400 Broad St", City: "Seattle", State: "WA", ZipCode: "98109"}
+	if *addr != want {
+		t.Errorf("parseAddressComponents() = %+v, want %+v", *addr, want)
+	}
+
+	for _, bad := range []string{
+		"400 Broad St Seattle WA 98109",
+		"400 Broad St, Seattle, 98109",
+		"400 Broad St, Seattle, WA",
+	} {
+		if _, err := parseAddressComponents(bad); err == nil {
+			t.Errorf("parseAddressComponents(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestParseAddressComponentsUnitNumber(t *testing.T) {
+	const address = "400 Broad St, Suite 2, Seattle, WA 98109"
+	if _, err := parseAddressComponents(address); err == nil {
+		t.Errorf("parseAddressComponents() of a street with a comma succeeded with the default pattern, want error")
+	}
+
+	f, err := ioutil.TempFile("", "addressPatterns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, `^(?P<street>.+), (?P<city>[^,]+), (?P<state>[A-Za-z]{2,}) (?P<zip>[0-9]{5,})$`)
+	f.Close()
+
+	patterns, err := loadAddressPatterns(f.Name())
+	if err != nil {
+		t.Fatalf("loadAddressPatterns() failed: %v", err)
+	}
+	orig := addressPatterns
+	addressPatterns = patterns
+	defer func() { addressPatterns = orig }()
+
+	addr, err := parseAddressComponents(address)
+	if err != nil {
+		t.Fatalf("parseAddressComponents() failed: %v", err)
+	}
+	want := Address{Street: "400 Broad St, Suite 2", City: "Seattle", State: "WA", ZipCode: "98109"}
+	if *addr != want {
+		t.Errorf("parseAddressComponents() = %+v, want %+v", *addr, want)
+	}
+}
+
+func TestLoadAddressPatterns(t *testing.T) {
+	f, err := ioutil.TempFile("", "addressPatterns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, "# No comma between the city and state.")
+	fmt.Fprintln(f, `^(?P<street>.+), (?P<city>[^,]+) (?P<state>[A-Z]{2}) (?P<zip>[0-9]{5})$`)
+	fmt.Fprintln(f, defaultAddressPattern)
+	f.Close()
+
+	patterns, err := loadAddressPatterns(f.Name())
+	if err != nil {
+		t.Fatalf("loadAddressPatterns() failed: %v", err)
+	}
+	orig := addressPatterns
+	addressPatterns = patterns
+	defer func() { addressPatterns = orig }()
+
+	want := Address{Street: "400 Broad St", City: "Seattle", State: "WA", ZipCode: "98109"}
+	for _, address := range []string{
+		"400 Broad St, Seattle WA 98109",
+		"400 Broad St, Seattle, WA 98109",
+	} {
+		addr, err := parseAddressComponents(address)
+		if err != nil {
+			t.Errorf("parseAddressComponents(%q) failed: %v", address, err)
+			continue
+		}
+		if *addr != want {
+			t.Errorf("parseAddressComponents(%q) = %+v, want %+v", address, *addr, want)
+		}
+	}
+	if _, err := parseAddressComponents("400 Broad St Seattle WA 98109"); err == nil {
+		t.Errorf("parseAddressComponents() of an address matching no pattern succeeded, want error")
+	}
+}
+
+func TestCompileAddressPatternErrors(t *testing.T) {
+	for _, pattern := range []string{
+		`^(?P<street>.+), (?P<city>.+`,
+		`^(?P<street>.+), (?P<city>.+), (?P<state>[A-Z]{2})$`,
+	} {
+		if _, err := compileAddressPattern(pattern); err == nil {
+			t.Errorf("compileAddressPattern(%q) succeeded, want error", pattern)
+		}
+	}
+}
+
+func TestValidateDeleteStoreReq(t *testing.T) {
+	req := &DeleteStoreReq{UserID: "u", StoreID: " store "}
+	if err := validateDeleteStoreReq(req); err != nil || req.StoreID != "store" {
+		t.Errorf("validateDeleteStoreReq() = %v with store id %q, want the trimmed store id", err, req.StoreID)
+	}
+	for _, req := range []*DeleteStoreReq{{StoreID: "store"}, {UserID: "u", StoreID: " "}} {
+		if err := validateDeleteStoreReq(req); err == nil {
+			t.Errorf("validateDeleteStoreReq(%+v) succeeded, want error", req)
+		}
+	}
+}
+
+func TestCleanAndValidateAddStoreReqCoords(t *testing.T) {
+	coord := func(f float64) *float64 { return &f }
+	const addr = "85 Pike St, Seattle, WA 98101"
+	req := &AddStoreReq{UserID: "u", Name: "Pike Place Market Stall", AddrText: addr, Lat: coord(47.61), Long: coord(-122.34)}
+	if err := cleanAndValidateAddStoreReq(req); err != nil {
+		t.Errorf("cleanAndValidateAddStoreReq() failed: %v", err)
+	}
+	for _, req := range []*AddStoreReq{
+		{UserID: "u", Name: "Stall", AddrText: addr, Lat: coord(47.61)},
+		{UserID: "u", Name: "Stall", AddrText: addr, Long: coord(-122.34)},
+		{UserID: "u", Name: "Stall", AddrText: addr, Lat: coord(91), Long: coord(-122.34)},
+		{UserID: "u", Name: "Stall", AddrText: addr, Lat: coord(47.61), Long: coord(-180.5)},
+		// Not vetted, so the address must already be in an accepted format.
+		{UserID: "u", Name: "Stall", AddrText: "Pike Place", Lat: coord(47.61), Long: coord(-122.34)},
+	} {
+		if err := cleanAndValidateAddStoreReq(req); err == nil {
+			t.Errorf("cleanAndValidateAddStoreReq(%+v) succeeded, want error", req)
+		}
+	}
+}
+
+func TestUnvettedStore(t *testing.T) {
+	a := &Store{Name: "Stall", Addr: "85 Pike St, Seattle, WA 98101"}
+	b := &Store{Name: "Stall", Addr: "85 Pike St, Seattle, WA 98101"}
+	if err := unvettedStore(a, 47.61, -122.34); err != nil {
+		t.Fatalf("unvettedStore() failed: %v", err)
+	}
+	if err := unvettedStore(b, 47.61, -122.34); err != nil {
+		t.Fatalf("unvettedStore() failed: %v", err)
+	}
+	if a.StoreID == "" || a.StoreID == b.StoreID {
+		t.Errorf("got store ids %q and %q, want distinct ids", a.StoreID, b.StoreID)
+	}
+	if !a.Unvetted || a.Lat != 47.61 || a.Long != -122.34 || a.Name != "Stall" {
+		t.Errorf("got store %+v, want the given info unvetted", a)
+	}
+	if got := storePlaceID(a); got != "" {
+		t.Errorf("storePlaceID() = %q, want none for an unvetted store", got)
+	}
+}
+
+func TestDuplicateStore(t *testing.T) {
+	stores := []*Store{
+		{StoreID: "qfc", Name: "QFC #823", Lat: 47.6101, Long: -122.3401},
+		{StoreID: "costco", Name: "Costco Wholesale #123 Seattle", Lat: 47.61, Long: -122.34},
+	}
+	for _, tc := range []struct {
+		name     string
+		lat, lng float64
+		want     string
+	}{
+		{"COSTCO", 47.6102, -122.3402, "costco"},
+		{"QFC @ Pike", 47.61, -122.34, "qfc"},
+		// A different chain at the same spot.
+		{"Safeway", 47.61, -122.34, ""},
+		// The same chain a mile away.
+		{"Costco", 47.625, -122.34, ""},
+		{"#12", 47.61, -122.34, ""},
+	} {
+		got := duplicateStore(stores, tc.name, tc.lat, tc.lng)
+		if (got == nil && tc.want != "") || (got != nil && got.StoreID != tc.want) {
+			t.Errorf("duplicateStore(%q, %v, %v) = %+v, want %q", tc.name, tc.lat, tc.lng, got, tc.want)
+		}
+	}
+}
+
+func TestVetStoreInfoTypes(t *testing.T) {
+	ctx := context.Background()
+	var candidate maps.PlacesSearchResult
+	candidate.PlaceID = "ChIJ-qfc"
+	candidate.Name = "QFC"
+	candidate.FormattedAddress = "500 Broadway E, Seattle, WA 98102, United States"
+	places := &fakePlaces{
+		found:   &maps.FindPlaceFromTextResponse{Candidates: []maps.PlacesSearchResult{candidate}},
+		details: map[string]maps.PlaceDetailsResult{},
+	}
+
+	for _, tc := range []struct {
+		types  []string
+		wantOK bool
+	}{
+		// The store type isn't listed first.
+		{[]string{"point_of_interest", "supermarket", "establishment"}, true},
+		{[]string{"supermarket"}, true},
+		{[]string{"point_of_interest", "establishment"}, false},
+		{nil, false},
+	} {
+		places.details["ChIJ-qfc"] = maps.PlaceDetailsResult{Types: tc.types}
+		err := vetStoreInfo(ctx, places, &Store{Name: "qfc", Addr: "broadway"})
+		if gotOK := err == nil; gotOK != tc.wantOK {
+			t.Errorf("vetStoreInfo() with types %q = %v, want ok: %t", tc.types, err, tc.wantOK)
+		}
+	}
+
+	// A failed details lookup isn't taken as a place with no types.
+	delete(places.details, "ChIJ-qfc")
+	err := vetStoreInfo(ctx, places, &Store{Name: "qfc", Addr: "broadway"})
+	if err == nil || !strings.Contains(err.Error(), "NOT_FOUND") {
+		t.Errorf("vetStoreInfo() with a failed details lookup = %v, want the lookup error", err)
+	}
+}
+
+func TestStoresPage(t *testing.T) {
+	var resp QueryStoresResp
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		resp = append(resp, &QueryStoreInfo{Store: &Store{StoreID: id}})
+	}
+
+	seen := make(map[string]bool)
+	walk := func(cursor string) *QueryStoresPage {
+		t.Helper()
+		req := &QueryStoresReq{UserID: "u", PageSize: 2, Cursor: cursor}
+		if err := cleanAndValidateQueryStoresReq(req); err != nil {
+			t.Fatalf("cleanAndValidateQueryStoresReq() failed: %v", err)
+		}
+		page := storesPage(resp, req.PageSize, req.offset)
+		for _, info := range page.Stores {
+			if seen[info.StoreID] {
+				t.Errorf("store %q is on more than one page", info.StoreID)
+			}
+			seen[info.StoreID] = true
+		}
+		return page
+	}
+
+	first := walk("")
+	if len(first.Stores) != 2 || first.Stores[0].StoreID != "a" || first.NextCursor == "" {
+		t.Fatalf("got first page %+v, want a and b with a next cursor", first)
+	}
+	second := walk(first.NextCursor)
+	if len(second.Stores) != 2 || second.Stores[0].StoreID != "c" || second.NextCursor == "" {
+		t.Fatalf("got second page %+v, want c and d with a next cursor", second)
+	}
+	if second.Pagination == nil || second.Offset != 2 || second.Limit != 2 || *second.Total != len(resp) {
+		t.Errorf("got second page pagination %+v, want offset 2, limit 2 and total %d", second.Pagination, len(resp))
+	}
+	last := walk(second.NextCursor)
+	if len(last.Stores) != 1 || last.Stores[0].StoreID != "e" || last.NextCursor != "" {
+		t.Errorf("got last page %+v, want e without a next cursor", last)
+	}
+	if len(seen) != len(resp) {
+		t.Errorf("walked %d stores, want all %d", len(seen), len(resp))
+	}
+
+	if err := cleanAndValidateQueryStoresReq(&QueryStoresReq{UserID: "u", Cursor: "bogus"}); err == nil {
+		t.Errorf("cleanAndValidateQueryStoresReq() with a bogus cursor succeeded, want error")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"googlemaps.github.io/maps"
+)
+
+// ******************************************
+// ** BEGIN BackfillStoreTypes
+// ******************************************
+
+type BackfillStoreTypesReq struct {
+	// Revet re-vets a store by its name and address if looking it up by place ID fails, such
+	// as when Places retired the ID. Only the type of the re-vetted place is kept.
+	Revet bool `json:"revet"`
+	// Cursor resumes a backfill where the previous request left off.
+	Cursor string `json:"cursor"`
+}
+
+type BackfillStoreTypesResp struct {
+	Progress *BatchProgress `json:"progress"`
+	// Updated are the stores that were given a type.
+	Updated  []*Store        `json:"updated"`
+	Failures []*StoreFailure `json:"failures"`
+	// NextCursor resumes the backfill if it yielded before every store was checked.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// BackfillStoreTypes sets the StoreType of the stores vetted before it was recorded, in
+// batches limited by jobLimits. Each typeless store is looked up in Places by its place ID,
+// pausing revetDelay between lookups so the backfill doesn't exhaust the quota. Stores that
+// already have a type and unvetted stores are skipped. It is an admin endpoint.
+func BackfillStoreTypes(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req BackfillStoreTypesReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	var cursor datastore.Cursor
+	if req.Cursor != "" {
+		var err error
+		if cursor, err = datastore.DecodeCursor(req.Cursor); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("cursor is invalid: %v", err)
+		}
+	}
+
+	places, err := PlacesClient()
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	resp := &BackfillStoreTypesResp{
+		Updated:  make([]*Store, 0),
+		Failures: make([]*StoreFailure, 0),
+	}
+	resp.Progress, err = runBatches(ctx, "backfill store types", jobLimits, func(ctx context.Context, size int) (int, bool, error) {
+		stores, cursors, err := loadStoreBatch(ctx, client, cursor, size)
+		if err != nil {
+			return 0, false, err
+		}
+		// As in RevetStores, only move past the stores that were looked up.
+		updated, failures, checked := backfillStoreTypeBatch(ctx, places, stores, req.Revet, revetDelay)
+		if checked > 0 {
+			cursor = cursors[checked-1]
+		}
+		for _, st := range updated {
+			if err := setStoreTypeInStorage(ctx, client, st.StoreID, st.StoreType); err != nil {
+				failures = append(failures, &StoreFailure{StoreID: st.StoreID, Error: err.Error()})
+				continue
+			}
+			resp.Updated = append(resp.Updated, st)
+		}
+		resp.Failures = append(resp.Failures, failures...)
+		return checked, checked == len(stores) && len(stores) < size, nil
+	})
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !resp.Progress.Done {
+		resp.NextCursor = cursor.String()
+	}
+
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END BackfillStoreTypes
+// ******************************************
+
+// backfillStoreTypeBatch looks up the type of each typeless vetted store, pausing delay
+// between lookups, and returns copies of the stores it found a type for, the stores it
+// couldn't, and how many of the stores it checked before ctx was done. If revet is set, a
+// store whose place ID lookup fails is re-vetted by its name and address.
+func backfillStoreTypeBatch(ctx context.Context, places placesClient, stores []*Store, revet bool, delay time.Duration) ([]*Store, []*StoreFailure, int) {
+	var updated []*Store
+	var failures []*StoreFailure
+	lookups := 0
+	for i, st := range stores {
+		if st.StoreType != "" || st.Unvetted {
+			continue
+		}
+		lookups++
+		if lookups > 1 {
+			select {
+			case <-ctx.Done():
+				return updated, failures, i
+			case <-time.After(delay):
+			}
+		}
+		storeType, err := lookupStoreType(ctx, places, st, revet)
+		if err != nil {
+			failures = append(failures, &StoreFailure{StoreID: st.StoreID, Error: err.Error()})
+			continue
+		}
+		after := *st
+		after.StoreType = storeType
+		updated = append(updated, &after)
+	}
+	return updated, failures, len(stores)
+}
+
+// lookupStoreType returns the store's type from its Places details, falling back to
+// re-vetting a copy of the store if revet is set.
+func lookupStoreType(ctx context.Context, places placesClient, st *Store, revet bool) (string, error) {
+	details, err := places.PlaceDetails(ctx, &maps.PlaceDetailsRequest{
+		PlaceID: storePlaceID(st),
+		Fields:  []maps.PlaceDetailsFieldMask{maps.PlaceDetailsFieldMaskTypes},
+	})
+	if err != nil {
+		if !revet {
+			return "", fmt.Errorf("failed to look up place: %v", err)
+		}
+		vetted := &Store{Name: st.Name, Addr: st.Addr}
+		if vetErr := vetStoreInfo(ctx, places, vetted); vetErr != nil {
+			return "", fmt.Errorf("failed to look up place: %v; failed to revet store: %v", err, vetErr)
+		}
+		return vetted.StoreType, nil
+	}
+	storeType := relevantStoreType(details.Types)
+	if storeType == "" {
+		return "", fmt.Errorf("place has none of the relevant store types: %v", details.Types)
+	}
+	return storeType, nil
+}
+
+// setStoreTypeInStorage sets the type of the store in a transaction, so that an edit made
+// during the backfill isn't overwritten. A store deleted since it was loaded is skipped.
+func setStoreTypeInStorage(ctx context.Context, client *datastore.Client, storeID, storeType string) error {
+	key := nameKey(ctx, StoreKind, storeID)
+	_, err := client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var st Store
+		if err := tx.Get(key, &st); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			return fmt.Errorf("failed to fetch store from storage: %v", err)
+		}
+		if st.StoreType != "" {
+			return nil
+		}
+		st.StoreType = storeType
+		if _, err := tx.Put(key, &st); err != nil {
+			return fmt.Errorf("failed to update store in storage: %v", err)
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"googlemaps.github.io/maps"
+)
+
+func TestBackfillStoreTypeBatch(t *testing.T) {
+	withTypes := func(d maps.PlaceDetailsResult, types ...string) maps.PlaceDetailsResult {
+		d.Types = types
+		return d
+	}
+	places := &fakePlaces{
+		details: map[string]maps.PlaceDetailsResult{
+			"qfc":     withTypes(placeDetails("QFC", "500 Broadway E, Seattle, WA 98102", 47.62, -122.32), "grocery_or_supermarket", "supermarket", "food", "store"),
+			"bartell": withTypes(placeDetails("Bartell Drugs", "600 Pine St, Seattle, WA 98101", 47.61, -122.33), "pharmacy", "drugstore", "store"),
+			"cafe":    withTypes(placeDetails("Cafe Vita", "1005 E Pike St, Seattle, WA 98122", 47.61, -122.32), "cafe", "food"),
+			"new-tj":  withTypes(placeDetails("Trader Joe's", "1700 E Madison St, Seattle, WA 98122", 47.61, -122.31), "supermarket", "store"),
+		},
+		found: &maps.FindPlaceFromTextResponse{Candidates: []maps.PlacesSearchResult{{
+			Name:             "Trader Joe's",
+			FormattedAddress: "1700 E Madison St, Seattle, WA 98122, United States",
+			PlaceID:          "new-tj",
+		}}},
+	}
+	stores := []*Store{
+		{StoreID: "qfc", Name: "QFC"},
+		// Keyed by an older ID, with its place ID recorded.
+		{StoreID: "bartell-old", PlaceID: "bartell", Name: "Bartell Drugs"},
+		// Already typed, so not looked up.
+		{StoreID: "safeway", Name: "Safeway", StoreType: "supermarket"},
+		// Not in Places.
+		{StoreID: "b3e1c2d4", Name: "Pike Place Market Stall", Unvetted: true},
+		{StoreID: "cafe", Name: "Cafe Vita"},
+		// Places retired the ID; only a revet finds the store's type.
+		{StoreID: "old-tj", Name: "Trader Joe's", Addr: "1700 E Madison St, Seattle, WA 98122"},
+	}
+
+	updated, failures, checked := backfillStoreTypeBatch(context.Background(), places, stores, false, 0)
+	if checked != len(stores) {
+		t.Errorf("checked %d stores, want all %d", checked, len(stores))
+	}
+	want := map[string]string{"qfc": "grocery_or_supermarket", "bartell-old": "pharmacy"}
+	if len(updated) != len(want) {
+		t.Fatalf("got %d updated stores, want %d", len(updated), len(want))
+	}
+	for _, st := range updated {
+		if st.StoreType != want[st.StoreID] {
+			t.Errorf("store %q got type %q, want %q", st.StoreID, st.StoreType, want[st.StoreID])
+		}
+	}
+	if stores[0].StoreType != "" {
+		t.Errorf("backfill modified the loaded store, want a copy")
+	}
+	if len(failures) != 2 || failures[0].StoreID != "cafe" || failures[1].StoreID != "old-tj" {
+		t.Errorf("got failures %+v, want cafe and old-tj", failures)
+	}
+
+	updated, failures, _ = backfillStoreTypeBatch(context.Background(), places, stores[5:], true, 0)
+	if len(failures) != 0 || len(updated) != 1 {
+		t.Fatalf("got %d updated and failures %+v with revet, want old-tj updated", len(updated), failures)
+	}
+	if st := updated[0]; st.StoreID != "old-tj" || st.StoreType != "supermarket" {
+		t.Errorf("got %+v with revet, want old-tj as a supermarket under its own ID", st)
+	}
+}
+
+func TestBackfillStoreTypeBatchCanceled(t *testing.T) {
+	places := &fakePlaces{details: map[string]maps.PlaceDetailsResult{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The typed store is skipped without a lookup, then only "a" is looked up.
+	stores := []*Store{{StoreID: "typed", StoreType: "supermarket"}, {StoreID: "a"}, {StoreID: "b"}}
+	_, failures, checked := backfillStoreTypeBatch(ctx, places, stores, false, time.Hour)
+	if len(failures) != 1 || failures[0].StoreID != "a" {
+		t.Errorf("got failures %+v after the request was canceled, want only a", failures)
+	}
+	// The cursor only moves past "a", so the next request backfills "b".
+	if checked != 2 {
+		t.Errorf("checked %d stores after the request was canceled, want 2", checked)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxTelemetryMessageLen  = 1000
+	maxTelemetryEndpointLen = 200
+	maxTelemetryVersionLen  = 50
+)
+
+// telemetryLimiter caps the client errors each user and each IP address may submit per
+// minute, set with the TELEMETRY_RATE_LIMIT env variable. Limits are per server instance.
+var telemetryLimiter = newRateLimiter(positiveIntFromEnv("TELEMETRY_RATE_LIMIT", 10), time.Minute)
+
+// ClientError is an error reported by a client app. It doesn't identify the user who
+// reported it.
+type ClientError struct {
+	ErrorID      string `datastore:"errorID" json:"error_id"`
+	Endpoint     string `datastore:"endpoint" json:"endpoint"`
+	Message      string `datastore:"message,noindex" json:"message"`
+	AppVersion   string `datastore:"appVersion" json:"app_version"`
+	TimestampSec int64  `datastore:"timestampSec" json:"timestamp_sec"`
+}
+
+// ******************************************
+// ** BEGIN ReportClientError
+// ******************************************
+
+type ReportClientErrorReq struct {
+	// UserID is optional since the app may fail before the user is set up. It's only used
+	// for rate limiting.
+	UserID     string `json:"user_id"`
+	Endpoint   string `json:"endpoint"`
+	Message    string `json:"message"`
+	AppVersion string `json:"app_version"`
+}
+
+// ReportClientError records an error a client app ran into. Personal data is scrubbed from
+// the message before it's stored.
+func ReportClientError(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ReportClientErrorReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if err := cleanAndValidateReportClientErrorReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	now := time.Now()
+	if !telemetryLimiter.allow("ip:"+clientIP(r), now) || (req.UserID != "" && !telemetryLimiter.allow("user:"+req.UserID, now)) {
+		return http.StatusTooManyRequests, fmt.Errorf("too many client errors reported, try again later")
+	}
+
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to generate error id: %v", err)
+	}
+	ce := &ClientError{
+		ErrorID:      uid.String(),
+		Endpoint:     req.Endpoint,
+		Message:      scrubPersonalData(req.Message),
+		AppVersion:   req.AppVersion,
+		TimestampSec: now.Unix(),
+	}
+	if err := createClientErrorInStorage(ctx, ce); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateReportClientErrorReq(req *ReportClientErrorReq) error {
+	req.Endpoint = strings.TrimSpace(req.Endpoint)
+	req.Message = strings.TrimSpace(req.Message)
+	req.AppVersion = strings.TrimSpace(req.AppVersion)
+	if req.Message == "" {
+		return fmt.Errorf("missing error message")
+	}
+	if req.AppVersion == "" {
+		return fmt.Errorf("missing app version")
+	}
+	if len(req.Endpoint) > maxTelemetryEndpointLen {
+		return fmt.Errorf("endpoint is longer than %d characters", maxTelemetryEndpointLen)
+	}
+	if len(req.AppVersion) > maxTelemetryVersionLen {
+		return fmt.Errorf("app version is longer than %d characters", maxTelemetryVersionLen)
+	}
+	if len(req.Message) > maxTelemetryMessageLen {
+		req.Message = req.Message[:maxTelemetryMessageLen]
+	}
+	return nil
+}
+
+// ******************************************
+// ** END ReportClientError
+// ******************************************
+
+// personalDataPatterns match the personal data that may show up in client error messages:
+// email addresses, user and other IDs, and phone and card numbers.
+var personalDataPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[^\s@]+@[^\s@]+`),
+	regexp.MustCompile(`(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`),
+	regexp.MustCompile(`\+?\d[\d\s().-]{6,}\d`),
+}
+
+// scrubPersonalData replaces the personal data in the message with "[redacted]".
+func scrubPersonalData(msg string) string {
+	for _, re := range personalDataPatterns {
+		msg = re.ReplaceAllString(msg, "[redacted]")
+	}
+	return msg
+}
+
+// clientIP returns the IP address the request came from. App Engine sets the
+// X-Appengine-User-Ip header and strips it from client requests.
+func clientIP(r *http.Request) string {
+	if ip := r.Header.Get("X-Appengine-User-Ip"); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiter allows up to limit events per key in each fixed window.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, windows: make(map[string]*rateWindow)}
+}
+
+// allow records an event for the key and reports whether it is within the limit.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.window {
+		// Drop expired windows now and then so keys that stopped sending don't pile up.
+		if !ok && len(l.windows) >= 1000 {
+			for k, w := range l.windows {
+				if now.Sub(w.start) >= l.window {
+					delete(l.windows, k)
+				}
+			}
+		}
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+func createClientErrorInStorage(ctx context.Context, ce *ClientError) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if _, err := client.Put(ctx, nameKey(ctx, ClientErrorKind, ce.ErrorID), ce); err != nil {
+		return fmt.Errorf("failed to create client error in storage: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Unix(1000, 0)
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow("a", now); got != want {
+			t.Errorf("allow #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if !l.allow("b", now) {
+		t.Error("allow(b) = false, want keys limited separately")
+	}
+	if !l.allow("a", now.Add(time.Minute)) {
+		t.Error("allow(a) in the next window = false, want true")
+	}
+}
+
+func TestScrubPersonalData(t *testing.T) {
+	msg := "user 3f2b8c1e-4a5d-4e6f-8a9b-0c1d2e3f4a5b (tony@stark.com, +1 206-555-0100) failed: 503"
+	got := scrubPersonalData(msg)
+	for _, leak := range []string{"3f2b8c1e", "tony@stark.com", "555-0100"} {
+		if strings.Contains(got, leak) {
+			t.Errorf("scrubPersonalData(%q) = %q, leaks %q", msg, got, leak)
+		}
+	}
+	if !strings.HasSuffix(got, "failed: 503") {
+		t.Errorf("scrubPersonalData(%q) = %q, want the status code kept", msg, got)
+	}
+}
+
+func TestCleanAndValidateReportClientErrorReq(t *testing.T) {
+	req := &ReportClientErrorReq{Endpoint: "/item/query", Message: strings.Repeat("x", 2*maxTelemetryMessageLen), AppVersion: "1.2.0"}
+	if err := cleanAndValidateReportClientErrorReq(req); err != nil {
+		t.Fatalf("cleanAndValidateReportClientErrorReq() failed: %v", err)
+	}
+	if len(req.Message) != maxTelemetryMessageLen {
+		t.Errorf("got message of length %d, want it truncated to %d", len(req.Message), maxTelemetryMessageLen)
+	}
+	for _, bad := range []*ReportClientErrorReq{
+		{AppVersion: "1.2.0"},
+		{Message: "crash"},
+		{Message: "crash", AppVersion: strings.Repeat("1", maxTelemetryVersionLen+1)},
+	} {
+		if err := cleanAndValidateReportClientErrorReq(bad); err == nil {
+			t.Errorf("cleanAndValidateReportClientErrorReq(%+v) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestReportClientErrorRateLimited(t *testing.T) {
+	orig := telemetryLimiter
+	telemetryLimiter = newRateLimiter(1, time.Minute)
+	t.Cleanup(func() { telemetryLimiter = orig })
+	telemetryLimiter.allow("ip:192.0.2.1", time.Now())
+
+	body := `{"message": "crash", "app_version": "1.2.0"}`
+	r := httptest.NewRequest("POST", "/telemetry/error", bytes.NewBufferString(body))
+	r.RemoteAddr = "192.0.2.1:1234"
+	status, err := ReportClientError(r.Context(), httptest.NewRecorder(), r)
+	if status != http.StatusTooManyRequests || err == nil {
+		t.Errorf("ReportClientError() = %d, %v, want 429", status, err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// clientIDHeader names the client app a request comes from. Each allowed client app has its
+// own datastore namespace, so apps sharing the server don't see each other's data.
+const clientIDHeader = "X-Client-ID"
+
+// validClientID matches client IDs that are safe to use in a datastore namespace.
+var validClientID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// allowedClientIDs are the client IDs accepted in clientIDHeader, set with the
+// comma-separated ALLOWED_CLIENT_IDS env variable. If it is unset, requests naming a client
+// are rejected and every request uses storageNamespace.
+var allowedClientIDs = clientIDsFromEnv("ALLOWED_CLIENT_IDS")
+
+// defaultClientID is the client of the requests that don't name one when allowedClientIDs is
+// set, set with the DEFAULT_CLIENT_ID env variable. It must be an allowed client ID. If it is
+// unset, those requests are rejected, so that no caller can skip the allowlist and reach the
+// data in storageNamespace.
+var defaultClientID = defaultClientIDFromEnv("DEFAULT_CLIENT_ID", allowedClientIDs)
+
+type namespaceKey struct{}
+
+// clientMiddleware scopes the request's storage to the namespace of the client app named in
+// clientIDHeader. Requests that don't name a client use storageNamespace if no client IDs are
+// allowed, and defaultClientID otherwise.
+func clientMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID := r.Header.Get(clientIDHeader)
+		if clientID == "" && len(allowedClientIDs) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if clientID == "" {
+			if defaultClientID == "" {
+				http.Error(w, fmt.Sprintf("missing %s header", clientIDHeader), http.StatusForbidden)
+				return
+			}
+			clientID = defaultClientID
+		}
+		if !allowedClientIDs[clientID] {
+			http.Error(w, fmt.Sprintf("client id is invalid: %q", clientID), http.StatusForbidden)
+			return
+		}
+		ctx := withNamespace(r.Context(), clientNamespace(clientID))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientNamespace returns the datastore namespace of the client app. It is nested under
+// storageNamespace so deployments stay isolated from each other too.
+func clientNamespace(clientID string) string {
+	if storageNamespace == "" {
+		return clientID
+	}
+	return storageNamespace + "." + clientID
+}
+
+func withNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// namespaceFromContext returns the datastore namespace of the request.
+func namespaceFromContext(ctx context.Context) string {
+	if ns, ok := ctx.Value(namespaceKey{}).(string); ok {
+		return ns
+	}
+	return storageNamespace
+}
+
+// detachedContext returns a context in the request's namespace that isn't canceled with the
+// request, for work that outlives it.
+func detachedContext(ctx context.Context) context.Context {
+	return withNamespace(context.Background(), namespaceFromContext(ctx))
+}
+
+// clientIDsFromEnv returns the set of comma-separated client IDs in the env variable key. A
+// malformed client ID stops the server.
+func clientIDsFromEnv(key string) map[string]bool {
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(os.Getenv(key), ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !validClientID.MatchString(id) {
+			log.Fatalf("%s env variable has malformed client id %q", key, id)
+		}
+		ids[id] = true
+	}
+	return ids
+}
+
+// defaultClientIDFromEnv returns the client ID in the env variable key. A client ID that
+// isn't in allowed stops the server.
+func defaultClientIDFromEnv(key string, allowed map[string]bool) string {
+	id := strings.TrimSpace(os.Getenv(key))
+	if id != "" && !allowed[id] {
+		log.Fatalf("%s env variable %q is not one of the allowed client ids", key, id)
+	}
+	return id
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientMiddleware(t *testing.T) {
+	origIDs, origDefault, origNS := allowedClientIDs, defaultClientID, storageNamespace
+	defer func() { allowedClientIDs, defaultClientID, storageNamespace = origIDs, origDefault, origNS }()
+	allowedClientIDs = map[string]bool{"seattle": true, "portland": true}
+	storageNamespace = "prod"
+
+	var userKeyNS string
+	h := clientMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userKeyNS = nameKey(r.Context(), UserKind, "user").Namespace
+	}))
+	serve := func(clientID string) int {
+		userKeyNS = ""
+		r := httptest.NewRequest("POST", "/user/query", nil)
+		if clientID != "" {
+			r.Header.Set(clientIDHeader, clientID)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		clientID string
+		wantNS   string
+	}{
+		{"seattle", "prod.seattle"},
+		{"portland", "prod.portland"},
+	} {
+		if code := serve(tc.clientID); code != http.StatusOK {
+			t.Fatalf("client %q: got status %d, want %d", tc.clientID, code, http.StatusOK)
+		}
+		// The same user ID maps to a different entity for each client, so users and the
+		// reports they upload are isolated.
+		if userKeyNS != tc.wantNS {
+			t.Errorf("client %q: got namespace %q, want %q", tc.clientID, userKeyNS, tc.wantNS)
+		}
+	}
+
+	if code := serve("tacoma"); code != http.StatusForbidden {
+		t.Errorf("unknown client: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if userKeyNS != "" {
+		t.Errorf("unknown client reached the handler")
+	}
+
+	// Without the header, the shared namespace is out of reach.
+	if code := serve(""); code != http.StatusForbidden {
+		t.Errorf("no client: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if userKeyNS != "" {
+		t.Errorf("request without a client reached the handler")
+	}
+	defaultClientID = "seattle"
+	if code := serve(""); code != http.StatusOK || userKeyNS != "prod.seattle" {
+		t.Errorf("no client with a default: got status %d and namespace %q, want %d and prod.seattle", code, userKeyNS, http.StatusOK)
+	}
+
+	// With no client IDs allowed, every request uses the shared namespace.
+	allowedClientIDs, defaultClientID = map[string]bool{}, ""
+	if code := serve(""); code != http.StatusOK || userKeyNS != "prod" {
+		t.Errorf("no clients allowed: got status %d and namespace %q, want %d and prod", code, userKeyNS, http.StatusOK)
+	}
+}
@@ -28,17 +28,36 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	"testing"
 )
 
 const (
-	devHostAddr          = "http://localhost:8080"
-	userSetupEndpoint    = "/user/setup"
-	storeAddEndpoint     = "/store/add"
-	reportUploadEndpoint = "/report/upload"
+	devHostAddr                = "http://localhost:8080"
+	userSetupEndpoint          = "/user/setup"
+	storeAddEndpoint           = "/store/add"
+	reportUploadEndpoint       = "/report/upload"
+	webhookSubscribeEndpoint   = "/webhook/subscribe"
+	webhookUnsubscribeEndpoint = "/webhook/unsubscribe"
+	webhookListEndpoint        = "/webhook/list"
+	storeQueryEndpoint         = "/store/query"
+	storeDeleteEndpoint        = "/store/delete"
+	favoritesAddEndpoint       = "/user/favorites/add"
+	favoritesRemoveEndpoint    = "/user/favorites/remove"
+	favoritesQueryEndpoint     = "/user/favorites/query"
+	userQueryEndpoint          = "/user/query"
+	adminPurgeEndpoint         = "/admin/purge"
+	statsCountsEndpoint        = "/stats/counts"
+	adminItemRawEndpoint       = "/admin/item/raw"
+	adminReportImportEndpoint  = "/admin/report/import"
+	telemetryErrorEndpoint     = "/telemetry/error"
+	itemQueryEndpoint          = "/item/query"
+	userByExternalEndpoint     = "/user/by-external"
 )
 
 var client *http.Client
@@ -49,6 +68,24 @@ type SetupUserReq struct {
 	ZipCode   string `json:"zip_code"`
 }
 
+type SetupExternalUserReq struct {
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	ZipCode    string `json:"zip_code"`
+	ExternalID string `json:"external_id"`
+}
+
+type QueryUserByExternalIDReq struct {
+	ExternalID string `json:"external_id"`
+}
+
+type QueryUserResp struct {
+	UserInfo struct {
+		UserID     string `json:"user_id"`
+		ExternalID string `json:"external_id"`
+	} `json:"user"`
+}
+
 type SetupUserResp struct {
 	UserID string `json:"user_id"`
 }
@@ -70,6 +107,115 @@ type UploadReportReq struct {
 	OutStock []string `json:"out_stock_items"`
 }
 
+type SubscribeWebhookReq struct {
+	UserID      string `json:"user_id"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type SubscribeWebhookResp struct {
+	WebhookID string `json:"webhook_id"`
+}
+
+type UnsubscribeWebhookReq struct {
+	UserID    string `json:"user_id"`
+	WebhookID string `json:"webhook_id"`
+}
+
+type Webhook struct {
+	WebhookID   string `json:"webhook_id"`
+	UserID      string `json:"user_id"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type QueryStoresReq struct {
+	UserID string `json:"user_id"`
+}
+
+type QueryStoreInfo struct {
+	StoreID  string `json:"storeId"`
+	Name     string `json:"name"`
+	Favorite bool   `json:"favorite"`
+}
+
+type FavoriteReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+}
+
+type ImportReportsReq struct {
+	Reports []*UploadReportReq `json:"reports"`
+}
+
+type ImportReportsResp struct {
+	Imported int `json:"imported"`
+	Results  []struct {
+		Index int    `json:"index"`
+		Error string `json:"error"`
+	} `json:"results"`
+}
+
+type DeleteStoreReq struct {
+	UserID  string `json:"user_id"`
+	StoreID string `json:"store_id"`
+}
+
+type UserReq struct {
+	UserID string `json:"user_id"`
+}
+
+type StatsCounts struct {
+	Users  int `json:"users"`
+	Stores int `json:"stores"`
+	Items  int `json:"items"`
+}
+
+type RawItemReq struct {
+	ItemName string `json:"item_name"`
+}
+
+type RawItem struct {
+	Name         string `json:"name"`
+	StockReports []struct {
+		UsersInfo []struct {
+			UserID string `json:"user_id"`
+		} `json:"user_info"`
+		StoreInfo struct {
+			StoreID string `json:"storeId"`
+		} `json:"store_info"`
+		TimestampSec int64 `json:"timestamp_sec"`
+		InStock      bool  `json:"in_stock"`
+		SeenCnt      int   `json:"seen_cnt"`
+	} `json:"stock_report"`
+}
+
+type QueryItemsReq struct {
+	UserID   string `json:"user_id"`
+	ItemName string `json:"item_name"`
+}
+
+type ItemInfo struct {
+	StoreName  string `json:"storeName"`
+	SecondsAgo int    `json:"secondsAgo"`
+	InStock    bool   `json:"inStock"`
+}
+
+type ClientErrorReq struct {
+	Endpoint   string `json:"endpoint"`
+	Message    string `json:"message"`
+	AppVersion string `json:"app_version"`
+}
+
+type PurgeKindsReq struct {
+	Kinds []string `json:"kinds"`
+}
+
+type PurgeKindsResp struct {
+	Deleted  map[string]int `json:"deleted"`
+	Progress struct {
+		Done bool `json:"done"`
+	} `json:"progress"`
+}
+
 func TestMain(m *testing.M) {
 	client = &http.Client{}
 	os.Exit(m.Run())
@@ -236,6 +382,307 @@ func TestDo3(t *testing.T) {
 	t.Log("Uploaded report")
 }
 
+// TestWebhookRoundTrip requires the server to run with the same ADMIN_KEY env variable
+// as this test.
+func TestWebhookRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{"Natasha", "Romanoff", "98109"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := subscribeWebhook(client, &SubscribeWebhookReq{UserID: ur.UserID, CallbackURL: "http://169.254.169.254/computeMetadata/v1/"}); err == nil {
+		t.Fatal("subscribing an internal callback url succeeded, want error")
+	}
+
+	wr, err := subscribeWebhook(client, &SubscribeWebhookReq{UserID: ur.UserID, CallbackURL: "https://example.com/hooks/report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Subscribed Webhook %v", wr.WebhookID)
+
+	if !webhookListed(t, wr.WebhookID) {
+		t.Fatalf("webhook %v is not listed after subscribing", wr.WebhookID)
+	}
+
+	if err := doPost(webhookUnsubscribeEndpoint, &UnsubscribeWebhookReq{UserID: ur.UserID, WebhookID: wr.WebhookID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("Unsubscribed Webhook %v", wr.WebhookID)
+
+	if webhookListed(t, wr.WebhookID) {
+		t.Fatalf("webhook %v is still listed after unsubscribing", wr.WebhookID)
+	}
+}
+
+func TestFavorites(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{"Wanda", "Maximoff", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Whole Foods", AddrText: "Westlake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := doPost(favoritesAddEndpoint, &FavoriteReq{UserID: ur.UserID, StoreID: sr.StoreID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var favs []*QueryStoreInfo
+	if err := doPost(favoritesQueryEndpoint, &UserReq{UserID: ur.UserID}, &favs); err != nil {
+		t.Fatal(err)
+	}
+	if len(favs) != 1 || favs[0].StoreID != sr.StoreID {
+		t.Fatalf("got favorites %+v, want only store %v", favs, sr.StoreID)
+	}
+	if !storeFavorited(t, ur.UserID, sr.StoreID) {
+		t.Errorf("store %v is not flagged as a favorite in store query", sr.StoreID)
+	}
+
+	if err := doPost(favoritesRemoveEndpoint, &FavoriteReq{UserID: ur.UserID, StoreID: sr.StoreID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if storeFavorited(t, ur.UserID, sr.StoreID) {
+		t.Errorf("store %v is still flagged as a favorite after removal", sr.StoreID)
+	}
+}
+
+// TestPurgeItems deletes every item in the datastore, so it only runs when PURGE_TEST=1.
+// It requires the server to run with the same ADMIN_KEY env variable as this test.
+func TestPurgeItems(t *testing.T) {
+	if os.Getenv("PURGE_TEST") != "1" {
+		t.Skip("set PURGE_TEST=1 to run, this deletes all items")
+	}
+
+	ur, err := setupUser(client, &SetupUserReq{"Nick", "Fury", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Costco", AddrText: "Issaquah"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadReport(client, &UploadReportReq{UserID: ur.UserID, StoreID: sr.StoreID, InStock: []string{"eggs"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := doAdminPost(adminPurgeEndpoint, &PurgeKindsReq{Kinds: []string{"User"}}, nil); err == nil {
+		t.Fatal("purging users succeeded, want error")
+	}
+	var purged PurgeKindsResp
+	if err := doAdminPost(adminPurgeEndpoint, &PurgeKindsReq{Kinds: []string{"Item"}}, &purged); err != nil {
+		t.Fatal(err)
+	}
+	if purged.Deleted["Item"] == 0 || len(purged.Deleted) != 1 {
+		t.Fatalf("got purge counts %v, want only a nonzero Item count", purged.Deleted)
+	}
+	if !purged.Progress.Done {
+		t.Fatalf("purge yielded before deleting every item")
+	}
+
+	// Users and stores are untouched.
+	if err := doPost(userQueryEndpoint, &UserReq{UserID: ur.UserID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	storeFavorited(t, ur.UserID, sr.StoreID)
+}
+
+// TestStatsCounts may fail if the counts were fetched less than a minute before it runs,
+// since the server caches them.
+func TestStatsCounts(t *testing.T) {
+	ur, err := setupUser(client, &SetupUserReq{"Maria", "Hill", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Fred Meyer", AddrText: "Ballard"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadReport(client, &UploadReportReq{UserID: ur.UserID, StoreID: sr.StoreID, InStock: []string{"flour"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var counts StatsCounts
+	if err := doPost(statsCountsEndpoint, struct{}{}, &counts); err != nil {
+		t.Fatal(err)
+	}
+	if counts.Users < 1 || counts.Stores < 1 || counts.Items < 1 {
+		t.Errorf("got counts %+v, want at least one user, store, and item", counts)
+	}
+}
+
+// TestRawItem requires the server to run with the same ADMIN_KEY env variable as this test.
+func TestRawItem(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{"Sam", "Wilson", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Trader Joe's", AddrText: "Capitol Hill"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadReport(client, &UploadReportReq{UserID: ur.UserID, StoreID: sr.StoreID, OutStock: []string{"yeast"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := doPost(adminItemRawEndpoint, &RawItemReq{ItemName: "yeast"}, nil); err == nil {
+		t.Fatal("fetching a raw item without the admin key succeeded, want error")
+	}
+	var item RawItem
+	if err := doAdminPost(adminItemRawEndpoint, &RawItemReq{ItemName: "yeast"}, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.Name != "yeast" {
+		t.Fatalf("got raw item %q, want yeast", item.Name)
+	}
+	for _, rep := range item.StockReports {
+		if rep.StoreInfo.StoreID != sr.StoreID || rep.InStock {
+			continue
+		}
+		for _, u := range rep.UsersInfo {
+			if u.UserID == ur.UserID {
+				if rep.SeenCnt < 1 || rep.TimestampSec == 0 {
+					t.Errorf("got raw stock report %+v, want seen count and timestamp", rep)
+				}
+				return
+			}
+		}
+	}
+	t.Errorf("raw item %+v is missing the uploaded out-of-stock report", item)
+}
+
+// TestImportReports requires the server to run with the same ADMIN_KEY env variable as this
+// test.
+func TestImportReports(t *testing.T) {
+	t.Parallel()
+
+	ur1, err := setupUser(client, &SetupUserReq{"Wanda", "Maximoff", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ur2, err := setupUser(client, &SetupUserReq{"Vision", "", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur1.UserID, Name: "PCC", AddrText: "Fremont"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &ImportReportsReq{Reports: []*UploadReportReq{
+		{UserID: ur1.UserID, StoreID: sr.StoreID, InStock: []string{"cornmeal"}},
+		{UserID: ur2.UserID, StoreID: sr.StoreID, InStock: []string{"cornmeal"}, OutStock: []string{"buttermilk"}},
+		{UserID: ur1.UserID, StoreID: "no such store", InStock: []string{"cornmeal"}},
+	}}
+	if err := doPost(adminReportImportEndpoint, req, nil); err == nil {
+		t.Fatal("importing reports without the admin key succeeded, want error")
+	}
+	var resp ImportReportsResp
+	if err := doAdminPost(adminReportImportEndpoint, req, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Imported != 2 || len(resp.Results) != 3 || resp.Results[2].Error == "" {
+		t.Fatalf("got import response %+v, want the first 2 reports imported", resp)
+	}
+
+	var item RawItem
+	if err := doAdminPost(adminItemRawEndpoint, &RawItemReq{ItemName: "cornmeal"}, &item); err != nil {
+		t.Fatal(err)
+	}
+	for _, rep := range item.StockReports {
+		if rep.StoreInfo.StoreID != sr.StoreID || !rep.InStock {
+			continue
+		}
+		if rep.SeenCnt < 2 {
+			t.Errorf("got seen count %d for the imported in-stock report, want both users", rep.SeenCnt)
+		}
+		return
+	}
+	t.Errorf("raw item %+v is missing the imported in-stock report", item)
+}
+
+// TestConcurrentDuplicateReports requires the server to run with the same ADMIN_KEY env
+// variable as this test.
+func TestConcurrentDuplicateReports(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{"Bucky", "Barnes", "98101"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Safeway", AddrText: "Queen Anne"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a double submit.
+	req := &UploadReportReq{UserID: ur.UserID, StoreID: sr.StoreID, InStock: []string{"baking soda"}}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- uploadReport(client, req) }()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var item RawItem
+	if err := doAdminPost(adminItemRawEndpoint, &RawItemReq{ItemName: "baking soda"}, &item); err != nil {
+		t.Fatal(err)
+	}
+	for _, rep := range item.StockReports {
+		if rep.StoreInfo.StoreID != sr.StoreID || !rep.InStock {
+			continue
+		}
+		if len(rep.UsersInfo) != 1 || rep.SeenCnt != 1 {
+			t.Errorf("got %d users and seen count %d for a double submit, want 1 and 1", len(rep.UsersInfo), rep.SeenCnt)
+		}
+		return
+	}
+	t.Errorf("raw item %+v is missing the uploaded in-stock report", item)
+}
+
+func storeFavorited(t *testing.T, userID, storeID string) bool {
+	var stores []*QueryStoreInfo
+	if err := doPost(storeQueryEndpoint, &QueryStoresReq{UserID: userID}, &stores); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range stores {
+		if st.StoreID == storeID {
+			return st.Favorite
+		}
+	}
+	t.Fatalf("store %v is missing from store query", storeID)
+	return false
+}
+
+func webhookListed(t *testing.T, webhookID string) bool {
+	var webhooks []*Webhook
+	if err := doAdminPost(webhookListEndpoint, struct{}{}, &webhooks); err != nil {
+		t.Fatal(err)
+	}
+	for _, wh := range webhooks {
+		if wh.WebhookID == webhookID {
+			return true
+		}
+	}
+	return false
+}
+
+func subscribeWebhook(client *http.Client, req *SubscribeWebhookReq) (*SubscribeWebhookResp, error) {
+	var resp SubscribeWebhookResp
+	if err := doPost(webhookSubscribeEndpoint, req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func setupUser(client *http.Client, req *SetupUserReq) (*SetupUserResp, error) {
 	var resp SetupUserResp
 	if err := doPost(userSetupEndpoint, req, &resp); err != nil {
@@ -259,7 +706,178 @@ func uploadReport(client *http.Client, req *UploadReportReq) error {
 	return nil
 }
 
+// TestClientErrorTelemetry uses up this machine's telemetry rate limit for a minute.
+func TestClientErrorTelemetry(t *testing.T) {
+	req := &ClientErrorReq{Endpoint: "/item/query", Message: "timed out", AppVersion: "test"}
+	if err := doPost(telemetryErrorEndpoint, req, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		err := doPost(telemetryErrorEndpoint, req, nil)
+		if err == nil {
+			continue
+		}
+		if !strings.Contains(err.Error(), "status 429") {
+			t.Fatal(err)
+		}
+		return
+	}
+	t.Fatal("client errors were never rate limited")
+}
+
+func TestAddStoreDedup(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{FirstName: "Sam", LastName: "Wilson", ZipCode: "98033"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &AddStoreReq{UserID: ur.UserID, Name: "Costco", AddrText: "Kirkland"}
+	first, err := addStore(client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := addStore(client, req)
+	if err != nil {
+		t.Fatalf("adding the same store again failed: %v", err)
+	}
+	if first.StoreID != second.StoreID {
+		t.Errorf("got store ids %q and %q for the same store, want the same id", first.StoreID, second.StoreID)
+	}
+}
+
+func TestDeleteStore(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{FirstName: "Bucky", LastName: "Barnes", ZipCode: "98104"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The store must not be used by any other test, which runs in parallel and would lose it.
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Metropolitan Market", AddrText: "Admiral"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !storeListed(t, ur.UserID, sr.StoreID) {
+		t.Fatalf("store %v is missing from store query", sr.StoreID)
+	}
+
+	if err := doPost(storeDeleteEndpoint, &DeleteStoreReq{UserID: ur.UserID, StoreID: sr.StoreID}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if storeListed(t, ur.UserID, sr.StoreID) {
+		t.Errorf("store %v is still listed in store query after deleting it", sr.StoreID)
+	}
+	if err := doPost(storeDeleteEndpoint, &DeleteStoreReq{UserID: ur.UserID, StoreID: sr.StoreID}, nil); err == nil {
+		t.Errorf("deleting store %v twice succeeded, want error", sr.StoreID)
+	}
+}
+
+// TestReadAfterWrite asks for a strongly consistent item query right after uploading a report,
+// which must see the report.
+func TestReadAfterWrite(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{FirstName: "Wanda", LastName: "Maximoff", ZipCode: "98105"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "QFC", AddrText: "University Village"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadReport(client, &UploadReportReq{UserID: ur.UserID, StoreID: sr.StoreID, InStock: []string{"almond paste"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []*ItemInfo
+	headers := map[string]string{"X-Read-Consistency": "strong"}
+	if err := doPostWithHeaders(itemQueryEndpoint, headers, &QueryItemsReq{UserID: ur.UserID, ItemName: "almond paste"}, &infos); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.Contains(info.StoreName, "QFC") && info.InStock && info.SecondsAgo < 60 {
+			return
+		}
+	}
+	t.Errorf("got %d reports on almond paste, want the one just uploaded at QFC", len(infos))
+}
+
+// TestQueryReportedName uploads an item under a name that isn't in the catalog, which must
+// still be found by querying that name, though queries resolve it to a catalog item.
+func TestQueryReportedName(t *testing.T) {
+	t.Parallel()
+
+	ur, err := setupUser(client, &SetupUserReq{FirstName: "Scott", LastName: "Lang", ZipCode: "98122"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sr, err := addStore(client, &AddStoreReq{UserID: ur.UserID, Name: "Central Co-op", AddrText: "Capitol Hill"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadReport(client, &UploadReportReq{UserID: ur.UserID, StoreID: sr.StoreID, InStock: []string{"toilet papers"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []*ItemInfo
+	headers := map[string]string{"X-Read-Consistency": "strong"}
+	if err := doPostWithHeaders(itemQueryEndpoint, headers, &QueryItemsReq{UserID: ur.UserID, ItemName: "toilet papers"}, &infos); err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.Contains(info.StoreName, "Central Co-op") && info.InStock && info.SecondsAgo < 60 {
+			return
+		}
+	}
+	t.Errorf("got %d reports on toilet papers, want the one just uploaded at Central Co-op", len(infos))
+}
+
+func TestUserByExternalID(t *testing.T) {
+	t.Parallel()
+
+	externalID := fmt.Sprintf("avengers-%d", time.Now().UnixNano())
+	var ur SetupUserResp
+	if err := doPost(userSetupEndpoint, &SetupExternalUserReq{"Carol", "Danvers", "98101", externalID}, &ur); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp QueryUserResp
+	if err := doAdminPost(userByExternalEndpoint, &QueryUserByExternalIDReq{externalID}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.UserInfo.UserID != ur.UserID || resp.UserInfo.ExternalID != externalID {
+		t.Errorf("got user %q with external id %q, want %q with %q", resp.UserInfo.UserID, resp.UserInfo.ExternalID, ur.UserID, externalID)
+	}
+
+	// A second user can't take the external ID.
+	if err := doPost(userSetupEndpoint, &SetupExternalUserReq{"Monica", "Rambeau", "98101", externalID}, nil); err == nil {
+		t.Errorf("set up a second user with external id %q, want error", externalID)
+	}
+}
+
+func storeListed(t *testing.T, userID, storeID string) bool {
+	var stores []*QueryStoreInfo
+	if err := doPost(storeQueryEndpoint, &QueryStoresReq{UserID: userID}, &stores); err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range stores {
+		if st.StoreID == storeID {
+			return true
+		}
+	}
+	return false
+}
+
 func doPost(endpoint string, reqData, respData interface{}) error {
+	return doPostWithHeaders(endpoint, nil, reqData, respData)
+}
+
+func doAdminPost(endpoint string, reqData, respData interface{}) error {
+	return doPostWithHeaders(endpoint, map[string]string{"X-Admin-Key": os.Getenv("ADMIN_KEY")}, reqData, respData)
+}
+
+func doPostWithHeaders(endpoint string, headers map[string]string, reqData, respData interface{}) error {
 	buf, err := json.Marshal(reqData)
 	if err != nil {
 		return fmt.Errorf("failed to marshal request: %v", err)
@@ -268,11 +886,18 @@ func doPost(endpoint string, reqData, respData interface{}) error {
 	if err != nil {
 		return fmt.Errorf("failed to set up request: %v", err)
 	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("request to %s failed with status %d: %s", endpoint, resp.StatusCode, msg)
+	}
 	if respData == nil {
 		return nil
 	}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTimeSeriesRadiusMiles = 10.0
+	maxTimeSeriesRadiusMiles     = 50.0
+	defaultTimeSeriesWindowHours = 7 * 24
+	maxTimeSeriesWindowHours     = 90 * 24
+	maxTimeSeriesBuckets         = 1000
+)
+
+// timeSeriesBucketSecs maps each bucket size to its length in seconds.
+var timeSeriesBucketSecs = map[string]int64{
+	"hour": secondsToHour,
+	"day":  secondsToDay,
+}
+
+// ******************************************
+// ** BEGIN QueryItemTimeSeries
+// ******************************************
+
+type QueryItemTimeSeriesReq struct {
+	UserID string `json:"user_id"`
+	// ItemNames defaults to every item reported near the zip code.
+	ItemNames []string `json:"item_names"`
+	// ZipCode defaults to the user's zip code.
+	ZipCode     string  `json:"zip_code"`
+	RadiusMiles float64 `json:"radius_miles"`
+	// Bucket is the size of each bucket in the series, "hour" or "day".
+	Bucket      string `json:"bucket"`
+	WindowHours int    `json:"window_hours"`
+}
+
+// QueryItemTimeSeriesResp maps each item name to its series of buckets, oldest first.
+type QueryItemTimeSeriesResp map[string][]*TimeBucket
+
+// TimeBucket counts the in-stock and out-of-stock reports made during a bucket of time.
+type TimeBucket struct {
+	StartSec    int64 `json:"start_timestamp_sec"`
+	InStockCnt  int   `json:"in_stock_count"`
+	OutStockCnt int   `json:"out_stock_count"`
+}
+
+// QueryItemTimeSeries fetches, for items reported at stores near a zip code, the number of
+// in-stock and out-of-stock reports in each hour or day of a window of time. Each user's
+// report counts once, at the time the user first made it.
+func QueryItemTimeSeries(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryItemTimeSeriesReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateQueryItemTimeSeriesReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+	if req.ZipCode == "" {
+		req.ZipCode = u.ZipCode
+	}
+	coords, ok := zipCodeToLatLong[req.ZipCode]
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("zip code %q is not supported", req.ZipCode)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	items, err := loadAllItems(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if len(req.ItemNames) > 0 {
+		wanted := make(map[string]bool, len(req.ItemNames))
+		for _, name := range req.ItemNames {
+			wanted[name] = true
+		}
+		filtered := make([]*Item, 0, len(req.ItemNames))
+		for _, item := range items {
+			if wanted[item.Name] {
+				filtered = append(filtered, item)
+			}
+		}
+		items = filtered
+	}
+
+	bucketSec := timeSeriesBucketSecs[req.Bucket]
+	end := time.Now().Unix()
+	start := end - int64(req.WindowHours)*secondsToHour
+	resp := itemTimeSeries(items, coords, req.RadiusMiles, bucketSec, start, end)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateQueryItemTimeSeriesReq(req *QueryItemTimeSeriesReq) error {
+	req.ZipCode = strings.TrimSpace(req.ZipCode)
+	req.Bucket = strings.ToLower(strings.TrimSpace(req.Bucket))
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	for i, name := range req.ItemNames {
+		req.ItemNames[i] = strings.ToLower(strings.TrimSpace(name))
+		if req.ItemNames[i] == "" {
+			return fmt.Errorf("item name at index %d is empty", i)
+		}
+	}
+	if req.ZipCode != "" {
+		if err := validateZipCode(req.ZipCode); err != nil {
+			return err
+		}
+	}
+	if req.RadiusMiles < 0 || req.RadiusMiles > maxTimeSeriesRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxTimeSeriesRadiusMiles)
+	}
+	if req.RadiusMiles == 0 {
+		req.RadiusMiles = defaultTimeSeriesRadiusMiles
+	}
+	if req.Bucket == "" {
+		req.Bucket = "day"
+	}
+	bucketSec, ok := timeSeriesBucketSecs[req.Bucket]
+	if !ok {
+		return fmt.Errorf("bucket must be hour or day")
+	}
+	if req.WindowHours < 0 || req.WindowHours > maxTimeSeriesWindowHours {
+		return fmt.Errorf("window must be between 0 and %d hours", maxTimeSeriesWindowHours)
+	}
+	if req.WindowHours == 0 {
+		req.WindowHours = defaultTimeSeriesWindowHours
+	}
+	if int64(req.WindowHours)*secondsToHour/bucketSec > maxTimeSeriesBuckets {
+		return fmt.Errorf("window must span at most %d buckets", maxTimeSeriesBuckets)
+	}
+	return nil
+}
+
+// ******************************************
+// ** END QueryItemTimeSeries
+// ******************************************
+
+// itemTimeSeries buckets the in-stock and out-of-stock reports of each item at stores within
+// radiusMiles of coords, made between start and end. Buckets are aligned to multiples of
+// bucketSec, and every bucket in the window is included, even if it has no reports. Items
+// without reports in the window are left out.
+func itemTimeSeries(items []*Item, coords coord, radiusMiles float64, bucketSec, start, end int64) QueryItemTimeSeriesResp {
+	first := start - start%bucketSec
+	resp := make(QueryItemTimeSeriesResp)
+	for _, item := range items {
+		var series []*TimeBucket
+		for _, sr := range item.StockReports {
+			if sr.StoreInfo == nil || sr.Unknown {
+				continue
+			}
+			if Distance(sr.StoreInfo.Lat, sr.StoreInfo.Long, coords.Lat, coords.Long) > radiusMiles {
+				continue
+			}
+			for _, u := range sr.UsersInfo {
+				if u.TimestampSec < start || u.TimestampSec > end {
+					continue
+				}
+				if series == nil {
+					series = make([]*TimeBucket, (end-first)/bucketSec+1)
+					for i := range series {
+						series[i] = &TimeBucket{StartSec: first + int64(i)*bucketSec}
+					}
+				}
+				b := series[(u.TimestampSec-first)/bucketSec]
+				if sr.InStock {
+					b.InStockCnt++
+				} else {
+					b.OutStockCnt++
+				}
+			}
+		}
+		if series != nil {
+			resp[item.Name] = series
+		}
+	}
+	return resp
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestItemTimeSeries(t *testing.T) {
+	origin := coord{Lat: 47.6, Long: -122.3}
+	near := &Store{StoreID: "near", Lat: 47.61, Long: -122.3}
+	far := &Store{StoreID: "far", Lat: 48.5, Long: -122.3}
+	const day = secondsToDay
+	items := []*Item{
+		{Name: "eggs", StockReports: []*StockReport{
+			{StoreInfo: near, InStock: true, UsersInfo: []*User{
+				{UserID: "a", TimestampSec: 10*day + 100},
+				{UserID: "b", TimestampSec: 10*day + 200},
+				{UserID: "c", TimestampSec: 12*day + 5},
+			}},
+			{StoreInfo: near, InStock: false, UsersInfo: []*User{
+				{UserID: "d", TimestampSec: 11*day + 50},
+				// Before the window.
+				{UserID: "e", TimestampSec: 9*day + 50},
+			}},
+			{StoreInfo: near, Unknown: true, UsersInfo: []*User{{UserID: "f", TimestampSec: 11*day + 60}}},
+			{StoreInfo: far, InStock: true, UsersInfo: []*User{{UserID: "g", TimestampSec: 11*day + 70}}},
+		}},
+		{Name: "flour", StockReports: []*StockReport{
+			{StoreInfo: far, InStock: false, UsersInfo: []*User{{UserID: "h", TimestampSec: 11*day + 80}}},
+		}},
+	}
+
+	resp := itemTimeSeries(items, origin, 10, day, 10*day+50, 12*day+10)
+	if _, ok := resp["flour"]; ok || len(resp) != 1 {
+		t.Fatalf("got series for %d items, want only eggs", len(resp))
+	}
+	want := []TimeBucket{
+		{StartSec: 10 * day, InStockCnt: 2},
+		{StartSec: 11 * day, OutStockCnt: 1},
+		{StartSec: 12 * day, InStockCnt: 1},
+	}
+	got := resp["eggs"]
+	if len(got) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("bucket %d = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
+
+func TestCleanAndValidateQueryItemTimeSeriesReq(t *testing.T) {
+	req := &QueryItemTimeSeriesReq{UserID: "user", ItemNames: []string{" Eggs "}}
+	if err := cleanAndValidateQueryItemTimeSeriesReq(req); err != nil {
+		t.Fatalf("cleanAndValidateQueryItemTimeSeriesReq() failed: %v", err)
+	}
+	if req.Bucket != "day" || req.WindowHours != defaultTimeSeriesWindowHours || req.ItemNames[0] != "eggs" {
+		t.Errorf("got %+v, want defaults and a cleaned item name", req)
+	}
+
+	for _, bad := range []*QueryItemTimeSeriesReq{
+		{},
+		{UserID: "user", Bucket: "week"},
+		{UserID: "user", ItemNames: []string{" "}},
+		{UserID: "user", WindowHours: maxTimeSeriesWindowHours + 1},
+		// 90 days of hours is more buckets than allowed.
+		{UserID: "user", Bucket: "hour", WindowHours: maxTimeSeriesWindowHours},
+	} {
+		if err := cleanAndValidateQueryItemTimeSeriesReq(bad); err == nil {
+			t.Errorf("cleanAndValidateQueryItemTimeSeriesReq(%+v) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracing follows OpenTelemetry: trace context is read from the W3C traceparent header and
+// finished traces are exported with OTLP over HTTP. Tracing is a no-op unless the
+// OTEL_EXPORTER_OTLP_ENDPOINT env variable is set.
+
+const (
+	traceparentHeader  = "traceparent"
+	defaultServiceName = "cv19-shopping-aid-server"
+)
+
+// spanExporter sends the spans of a finished trace to a tracing backend.
+type spanExporter interface {
+	Export(spans []*Span)
+}
+
+// tracer records spans and hands each finished trace to its exporter. A tracer without an
+// exporter records nothing.
+type tracer struct {
+	exporter spanExporter
+}
+
+// traces is the server's tracer, set up from the OTEL_EXPORTER_OTLP_ENDPOINT and
+// OTEL_SERVICE_NAME env variables.
+var traces = tracerFromEnv()
+
+func tracerFromEnv() *tracer {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		return &tracer{}
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = defaultServiceName
+	}
+	return &tracer{exporter: &otlpExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}}
+}
+
+// Span is a timed operation within a trace. Its methods are no-ops on a nil span, which is
+// what startSpan returns when tracing is off.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]string
+	// Err is the error the operation failed with, if any.
+	Err string
+
+	kind  spanKind
+	trace *traceRecord
+}
+
+// spanKind says how a span's operation relates to other services, as OpenTelemetry span
+// kinds do.
+type spanKind int
+
+const (
+	// spanInternal is an operation within this server.
+	spanInternal spanKind = iota
+	// spanServer is a request this server handles.
+	spanServer
+	// spanClient is a call to another service, such as datastore or Places.
+	spanClient
+)
+
+// traceRecord collects the finished spans of a trace within this server until its root span
+// ends.
+type traceRecord struct {
+	mu       sync.Mutex
+	spans    []*Span
+	root     *Span
+	exporter spanExporter
+}
+
+type spanKey struct{}
+
+// startSpan starts a span named name as a child of the span in ctx, if any, and returns a
+// context holding the new span.
+func startSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent, _ := ctx.Value(spanKey{}).(*Span)
+	if parent == nil {
+		return ctx, nil // tracing is off or the request isn't sampled
+	}
+	s := &Span{
+		TraceID:  parent.TraceID,
+		SpanID:   newTraceID(8),
+		ParentID: parent.SpanID,
+		Name:     name,
+		Start:    time.Now(),
+		trace:    parent.trace,
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// startClientSpan starts a span like startSpan for a call to another service, so that
+// tracing backends show the time spent waiting on it.
+func startClientSpan(ctx context.Context, name string) (context.Context, *Span) {
+	ctx, s := startSpan(ctx, name)
+	if s != nil {
+		s.kind = spanClient
+	}
+	return ctx, s
+}
+
+// startRootSpan starts the span of a request in this server. The parent is the span named in
+// the traceparent header, if any. It returns a nil span if tracing is off or the caller
+// asked not to sample the trace.
+func (t *tracer) startRootSpan(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	if t.exporter == nil {
+		return ctx, nil
+	}
+	s := &Span{Name: name, Start: time.Now(), SpanID: newTraceID(8), kind: spanServer}
+	if traceID, parentID, sampled, ok := parseTraceparent(traceparent); ok {
+		if !sampled {
+			return ctx, nil
+		}
+		s.TraceID, s.ParentID = traceID, parentID
+	} else {
+		s.TraceID = newTraceID(16)
+	}
+	s.trace = &traceRecord{root: s, exporter: t.exporter}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SetAttr records a key-value pair describing the operation.
+func (s *Span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.Attrs == nil {
+		s.Attrs = make(map[string]string)
+	}
+	s.Attrs[key] = value
+}
+
+// SetError marks the operation as failed if err is non-nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Err = err.Error()
+}
+
+// Finish ends the span. Finishing the root span exports the trace.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	tr := s.trace
+	tr.mu.Lock()
+	tr.spans = append(tr.spans, s)
+	spans := tr.spans
+	tr.mu.Unlock()
+	if s == tr.root {
+		tr.exporter.Export(spans)
+	}
+}
+
+// parseTraceparent parses a W3C traceparent header value of the form
+// `<version>-<trace id>-<parent span id>-<flags>`.
+func parseTraceparent(v string) (traceID, parentID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return "", "", false, false
+	}
+	traceID, parentID = parts[1], parts[2]
+	if !validTraceID(traceID, 16) || !validTraceID(parentID, 8) || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return "", "", false, false
+	}
+	return traceID, parentID, flags&1 == 1, true
+}
+
+// validTraceID reports whether id is the lowercase hex encoding of n bytes that aren't all
+// zero.
+func validTraceID(id string, n int) bool {
+	b, err := hex.DecodeString(id)
+	if err != nil || len(b) != n || id != strings.ToLower(id) {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func newTraceID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("failed to generate trace id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// statusRecorder remembers the status code a handler replied with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// tracingMiddleware records a span for each request, continuing the trace named in the
+// traceparent header. Handlers pass r.Context() on so that their datastore and Places calls
+// are recorded as child spans.
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := traces.startRootSpan(r.Context(), r.Method+" "+r.URL.Path, r.Header.Get(traceparentHeader))
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttr("http.status_code", strconv.Itoa(rec.status))
+		if rec.status >= 500 {
+			span.SetError(fmt.Errorf("%s", http.StatusText(rec.status)))
+		}
+		span.Finish()
+	})
+}
+
+// otlpExporter exports traces as OTLP JSON over HTTP.
+type otlpExporter struct {
+	url     string
+	service string
+	client  *http.Client
+}
+
+// Export sends the spans in the background so that requests don't wait on the collector.
+// Failures are logged.
+func (e *otlpExporter) Export(spans []*Span) {
+	payload, err := json.Marshal(e.encode(spans))
+	if err != nil {
+		log.Printf("failed to encode trace: %v", err)
+		return
+	}
+	go func() {
+		resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			log.Printf("failed to export trace: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			log.Printf("failed to export trace: collector responded with status %d", resp.StatusCode)
+		}
+	}()
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	StartNano    string     `json:"startTimeUnixNano"`
+	EndNano      string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpKindServer   = 2
+	otlpKindClient   = 3
+	otlpStatusUnset  = 0
+	otlpStatusError  = 2
+)
+
+var otlpKinds = map[spanKind]int{
+	spanInternal: otlpKindInternal,
+	spanServer:   otlpKindServer,
+	spanClient:   otlpKindClient,
+}
+
+func (e *otlpExporter) encode(spans []*Span) map[string]interface{} {
+	res := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:      s.TraceID,
+			SpanID:       s.SpanID,
+			ParentSpanID: s.ParentID,
+			Name:         s.Name,
+			Kind:         otlpKinds[s.kind],
+			StartNano:    strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndNano:      strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:       otlpStatus{Code: otlpStatusUnset},
+		}
+		for k, v := range s.Attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: k, Value: otlpValue{StringValue: v}})
+		}
+		if s.Err != "" {
+			o.Status = otlpStatus{Code: otlpStatusError, Message: s.Err}
+		}
+		res = append(res, o)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []otlpAttr{{Key: "service.name", Value: otlpValue{StringValue: e.service}}},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": defaultServiceName},
+				"spans": res,
+			}},
+		}},
+	}
+}
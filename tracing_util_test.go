@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"googlemaps.github.io/maps"
+)
+
+// memSpanExporter keeps exported spans in memory.
+type memSpanExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memSpanExporter) Export(spans []*Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+}
+
+func withTracer(t *testing.T, exp spanExporter) {
+	orig := traces
+	t.Cleanup(func() { traces = orig })
+	traces = &tracer{exporter: exp}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	exp := &memSpanExporter{}
+	withTracer(t, exp)
+
+	places := &tracedPlaces{places: &fakePlaces{}}
+	h := tracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		places.FindPlaceFromText(r.Context(), &maps.FindPlaceFromTextRequest{Input: "safeway"})
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	r := httptest.NewRequest("POST", "/store/add", nil)
+	r.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if len(exp.spans) != 2 {
+		t.Fatalf("got %d spans, want 2: %+v", len(exp.spans), exp.spans)
+	}
+	child, root := exp.spans[0], exp.spans[1]
+	if root.Name != "POST /store/add" || root.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || root.ParentID != "00f067aa0ba902b7" {
+		t.Errorf("got root span %+v, want one continuing the incoming trace", root)
+	}
+	if root.Attrs["http.status_code"] != "502" || root.Err == "" {
+		t.Errorf("got root span attrs %v and error %q, want a failed 502", root.Attrs, root.Err)
+	}
+	if child.Name != "places.FindPlaceFromText" || child.TraceID != root.TraceID || child.ParentID != root.SpanID {
+		t.Errorf("got child span %+v, want a child of the root span", child)
+	}
+	if child.Err != "not implemented" {
+		t.Errorf("got child span error %q, want %q", child.Err, "not implemented")
+	}
+}
+
+func TestTracingMiddlewareNotSampled(t *testing.T) {
+	exp := &memSpanExporter{}
+	withTracer(t, exp)
+
+	h := tracingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, span := startSpan(r.Context(), "child"); span != nil {
+			t.Error("startSpan() in an unsampled request returned a span")
+		}
+	}))
+	r := httptest.NewRequest("POST", "/item/query", nil)
+	r.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if len(exp.spans) != 0 {
+		t.Errorf("got %d spans, want none", len(exp.spans))
+	}
+}
+
+func TestTracingOff(t *testing.T) {
+	withTracer(t, nil)
+
+	ctx, span := traces.startRootSpan(context.Background(), "POST /item/query", "")
+	if span != nil {
+		t.Fatal("startRootSpan() with tracing off returned a span")
+	}
+	_, child := startSpan(ctx, "child")
+	child.SetAttr("k", "v")
+	child.SetError(fmt.Errorf("failed"))
+	child.Finish()
+}
+
+func TestOTLPEncode(t *testing.T) {
+	exp := &memSpanExporter{}
+	withTracer(t, exp)
+	ctx, root := traces.startRootSpan(context.Background(), "POST /item/query", "")
+	_, child := startClientSpan(ctx, "datastore.query Item")
+	child.SetError(fmt.Errorf("unavailable"))
+	child.Finish()
+	root.Finish()
+
+	b, err := json.Marshal((&otlpExporter{service: "test"}).encode(exp.spans))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"traceId":"` + root.TraceID + `"`,
+		`"parentSpanId":"` + root.SpanID + `"`,
+		`"status":{"code":2,"message":"unavailable"}`,
+		`"kind":2`,
+		`"kind":3`,
+		`"stringValue":"test"`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("encoded trace %s does not contain %s", b, want)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tc := range []struct {
+		v       string
+		sampled bool
+		ok      bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01", false, false},
+	} {
+		_, _, sampled, ok := parseTraceparent(tc.v)
+		if sampled != tc.sampled || ok != tc.ok {
+			t.Errorf("parseTraceparent(%q) = sampled %v, ok %v, want %v, %v", tc.v, sampled, ok, tc.sampled, tc.ok)
+		}
+	}
+}
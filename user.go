@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,13 +14,64 @@ import (
 )
 
 // User represents the user entity in storage.
-// It stores the userID (key), first and last name, zipcode, and creation timestamp in seconds.
+// It stores the userID (key), first and last name, zipcode, optional email, and creation timestamp in seconds.
 type User struct {
 	UserID       string `datastore:"userID" json:"user_id"`
 	FirstName    string `datastore:"firstName" json:"first_name"`
 	LastName     string `datastore:"lastName" json:"last_name"`
 	ZipCode      string `datastore:"zipCode" json:"zip_code"`
+	Email        string `datastore:"email,noindex" json:"email,omitempty"`
 	TimestampSec int64  `datastore:"timestampSec" json:"timestamp_sec"`
+	// DefaultRadiusMiles is the radius QueryStores and QueryItems search within when the
+	// request doesn't give one. Zero means no limit.
+	DefaultRadiusMiles float64 `datastore:"defaultRadiusMiles,noindex" json:"default_radius_miles,omitempty"`
+	// Lat and Long are where the user searches from, if set with EditUser. Both are zero
+	// otherwise.
+	Lat  float64 `datastore:"lat,noindex" json:"latitude,omitempty"`
+	Long float64 `datastore:"long,noindex" json:"longitude,omitempty"`
+	// ExternalID is the user's ID in the app that set the user up, if it gave one. Each
+	// external ID belongs to one user; see ExternalUserID.
+	ExternalID string `datastore:"externalID" json:"external_id,omitempty"`
+}
+
+// ExternalUserID maps an external ID to the user that has it. It is keyed by the external
+// ID, so that a transaction can check that the external ID is still free before setting up a
+// user with it.
+type ExternalUserID struct {
+	ExternalID string `datastore:"externalID" json:"external_id"`
+	UserID     string `datastore:"userID" json:"user_id"`
+}
+
+// maxExternalIDLen is the longest external ID a user can be set up with.
+const maxExternalIDLen = 128
+
+// errExternalIDTaken is returned when setting up a user with an external ID that another user
+// already has.
+var errExternalIDTaken = fmt.Errorf("external id is already taken")
+
+// hasCoords reports whether the user set the coordinates to search from.
+func (u *User) hasCoords() bool {
+	return u.Lat != 0 || u.Long != 0
+}
+
+// maxSearchRadiusMiles is the largest radius a user's searches may be limited to.
+const maxSearchRadiusMiles = 100.0
+
+// searchRadius returns the radius to search within for the user: reqRadius if the request
+// gave one, otherwise the user's default. Zero means no limit.
+func searchRadius(reqRadius float64, u *User) float64 {
+	if reqRadius > 0 {
+		return reqRadius
+	}
+	return u.DefaultRadiusMiles
+}
+
+// validateSearchRadius checks a search radius, where zero means no limit.
+func validateSearchRadius(radiusMiles float64) error {
+	if radiusMiles < 0 || radiusMiles > maxSearchRadiusMiles {
+		return fmt.Errorf("radius must be between 0 and %v miles", maxSearchRadiusMiles)
+	}
+	return nil
 }
 
 // ******************************************
@@ -31,6 +83,9 @@ type SetupUserReq struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	ZipCode   string `json:"zip_code"`
+	Email     string `json:"email"`
+	// ExternalID optionally maps the user to their ID in the client app. It must be unique.
+	ExternalID string `json:"external_id"`
 }
 
 // SetupUserResp represents response to SetupUser.
@@ -59,10 +114,18 @@ func SetupUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (int
 		FirstName:    req.FirstName,
 		LastName:     req.LastName,
 		ZipCode:      req.ZipCode,
+		Email:        req.Email,
 		TimestampSec: time.Now().Unix(),
+		ExternalID:   req.ExternalID,
 	}
 
-	if err := createOrUpdateUserInStorage(ctx, user); err != nil {
+	if user.ExternalID != "" {
+		if err := createExternalUserInStorage(ctx, user); err == errExternalIDTaken {
+			return http.StatusConflict, err
+		} else if err != nil {
+			return http.StatusInternalServerError, err
+		}
+	} else if err := createOrUpdateUserInStorage(ctx, user); err != nil {
 		return http.StatusInternalServerError, err
 	}
 
@@ -89,7 +152,23 @@ func validateSetupUserReq(req *SetupUserReq) error {
 	if req.ZipCode == "" {
 		return fmt.Errorf("missing zip code")
 	}
-	return validateZipCode(req.ZipCode)
+	if err := validateZipCode(req.ZipCode); err != nil {
+		return err
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if err := validateEmail(req.Email); err != nil {
+		return err
+	}
+	req.ExternalID = strings.TrimSpace(req.ExternalID)
+	return validateExternalID(req.ExternalID)
+}
+
+// validateExternalID checks an external ID, where empty means none.
+func validateExternalID(externalID string) error {
+	if len(externalID) > maxExternalIDLen {
+		return fmt.Errorf("external id must be at most %d characters", maxExternalIDLen)
+	}
+	return nil
 }
 
 func validateZipCode(zipCode string) error {
@@ -103,6 +182,18 @@ func validateZipCode(zipCode string) error {
 	return nil
 }
 
+// validateEmail checks that a non-empty email looks like an address. The email is optional.
+func validateEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, " \t") {
+		return fmt.Errorf("email %q is not an address", email)
+	}
+	return nil
+}
+
 // ******************************************
 // ** END SetupUser
 // ******************************************
@@ -116,6 +207,15 @@ type EditUserReq struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
 	ZipCode   string `json:"zip_code"`
+	// Email, DefaultRadiusMiles, and the coordinates are left as they are unless given. An
+	// empty email clears it.
+	Email *string `json:"email"`
+	// DefaultRadiusMiles is the radius the user's searches default to. Zero clears it.
+	DefaultRadiusMiles *float64 `json:"default_radius_miles"`
+	// CoordsReq holds the coordinates the user's searches are from.
+	CoordsReq
+	// ClearCoords clears the user's coordinates. It can't be given with them.
+	ClearCoords bool `json:"clear_coords"`
 }
 
 func EditUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
@@ -135,10 +235,7 @@ func EditUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (int,
 		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
 	}
 
-	u.FirstName = req.FirstName
-	u.LastName = req.LastName
-	u.ZipCode = req.ZipCode
-
+	applyUserEdit(u, &req)
 	if err := createOrUpdateUserInStorage(ctx, u); err != nil {
 		return http.StatusInternalServerError, err
 	}
@@ -161,9 +258,42 @@ func validateEditUserReq(req *EditUserReq) error {
 	if req.ZipCode == "" {
 		return fmt.Errorf("missing zip code")
 	}
+	if req.Email != nil {
+		if err := validateEmail(*req.Email); err != nil {
+			return err
+		}
+	}
+	if err := req.CoordsReq.validate(); err != nil {
+		return err
+	}
+	if req.ClearCoords && req.Lat != nil {
+		return fmt.Errorf("coordinates can't be given and cleared together")
+	}
+	if req.DefaultRadiusMiles != nil {
+		return validateSearchRadius(*req.DefaultRadiusMiles)
+	}
 	return nil
 }
 
+// applyUserEdit sets the fields of the user that the edit gives.
+func applyUserEdit(u *User, req *EditUserReq) {
+	u.FirstName = req.FirstName
+	u.LastName = req.LastName
+	u.ZipCode = req.ZipCode
+	if req.Email != nil {
+		u.Email = *req.Email
+	}
+	if req.DefaultRadiusMiles != nil {
+		u.DefaultRadiusMiles = *req.DefaultRadiusMiles
+	}
+	if req.Lat != nil {
+		u.Lat, u.Long = *req.Lat, *req.Long
+	}
+	if req.ClearCoords {
+		u.Lat, u.Long = 0, 0
+	}
+}
+
 // ******************************************
 // ** END EditUser
 // ******************************************
@@ -185,7 +315,7 @@ func DeleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (in
 		return http.StatusBadRequest, err
 	}
 
-	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("failed to query storage: %v", err)
 	}
@@ -193,7 +323,7 @@ func DeleteUser(ctx context.Context, w http.ResponseWriter, r *http.Request) (in
 		return http.StatusBadRequest, fmt.Errorf("user id is invalid: %q", req.UserID)
 	}
 
-	if err := deleteUserInStorage(ctx, req.UserID); err != nil {
+	if err := deleteUserInStorage(ctx, u); err != nil {
 		return http.StatusInternalServerError, err
 	}
 
@@ -255,28 +385,315 @@ func validateQueryUserReq(req *QueryUserReq) error {
 // ** END QueryUser
 // ******************************************
 
+// ******************************************
+// ** BEGIN QueryUserByExternalID
+// ******************************************
+
+type QueryUserByExternalIDReq struct {
+	ExternalID string `json:"external_id"`
+}
+
+// QueryUserByExternalID fetches the user set up with an external ID, so that apps with their
+// own user accounts can map them to ours. It is an admin endpoint, since a user ID is all it
+// takes to act as the user.
+func QueryUserByExternalID(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryUserByExternalIDReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if req.ExternalID == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing external id")
+	}
+
+	u, ok, err := getUserByExternalIDInStorage(ctx, req.ExternalID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusNotFound, fmt.Errorf("no user has external id %q", req.ExternalID)
+	}
+	if err := EncodeResp(w, &QueryUserResp{UserInfo: u}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END QueryUserByExternalID
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryOnboarding
+// ******************************************
+
+// Onboarding steps a user may not have done yet.
+const (
+	onboardingEmail     = "email"
+	onboardingFavorites = "favorites"
+	onboardingReports   = "reports"
+)
+
+// onboardingActions maps each onboarding step to the action that completes it.
+var onboardingActions = map[string]string{
+	onboardingEmail:     "add_email",
+	onboardingFavorites: "favorite_store",
+	onboardingReports:   "upload_report",
+}
+
+type QueryOnboardingReq struct {
+	UserID string `json:"user_id"`
+}
+
+type QueryOnboardingResp struct {
+	// Missing lists the onboarding steps the user hasn't done, in the order they're
+	// suggested.
+	Missing []string `json:"missing"`
+	// NextActions lists the action that completes each missing step.
+	NextActions []string `json:"next_actions"`
+	FavoriteCnt int      `json:"favorite_count"`
+	ReportCnt   int      `json:"report_count"`
+	Complete    bool     `json:"complete"`
+}
+
+// QueryOnboarding fetches which optional onboarding steps the user hasn't done yet and
+// what to do next.
+func QueryOnboarding(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryOnboardingReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if req.UserID == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing user id")
+	}
+
+	u, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	favs, err := getFavoritesInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	items, err := loadAllItems(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := onboardingStatus(u, len(favs), userReportCnt(items, u.UserID))
+	if err := EncodeResp(w, resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func onboardingStatus(u *User, favoriteCnt, reportCnt int) *QueryOnboardingResp {
+	resp := &QueryOnboardingResp{
+		Missing:     make([]string, 0),
+		NextActions: make([]string, 0),
+		FavoriteCnt: favoriteCnt,
+		ReportCnt:   reportCnt,
+	}
+	// Reporting stock is what helps other users the most, so it is suggested first.
+	if reportCnt == 0 {
+		resp.Missing = append(resp.Missing, onboardingReports)
+	}
+	if favoriteCnt == 0 {
+		resp.Missing = append(resp.Missing, onboardingFavorites)
+	}
+	if u.Email == "" {
+		resp.Missing = append(resp.Missing, onboardingEmail)
+	}
+	for _, step := range resp.Missing {
+		resp.NextActions = append(resp.NextActions, onboardingActions[step])
+	}
+	resp.Complete = len(resp.Missing) == 0
+	return resp
+}
+
+// userReportCnt counts the stock reports the user contributed to.
+func userReportCnt(items []*Item, userID string) int {
+	cnt := 0
+	for _, item := range items {
+		for _, sr := range item.StockReports {
+			for _, u := range sr.UsersInfo {
+				if u.UserID == userID {
+					cnt++
+					break
+				}
+			}
+		}
+	}
+	return cnt
+}
+
+// ******************************************
+// ** END QueryOnboarding
+// ******************************************
+
+// ******************************************
+// ** BEGIN QueryUserReports
+// ******************************************
+
+type QueryUserReportsReq struct {
+	UserID string `json:"user_id"`
+}
+
+type QueryUserReportsResp []*UserReport
+
+// UserReport is a stock report the user contributed to. TimestampSec is when the user made
+// it, which may be before others confirmed it.
+type UserReport struct {
+	ItemName     string `json:"item_name"`
+	StoreID      string `json:"store_id"`
+	StoreName    string `json:"store_name"`
+	InStock      bool   `json:"in_stock"`
+	Unknown      bool   `json:"unknown,omitempty"`
+	TimestampSec int64  `json:"timestamp_sec"`
+}
+
+// QueryUserReports fetches the stock reports the user contributed to, newest first, so that
+// they can review them and retract mistakes with DeleteReport. Reports are embedded in their
+// items, so it scans every item like QueryOnboarding does rather than keeping an index of
+// reports by user that every upload, dispute, prune, and merge would have to update.
+func QueryUserReports(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req QueryUserReportsReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	if req.UserID == "" {
+		return http.StatusBadRequest, fmt.Errorf("missing user id")
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer client.Close()
+
+	items, err := loadAllItems(ctx, client)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := userReports(items, req.UserID)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END QueryUserReports
+// ******************************************
+
+// userReports returns the stock reports the user contributed to, newest first by when the
+// user made them, then by item name.
+func userReports(items []*Item, userID string) QueryUserReportsResp {
+	resp := make(QueryUserReportsResp, 0)
+	for _, item := range items {
+		for _, sr := range item.StockReports {
+			for _, u := range sr.UsersInfo {
+				if u.UserID != userID {
+					continue
+				}
+				ur := &UserReport{
+					ItemName:     item.Name,
+					InStock:      sr.InStock,
+					Unknown:      sr.Unknown,
+					TimestampSec: u.TimestampSec,
+				}
+				if sr.StoreInfo != nil {
+					ur.StoreID = sr.StoreInfo.StoreID
+					ur.StoreName = sr.StoreInfo.Name
+				}
+				resp = append(resp, ur)
+				break
+			}
+		}
+	}
+	sort.SliceStable(resp, func(i, j int) bool {
+		if resp[i].TimestampSec != resp[j].TimestampSec {
+			return resp[i].TimestampSec > resp[j].TimestampSec
+		}
+		return resp[i].ItemName < resp[j].ItemName
+	})
+	return resp
+}
+
 // GetUserInStorage fetches the user in with key = userID in storage.
 // Returns a non-nil error if storage client experienced a failure.
 // If no error, returns true/false to indicate that userID exists or not.
 func GetUserInStorage(ctx context.Context, userID string) (*User, bool, error) {
+	ctx, span := startClientSpan(ctx, "datastore.get User")
+	defer span.Finish()
 	client, err := StorageClient(ctx)
 	if err != nil {
 		return nil, false, err
 	}
 	defer client.Close()
 
-	key := datastore.NameKey(UserKind, userID, nil)
+	key := nameKey(ctx, UserKind, userID)
 	var u User
 	err = client.Get(ctx, key, &u)
 	if err != nil {
 		if err == datastore.ErrNoSuchEntity {
 			return nil, false, nil // userID does not exist
 		}
+		span.SetError(err)
 		return nil, false, err // storage error
 	}
 	return &u, true, nil // userID does exist
 }
 
+// getUsersInStorage fetches the users with the IDs, keyed by ID. IDs of users that don't
+// exist are left out.
+func getUsersInStorage(ctx context.Context, client *datastore.Client, ids []string) (map[string]*User, error) {
+	ctx, span := startClientSpan(ctx, "datastore.get User")
+	defer span.Finish()
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = nameKey(ctx, UserKind, id)
+	}
+	found := make([]User, len(ids))
+	err := getMulti(ctx, client, keys, found)
+	merr, isMultiErr := err.(datastore.MultiError)
+	if err != nil && !isMultiErr {
+		span.SetError(err)
+		return nil, fmt.Errorf("failed to get users from storage: %v", err)
+	}
+
+	users := make(map[string]*User, len(ids))
+	for i, id := range ids {
+		if isMultiErr && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get user %q from storage: %v", id, merr[i])
+		}
+		users[id] = &found[i]
+	}
+	return users, nil
+}
+
 // createOrUpdateUserInStorage puts the user with key = userID in storage.
 func createOrUpdateUserInStorage(ctx context.Context, u *User) error {
 	client, err := StorageClient(ctx)
@@ -285,7 +702,7 @@ func createOrUpdateUserInStorage(ctx context.Context, u *User) error {
 	}
 	defer client.Close()
 
-	key := datastore.NameKey(UserKind, u.UserID, nil)
+	key := nameKey(ctx, UserKind, u.UserID)
 	_, err = client.Put(ctx, key, u)
 	if err != nil {
 		return fmt.Errorf("failed to create user in storage: %v", err)
@@ -293,16 +710,76 @@ func createOrUpdateUserInStorage(ctx context.Context, u *User) error {
 	return nil
 }
 
-// deleteUserInStorage deletes the user with key = userID in storage.
-func deleteUserInStorage(ctx context.Context, userID string) error {
+// createExternalUserInStorage puts the user and the ExternalUserID of its external ID in
+// storage in one transaction. It returns errExternalIDTaken if another user has the external
+// ID.
+func createExternalUserInStorage(ctx context.Context, u *User) error {
 	client, err := StorageClient(ctx)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
-	key := datastore.NameKey(UserKind, userID, nil)
-	if err := client.Delete(ctx, key); err != nil {
+	userKey := nameKey(ctx, UserKind, u.UserID)
+	extKey := nameKey(ctx, ExternalUserIDKind, u.ExternalID)
+	_, err = client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var ext ExternalUserID
+		if err := tx.Get(extKey, &ext); err == nil {
+			return errExternalIDTaken
+		} else if err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("failed to look up external id in storage: %v", err)
+		}
+		if _, err := tx.Put(extKey, &ExternalUserID{ExternalID: u.ExternalID, UserID: u.UserID}); err != nil {
+			return fmt.Errorf("failed to create external id in storage: %v", err)
+		}
+		if _, err := tx.Put(userKey, u); err != nil {
+			return fmt.Errorf("failed to create user in storage: %v", err)
+		}
+		return nil
+	})
+	return err
+}
+
+// getUserByExternalIDInStorage fetches the user with the external ID. Both lookups are by
+// key, so a user that was just set up is found.
+func getUserByExternalIDInStorage(ctx context.Context, externalID string) (*User, bool, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer client.Close()
+
+	var ext ExternalUserID
+	if err := client.Get(ctx, nameKey(ctx, ExternalUserIDKind, externalID), &ext); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to look up external id in storage: %v", err)
+	}
+	var u User
+	if err := client.Get(ctx, nameKey(ctx, UserKind, ext.UserID), &u); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to get user from storage: %v", err)
+	}
+	return &u, true, nil
+}
+
+// deleteUserInStorage deletes the user, and the ExternalUserID of its external ID if it has
+// one, in storage.
+func deleteUserInStorage(ctx context.Context, u *User) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	keys := []*datastore.Key{nameKey(ctx, UserKind, u.UserID), regionSubscriptionKey(ctx, u.UserID)}
+	if u.ExternalID != "" {
+		keys = append(keys, nameKey(ctx, ExternalUserIDKind, u.ExternalID))
+	}
+	if err := client.DeleteMulti(ctx, keys); err != nil {
 		return fmt.Errorf("failed to delete user in storage: %v", err)
 	}
 	return nil
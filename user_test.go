@@ -0,0 +1,169 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOnboardingStatus(t *testing.T) {
+	fresh := &User{UserID: "fresh"}
+	active := &User{UserID: "active", Email: "active@example.com"}
+	items := []*Item{
+		{Name: "eggs", StockReports: []*StockReport{
+			{UsersInfo: []*User{{UserID: "active"}, {UserID: "other"}}},
+			{UsersInfo: []*User{{UserID: "other"}}},
+		}},
+		{Name: "flour", StockReports: []*StockReport{
+			{UsersInfo: []*User{{UserID: "active"}}},
+		}},
+	}
+
+	got := onboardingStatus(fresh, 0, userReportCnt(items, fresh.UserID))
+	if want := []string{"reports", "favorites", "email"}; !reflect.DeepEqual(got.Missing, want) {
+		t.Errorf("got missing %q for a fresh user, want %q", got.Missing, want)
+	}
+	if want := []string{"upload_report", "favorite_store", "add_email"}; !reflect.DeepEqual(got.NextActions, want) {
+		t.Errorf("got next actions %q for a fresh user, want %q", got.NextActions, want)
+	}
+	if got.Complete {
+		t.Error("fresh user is complete, want incomplete")
+	}
+
+	got = onboardingStatus(active, 2, userReportCnt(items, active.UserID))
+	if len(got.Missing) != 0 || len(got.NextActions) != 0 || !got.Complete {
+		t.Errorf("got %+v for an active user, want complete", got)
+	}
+	if got.ReportCnt != 2 || got.FavoriteCnt != 2 {
+		t.Errorf("got %d reports and %d favorites, want 2 and 2", got.ReportCnt, got.FavoriteCnt)
+	}
+}
+
+func TestUserReports(t *testing.T) {
+	qfc := &Store{StoreID: "qfc", Name: "QFC"}
+	safeway := &Store{StoreID: "safeway", Name: "Safeway"}
+	alice, bob := &User{UserID: "alice"}, &User{UserID: "bob"}
+	eggs, milk := &Item{Name: "eggs"}, &Item{Name: "milk"}
+	addStockReport(eggs, qfc, alice, &itemStock{Name: "eggs", InStock: true}, 100)
+	addStockReport(eggs, qfc, bob, &itemStock{Name: "eggs", InStock: true}, 300)
+	addStockReport(milk, safeway, bob, &itemStock{Name: "milk", InStock: false}, 200)
+	addStockReport(milk, qfc, alice, &itemStock{Name: "milk", Unknown: true}, 400)
+
+	got := userReports([]*Item{eggs, milk}, "alice")
+	want := QueryUserReportsResp{
+		{ItemName: "milk", StoreID: "qfc", StoreName: "QFC", Unknown: true, TimestampSec: 400},
+		// Alice's own time, not bob's later confirmation.
+		{ItemName: "eggs", StoreID: "qfc", StoreName: "QFC", InStock: true, TimestampSec: 100},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d reports, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("report %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if got := userReports([]*Item{eggs, milk}, "carol"); len(got) != 0 {
+		t.Errorf("got %d reports for a user who made none, want 0", len(got))
+	}
+}
+
+func TestValidateEmail(t *testing.T) {
+	for _, email := range []string{"", "a@b.com"} {
+		if err := validateEmail(email); err != nil {
+			t.Errorf("validateEmail(%q) failed: %v", email, err)
+		}
+	}
+	for _, email := range []string{"ab.com", "@b.com", "a@", "a b@c.com"} {
+		if err := validateEmail(email); err == nil {
+			t.Errorf("validateEmail(%q) succeeded, want error", email)
+		}
+	}
+}
+
+func TestValidateSetupUserReqExternalID(t *testing.T) {
+	newReq := func(externalID string) *SetupUserReq {
+		return &SetupUserReq{FirstName: "Carol", LastName: "Danvers", ZipCode: "98101", ExternalID: externalID}
+	}
+	req := newReq("  app-123 ")
+	if err := validateSetupUserReq(req); err != nil {
+		t.Fatalf("validateSetupUserReq() = %v, want ok", err)
+	}
+	if req.ExternalID != "app-123" {
+		t.Errorf("got external id %q, want it trimmed to %q", req.ExternalID, "app-123")
+	}
+	if err := validateSetupUserReq(newReq("")); err != nil {
+		t.Errorf("validateSetupUserReq() without an external id = %v, want ok", err)
+	}
+	if err := validateSetupUserReq(newReq(strings.Repeat("x", maxExternalIDLen+1))); err == nil {
+		t.Errorf("validateSetupUserReq() with a %d character external id succeeded, want error", maxExternalIDLen+1)
+	}
+}
+
+func TestValidateEditUserReqRadius(t *testing.T) {
+	newReq := func(radius float64) *EditUserReq {
+		return &EditUserReq{UserID: "u", FirstName: "Sam", LastName: "Wilson", ZipCode: "98101", DefaultRadiusMiles: &radius}
+	}
+	for _, radius := range []float64{0, 5, maxSearchRadiusMiles} {
+		if err := validateEditUserReq(newReq(radius)); err != nil {
+			t.Errorf("validateEditUserReq() with radius %v = %v, want ok", radius, err)
+		}
+	}
+	for _, radius := range []float64{-1, maxSearchRadiusMiles + 1} {
+		if err := validateEditUserReq(newReq(radius)); err == nil {
+			t.Errorf("validateEditUserReq() with radius %v succeeded, want error", radius)
+		}
+	}
+}
+
+func TestApplyUserEdit(t *testing.T) {
+	stored := User{UserID: "u", FirstName: "Sam", LastName: "Wilson", ZipCode: "98101", Email: "sam@example.com", DefaultRadiusMiles: 5, Lat: 47.6, Long: -122.3}
+
+	u := stored
+	applyUserEdit(&u, &EditUserReq{UserID: "u", FirstName: "Samuel", LastName: "Wilson", ZipCode: "98102"})
+	want := stored
+	want.FirstName, want.ZipCode = "Samuel", "98102"
+	if u != want {
+		t.Errorf("got user %+v after a partial edit, want %+v", u, want)
+	}
+
+	u = stored
+	email, radius := "", 0.0
+	applyUserEdit(&u, &EditUserReq{UserID: "u", FirstName: "Sam", LastName: "Wilson", ZipCode: "98101", Email: &email, DefaultRadiusMiles: &radius, ClearCoords: true})
+	want = stored
+	want.Email, want.DefaultRadiusMiles, want.Lat, want.Long = "", 0, 0, 0
+	if u != want {
+		t.Errorf("got user %+v after clearing the optional fields, want %+v", u, want)
+	}
+}
+
+func TestSearchRadiusDefaultsToUser(t *testing.T) {
+	u := &User{ZipCode: "98101", DefaultRadiusMiles: 5}
+	if got := searchRadius(0, u); got != 5 {
+		t.Errorf("searchRadius() without a request radius = %v, want the user's default 5", got)
+	}
+	if got := searchRadius(20, u); got != 20 {
+		t.Errorf("searchRadius() with a request radius = %v, want 20", got)
+	}
+
+	coords := zipCodeToLatLong["98101"]
+	downtown := &Store{StoreID: "downtown", Lat: 47.615, Long: -122.335}
+	tacoma := &Store{StoreID: "tacoma", Lat: 47.25, Long: -122.44}
+	stores := storesWithinRadius([]*Store{downtown, tacoma}, coords, searchRadius(0, u))
+	if len(stores) != 1 || stores[0] != downtown {
+		t.Errorf("got stores %v within the user's default radius, want only downtown", stores)
+	}
+	items := itemsWithinRadius(QueryItemsResp{
+		{StoreName: "downtown", StoreLat: downtown.Lat, StoreLng: downtown.Long},
+		{StoreName: "tacoma", StoreLat: tacoma.Lat, StoreLng: tacoma.Long},
+	}, coords, searchRadius(0, u))
+	if len(items) != 1 || items[0].StoreName != "downtown" {
+		t.Errorf("got %d items within the user's default radius, want only downtown's", len(items))
+	}
+
+	// Without a default, nothing is left out.
+	if stores := storesWithinRadius([]*Store{downtown, tacoma}, coords, searchRadius(0, &User{})); len(stores) != 2 {
+		t.Errorf("got %d stores without a radius, want 2", len(stores))
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	webhookSignatureHeader = "X-Webhook-Signature"
+	reportUploadedEvent    = "report.uploaded"
+)
+
+var (
+	webhookMaxAttempts    = 3
+	webhookInitialBackoff = time.Second
+)
+
+// Webhook represents a callback URL subscribed to report events.
+type Webhook struct {
+	WebhookID    string `datastore:"webhookID" json:"webhook_id"`
+	UserID       string `datastore:"userID" json:"user_id"`
+	CallbackURL  string `datastore:"callbackURL" json:"callback_url"`
+	TimestampSec int64  `datastore:"timestampSec" json:"timestamp_sec"`
+}
+
+// ReportEvent is the JSON payload POSTed to webhook subscribers when a report is uploaded.
+// It does not identify the user who uploaded the report.
+type ReportEvent struct {
+	Type         string   `json:"type"`
+	StoreID      string   `json:"store_id"`
+	StoreName    string   `json:"store_name"`
+	StoreAddr    string   `json:"store_address"`
+	InStock      []string `json:"in_stock_items"`
+	OutStock     []string `json:"out_stock_items"`
+	Unknown      []string `json:"unknown_items,omitempty"`
+	TimestampSec int64    `json:"timestamp_sec"`
+}
+
+// ******************************************
+// ** BEGIN SubscribeWebhook
+// ******************************************
+
+type SubscribeWebhookReq struct {
+	UserID      string `json:"user_id"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type SubscribeWebhookResp struct {
+	WebhookID string `json:"webhook_id"`
+}
+
+// SubscribeWebhook registers a callback URL that receives report events.
+func SubscribeWebhook(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req SubscribeWebhookReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateSubscribeWebhookReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to generate webhook id: %v", err)
+	}
+	wh := &Webhook{
+		WebhookID:    uid.String(),
+		UserID:       req.UserID,
+		CallbackURL:  req.CallbackURL,
+		TimestampSec: time.Now().Unix(),
+	}
+	if err := createWebhookInStorage(ctx, wh); err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	if err := EncodeResp(w, &SubscribeWebhookResp{WebhookID: wh.WebhookID}); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateSubscribeWebhookReq(req *SubscribeWebhookReq) error {
+	req.CallbackURL = strings.TrimSpace(req.CallbackURL)
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.CallbackURL == "" {
+		return fmt.Errorf("missing callback url")
+	}
+	return validateCallbackURL(req.CallbackURL)
+}
+
+// validateCallbackURL rejects callback URLs that are malformed or that point at blocked
+// outbound targets (see outbound_util.go). Deliveries go through outboundClient, which
+// checks the address again at connect time.
+func validateCallbackURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil {
+		return fmt.Errorf("callback url is malformed: %v", err)
+	}
+	if err := validateOutboundScheme(u); err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host == "" {
+		return fmt.Errorf("callback url is missing a host")
+	}
+	if strings.EqualFold(host, "localhost") {
+		return fmt.Errorf("callback url must not target an internal host")
+	}
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil {
+			return fmt.Errorf("callback url host could not be resolved: %v", err)
+		}
+	}
+	for _, ip := range ips {
+		if ipBlocked(ip, outboundBlockedNetworks) {
+			return fmt.Errorf("callback url must not target an internal host")
+		}
+	}
+	return nil
+}
+
+// ******************************************
+// ** END SubscribeWebhook
+// ******************************************
+
+// ******************************************
+// ** BEGIN UnsubscribeWebhook
+// ******************************************
+
+type UnsubscribeWebhookReq struct {
+	UserID    string `json:"user_id"`
+	WebhookID string `json:"webhook_id"`
+}
+
+// UnsubscribeWebhook removes a webhook. Only the user who subscribed it can remove it.
+func UnsubscribeWebhook(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req UnsubscribeWebhookReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := validateUnsubscribeWebhookReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	wh, ok, err := getWebhookInStorage(ctx, req.WebhookID)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("webhook id is invalid: %q", req.WebhookID)
+	}
+	if wh.UserID != req.UserID {
+		return http.StatusForbidden, fmt.Errorf("webhook %q does not belong to user", req.WebhookID)
+	}
+
+	if err := deleteWebhookInStorage(ctx, req.WebhookID); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func validateUnsubscribeWebhookReq(req *UnsubscribeWebhookReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if req.WebhookID == "" {
+		return fmt.Errorf("missing webhook id")
+	}
+	return nil
+}
+
+// ******************************************
+// ** END UnsubscribeWebhook
+// ******************************************
+
+// ******************************************
+// ** BEGIN ListWebhooks
+// ******************************************
+
+type ListWebhooksResp []*Webhook
+
+// ListWebhooks fetches every registered webhook. It is an admin endpoint.
+func ListWebhooks(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	webhooks, err := loadAllWebhooks(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+
+	resp := make(ListWebhooksResp, 0, len(webhooks))
+	resp = append(resp, webhooks...)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+// ******************************************
+// ** END ListWebhooks
+// ******************************************
+
+func createWebhookInStorage(ctx context.Context, wh *Webhook) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	key := nameKey(ctx, WebhookKind, wh.WebhookID)
+	if _, err := client.Put(ctx, key, wh); err != nil {
+		return fmt.Errorf("failed to create webhook in storage: %v", err)
+	}
+	return nil
+}
+
+// getWebhookInStorage fetches the webhook with key = webhookID in storage.
+// Returns a non-nil error if storage client experienced a failure.
+// If no error, returns true/false to indicate that webhookID exists or not.
+func getWebhookInStorage(ctx context.Context, webhookID string) (*Webhook, bool, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	defer client.Close()
+
+	var wh Webhook
+	key := nameKey(ctx, WebhookKind, webhookID)
+	if err := client.Get(ctx, key, &wh); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to get webhook from storage: %v", err)
+	}
+	return &wh, true, nil
+}
+
+func deleteWebhookInStorage(ctx context.Context, webhookID string) error {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	key := nameKey(ctx, WebhookKind, webhookID)
+	if err := client.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete webhook in storage: %v", err)
+	}
+	return nil
+}
+
+func loadAllWebhooks(ctx context.Context) ([]*Webhook, error) {
+	client, err := StorageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var webhooks []*Webhook
+	it := client.Run(ctx, newQuery(ctx, WebhookKind))
+	for {
+		var wh Webhook
+		_, err := it.Next(&wh)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to query for all webhooks: %v", err)
+		}
+		webhooks = append(webhooks, &wh)
+	}
+	return webhooks, nil
+}
+
+// dispatchReportEvent POSTs the report event to every webhook subscribed in ctx's namespace.
+// It is meant to be run in its own goroutine with a context that outlives the upload
+// request; failures are logged and never surface to the uploader.
+func dispatchReportEvent(ctx context.Context, ev *ReportEvent) {
+	secret := os.Getenv("WEBHOOK_SECRET") // See GCP console for secret
+	if secret == "" {
+		log.Println("webhook secret env variable is not set, skipping report event dispatch")
+		return
+	}
+
+	webhooks, err := loadAllWebhooks(ctx)
+	if err != nil {
+		log.Printf("failed to load webhooks: %v", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		log.Printf("failed to encode report event: %v", err)
+		return
+	}
+	for _, wh := range webhooks {
+		go func(wh *Webhook) {
+			if err := sendWebhookEvent(wh.CallbackURL, payload, secret); err != nil {
+				log.Printf("failed to deliver report event to webhook %q: %v", wh.WebhookID, err)
+			}
+		}(wh)
+	}
+}
+
+// sendWebhookEvent POSTs the signed payload to callbackURL, retrying with exponential backoff
+// until the receiver responds with a 2xx status or webhookMaxAttempts is reached.
+func sendWebhookEvent(callbackURL string, payload []byte, secret string) error {
+	signature := signWebhookPayload(payload, secret)
+	backoff := webhookInitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= webhookMaxAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		req, err := http.NewRequest("POST", callbackURL, bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("failed to set up request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(webhookSignatureHeader, signature)
+		resp, err := outboundClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("receiver responded with status %d", resp.StatusCode)
+	}
+	return fmt.Errorf("gave up after %d attempts: %v", webhookMaxAttempts, lastErr)
+}
+
+// signWebhookPayload returns the value of the signature header for the payload. Receivers
+// verify it by computing the hex-encoded HMAC-SHA256 of the raw request body with the
+// shared secret.
+func signWebhookPayload(payload []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
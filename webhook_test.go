@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withLoopbackAllowed lets outbound requests reach the httptest receivers on 127.0.0.1.
+func withLoopbackAllowed(t *testing.T) {
+	orig := outboundClient
+	outboundClient = newOutboundClient(nil)
+	t.Cleanup(func() { outboundClient = orig })
+}
+
+func TestSendWebhookEvent(t *testing.T) {
+	withLoopbackAllowed(t)
+	webhookInitialBackoff = time.Millisecond
+	const secret = "shh"
+
+	attempts := 0
+	var gotBody []byte
+	var gotSignature string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// Fail the first delivery to exercise the retry.
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		gotBody, _ = ioutil.ReadAll(r.Body)
+		gotSignature = r.Header.Get(webhookSignatureHeader)
+	}))
+	defer receiver.Close()
+
+	ev := &ReportEvent{
+		Type:      reportUploadedEvent,
+		StoreID:   "store-1",
+		StoreName: "Costco",
+		InStock:   []string{"toilet paper"},
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sendWebhookEvent(receiver.URL, payload, secret); err != nil {
+		t.Fatalf("sendWebhookEvent() = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+
+	var got ReportEvent
+	if err := json.Unmarshal(gotBody, &got); err != nil {
+		t.Fatalf("receiver got malformed event: %v", err)
+	}
+	if got.StoreID != ev.StoreID || len(got.InStock) != 1 {
+		t.Errorf("receiver got event %+v, want %+v", got, ev)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(gotBody)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); gotSignature != want {
+		t.Errorf("got signature %q, want %q", gotSignature, want)
+	}
+}
+
+func TestSendWebhookEventGivesUp(t *testing.T) {
+	withLoopbackAllowed(t)
+	webhookInitialBackoff = time.Millisecond
+
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	if err := sendWebhookEvent(receiver.URL, []byte("{}"), "shh"); err == nil {
+		t.Error("sendWebhookEvent() succeeded, want error")
+	}
+	if attempts != webhookMaxAttempts {
+		t.Errorf("got %d attempts, want %d", attempts, webhookMaxAttempts)
+	}
+}
+
+func TestValidateCallbackURL(t *testing.T) {
+	for _, tc := range []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hooks/report", false},
+		{"ftp://93.184.216.34/hooks/report", true},
+		{"https://", true},
+		{"http://localhost:8080/hook", true},
+		{"http://127.0.0.1/hook", true},
+		{"http://169.254.169.254/computeMetadata/v1/", true},
+		{"http://10.0.0.5/hook", true},
+		{"http://192.168.1.1/hook", true},
+		{"http://[::1]/hook", true},
+	} {
+		err := validateCallbackURL(tc.url)
+		if gotErr := err != nil; gotErr != tc.wantErr {
+			t.Errorf("validateCallbackURL(%q) = %v, want error: %v", tc.url, err, tc.wantErr)
+		}
+	}
+}
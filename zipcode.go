@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// maxResolveZipCodes caps the number of zip codes resolved in a single request.
+const maxResolveZipCodes = 100
+
+// ******************************************
+// ** BEGIN ResolveZipCodes
+// ******************************************
+
+type ResolveZipCodesReq struct {
+	UserID   string   `json:"user_id"`
+	ZipCodes []string `json:"zip_codes"`
+}
+
+// ResolveZipCodesResp maps each requested zip code to its coordinates.
+type ResolveZipCodesResp map[string]*ZipCodeCoords
+
+// ZipCodeCoords holds the coordinates of a zip code. Known is false, and the coordinates
+// are left out, for a zip code that isn't in the zip code data.
+type ZipCodeCoords struct {
+	Known bool    `json:"known"`
+	Lat   float64 `json:"latitude,omitempty"`
+	Long  float64 `json:"longitude,omitempty"`
+}
+
+// ResolveZipCodes looks up the coordinates of each zip code in the request.
+func ResolveZipCodes(ctx context.Context, w http.ResponseWriter, r *http.Request) (int, error) {
+	var req ResolveZipCodesReq
+	if err := DecodeReq(r.Body, &req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if err := cleanAndValidateResolveZipCodesReq(&req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	_, ok, err := GetUserInStorage(ctx, req.UserID)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("failed to check user creds: %v", err)
+	}
+	if !ok {
+		return http.StatusForbidden, fmt.Errorf("user id is invalid: %q", req.UserID)
+	}
+
+	resp := resolveZipCodes(req.ZipCodes)
+	if err := EncodeResp(w, &resp); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return http.StatusOK, nil
+}
+
+func cleanAndValidateResolveZipCodesReq(req *ResolveZipCodesReq) error {
+	if req.UserID == "" {
+		return fmt.Errorf("missing user id")
+	}
+	if len(req.ZipCodes) == 0 {
+		return fmt.Errorf("missing zip codes")
+	}
+	if len(req.ZipCodes) > maxResolveZipCodes {
+		return fmt.Errorf("at most %d zip codes can be resolved at once", maxResolveZipCodes)
+	}
+	for i := range req.ZipCodes {
+		req.ZipCodes[i] = strings.TrimSpace(req.ZipCodes[i])
+	}
+	return nil
+}
+
+// resolveZipCodes looks up each zip code in the zip code data. Malformed zip codes are
+// flagged as unknown rather than failing the whole batch.
+func resolveZipCodes(zipCodes []string) ResolveZipCodesResp {
+	resp := make(ResolveZipCodesResp, len(zipCodes))
+	for _, zipCode := range zipCodes {
+		coords, ok := zipCodeToLatLong[zipCode]
+		if !ok {
+			resp[zipCode] = &ZipCodeCoords{Known: false}
+			continue
+		}
+		resp[zipCode] = &ZipCodeCoords{Known: true, Lat: coords.Lat, Long: coords.Long}
+	}
+	return resp
+}
+
+// ******************************************
+// ** END ResolveZipCodes
+// ******************************************
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestResolveZipCodes(t *testing.T) {
+	seattle := zipCodeToLatLong["98101"]
+	resp := resolveZipCodes([]string{"98101", "00000", "abcde", "98101"})
+
+	if len(resp) != 3 {
+		t.Fatalf("got %d zip codes, want 3", len(resp))
+	}
+	if got := resp["98101"]; !got.Known || got.Lat != seattle.Lat || got.Long != seattle.Long {
+		t.Errorf("98101 resolved to %+v, want %+v", got, seattle)
+	}
+	for _, zipCode := range []string{"00000", "abcde"} {
+		if got := resp[zipCode]; got == nil || got.Known {
+			t.Errorf("%s resolved to %+v, want unknown", zipCode, got)
+		}
+	}
+}
+
+func TestCleanAndValidateResolveZipCodesReq(t *testing.T) {
+	req := &ResolveZipCodesReq{UserID: "user", ZipCodes: []string{" 98101 "}}
+	if err := cleanAndValidateResolveZipCodesReq(req); err != nil || req.ZipCodes[0] != "98101" {
+		t.Errorf("cleanAndValidateResolveZipCodesReq() = %v with zip codes %q", err, req.ZipCodes)
+	}
+
+	tooMany := make([]string, maxResolveZipCodes+1)
+	for _, bad := range []*ResolveZipCodesReq{
+		{ZipCodes: []string{"98101"}},
+		{UserID: "user"},
+		{UserID: "user", ZipCodes: tooMany},
+	} {
+		if err := cleanAndValidateResolveZipCodesReq(bad); err == nil {
+			t.Errorf("cleanAndValidateResolveZipCodesReq(%+v) succeeded, want error", bad)
+		}
+	}
+}